@@ -3,7 +3,6 @@ package main
 import (
 	"context"
 	"crypto/tls"
-	"crypto/x509"
 	"fmt"
 	"net/http"
 	"os"
@@ -15,6 +14,7 @@ import (
 	"device-assignment-api/internal/database"
 	"device-assignment-api/internal/handlers"
 	"device-assignment-api/internal/middleware"
+	"device-assignment-api/internal/models"
 	"device-assignment-api/internal/services"
 	"device-assignment-api/pkg/auth"
 	"device-assignment-api/pkg/logger"
@@ -57,48 +57,234 @@ func main() {
 	// Initialize repositories
 	deviceRepo := database.NewDeviceRepository(db.DB())
 	assignmentRepo := database.NewAssignmentRepository(db.DB())
+	certificateRepo := database.NewDeviceCertificateRepository(db.DB())
+	statusRepo := database.NewDeviceStatusRepository(db.DB())
+
+	// Resolve how devices are identified across certificate renewals
+	trustBundles, err := auth.LoadTrustBundles(cfg.Device.SPIFFETrustBundles)
+	if err != nil {
+		log.Error("Invalid device identity configuration", "error", err)
+		os.Exit(1)
+	}
+	identity, err := auth.NewIdentityConfig(cfg.Device.IdentityMode, cfg.Device.IdentitySANType, trustBundles)
+	if err != nil {
+		log.Error("Invalid device identity configuration", "error", err)
+		os.Exit(1)
+	}
+
+	// Decide which unknown devices may register. Outside approval mode, new devices are active
+	// unless an administrator must activate them first.
+	registrationMode, err := models.ParseRegistrationMode(cfg.Device.RegistrationMode)
+	if err != nil {
+		log.Error("Invalid device registration mode", "error", err)
+		os.Exit(1)
+	}
+
+	registration := services.RegistrationPolicy{
+		Mode:          registrationMode,
+		InitialStatus: models.DeviceStatusActive,
+		Allowlist:     database.NewDeviceAllowlistRepository(db.DB()),
+	}
+	if cfg.Device.RequireActivation {
+		registration.InitialStatus = models.DeviceStatusProvisioned
+	}
+
+	log.Info("Device registration configured", "mode", registrationMode)
+
+	// Coalesce device heartbeats in memory and write them in batches
+	presenceTracker := services.NewPresenceTracker(
+		database.NewDevicePresenceRepository(db.DB()),
+		cfg.Device.OnlineTimeout,
+		cfg.Device.HeartbeatFlushInterval,
+		log,
+	)
+	presenceTracker.Start()
+	defer presenceTracker.Stop()
 
 	// Initialize services
-	deviceService := services.NewDeviceService(deviceRepo, assignmentRepo, log)
+	deviceService := services.NewDeviceService(deviceRepo, assignmentRepo, certificateRepo, statusRepo, identity, services.OwnershipPolicy{}, registration, presenceTracker, log)
+
+	// Warn about device certificates approaching expiry
+	expiryMonitor := services.NewCertificateExpiryMonitor(deviceRepo, cfg.Device.CertExpiryWarning, cfg.Device.CertExpiryCheckInterval, log)
+	expiryMonitor.Start()
+	defer expiryMonitor.Stop()
 
 	// Initialize JWT manager
-	jwtManager := auth.NewJWTManager(cfg.JWT.SecretKey, cfg.JWT.TokenDuration, cfg.JWT.Issuer)
+	jwtManager, err := setupJWTManager(&cfg.JWT, log)
+	if err != nil {
+		log.Error("Failed to initialize JWT manager", "error", err)
+		os.Exit(1)
+	}
 
-	// Initialize middleware
-	jwtMiddleware := middleware.NewJWTAuthMiddleware(jwtManager, log)
-	certMiddleware := middleware.NewCertificateAuthMiddleware(log)
+	// Accept tokens from an external identity provider alongside locally issued ones
+	tokenValidator, err := setupTokenValidator(jwtManager, &cfg.OIDC, log)
+	if err != nil {
+		log.Error("Failed to initialize OIDC token validation", "error", err)
+		os.Exit(1)
+	}
 
-	// Initialize handlers
-	deviceHandler := handlers.NewDeviceHandler(deviceService, log)
+	// Load TLS material, reloaded on SIGHUP or when the files change
+	tlsReloader, err := auth.NewTLSReloader(cfg.TLS.CertFile, cfg.TLS.KeyFile, cfg.TLS.CAFile, log)
+	if err != nil {
+		log.Error("Failed to configure TLS", "error", err)
+		os.Exit(1)
+	}
+	tlsReloader.Watch(cfg.TLS.ReloadInterval)
+	defer tlsReloader.Stop()
 
-	// Setup routes
-	router := setupRoutes(deviceHandler, jwtMiddleware, certMiddleware, log)
+	go func() {
+		hup := make(chan os.Signal, 1)
+		signal.Notify(hup, syscall.SIGHUP)
+		for range hup {
+			log.Info("Received SIGHUP, reloading TLS material")
+			tlsReloader.Reload()
+		}
+	}()
 
-	// Configure TLS
-	tlsConfig, err := configureTLS(&cfg.TLS)
+	// Initialize certificate revocation checking
+	revocationChecker, err := setupRevocation(&cfg.TLS, tlsReloader, log)
 	if err != nil {
-		log.Error("Failed to configure TLS", "error", err)
+		log.Error("Failed to initialize certificate revocation checking", "error", err)
 		os.Exit(1)
 	}
 
-	// Create server
-	server := &http.Server{
-		Addr:         ":" + cfg.Server.Port,
-		Handler:      router,
-		TLSConfig:    tlsConfig,
-		ReadTimeout:  cfg.Server.ReadTimeout,
-		WriteTimeout: cfg.Server.WriteTimeout,
-		IdleTimeout:  cfg.Server.IdleTimeout,
+	// Load the admission policy for device certificates
+	certPolicy, err := setupCertificatePolicy(cfg.Device.CertPolicyFile, log)
+	if err != nil {
+		log.Error("Failed to load certificate policy", "error", err)
+		os.Exit(1)
 	}
 
-	// Start server in a goroutine
-	go func() {
-		log.Info("Starting HTTPS server", "port", cfg.Server.Port)
-		if err := server.ListenAndServeTLS(cfg.TLS.CertFile, cfg.TLS.KeyFile); err != nil && err != http.ErrServerClosed {
-			log.Error("Server failed to start", "error", err)
+	// Resolve token roles into permissions
+	rbac := auth.NewRBAC(cfg.RBAC.AdminUsers)
+
+	// Local password login is optional when users come from an external identity provider.
+	// Its sessions also provide the denylist of revoked access tokens.
+	var authHandler *handlers.AuthHandler
+	var tokenDenylist auth.TokenDenylist
+	if cfg.Auth.LocalEnabled {
+		sessionService := services.NewSessionService(
+			database.NewSessionRepository(db.DB()),
+			database.NewUserRepository(db.DB()),
+			jwtManager,
+			cfg.JWT.RefreshTokenDuration,
+			cfg.JWT.UserTokenCertBinding,
+			log,
+		)
+
+		userService, err := setupUserService(db, sessionService, &cfg.Auth, log)
+		if err != nil {
+			log.Error("Failed to initialize local user accounts", "error", err)
 			os.Exit(1)
 		}
-	}()
+
+		authHandler = handlers.NewAuthHandler(userService, sessionService, log)
+		tokenDenylist = sessionService
+		log.Info("Local password login enabled")
+	}
+
+	// Personal access tokens and service API keys for automation
+	apiKeyService := services.NewAPIKeyService(database.NewAPIKeyRepository(db.DB()), log)
+
+	// Initialize middleware
+	jwtMiddleware := middleware.NewJWTAuthMiddleware(tokenValidator, rbac, tokenDenylist, apiKeyService, log)
+	certMiddleware := middleware.NewCertificateAuthMiddleware(revocationChecker, certPolicy, identity, deviceService, log)
+
+	// Devices can optionally trade a certificate authentication for a short-lived device token
+	var deviceTokens *services.DeviceTokenService
+	var deviceTokenValidator auth.DeviceTokenValidator
+	if cfg.Device.TokenEnabled {
+		deviceTokens = services.NewDeviceTokenService(jwtManager, cfg.Device.TokenDuration, cfg.Device.TokenCertBinding, log)
+		deviceTokenValidator = jwtManager
+		log.Info("Device access tokens enabled",
+			"duration", cfg.Device.TokenDuration,
+			"certificate_bound", cfg.Device.TokenCertBinding)
+	}
+	deviceMiddleware := middleware.NewDeviceAuthMiddleware(certMiddleware, deviceTokenValidator, deviceService, log)
+
+	// Initialize handlers
+	deviceHandler := handlers.NewDeviceHandler(deviceService, deviceTokens, log)
+	adminHandler := handlers.NewAdminHandler(deviceService, certPolicy, log)
+	apiKeyHandler := handlers.NewAPIKeyHandler(apiKeyService, log)
+	jwksHandler := handlers.NewJWKSHandler(jwtManager, log)
+
+	var estHandler *handlers.ESTHandler
+	if cfg.EST.Enabled {
+		ca, err := auth.LoadCertificateAuthority(cfg.EST.CACertFile, cfg.EST.CAKeyFile, cfg.EST.CertValidity)
+		if err != nil {
+			log.Error("Failed to load EST issuing CA", "error", err)
+			os.Exit(1)
+		}
+
+		estHandler = handlers.NewESTHandler(ca, deviceService, cfg.EST.BootstrapUsername, cfg.EST.BootstrapPassword, log)
+		log.Info("EST enrollment enabled", "issuer", ca.Certificate().Subject.String())
+	}
+
+	// Setup routes. Devices and users share one router unless a separate user port is configured.
+	deviceRouter := newRouter()
+	setupDeviceRoutes(deviceRouter, deviceHandler, estHandler, certMiddleware, deviceMiddleware)
+
+	userRouter := deviceRouter
+	if cfg.Server.SeparateUserListener() {
+		userRouter = newRouter()
+	}
+	setupUserRoutes(userRouter, deviceHandler, adminHandler, authHandler, apiKeyHandler, jwksHandler, jwtMiddleware)
+
+	// Behind a TLS-terminating proxy, client certificates arrive in a header
+	forwardedMiddleware, err := setupForwardedCertificates(&cfg.TLS.ForwardedCert, tlsReloader, log)
+	if err != nil {
+		log.Error("Failed to configure forwarded client certificates", "error", err)
+		os.Exit(1)
+	}
+	if forwardedMiddleware != nil {
+		deviceRouter.Use(forwardedMiddleware.Handler)
+		if userRouter != deviceRouter {
+			userRouter.Use(forwardedMiddleware.Handler)
+		}
+	}
+
+	// Configure TLS and create servers
+	var servers []*http.Server
+	if cfg.Server.SeparateUserListener() {
+		// The device listener requires a client certificate at the handshake, except when EST
+		// bootstrap enrollment must accept devices that have no certificate yet, devices
+		// may present a device token instead, or a proxy forwards the device's certificate
+		deviceClientAuth := tls.RequireAndVerifyClientCert
+		if estHandler != nil || deviceTokens != nil || forwardedMiddleware != nil {
+			deviceClientAuth = tls.VerifyClientCertIfGiven
+		}
+
+		// The user listener only asks for client certificates when user tokens are bound to them
+		userTLS := configureTLS(tlsReloader, nil, tls.NoClientCert)
+		if cfg.JWT.UserTokenCertBinding {
+			userTLS = configureTLS(tlsReloader, revocationChecker, tls.VerifyClientCertIfGiven)
+		}
+
+		servers = append(servers,
+			newServer(cfg.Server.Port, deviceRouter, configureTLS(tlsReloader, revocationChecker, deviceClientAuth), &cfg.Server),
+			newServer(cfg.Server.UserPort, userRouter, userTLS, &cfg.Server),
+		)
+		logRoutes("device", deviceRouter, log)
+		logRoutes("user", userRouter, log)
+	} else {
+		// A single listener asks for but does not require client certificates, so users can
+		// reach the JWT routes; device routes enforce a verified chain in the middleware
+		servers = append(servers,
+			newServer(cfg.Server.Port, deviceRouter, configureTLS(tlsReloader, revocationChecker, tls.VerifyClientCertIfGiven), &cfg.Server),
+		)
+		logRoutes("shared", deviceRouter, log)
+	}
+
+	// Start servers in goroutines
+	for _, server := range servers {
+		go func(server *http.Server) {
+			log.Info("Starting HTTPS server", "addr", server.Addr)
+			if err := server.ListenAndServeTLS("", ""); err != nil && err != http.ErrServerClosed {
+				log.Error("Server failed to start", "addr", server.Addr, "error", err)
+				os.Exit(1)
+			}
+		}(server)
+	}
 
 	// Wait for interrupt signal to gracefully shutdown the server
 	quit := make(chan os.Signal, 1)
@@ -111,23 +297,37 @@ func main() {
 	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
 	defer cancel()
 
-	if err := server.Shutdown(ctx); err != nil {
-		log.Error("Server forced to shutdown", "error", err)
-		os.Exit(1)
+	for _, server := range servers {
+		if err := server.Shutdown(ctx); err != nil {
+			log.Error("Server forced to shutdown", "addr", server.Addr, "error", err)
+			os.Exit(1)
+		}
 	}
 
 	log.Info("Server exited gracefully")
 }
 
-// setupRoutes configures the HTTP routes
-func setupRoutes(
-	deviceHandler *handlers.DeviceHandler,
-	jwtMiddleware *middleware.JWTAuthMiddleware,
-	certMiddleware *middleware.CertificateAuthMiddleware,
-	log logger.Logger,
-) *mux.Router {
+// newRouter creates a router with the health check endpoint
+func newRouter() *mux.Router {
 	router := mux.NewRouter()
 
+	// Health check endpoint
+	router.HandleFunc("/health", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+		w.Write([]byte("OK"))
+	}).Methods("GET")
+
+	return router
+}
+
+// setupDeviceRoutes configures the routes devices call with their client certificate
+func setupDeviceRoutes(
+	router *mux.Router,
+	deviceHandler *handlers.DeviceHandler,
+	estHandler *handlers.ESTHandler,
+	certMiddleware *middleware.CertificateAuthMiddleware,
+	deviceMiddleware *middleware.DeviceAuthMiddleware,
+) {
 	// API v1 routes
 	api := router.PathPrefix("/api/v1").Subrouter()
 
@@ -136,54 +336,376 @@ func setupRoutes(
 		certMiddleware.Authenticate(http.HandlerFunc(deviceHandler.AuthenticateDevice))).
 		Methods("POST")
 
-	// Device management endpoints (require JWT authentication)
+	// Registered device endpoints (client certificate or device token)
+	api.Handle("/devices/me",
+		deviceMiddleware.Authenticate(http.HandlerFunc(deviceHandler.GetCurrentDevice))).
+		Methods("GET")
+
+	api.Handle("/devices/me",
+		deviceMiddleware.Authenticate(http.HandlerFunc(deviceHandler.ReportCurrentDevice))).
+		Methods("PATCH")
+
+	api.Handle("/devices/me/heartbeat",
+		deviceMiddleware.Authenticate(http.HandlerFunc(deviceHandler.Heartbeat))).
+		Methods("POST")
+
+	// EST enrollment endpoints (RFC 7030)
+	if estHandler != nil {
+		est := router.PathPrefix("/.well-known/est").Subrouter()
+
+		est.HandleFunc("/cacerts", estHandler.CACerts).Methods("GET")
+
+		est.HandleFunc("/simpleenroll", estHandler.SimpleEnroll).Methods("POST")
+
+		est.Handle("/simplereenroll",
+			certMiddleware.Authenticate(http.HandlerFunc(estHandler.SimpleReenroll))).
+			Methods("POST")
+	}
+}
+
+// setupUserRoutes configures the routes users call with a JWT. Every route requires a
+// permission resolved from the token's roles; device routes registered with a scope also
+// accept personal access tokens and service API keys granted that scope.
+func setupUserRoutes(
+	router *mux.Router,
+	deviceHandler *handlers.DeviceHandler,
+	adminHandler *handlers.AdminHandler,
+	authHandler *handlers.AuthHandler,
+	apiKeyHandler *handlers.APIKeyHandler,
+	jwksHandler *handlers.JWKSHandler,
+	jwtMiddleware *middleware.JWTAuthMiddleware,
+) {
+	// Public keys for verifying tokens issued by this service
+	router.HandleFunc("/.well-known/jwks.json", jwksHandler.GetJWKS).Methods("GET")
+
+	// API v1 routes
+	api := router.PathPrefix("/api/v1").Subrouter()
+
+	// Device management endpoints (require a JWT or an API credential with the scope)
+	api.Handle("/devices",
+		jwtMiddleware.RequireScope(auth.ScopeDevicesRead, http.HandlerFunc(deviceHandler.ListDevices))).
+		Methods("GET")
+
 	api.Handle("/devices/{deviceId}",
-		jwtMiddleware.Authenticate(http.HandlerFunc(deviceHandler.GetDevice))).
+		jwtMiddleware.RequireScope(auth.ScopeDevicesRead, http.HandlerFunc(deviceHandler.GetDevice))).
+		Methods("GET")
+
+	api.Handle("/devices/{deviceId}",
+		jwtMiddleware.RequireScope(auth.ScopeDevicesWrite, http.HandlerFunc(deviceHandler.UpdateDevice))).
+		Methods("PATCH")
+
+	api.Handle("/devices/{deviceId}/certificates",
+		jwtMiddleware.RequireScope(auth.ScopeDevicesRead, http.HandlerFunc(deviceHandler.GetDeviceCertificates))).
 		Methods("GET")
 
 	api.Handle("/devices/{deviceId}/assign",
-		jwtMiddleware.Authenticate(http.HandlerFunc(deviceHandler.AssignDevice))).
+		jwtMiddleware.RequireScope(auth.ScopeAssignmentsWrite, http.HandlerFunc(deviceHandler.AssignDevice))).
 		Methods("POST")
 
 	api.Handle("/devices/{deviceId}/unassign",
-		jwtMiddleware.Authenticate(http.HandlerFunc(deviceHandler.UnassignDevice))).
+		jwtMiddleware.RequireScope(auth.ScopeAssignmentsWrite, http.HandlerFunc(deviceHandler.UnassignDevice))).
 		Methods("DELETE")
 
 	api.Handle("/users/me/devices",
-		jwtMiddleware.Authenticate(http.HandlerFunc(deviceHandler.GetUserDevices))).
+		jwtMiddleware.RequireScope(auth.ScopeDevicesRead, http.HandlerFunc(deviceHandler.GetUserDevices))).
 		Methods("GET")
 
-	// Health check endpoint
-	router.HandleFunc("/health", func(w http.ResponseWriter, r *http.Request) {
-		w.WriteHeader(http.StatusOK)
-		w.Write([]byte("OK"))
-	}).Methods("GET")
+	// Administration endpoints (require an admin permission or scope)
+	admin := api.PathPrefix("/admin").Subrouter()
+
+	admin.Handle("/devices",
+		jwtMiddleware.RequireScope(auth.ScopeAdminDevicesRead, http.HandlerFunc(adminHandler.ListDevices))).
+		Methods("GET")
 
-	// Log all routes
+	admin.Handle("/devices/expiring",
+		jwtMiddleware.RequireScope(auth.ScopeAdminDevicesRead, http.HandlerFunc(adminHandler.ListExpiringDevices))).
+		Methods("GET")
+
+	admin.Handle("/devices/{deviceId}/status",
+		jwtMiddleware.RequireScope(auth.ScopeAdminDevicesLifecycle, http.HandlerFunc(adminHandler.ChangeDeviceStatus))).
+		Methods("POST")
+
+	admin.Handle("/devices/{deviceId}/approve",
+		jwtMiddleware.RequireScope(auth.ScopeAdminDevicesLifecycle, http.HandlerFunc(adminHandler.ApproveDevice))).
+		Methods("POST")
+
+	admin.Handle("/devices/{deviceId}/reject",
+		jwtMiddleware.RequireScope(auth.ScopeAdminDevicesLifecycle, http.HandlerFunc(adminHandler.RejectDevice))).
+		Methods("POST")
+
+	admin.Handle("/devices/{deviceId}/status-history",
+		jwtMiddleware.RequireScope(auth.ScopeAdminDevicesRead, http.HandlerFunc(adminHandler.GetDeviceStatusHistory))).
+		Methods("GET")
+
+	admin.Handle("/devices/{deviceId}/assign",
+		jwtMiddleware.RequireScope(auth.ScopeAdminAssignmentsWrite, http.HandlerFunc(adminHandler.AssignDevice))).
+		Methods("POST")
+
+	admin.Handle("/devices/{deviceId}/unassign",
+		jwtMiddleware.RequireScope(auth.ScopeAdminAssignmentsWrite, http.HandlerFunc(adminHandler.UnassignDevice))).
+		Methods("DELETE")
+
+	admin.Handle("/users/{userId}/devices",
+		jwtMiddleware.RequireScope(auth.ScopeAdminDevicesRead, http.HandlerFunc(adminHandler.GetUserDevices))).
+		Methods("GET")
+
+	admin.Handle("/device-allowlist",
+		jwtMiddleware.RequireScope(auth.ScopeAdminDevicesLifecycle, http.HandlerFunc(adminHandler.ImportAllowlistEntries))).
+		Methods("POST")
+
+	admin.Handle("/device-allowlist",
+		jwtMiddleware.RequireScope(auth.ScopeAdminDevicesRead, http.HandlerFunc(adminHandler.ListAllowlistEntries))).
+		Methods("GET")
+
+	admin.Handle("/device-allowlist/{entryId}",
+		jwtMiddleware.RequireScope(auth.ScopeAdminDevicesLifecycle, http.HandlerFunc(adminHandler.DeleteAllowlistEntry))).
+		Methods("DELETE")
+
+	admin.Handle("/certificate-policy",
+		jwtMiddleware.RequireScope(auth.ScopeAdminDevicesRead, http.HandlerFunc(adminHandler.GetCertificatePolicy))).
+		Methods("GET")
+
+	// Personal access token endpoints (JWT only, so a token cannot mint further tokens)
+	api.Handle("/users/me/tokens",
+		jwtMiddleware.Authenticate(http.HandlerFunc(apiKeyHandler.CreatePersonalToken))).
+		Methods("POST")
+
+	api.Handle("/users/me/tokens",
+		jwtMiddleware.Authenticate(http.HandlerFunc(apiKeyHandler.ListPersonalTokens))).
+		Methods("GET")
+
+	api.Handle("/users/me/tokens/{tokenId}",
+		jwtMiddleware.Authenticate(http.HandlerFunc(apiKeyHandler.RevokePersonalToken))).
+		Methods("DELETE")
+
+	// Service API key endpoints
+	admin.Handle("/api-keys",
+		jwtMiddleware.RequirePermission(auth.PermissionAdminAPIKeysManage, http.HandlerFunc(apiKeyHandler.CreateServiceKey))).
+		Methods("POST")
+
+	admin.Handle("/api-keys",
+		jwtMiddleware.RequirePermission(auth.PermissionAdminAPIKeysManage, http.HandlerFunc(apiKeyHandler.ListServiceKeys))).
+		Methods("GET")
+
+	admin.Handle("/api-keys/{keyId}",
+		jwtMiddleware.RequirePermission(auth.PermissionAdminAPIKeysManage, http.HandlerFunc(apiKeyHandler.RevokeServiceKey))).
+		Methods("DELETE")
+
+	// Local user account endpoints (when password login is enabled)
+	if authHandler != nil {
+		api.HandleFunc("/auth/login", authHandler.Login).Methods("POST")
+
+		api.HandleFunc("/auth/refresh", authHandler.Refresh).Methods("POST")
+
+		api.Handle("/auth/logout",
+			jwtMiddleware.Authenticate(http.HandlerFunc(authHandler.Logout))).
+			Methods("POST")
+
+		api.Handle("/users/me/password",
+			jwtMiddleware.Authenticate(http.HandlerFunc(authHandler.ChangePassword))).
+			Methods("POST")
+
+		api.Handle("/users/me/sessions",
+			jwtMiddleware.Authenticate(http.HandlerFunc(authHandler.GetSessions))).
+			Methods("GET")
+
+		api.Handle("/users/me/sessions/{sessionId}",
+			jwtMiddleware.Authenticate(http.HandlerFunc(authHandler.RevokeSession))).
+			Methods("DELETE")
+
+		admin.Handle("/users",
+			jwtMiddleware.RequirePermission(auth.PermissionAdminUsersManage, http.HandlerFunc(authHandler.CreateUser))).
+			Methods("POST")
+	}
+}
+
+// logRoutes logs every route registered on a router
+func logRoutes(listener string, router *mux.Router, log logger.Logger) {
 	router.Walk(func(route *mux.Route, router *mux.Router, ancestors []*mux.Route) error {
 		pathTemplate, err := route.GetPathTemplate()
 		if err == nil {
 			methods, _ := route.GetMethods()
-			log.Debug("Registered route", "path", pathTemplate, "methods", methods)
+			log.Debug("Registered route", "listener", listener, "path", pathTemplate, "methods", methods)
 		}
 		return nil
 	})
+}
 
-	return router
+// newServer creates an HTTPS server for a listener
+func newServer(port string, handler http.Handler, tlsConfig *tls.Config, serverConfig *config.ServerConfig) *http.Server {
+	return &http.Server{
+		Addr:         ":" + port,
+		Handler:      handler,
+		TLSConfig:    tlsConfig,
+		ReadTimeout:  serverConfig.ReadTimeout,
+		WriteTimeout: serverConfig.WriteTimeout,
+		IdleTimeout:  serverConfig.IdleTimeout,
+	}
+}
+
+// setupJWTManager builds the JWT manager from either an asymmetric signing key file or the
+// HMAC secret. Verification key files keep previously used signing keys valid during rotation.
+func setupJWTManager(jwtConfig *config.JWTConfig, log logger.Logger) (*auth.JWTManager, error) {
+	if jwtConfig.SigningKeyFile == "" {
+		log.Info("JWT signing with HMAC secret")
+		return auth.NewJWTManager(jwtConfig.SecretKey, jwtConfig.TokenDuration, jwtConfig.Issuer), nil
+	}
+
+	signingKey, err := auth.LoadJWTKey(jwtConfig.SigningKeyFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load JWT signing key: %w", err)
+	}
+
+	var verificationKeys []*auth.JWTKey
+	for _, file := range jwtConfig.VerificationKeyFiles {
+		key, err := auth.LoadJWTKey(file)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load JWT verification key: %w", err)
+		}
+		verificationKeys = append(verificationKeys, key)
+		log.Info("JWT verification key loaded", "kid", key.ID, "alg", key.Method.Alg())
+	}
+
+	log.Info("JWT signing key loaded", "kid", signingKey.ID, "alg", signingKey.Method.Alg())
+	return auth.NewJWTManagerWithKeys(signingKey, verificationKeys, jwtConfig.TokenDuration, jwtConfig.Issuer)
 }
 
-// configureTLS sets up TLS configuration for mTLS
-func configureTLS(tlsConfig *config.TLSConfig) (*tls.Config, error) {
-	// Load server certificate
-	cert, err := tls.LoadX509KeyPair(tlsConfig.CertFile, tlsConfig.KeyFile)
+// setupUserService creates the local user account service and bootstraps the first admin
+// user when configured
+func setupUserService(db *database.PostgresDB, sessionService *services.SessionService, authConfig *config.AuthConfig, log logger.Logger) (*services.UserService, error) {
+	userService, err := services.NewUserService(database.NewUserRepository(db.DB()), sessionService, log)
+	if err != nil {
+		return nil, err
+	}
+
+	if authConfig.BootstrapAdminUsername != "" {
+		if err := userService.BootstrapAdmin(authConfig.BootstrapAdminUsername, authConfig.BootstrapAdminPassword); err != nil {
+			return nil, err
+		}
+	}
+
+	return userService, nil
+}
+
+// setupTokenValidator returns the JWT manager, or routes tokens by issuer between the OIDC
+// provider and the JWT manager when an external provider is configured
+func setupTokenValidator(jwtManager *auth.JWTManager, oidcConfig *config.OIDCConfig, log logger.Logger) (auth.TokenValidator, error) {
+	if !oidcConfig.Enabled() {
+		return jwtManager, nil
+	}
+
+	verifier, err := auth.NewOIDCVerifier(auth.OIDCConfig{
+		IssuerURL:   oidcConfig.IssuerURL,
+		Audience:    oidcConfig.Audience,
+		UserIDClaim: oidcConfig.UserIDClaim,
+		ClockSkew:   oidcConfig.ClockSkew,
+		CacheTTL:    oidcConfig.JWKSCacheTTL,
+		RolesClaim:  oidcConfig.RolesClaim,
+		GroupRoles:  oidcConfig.GroupRoles,
+	}, log)
+	if err != nil {
+		return nil, err
+	}
+
+	log.Info("OIDC token validation enabled", "issuer", oidcConfig.IssuerURL, "user_id_claim", oidcConfig.UserIDClaim)
+	return auth.NewIssuerTokenValidator(map[string]auth.TokenValidator{verifier.Issuer(): verifier}, jwtManager), nil
+}
+
+// setupCertificatePolicy loads the device certificate admission policy, returning nil when
+// no policy file is configured
+func setupCertificatePolicy(path string, log logger.Logger) (*auth.PolicyEngine, error) {
+	if path == "" {
+		return nil, nil
+	}
+
+	policy, err := auth.LoadCertificatePolicy(path)
+	if err != nil {
+		return nil, err
+	}
+
+	engine, err := auth.NewPolicyEngine(policy)
+	if err != nil {
+		return nil, fmt.Errorf("invalid certificate policy: %w", err)
+	}
+
+	log.Info("Device certificate policy loaded", "file", path)
+	return engine, nil
+}
+
+// setupForwardedCertificates creates the middleware accepting client certificates forwarded by
+// trusted proxies, returning nil when no forwarded certificate header is configured
+func setupForwardedCertificates(forwardedConfig *config.ForwardedCertConfig, reloader *auth.TLSReloader, log logger.Logger) (*middleware.ForwardedCertificateMiddleware, error) {
+	if !forwardedConfig.Enabled() {
+		return nil, nil
+	}
+
+	format, err := auth.ParseForwardedCertFormat(forwardedConfig.Format)
 	if err != nil {
-		return nil, fmt.Errorf("failed to load server certificate: %w", err)
+		return nil, err
 	}
 
+	proxies, err := auth.NewTrustedProxies(forwardedConfig.TrustedProxyCIDRs, forwardedConfig.TrustedProxySPKIs)
+	if err != nil {
+		return nil, err
+	}
+
+	log.Info("Forwarded client certificates enabled",
+		"header", forwardedConfig.Header,
+		"format", format,
+		"trusted_proxy_cidrs", forwardedConfig.TrustedProxyCIDRs,
+		"trusted_proxy_spki_fingerprints", forwardedConfig.TrustedProxySPKIs)
+	return middleware.NewForwardedCertificateMiddleware(forwardedConfig.Header, format, proxies, reloader.ClientCAs, log), nil
+}
+
+// setupRevocation builds the revocation checker from configuration, returning nil when disabled.
+// CRLs must be signed by a client CA and are reloaded whenever the client CA pool is.
+func setupRevocation(tlsConfig *config.TLSConfig, reloader *auth.TLSReloader, log logger.Logger) (auth.RevocationChecker, error) {
+	var checkers auth.MultiRevocationChecker
+
+	if sources := tlsConfig.Revocation.CRLSources(); len(sources) > 0 {
+		crlChecker, err := auth.NewCRLChecker(sources, reloader.ClientCACertificates(), tlsConfig.Revocation.CRLRefreshInterval, log)
+		if err != nil {
+			return nil, err
+		}
+		crlChecker.Start()
+		reloader.OnReload(func() {
+			crlChecker.SetIssuers(reloader.ClientCACertificates())
+		})
+		checkers = append(checkers, crlChecker)
+
+		log.Info("CRL revocation checking enabled", "sources", len(sources))
+	}
+
+	if tlsConfig.Revocation.OCSPEnabled {
+		failMode, err := auth.ParseOCSPFailMode(tlsConfig.Revocation.OCSPFailMode)
+		if err != nil {
+			return nil, err
+		}
+
+		checkers = append(checkers, auth.NewOCSPChecker(
+			tlsConfig.Revocation.OCSPResponderURL,
+			failMode,
+			tlsConfig.Revocation.OCSPTimeout,
+			log,
+		))
+
+		log.Info("OCSP revocation checking enabled", "fail_mode", failMode)
+	}
+
+	if len(checkers) == 0 {
+		return nil, nil
+	}
+
+	return checkers, nil
+}
+
+// configureTLS sets up TLS configuration for a listener. The server certificate and client CA
+// pool are served from the reloader so they can be replaced without a restart.
+func configureTLS(reloader *auth.TLSReloader, revocationChecker auth.RevocationChecker, clientAuth tls.ClientAuthType) *tls.Config {
 	config := &tls.Config{
-		Certificates: []tls.Certificate{cert},
-		ClientAuth:   tls.RequireAndVerifyClientCert,
-		MinVersion:   tls.VersionTLS12,
+		GetCertificate: reloader.GetCertificate,
+		ClientAuth:     clientAuth,
+		MinVersion:     tls.VersionTLS12,
 		CipherSuites: []uint16{
 			tls.TLS_ECDHE_RSA_WITH_AES_256_GCM_SHA384,
 			tls.TLS_ECDHE_RSA_WITH_CHACHA20_POLY1305,
@@ -193,24 +715,13 @@ func configureTLS(tlsConfig *config.TLSConfig) (*tls.Config, error) {
 		PreferServerCipherSuites: true,
 	}
 
-	// Load CA certificate for client verification if provided
-	if tlsConfig.CAFile != "" {
-		caCert, err := os.ReadFile(tlsConfig.CAFile)
-		if err != nil {
-			return nil, fmt.Errorf("failed to read CA certificate: %w", err)
-		}
-
-		caCertPool := config.ClientCAs
-		if caCertPool == nil {
-			caCertPool = x509.NewCertPool()
-		}
-
-		if !caCertPool.AppendCertsFromPEM(caCert) {
-			return nil, fmt.Errorf("failed to parse CA certificate")
-		}
-
-		config.ClientCAs = caCertPool
+	// Reject revoked client certificates during the handshake
+	if revocationChecker != nil {
+		config.VerifyPeerCertificate = auth.VerifyPeerRevocation(revocationChecker)
 	}
 
-	return config, nil
+	// Each handshake picks up the current certificate and client CA pool
+	config.GetConfigForClient = reloader.ConfigForClient(config)
+
+	return config
 }
@@ -4,17 +4,24 @@ import (
 	"fmt"
 	"os"
 	"strconv"
+	"strings"
 	"time"
 )
 
 // ServerConfig holds server-related configuration
 type ServerConfig struct {
 	Port         string
+	UserPort     string
 	ReadTimeout  time.Duration
 	WriteTimeout time.Duration
 	IdleTimeout  time.Duration
 }
 
+// SeparateUserListener reports whether user-facing routes are served on their own port
+func (c *ServerConfig) SeparateUserListener() bool {
+	return c.UserPort != ""
+}
+
 // DatabaseConfig holds database connection configuration
 type DatabaseConfig struct {
 	Host     string
@@ -29,15 +36,116 @@ type DatabaseConfig struct {
 type TLSConfig struct {
 	CertFile   string
 	KeyFile    string
-	CAFile     string
-	RequireSSL bool
+	CAFile         string
+	RequireSSL     bool
+	ReloadInterval time.Duration
+	Revocation     RevocationConfig
+	ForwardedCert  ForwardedCertConfig
+}
+
+// ForwardedCertConfig holds settings for accepting client certificates forwarded by a
+// TLS-terminating proxy
+type ForwardedCertConfig struct {
+	Header            string
+	Format            string
+	TrustedProxyCIDRs []string
+	TrustedProxySPKIs []string
+}
+
+// Enabled reports whether forwarded client certificates are accepted
+func (c *ForwardedCertConfig) Enabled() bool {
+	return c.Header != ""
+}
+
+// RevocationConfig holds client certificate revocation checking configuration
+type RevocationConfig struct {
+	CRLFiles           []string
+	CRLURLs            []string
+	CRLRefreshInterval time.Duration
+	OCSPEnabled        bool
+	OCSPResponderURL   string
+	OCSPFailMode       string
+	OCSPTimeout        time.Duration
+}
+
+// CRLSources returns all configured CRL files and URLs
+func (c *RevocationConfig) CRLSources() []string {
+	sources := make([]string, 0, len(c.CRLFiles)+len(c.CRLURLs))
+	sources = append(sources, c.CRLFiles...)
+	return append(sources, c.CRLURLs...)
 }
 
 // JWTConfig holds JWT authentication configuration
 type JWTConfig struct {
-	SecretKey     string
-	TokenDuration time.Duration
-	Issuer        string
+	SecretKey            string
+	SigningKeyFile       string
+	VerificationKeyFiles []string
+	TokenDuration        time.Duration
+	RefreshTokenDuration time.Duration
+	Issuer               string
+	UserTokenCertBinding bool
+}
+
+// OIDCConfig holds settings for accepting user tokens from an external OpenID Connect provider
+type OIDCConfig struct {
+	IssuerURL    string
+	Audience     string
+	UserIDClaim  string
+	ClockSkew    time.Duration
+	JWKSCacheTTL time.Duration
+	RolesClaim   string
+	GroupRoles   map[string]string
+}
+
+// Enabled reports whether an external OIDC provider is configured
+func (c *OIDCConfig) Enabled() bool {
+	return c.IssuerURL != ""
+}
+
+// AuthConfig holds local user account and password login configuration
+type AuthConfig struct {
+	LocalEnabled           bool
+	BootstrapAdminUsername string
+	BootstrapAdminPassword string
+}
+
+// RBACConfig holds role-based access control configuration
+type RBACConfig struct {
+	AdminUsers []string
+}
+
+// DeviceConfig holds device identity and registration configuration
+type DeviceConfig struct {
+	IdentityMode       string
+	IdentitySANType    string
+	SPIFFETrustBundles map[string]string
+	TokenEnabled       bool
+	TokenDuration      time.Duration
+	TokenCertBinding   bool
+	CertPolicyFile     string
+
+	// RegistrationMode decides which unknown devices may register: open, allowlist or approval
+	RegistrationMode string
+	// RequireActivation registers new devices as provisioned until an administrator activates them
+	RequireActivation bool
+
+	// CertExpiryWarning is how long before expiry device certificates are reported as expiring
+	CertExpiryWarning       time.Duration
+	CertExpiryCheckInterval time.Duration
+
+	// OnlineTimeout is how long after its last heartbeat a device is still reported online
+	OnlineTimeout          time.Duration
+	HeartbeatFlushInterval time.Duration
+}
+
+// ESTConfig holds EST (RFC 7030) enrollment configuration
+type ESTConfig struct {
+	Enabled           bool
+	CACertFile        string
+	CAKeyFile         string
+	CertValidity      time.Duration
+	BootstrapUsername string
+	BootstrapPassword string
 }
 
 // Config holds all application configuration
@@ -46,6 +154,11 @@ type Config struct {
 	Database DatabaseConfig
 	TLS      TLSConfig
 	JWT      JWTConfig
+	OIDC     OIDCConfig
+	Auth     AuthConfig
+	RBAC     RBACConfig
+	Device   DeviceConfig
+	EST      ESTConfig
 }
 
 // Load reads configuration from environment variables with sensible defaults
@@ -53,6 +166,7 @@ func Load() (*Config, error) {
 	config := &Config{
 		Server: ServerConfig{
 			Port:         getEnv("SERVER_PORT", "8443"),
+			UserPort:     getEnv("SERVER_USER_PORT", ""),
 			ReadTimeout:  getDurationEnv("SERVER_READ_TIMEOUT", "15s"),
 			WriteTimeout: getDurationEnv("SERVER_WRITE_TIMEOUT", "15s"),
 			IdleTimeout:  getDurationEnv("SERVER_IDLE_TIMEOUT", "60s"),
@@ -66,15 +180,78 @@ func Load() (*Config, error) {
 			SSLMode:  getEnv("DB_SSL_MODE", "prefer"),
 		},
 		TLS: TLSConfig{
-			CertFile:   getEnv("TLS_CERT_FILE", ""),
-			KeyFile:    getEnv("TLS_KEY_FILE", ""),
-			CAFile:     getEnv("TLS_CA_FILE", ""),
-			RequireSSL: getBoolEnv("TLS_REQUIRE_SSL", true),
+			CertFile:       getEnv("TLS_CERT_FILE", ""),
+			KeyFile:        getEnv("TLS_KEY_FILE", ""),
+			CAFile:         getEnv("TLS_CA_FILE", ""),
+			RequireSSL:     getBoolEnv("TLS_REQUIRE_SSL", true),
+			ReloadInterval: getDurationEnv("TLS_RELOAD_INTERVAL", "1m"),
+			Revocation: RevocationConfig{
+				CRLFiles:           getListEnv("TLS_CRL_FILES"),
+				CRLURLs:            getListEnv("TLS_CRL_URLS"),
+				CRLRefreshInterval: getDurationEnv("TLS_CRL_REFRESH_INTERVAL", "1h"),
+				OCSPEnabled:        getBoolEnv("TLS_OCSP_ENABLED", false),
+				OCSPResponderURL:   getEnv("TLS_OCSP_RESPONDER_URL", ""),
+				OCSPFailMode:       getEnv("TLS_OCSP_FAIL_MODE", "soft"),
+				OCSPTimeout:        getDurationEnv("TLS_OCSP_TIMEOUT", "5s"),
+			},
+			ForwardedCert: ForwardedCertConfig{
+				Header:            getEnv("TLS_FORWARDED_CERT_HEADER", ""),
+				Format:            getEnv("TLS_FORWARDED_CERT_FORMAT", "xfcc"),
+				TrustedProxyCIDRs: getListEnv("TLS_TRUSTED_PROXY_CIDRS"),
+				TrustedProxySPKIs: getListEnv("TLS_TRUSTED_PROXY_SPKI_FINGERPRINTS"),
+			},
 		},
 		JWT: JWTConfig{
-			SecretKey:     getEnv("JWT_SECRET_KEY", ""),
-			TokenDuration: getDurationEnv("JWT_TOKEN_DURATION", "24h"),
-			Issuer:        getEnv("JWT_ISSUER", "device-assignment-api"),
+			SecretKey:            getEnv("JWT_SECRET_KEY", ""),
+			SigningKeyFile:       getEnv("JWT_SIGNING_KEY_FILE", ""),
+			VerificationKeyFiles: getListEnv("JWT_VERIFICATION_KEY_FILES"),
+			TokenDuration:        getDurationEnv("JWT_TOKEN_DURATION", "15m"),
+			RefreshTokenDuration: getDurationEnv("JWT_REFRESH_TOKEN_DURATION", "720h"),
+			Issuer:               getEnv("JWT_ISSUER", "device-assignment-api"),
+			UserTokenCertBinding: getBoolEnv("JWT_USER_TOKEN_CERT_BINDING", false),
+		},
+		OIDC: OIDCConfig{
+			IssuerURL:    getEnv("OIDC_ISSUER_URL", ""),
+			Audience:     getEnv("OIDC_AUDIENCE", ""),
+			UserIDClaim:  getEnv("OIDC_USER_ID_CLAIM", "sub"),
+			ClockSkew:    getDurationEnv("OIDC_CLOCK_SKEW", "60s"),
+			JWKSCacheTTL: getDurationEnv("OIDC_JWKS_CACHE_TTL", "1h"),
+			RolesClaim:   getEnv("OIDC_ROLES_CLAIM", "groups"),
+			GroupRoles:   getMapEnv("OIDC_GROUP_ROLES"),
+		},
+		Auth: AuthConfig{
+			LocalEnabled:           getBoolEnv("AUTH_LOCAL_ENABLED", true),
+			BootstrapAdminUsername: getEnv("AUTH_BOOTSTRAP_ADMIN_USERNAME", ""),
+			BootstrapAdminPassword: getEnv("AUTH_BOOTSTRAP_ADMIN_PASSWORD", ""),
+		},
+		RBAC: RBACConfig{
+			AdminUsers: getListEnv("RBAC_ADMIN_USERS"),
+		},
+		Device: DeviceConfig{
+			IdentityMode:       getEnv("DEVICE_IDENTITY_MODE", "issuer_serial"),
+			IdentitySANType:    getEnv("DEVICE_IDENTITY_SAN_TYPE", "dns"),
+			SPIFFETrustBundles: getMapEnv("DEVICE_SPIFFE_TRUST_BUNDLES"),
+			TokenEnabled:       getBoolEnv("DEVICE_TOKEN_ENABLED", false),
+			TokenDuration:      getDurationEnv("DEVICE_TOKEN_DURATION", "15m"),
+			TokenCertBinding:   getBoolEnv("DEVICE_TOKEN_CERT_BINDING", false),
+			CertPolicyFile:     getEnv("DEVICE_CERT_POLICY_FILE", ""),
+
+			RegistrationMode:  getEnv("DEVICE_REGISTRATION_MODE", "open"),
+			RequireActivation: getBoolEnv("DEVICE_REQUIRE_ACTIVATION", false),
+
+			CertExpiryWarning:       getDurationEnv("DEVICE_CERT_EXPIRY_WARNING", "720h"),
+			CertExpiryCheckInterval: getDurationEnv("DEVICE_CERT_EXPIRY_CHECK_INTERVAL", "1h"),
+
+			OnlineTimeout:          getDurationEnv("DEVICE_ONLINE_TIMEOUT", "5m"),
+			HeartbeatFlushInterval: getDurationEnv("DEVICE_HEARTBEAT_FLUSH_INTERVAL", "10s"),
+		},
+		EST: ESTConfig{
+			Enabled:           getBoolEnv("EST_ENABLED", false),
+			CACertFile:        getEnv("EST_CA_CERT_FILE", ""),
+			CAKeyFile:         getEnv("EST_CA_KEY_FILE", ""),
+			CertValidity:      getDurationEnv("EST_CERT_VALIDITY", "8760h"),
+			BootstrapUsername: getEnv("EST_BOOTSTRAP_USERNAME", ""),
+			BootstrapPassword: getEnv("EST_BOOTSTRAP_PASSWORD", ""),
 		},
 	}
 
@@ -87,6 +264,10 @@ func Load() (*Config, error) {
 
 // validate ensures required configuration values are present
 func (c *Config) validate() error {
+	if c.Server.UserPort != "" && c.Server.UserPort == c.Server.Port {
+		return fmt.Errorf("SERVER_USER_PORT must differ from SERVER_PORT")
+	}
+
 	if c.Database.Password == "" {
 		return fmt.Errorf("DB_PASSWORD is required")
 	}
@@ -99,8 +280,62 @@ func (c *Config) validate() error {
 		return fmt.Errorf("TLS_CA_FILE is required for client certificate verification")
 	}
 
-	if c.JWT.SecretKey == "" {
-		return fmt.Errorf("JWT_SECRET_KEY is required")
+	if mode := c.TLS.Revocation.OCSPFailMode; mode != "soft" && mode != "hard" {
+		return fmt.Errorf("TLS_OCSP_FAIL_MODE must be \"soft\" or \"hard\"")
+	}
+
+	if c.TLS.ForwardedCert.Enabled() {
+		if format := c.TLS.ForwardedCert.Format; format != "xfcc" && format != "pem" {
+			return fmt.Errorf("TLS_FORWARDED_CERT_FORMAT must be \"xfcc\" or \"pem\"")
+		}
+
+		if len(c.TLS.ForwardedCert.TrustedProxyCIDRs) == 0 && len(c.TLS.ForwardedCert.TrustedProxySPKIs) == 0 {
+			return fmt.Errorf("TLS_TRUSTED_PROXY_CIDRS or TLS_TRUSTED_PROXY_SPKI_FINGERPRINTS is required when TLS_FORWARDED_CERT_HEADER is set")
+		}
+	}
+
+	if c.JWT.SecretKey == "" && c.JWT.SigningKeyFile == "" {
+		return fmt.Errorf("JWT_SECRET_KEY or JWT_SIGNING_KEY_FILE is required")
+	}
+
+	if c.OIDC.Enabled() {
+		if c.OIDC.Audience == "" {
+			return fmt.Errorf("OIDC_AUDIENCE is required when OIDC_ISSUER_URL is set")
+		}
+
+		switch c.OIDC.UserIDClaim {
+		case "sub", "email", "preferred_username":
+		default:
+			return fmt.Errorf("OIDC_USER_ID_CLAIM must be \"sub\", \"email\" or \"preferred_username\"")
+		}
+
+		for group, role := range c.OIDC.GroupRoles {
+			if role != "user" && role != "admin" {
+				return fmt.Errorf("OIDC_GROUP_ROLES maps group %q to unknown role %q", group, role)
+			}
+		}
+	}
+
+	if (c.Auth.BootstrapAdminUsername == "") != (c.Auth.BootstrapAdminPassword == "") {
+		return fmt.Errorf("AUTH_BOOTSTRAP_ADMIN_USERNAME and AUTH_BOOTSTRAP_ADMIN_PASSWORD must be set together")
+	}
+
+	if c.Device.CertExpiryCheckInterval > 0 && c.Device.CertExpiryWarning <= 0 {
+		return fmt.Errorf("DEVICE_CERT_EXPIRY_WARNING must be positive when DEVICE_CERT_EXPIRY_CHECK_INTERVAL is set")
+	}
+
+	if c.Device.OnlineTimeout <= 0 || c.Device.HeartbeatFlushInterval <= 0 {
+		return fmt.Errorf("DEVICE_ONLINE_TIMEOUT and DEVICE_HEARTBEAT_FLUSH_INTERVAL must be positive")
+	}
+
+	if c.EST.Enabled {
+		if c.EST.CACertFile == "" || c.EST.CAKeyFile == "" {
+			return fmt.Errorf("EST_CA_CERT_FILE and EST_CA_KEY_FILE are required when EST is enabled")
+		}
+
+		if c.EST.BootstrapUsername == "" || c.EST.BootstrapPassword == "" {
+			return fmt.Errorf("EST_BOOTSTRAP_USERNAME and EST_BOOTSTRAP_PASSWORD are required when EST is enabled")
+		}
 	}
 
 	return nil
@@ -146,3 +381,35 @@ func getDurationEnv(key string, defaultValue string) time.Duration {
 	parsed, _ := time.ParseDuration(defaultValue)
 	return parsed
 }
+
+func getListEnv(key string) []string {
+	value := os.Getenv(key)
+	if value == "" {
+		return nil
+	}
+
+	var items []string
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+func getMapEnv(key string) map[string]string {
+	items := getListEnv(key)
+	if len(items) == 0 {
+		return nil
+	}
+
+	values := make(map[string]string, len(items))
+	for _, item := range items {
+		name, value, found := strings.Cut(item, "=")
+		if !found {
+			continue
+		}
+		values[strings.TrimSpace(name)] = strings.TrimSpace(value)
+	}
+	return values
+}
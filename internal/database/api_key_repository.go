@@ -0,0 +1,149 @@
+package database
+
+import (
+	"database/sql"
+	"fmt"
+
+	"device-assignment-api/internal/models"
+
+	"github.com/google/uuid"
+	"github.com/lib/pq"
+)
+
+// apiKeyColumns lists the API key columns selected by every API key query
+const apiKeyColumns = `id, kind, name, user_id, prefix, key_hash, scopes, created_by, created_at, expires_at, last_used_at, revoked_at`
+
+// scanAPIKey scans the apiKeyColumns into an API key
+func scanAPIKey(row rowScanner, key *models.APIKey) error {
+	return row.Scan(
+		&key.ID,
+		&key.Kind,
+		&key.Name,
+		&key.UserID,
+		&key.Prefix,
+		&key.KeyHash,
+		pq.Array(&key.Scopes),
+		&key.CreatedBy,
+		&key.CreatedAt,
+		&key.ExpiresAt,
+		&key.LastUsedAt,
+		&key.RevokedAt,
+	)
+}
+
+// APIKeyRepositoryImpl implements the APIKeyRepository interface using PostgreSQL
+type APIKeyRepositoryImpl struct {
+	db *sql.DB
+}
+
+// NewAPIKeyRepository creates a new APIKeyRepositoryImpl
+func NewAPIKeyRepository(db *sql.DB) *APIKeyRepositoryImpl {
+	return &APIKeyRepositoryImpl{db: db}
+}
+
+// CreateAPIKey stores a new API key in the database
+func (r *APIKeyRepositoryImpl) CreateAPIKey(key *models.APIKey) error {
+	query := `
+		INSERT INTO api_keys (id, kind, name, user_id, prefix, key_hash, scopes, created_by, created_at, expires_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10)`
+
+	_, err := r.db.Exec(query,
+		key.ID,
+		key.Kind,
+		key.Name,
+		key.UserID,
+		key.Prefix,
+		key.KeyHash,
+		pq.Array(key.Scopes),
+		key.CreatedBy,
+		key.CreatedAt,
+		key.ExpiresAt,
+	)
+	if err != nil {
+		if pqErr, ok := err.(*pq.Error); ok && pqErr.Code == "23505" {
+			return fmt.Errorf("api key name already exists")
+		}
+		return fmt.Errorf("failed to create api key: %w", err)
+	}
+
+	return nil
+}
+
+// GetAPIKey retrieves an API key by its UUID
+func (r *APIKeyRepositoryImpl) GetAPIKey(id uuid.UUID) (*models.APIKey, error) {
+	return r.getAPIKey(`SELECT `+apiKeyColumns+` FROM api_keys WHERE id = $1`, id)
+}
+
+// GetAPIKeyByHash retrieves an API key by the hash of its value
+func (r *APIKeyRepositoryImpl) GetAPIKeyByHash(keyHash string) (*models.APIKey, error) {
+	return r.getAPIKey(`SELECT `+apiKeyColumns+` FROM api_keys WHERE key_hash = $1`, keyHash)
+}
+
+// getAPIKey retrieves the API key selected by a single-row query
+func (r *APIKeyRepositoryImpl) getAPIKey(query string, arg interface{}) (*models.APIKey, error) {
+	key := &models.APIKey{}
+	err := scanAPIKey(r.db.QueryRow(query, arg), key)
+
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, fmt.Errorf("api key not found")
+		}
+		return nil, fmt.Errorf("failed to get api key: %w", err)
+	}
+
+	return key, nil
+}
+
+// ListAPIKeys retrieves the unrevoked keys of a kind, limited to one user's keys when userID
+// is not empty
+func (r *APIKeyRepositoryImpl) ListAPIKeys(kind models.APIKeyKind, userID string) ([]*models.APIKey, error) {
+	query := `
+		SELECT ` + apiKeyColumns + `
+		FROM api_keys
+		WHERE kind = $1 AND ($2 = '' OR user_id = $2) AND revoked_at IS NULL
+		ORDER BY created_at DESC`
+
+	rows, err := r.db.Query(query, kind, userID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list api keys: %w", err)
+	}
+	defer rows.Close()
+
+	var keys []*models.APIKey
+	for rows.Next() {
+		key := &models.APIKey{}
+		if err := scanAPIKey(rows, key); err != nil {
+			return nil, fmt.Errorf("failed to scan api key: %w", err)
+		}
+		keys = append(keys, key)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating over api keys: %w", err)
+	}
+
+	return keys, nil
+}
+
+// TouchAPIKey records that an API key was used
+func (r *APIKeyRepositoryImpl) TouchAPIKey(id uuid.UUID) error {
+	if _, err := r.db.Exec(`UPDATE api_keys SET last_used_at = NOW() WHERE id = $1`, id); err != nil {
+		return fmt.Errorf("failed to update api key last use: %w", err)
+	}
+
+	return nil
+}
+
+// RevokeAPIKey marks an API key as revoked
+func (r *APIKeyRepositoryImpl) RevokeAPIKey(id uuid.UUID) error {
+	query := `
+		UPDATE api_keys
+		SET revoked_at = NOW()
+		WHERE id = $1 AND revoked_at IS NULL`
+
+	if _, err := r.db.Exec(query, id); err != nil {
+		return fmt.Errorf("failed to revoke api key: %w", err)
+	}
+
+	return nil
+}
@@ -128,6 +128,132 @@ func (r *AssignmentRepositoryImpl) UnassignDevice(deviceID uuid.UUID) error {
 	return nil
 }
 
+// AssignDevice stores the assignment of an unassigned device in one transaction. The device row
+// is locked while its status and assignment are checked, so that an assignment cannot be
+// stored concurrently with another assignment or a status change.
+func (r *AssignmentRepositoryImpl) AssignDevice(assignment *models.Assignment) error {
+	tx, err := r.db.Begin()
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	var status models.DeviceStatus
+	err = tx.QueryRow(`SELECT status FROM devices WHERE id = $1 FOR UPDATE`, assignment.DeviceID).Scan(&status)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return fmt.Errorf("device not found")
+		}
+		return fmt.Errorf("failed to lock device: %w", err)
+	}
+
+	if status != models.DeviceStatusActive {
+		return fmt.Errorf("device is not active")
+	}
+
+	var isAssigned bool
+	err = tx.QueryRow(`
+		SELECT EXISTS(
+			SELECT 1 FROM assignments
+			WHERE device_id = $1 AND unassigned_at IS NULL
+		)`, assignment.DeviceID).Scan(&isAssigned)
+	if err != nil {
+		return fmt.Errorf("failed to check assignment status: %w", err)
+	}
+
+	if isAssigned {
+		return fmt.Errorf("device is already assigned to another user")
+	}
+
+	_, err = tx.Exec(`
+		INSERT INTO assignments (id, device_id, user_id, assigned_at, unassigned_at)
+		VALUES ($1, $2, $3, $4, $5)`,
+		assignment.ID,
+		assignment.DeviceID,
+		assignment.UserID,
+		assignment.AssignedAt,
+		assignment.UnassignedAt,
+	)
+	if err != nil {
+		return fmt.Errorf("failed to create assignment: %w", err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit assignment: %w", err)
+	}
+
+	return nil
+}
+
+// ReassignDevice ends the device's active assignment, if any, and stores the new assignment in
+// one transaction. The device row and its active assignment are locked, so that concurrent
+// reassignments of the same device are applied one after the other.
+func (r *AssignmentRepositoryImpl) ReassignDevice(assignment *models.Assignment) (bool, error) {
+	tx, err := r.db.Begin()
+	if err != nil {
+		return false, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	var status models.DeviceStatus
+	err = tx.QueryRow(`SELECT status FROM devices WHERE id = $1 FOR UPDATE`, assignment.DeviceID).Scan(&status)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return false, fmt.Errorf("device not found")
+		}
+		return false, fmt.Errorf("failed to lock device: %w", err)
+	}
+
+	if status != models.DeviceStatusActive {
+		return false, fmt.Errorf("device is not active")
+	}
+
+	var currentUserID string
+	err = tx.QueryRow(`
+		SELECT user_id FROM assignments
+		WHERE device_id = $1 AND unassigned_at IS NULL
+		ORDER BY assigned_at DESC
+		LIMIT 1
+		FOR UPDATE`, assignment.DeviceID).Scan(&currentUserID)
+	if err != nil && err != sql.ErrNoRows {
+		return false, fmt.Errorf("failed to lock active assignment: %w", err)
+	}
+	replaced := err == nil
+
+	if replaced && currentUserID == assignment.UserID {
+		return false, fmt.Errorf("device is already assigned to the user")
+	}
+
+	if replaced {
+		_, err = tx.Exec(`
+			UPDATE assignments
+			SET unassigned_at = NOW()
+			WHERE device_id = $1 AND unassigned_at IS NULL`, assignment.DeviceID)
+		if err != nil {
+			return false, fmt.Errorf("failed to unassign device: %w", err)
+		}
+	}
+
+	_, err = tx.Exec(`
+		INSERT INTO assignments (id, device_id, user_id, assigned_at, unassigned_at)
+		VALUES ($1, $2, $3, $4, $5)`,
+		assignment.ID,
+		assignment.DeviceID,
+		assignment.UserID,
+		assignment.AssignedAt,
+		assignment.UnassignedAt,
+	)
+	if err != nil {
+		return false, fmt.Errorf("failed to create assignment: %w", err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return false, fmt.Errorf("failed to commit reassignment: %w", err)
+	}
+
+	return replaced, nil
+}
+
 // IsDeviceAssigned checks if a device is currently assigned to any user
 func (r *AssignmentRepositoryImpl) IsDeviceAssigned(deviceID uuid.UUID) (bool, error) {
 	query := `
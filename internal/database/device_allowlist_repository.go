@@ -0,0 +1,142 @@
+package database
+
+import (
+	"database/sql"
+	"fmt"
+
+	"device-assignment-api/internal/models"
+
+	"github.com/google/uuid"
+)
+
+// DeviceAllowlistRepositoryImpl implements the DeviceAllowlistRepository interface using PostgreSQL
+type DeviceAllowlistRepositoryImpl struct {
+	db *sql.DB
+}
+
+// NewDeviceAllowlistRepository creates a new DeviceAllowlistRepositoryImpl
+func NewDeviceAllowlistRepository(db *sql.DB) *DeviceAllowlistRepositoryImpl {
+	return &DeviceAllowlistRepositoryImpl{db: db}
+}
+
+// CreateAllowlistEntries stores the entries in a single transaction. Entries with the same
+// serial number, issuer DN, fingerprint and public key fingerprint as an existing entry are
+// skipped.
+func (r *DeviceAllowlistRepositoryImpl) CreateAllowlistEntries(entries []*models.AllowlistEntry) (int, error) {
+	tx, err := r.db.Begin()
+	if err != nil {
+		return 0, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	query := `
+		INSERT INTO device_allowlist (id, serial_number, issuer_dn, fingerprint, spki_fingerprint, note, created_by, created_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8)
+		ON CONFLICT (serial_number, issuer_dn, fingerprint, spki_fingerprint) DO NOTHING`
+
+	added := 0
+	for _, entry := range entries {
+		result, err := tx.Exec(query,
+			entry.ID,
+			entry.SerialNumber,
+			entry.IssuerDN,
+			entry.Fingerprint,
+			entry.SPKIFingerprint,
+			entry.Note,
+			entry.CreatedBy,
+			entry.CreatedAt,
+		)
+		if err != nil {
+			return 0, fmt.Errorf("failed to create allowlist entry: %w", err)
+		}
+
+		rowsAffected, err := result.RowsAffected()
+		if err != nil {
+			return 0, fmt.Errorf("failed to get rows affected: %w", err)
+		}
+		added += int(rowsAffected)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return 0, fmt.Errorf("failed to commit allowlist entries: %w", err)
+	}
+
+	return added, nil
+}
+
+// ListAllowlistEntries retrieves every allowlist entry, newest first
+func (r *DeviceAllowlistRepositoryImpl) ListAllowlistEntries() ([]*models.AllowlistEntry, error) {
+	query := `
+		SELECT id, serial_number, issuer_dn, fingerprint, spki_fingerprint, note, created_by, created_at
+		FROM device_allowlist
+		ORDER BY created_at DESC`
+
+	rows, err := r.db.Query(query)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list allowlist entries: %w", err)
+	}
+	defer rows.Close()
+
+	var entries []*models.AllowlistEntry
+	for rows.Next() {
+		entry := &models.AllowlistEntry{}
+		err := rows.Scan(
+			&entry.ID,
+			&entry.SerialNumber,
+			&entry.IssuerDN,
+			&entry.Fingerprint,
+			&entry.SPKIFingerprint,
+			&entry.Note,
+			&entry.CreatedBy,
+			&entry.CreatedAt,
+		)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan allowlist entry: %w", err)
+		}
+		entries = append(entries, entry)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating over allowlist entries: %w", err)
+	}
+
+	return entries, nil
+}
+
+// DeleteAllowlistEntry removes an allowlist entry
+func (r *DeviceAllowlistRepositoryImpl) DeleteAllowlistEntry(id uuid.UUID) error {
+	result, err := r.db.Exec(`DELETE FROM device_allowlist WHERE id = $1`, id)
+	if err != nil {
+		return fmt.Errorf("failed to delete allowlist entry: %w", err)
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("failed to get rows affected: %w", err)
+	}
+
+	if rowsAffected == 0 {
+		return fmt.Errorf("allowlist entry not found")
+	}
+
+	return nil
+}
+
+// IsAllowlisted reports whether an entry matches the certificate's fingerprint or public key
+// fingerprint, or its serial number with either the same issuer DN or no issuer DN
+func (r *DeviceAllowlistRepositoryImpl) IsAllowlisted(issuerDN, serialNumber, fingerprint, spkiFingerprint string) (bool, error) {
+	query := `
+		SELECT EXISTS(
+			SELECT 1 FROM device_allowlist
+			WHERE (fingerprint <> '' AND fingerprint = $3)
+				OR (spki_fingerprint <> '' AND spki_fingerprint = $4)
+				OR (serial_number <> '' AND serial_number = $2 AND issuer_dn IN ('', $1))
+		)`
+
+	var allowed bool
+	if err := r.db.QueryRow(query, issuerDN, serialNumber, fingerprint, spkiFingerprint).Scan(&allowed); err != nil {
+		return false, fmt.Errorf("failed to check device allowlist: %w", err)
+	}
+
+	return allowed, nil
+}
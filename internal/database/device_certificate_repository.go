@@ -0,0 +1,88 @@
+package database
+
+import (
+	"database/sql"
+	"fmt"
+
+	"device-assignment-api/internal/models"
+
+	"github.com/google/uuid"
+)
+
+// DeviceCertificateRepositoryImpl implements the DeviceCertificateRepository interface using PostgreSQL
+type DeviceCertificateRepositoryImpl struct {
+	db *sql.DB
+}
+
+// NewDeviceCertificateRepository creates a new DeviceCertificateRepositoryImpl
+func NewDeviceCertificateRepository(db *sql.DB) *DeviceCertificateRepositoryImpl {
+	return &DeviceCertificateRepositoryImpl{db: db}
+}
+
+// RecordCertificate stores a certificate, or updates its last seen time if already recorded
+func (r *DeviceCertificateRepositoryImpl) RecordCertificate(certificate *models.DeviceCertificate) error {
+	query := `
+		INSERT INTO device_certificates (id, device_id, serial_number, issuer_dn, not_before, not_after,
+			fingerprint_sha256, first_seen_at, last_seen_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9)
+		ON CONFLICT (device_id, fingerprint_sha256) DO UPDATE SET last_seen_at = EXCLUDED.last_seen_at`
+
+	_, err := r.db.Exec(query,
+		certificate.ID,
+		certificate.DeviceID,
+		certificate.SerialNumber,
+		certificate.IssuerDN,
+		certificate.NotBefore,
+		certificate.NotAfter,
+		certificate.Fingerprint,
+		certificate.FirstSeenAt,
+		certificate.LastSeenAt,
+	)
+	if err != nil {
+		return fmt.Errorf("failed to record device certificate: %w", err)
+	}
+
+	return nil
+}
+
+// GetCertificatesByDeviceID retrieves every certificate a device has presented, newest first
+func (r *DeviceCertificateRepositoryImpl) GetCertificatesByDeviceID(deviceID uuid.UUID) ([]*models.DeviceCertificate, error) {
+	query := `
+		SELECT id, device_id, serial_number, issuer_dn, not_before, not_after,
+			fingerprint_sha256, first_seen_at, last_seen_at
+		FROM device_certificates
+		WHERE device_id = $1
+		ORDER BY first_seen_at DESC`
+
+	rows, err := r.db.Query(query, deviceID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get device certificates: %w", err)
+	}
+	defer rows.Close()
+
+	var certificates []*models.DeviceCertificate
+	for rows.Next() {
+		certificate := &models.DeviceCertificate{}
+		err := rows.Scan(
+			&certificate.ID,
+			&certificate.DeviceID,
+			&certificate.SerialNumber,
+			&certificate.IssuerDN,
+			&certificate.NotBefore,
+			&certificate.NotAfter,
+			&certificate.Fingerprint,
+			&certificate.FirstSeenAt,
+			&certificate.LastSeenAt,
+		)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan device certificate: %w", err)
+		}
+		certificates = append(certificates, certificate)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating over device certificates: %w", err)
+	}
+
+	return certificates, nil
+}
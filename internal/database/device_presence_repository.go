@@ -0,0 +1,62 @@
+package database
+
+import (
+	"database/sql"
+	"fmt"
+	"time"
+
+	"device-assignment-api/internal/models"
+
+	"github.com/lib/pq"
+)
+
+// DevicePresenceRepositoryImpl implements the DevicePresenceRepository interface using PostgreSQL
+type DevicePresenceRepositoryImpl struct {
+	db *sql.DB
+}
+
+// NewDevicePresenceRepository creates a new DevicePresenceRepositoryImpl
+func NewDevicePresenceRepository(db *sql.DB) *DevicePresenceRepositoryImpl {
+	return &DevicePresenceRepositoryImpl{db: db}
+}
+
+// RecordHeartbeats stores the last sighting of each device with a single UPDATE, passing the
+// heartbeats as parallel arrays. An empty reported status keeps the stored one.
+func (r *DevicePresenceRepositoryImpl) RecordHeartbeats(heartbeats []*models.DeviceHeartbeat) error {
+	if len(heartbeats) == 0 {
+		return nil
+	}
+
+	deviceIDs := make([]string, len(heartbeats))
+	seenAt := make([]string, len(heartbeats))
+	sourceIPs := make([]string, len(heartbeats))
+	reportedStatuses := make([]string, len(heartbeats))
+	for i, heartbeat := range heartbeats {
+		deviceIDs[i] = heartbeat.DeviceID.String()
+		seenAt[i] = heartbeat.SeenAt.UTC().Format(time.RFC3339Nano)
+		sourceIPs[i] = heartbeat.SourceIP
+		reportedStatuses[i] = heartbeat.ReportedStatus
+	}
+
+	query := `
+		UPDATE devices AS d
+		SET last_seen_at = h.seen_at,
+			last_seen_ip = h.source_ip,
+			reported_status = COALESCE(NULLIF(h.reported_status, ''), d.reported_status)
+		FROM unnest($1::uuid[], $2::timestamptz[], $3::text[], $4::text[])
+			AS h(device_id, seen_at, source_ip, reported_status)
+		WHERE d.id = h.device_id
+			AND (d.last_seen_at IS NULL OR d.last_seen_at < h.seen_at)`
+
+	_, err := r.db.Exec(query,
+		pq.Array(deviceIDs),
+		pq.Array(seenAt),
+		pq.Array(sourceIPs),
+		pq.Array(reportedStatuses),
+	)
+	if err != nil {
+		return fmt.Errorf("failed to record device heartbeats: %w", err)
+	}
+
+	return nil
+}
@@ -3,12 +3,53 @@ package database
 import (
 	"database/sql"
 	"fmt"
+	"strings"
+	"time"
 
 	"device-assignment-api/internal/models"
 
 	"github.com/google/uuid"
+	"github.com/lib/pq"
 )
 
+// deviceColumns lists the device columns selected by every device query, prefixed with the d alias
+const deviceColumns = `d.id, d.certificate_serial_number, d.certificate_issuer_dn, d.certificate_issuer_cn,
+			d.certificate_authority_key_id, COALESCE(d.identity_key, ''), d.spiffe_id,
+			d.certificate_not_before, d.certificate_not_after, d.name, d.model, d.hardware_revision,
+			d.firmware_version, d.labels, d.status, d.last_seen_at, d.last_seen_ip, d.reported_status,
+			d.created_at`
+
+// rowScanner is satisfied by both *sql.Row and *sql.Rows
+type rowScanner interface {
+	Scan(dest ...any) error
+}
+
+// scanDevice scans the deviceColumns followed by any extra destinations
+func scanDevice(row rowScanner, device *models.Device, extra ...any) error {
+	dest := []any{
+		&device.ID,
+		&device.CertificateSerialNumber,
+		&device.CertificateIssuerDN,
+		&device.CertificateIssuerCN,
+		&device.CertificateAuthorityKeyID,
+		&device.IdentityKey,
+		&device.SPIFFEID,
+		&device.CertificateNotBefore,
+		&device.CertificateNotAfter,
+		&device.Name,
+		&device.Model,
+		&device.HardwareRevision,
+		&device.FirmwareVersion,
+		&device.Labels,
+		&device.Status,
+		&device.LastSeenAt,
+		&device.LastSeenIP,
+		&device.ReportedStatus,
+		&device.CreatedAt,
+	}
+	return row.Scan(append(dest, extra...)...)
+}
+
 // DeviceRepositoryImpl implements the DeviceRepository interface using PostgreSQL
 type DeviceRepositoryImpl struct {
 	db *sql.DB
@@ -22,10 +63,29 @@ func NewDeviceRepository(db *sql.DB) *DeviceRepositoryImpl {
 // CreateDevice stores a new device in the database
 func (r *DeviceRepositoryImpl) CreateDevice(device *models.Device) error {
 	query := `
-		INSERT INTO devices (id, certificate_serial_number, certificate_issuer_cn, created_at)
-		VALUES ($1, $2, $3, $4)`
+		INSERT INTO devices (id, certificate_serial_number, certificate_issuer_dn, certificate_issuer_cn,
+			certificate_authority_key_id, identity_key, spiffe_id, certificate_not_before, certificate_not_after,
+			name, model, hardware_revision, firmware_version, labels, status, created_at)
+		VALUES ($1, $2, $3, $4, $5, NULLIF($6, ''), $7, $8, $9, $10, $11, $12, $13, $14, $15, $16)`
 
-	_, err := r.db.Exec(query, device.ID, device.CertificateSerialNumber, device.CertificateIssuerCN, device.CreatedAt)
+	_, err := r.db.Exec(query,
+		device.ID,
+		device.CertificateSerialNumber,
+		device.CertificateIssuerDN,
+		device.CertificateIssuerCN,
+		device.CertificateAuthorityKeyID,
+		device.IdentityKey,
+		device.SPIFFEID,
+		device.CertificateNotBefore,
+		device.CertificateNotAfter,
+		device.Name,
+		device.Model,
+		device.HardwareRevision,
+		device.FirmwareVersion,
+		device.Labels,
+		device.Status,
+		device.CreatedAt,
+	)
 	if err != nil {
 		return fmt.Errorf("failed to create device: %w", err)
 	}
@@ -36,17 +96,12 @@ func (r *DeviceRepositoryImpl) CreateDevice(device *models.Device) error {
 // GetDeviceByID retrieves a device by its UUID
 func (r *DeviceRepositoryImpl) GetDeviceByID(id uuid.UUID) (*models.Device, error) {
 	query := `
-		SELECT id, certificate_serial_number, certificate_issuer_cn, created_at
-		FROM devices
-		WHERE id = $1`
+		SELECT ` + deviceColumns + `
+		FROM devices d
+		WHERE d.id = $1`
 
 	device := &models.Device{}
-	err := r.db.QueryRow(query, id).Scan(
-		&device.ID,
-		&device.CertificateSerialNumber,
-		&device.CertificateIssuerCN,
-		&device.CreatedAt,
-	)
+	err := scanDevice(r.db.QueryRow(query, id), device)
 
 	if err != nil {
 		if err == sql.ErrNoRows {
@@ -58,36 +113,152 @@ func (r *DeviceRepositoryImpl) GetDeviceByID(id uuid.UUID) (*models.Device, erro
 	return device, nil
 }
 
-// GetDeviceBySerialNumber retrieves a device by its certificate serial number
-func (r *DeviceRepositoryImpl) GetDeviceBySerialNumber(serialNumber string) (*models.Device, error) {
+// GetDeviceByIssuerAndSerial retrieves a device by its certificate issuer DN and serial number
+func (r *DeviceRepositoryImpl) GetDeviceByIssuerAndSerial(issuerDN, serialNumber string) (*models.Device, error) {
 	query := `
-		SELECT id, certificate_serial_number, certificate_issuer_cn, created_at
-		FROM devices
-		WHERE certificate_serial_number = $1`
+		SELECT ` + deviceColumns + `
+		FROM devices d
+		WHERE d.certificate_issuer_dn = $1 AND d.certificate_serial_number = $2`
 
 	device := &models.Device{}
-	err := r.db.QueryRow(query, serialNumber).Scan(
-		&device.ID,
-		&device.CertificateSerialNumber,
-		&device.CertificateIssuerCN,
-		&device.CreatedAt,
-	)
+	err := scanDevice(r.db.QueryRow(query, issuerDN, serialNumber), device)
+
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, fmt.Errorf("device not found")
+		}
+		return nil, fmt.Errorf("failed to get device by issuer and serial number: %w", err)
+	}
+
+	return device, nil
+}
+
+// GetLegacyDevice retrieves a device registered before issuer DNs were recorded,
+// matching on serial number and issuer common name
+func (r *DeviceRepositoryImpl) GetLegacyDevice(issuerCN, serialNumber string) (*models.Device, error) {
+	query := `
+		SELECT ` + deviceColumns + `
+		FROM devices d
+		WHERE d.certificate_issuer_dn = '' AND d.certificate_issuer_cn = $1 AND d.certificate_serial_number = $2`
+
+	device := &models.Device{}
+	err := scanDevice(r.db.QueryRow(query, issuerCN, serialNumber), device)
 
 	if err != nil {
 		if err == sql.ErrNoRows {
 			return nil, fmt.Errorf("device not found")
 		}
-		return nil, fmt.Errorf("failed to get device by serial number: %w", err)
+		return nil, fmt.Errorf("failed to get legacy device: %w", err)
 	}
 
 	return device, nil
 }
 
+// GetDeviceByIdentityKey retrieves a device by its stable identity key
+func (r *DeviceRepositoryImpl) GetDeviceByIdentityKey(identityKey string) (*models.Device, error) {
+	query := `
+		SELECT ` + deviceColumns + `
+		FROM devices d
+		WHERE d.identity_key = $1`
+
+	device := &models.Device{}
+	err := scanDevice(r.db.QueryRow(query, identityKey), device)
+
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, fmt.Errorf("device not found")
+		}
+		return nil, fmt.Errorf("failed to get device by identity key: %w", err)
+	}
+
+	return device, nil
+}
+
+// UpdateDeviceCertificate records the device's current certificate identity, validity, identity key and SPIFFE ID
+func (r *DeviceRepositoryImpl) UpdateDeviceCertificate(device *models.Device) error {
+	query := `
+		UPDATE devices
+		SET certificate_serial_number = $2, certificate_issuer_dn = $3, certificate_issuer_cn = $4,
+			certificate_authority_key_id = $5, identity_key = NULLIF($6, ''), spiffe_id = $7,
+			certificate_not_before = $8, certificate_not_after = $9
+		WHERE id = $1`
+
+	result, err := r.db.Exec(query,
+		device.ID,
+		device.CertificateSerialNumber,
+		device.CertificateIssuerDN,
+		device.CertificateIssuerCN,
+		device.CertificateAuthorityKeyID,
+		device.IdentityKey,
+		device.SPIFFEID,
+		device.CertificateNotBefore,
+		device.CertificateNotAfter,
+	)
+	if err != nil {
+		return fmt.Errorf("failed to update device certificate: %w", err)
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("failed to get rows affected: %w", err)
+	}
+
+	if rowsAffected == 0 {
+		return fmt.Errorf("device not found")
+	}
+
+	return nil
+}
+
+// UpdateDeviceMetadata records the device's values of the metadata fields set in the update.
+// Only those columns are written, so concurrent updates of other fields are not overwritten.
+func (r *DeviceRepositoryImpl) UpdateDeviceMetadata(device *models.Device, fields models.DeviceMetadataUpdate) error {
+	b := &queryBuilder{}
+	var sets []string
+	if fields.Name != nil {
+		sets = append(sets, "name = "+b.arg(device.Name))
+	}
+	if fields.Model != nil {
+		sets = append(sets, "model = "+b.arg(device.Model))
+	}
+	if fields.HardwareRevision != nil {
+		sets = append(sets, "hardware_revision = "+b.arg(device.HardwareRevision))
+	}
+	if fields.FirmwareVersion != nil {
+		sets = append(sets, "firmware_version = "+b.arg(device.FirmwareVersion))
+	}
+	if fields.Labels != nil {
+		sets = append(sets, "labels = "+b.arg(device.Labels))
+	}
+	if len(sets) == 0 {
+		return nil
+	}
+	b.where("id = ?", device.ID)
+
+	query := `UPDATE devices SET ` + strings.Join(sets, ", ") + ` ` + b.whereClause()
+
+	result, err := r.db.Exec(query, b.args...)
+	if err != nil {
+		return fmt.Errorf("failed to update device metadata: %w", err)
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("failed to get rows affected: %w", err)
+	}
+
+	if rowsAffected == 0 {
+		return fmt.Errorf("device not found")
+	}
+
+	return nil
+}
+
 // GetDeviceWithAssignment retrieves a device with its assignment information
 func (r *DeviceRepositoryImpl) GetDeviceWithAssignment(id uuid.UUID) (*models.DeviceWithAssignment, error) {
 	query := `
 		SELECT 
-			d.id, d.certificate_serial_number, d.certificate_issuer_cn, d.created_at,
+			` + deviceColumns + `,
 			a.id, a.user_id, a.assigned_at,
 			CASE WHEN a.unassigned_at IS NULL AND a.id IS NOT NULL THEN true ELSE false END as is_assigned
 		FROM devices d
@@ -95,11 +266,7 @@ func (r *DeviceRepositoryImpl) GetDeviceWithAssignment(id uuid.UUID) (*models.De
 		WHERE d.id = $1`
 
 	deviceWithAssignment := &models.DeviceWithAssignment{}
-	err := r.db.QueryRow(query, id).Scan(
-		&deviceWithAssignment.ID,
-		&deviceWithAssignment.CertificateSerialNumber,
-		&deviceWithAssignment.CertificateIssuerCN,
-		&deviceWithAssignment.CreatedAt,
+	err := scanDevice(r.db.QueryRow(query, id), &deviceWithAssignment.Device,
 		&deviceWithAssignment.AssignmentID,
 		&deviceWithAssignment.UserID,
 		&deviceWithAssignment.AssignedAt,
@@ -116,12 +283,12 @@ func (r *DeviceRepositoryImpl) GetDeviceWithAssignment(id uuid.UUID) (*models.De
 	return deviceWithAssignment, nil
 }
 
-// DeviceExists checks if a device exists by serial number
-func (r *DeviceRepositoryImpl) DeviceExists(serialNumber string) (bool, error) {
-	query := `SELECT EXISTS(SELECT 1 FROM devices WHERE certificate_serial_number = $1)`
+// DeviceExists checks if a device exists by certificate issuer DN and serial number
+func (r *DeviceRepositoryImpl) DeviceExists(issuerDN, serialNumber string) (bool, error) {
+	query := `SELECT EXISTS(SELECT 1 FROM devices WHERE certificate_issuer_dn = $1 AND certificate_serial_number = $2)`
 
 	var exists bool
-	err := r.db.QueryRow(query, serialNumber).Scan(&exists)
+	err := r.db.QueryRow(query, issuerDN, serialNumber).Scan(&exists)
 	if err != nil {
 		return false, fmt.Errorf("failed to check device existence: %w", err)
 	}
@@ -129,31 +296,162 @@ func (r *DeviceRepositoryImpl) DeviceExists(serialNumber string) (bool, error) {
 	return exists, nil
 }
 
-// GetDevicesByUserID retrieves all devices assigned to a specific user
-func (r *DeviceRepositoryImpl) GetDevicesByUserID(userID string) ([]*models.DeviceWithAssignment, error) {
+// deviceSortKeys maps each sort field to the SQL expression devices are ordered by and the
+// type its cursor key is cast to. Devices that never sent a heartbeat sort as last seen at the
+// Unix epoch, matching models.DeviceSort.Key.
+var deviceSortKeys = map[models.DeviceSort]struct{ expression, cast string }{
+	models.DeviceSortCreatedAt:  {"d.created_at", "timestamptz"},
+	models.DeviceSortLastSeenAt: {"COALESCE(d.last_seen_at, 'epoch'::timestamptz)", "timestamptz"},
+	models.DeviceSortName:       {"d.name", "text"},
+}
+
+// deviceListFrom joins every device with its active assignment, if any
+const deviceListFrom = `
+		FROM devices d
+		LEFT JOIN assignments a ON d.id = a.device_id AND a.unassigned_at IS NULL`
+
+// deviceFilter builds the conditions selecting the devices that match the query's filters
+func deviceFilter(query *models.DeviceQuery) *queryBuilder {
+	b := &queryBuilder{}
+
+	if query.UserID != "" {
+		b.where("a.user_id = ?", query.UserID)
+	}
+
+	if query.Assigned != nil {
+		if *query.Assigned {
+			b.where("a.id IS NOT NULL")
+		} else {
+			b.where("a.id IS NULL")
+		}
+	}
+
+	if query.Issuer != "" {
+		b.where("(d.certificate_issuer_dn = ? OR d.certificate_issuer_cn = ?)", query.Issuer, query.Issuer)
+	}
+
+	if len(query.Statuses) > 0 {
+		statuses := make([]string, len(query.Statuses))
+		for i, status := range query.Statuses {
+			statuses[i] = string(status)
+		}
+		b.where("d.status = ANY(?)", pq.Array(statuses))
+	}
+
+	if len(query.Labels) > 0 {
+		b.where("d.labels @> ?::jsonb", query.Labels)
+	}
+
+	if query.CreatedAfter != nil {
+		b.where("d.created_at > ?", *query.CreatedAfter)
+	}
+	if query.CreatedBefore != nil {
+		b.where("d.created_at < ?", *query.CreatedBefore)
+	}
+	if query.LastSeenAfter != nil {
+		b.where("d.last_seen_at > ?", *query.LastSeenAfter)
+	}
+	if query.LastSeenBefore != nil {
+		b.where("d.last_seen_at < ?", *query.LastSeenBefore)
+	}
+
+	return b
+}
+
+// QueryDevices retrieves at most query.Limit devices matching the query's filters, with their
+// current assignment information. Pages are read by keyset: the listing is ordered by the sort
+// key and device ID, and continues after the cursor's key and ID.
+func (r *DeviceRepositoryImpl) QueryDevices(query *models.DeviceQuery) ([]*models.DeviceWithAssignment, error) {
+	sortKey, ok := deviceSortKeys[query.Sort]
+	if !ok {
+		return nil, fmt.Errorf("unsupported device sort %q", query.Sort)
+	}
+
+	direction, comparison := "ASC", ">"
+	if query.Descending {
+		direction, comparison = "DESC", "<"
+	}
+
+	b := deviceFilter(query)
+	if query.After != nil {
+		b.where(fmt.Sprintf("(%s, d.id) %s (?::%s, ?::uuid)", sortKey.expression, comparison, sortKey.cast),
+			query.After.Key, query.After.ID)
+	}
+
+	sqlQuery := `
+		SELECT
+			` + deviceColumns + `,
+			a.id, a.user_id, a.assigned_at,
+			CASE WHEN a.id IS NOT NULL THEN true ELSE false END as is_assigned` +
+		deviceListFrom + `
+		` + b.whereClause() + `
+		ORDER BY ` + sortKey.expression + ` ` + direction + `, d.id ` + direction + `
+		LIMIT ` + b.arg(query.Limit)
+
+	rows, err := r.db.Query(sqlQuery, b.args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list devices: %w", err)
+	}
+	defer rows.Close()
+
+	var devices []*models.DeviceWithAssignment
+	for rows.Next() {
+		device := &models.DeviceWithAssignment{}
+		err := scanDevice(rows, &device.Device,
+			&device.AssignmentID,
+			&device.UserID,
+			&device.AssignedAt,
+			&device.IsAssigned,
+		)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan device: %w", err)
+		}
+		devices = append(devices, device)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating over devices: %w", err)
+	}
+
+	return devices, nil
+}
+
+// CountDevices counts every device matching the query's filters
+func (r *DeviceRepositoryImpl) CountDevices(query *models.DeviceQuery) (int, error) {
+	b := deviceFilter(query)
+
+	var count int
+	err := r.db.QueryRow(`SELECT COUNT(*)`+deviceListFrom+` `+b.whereClause(), b.args...).Scan(&count)
+	if err != nil {
+		return 0, fmt.Errorf("failed to count devices: %w", err)
+	}
+
+	return count, nil
+}
+
+// ListDevicesExpiringBetween retrieves devices whose certificate expires after from and no
+// later than to, soonest first
+func (r *DeviceRepositoryImpl) ListDevicesExpiringBetween(from, to time.Time) ([]*models.DeviceWithAssignment, error) {
 	query := `
-		SELECT 
-			d.id, d.certificate_serial_number, d.certificate_issuer_cn, d.created_at,
-			a.id, a.user_id, a.assigned_at, true as is_assigned
+		SELECT
+			` + deviceColumns + `,
+			a.id, a.user_id, a.assigned_at,
+			CASE WHEN a.id IS NOT NULL THEN true ELSE false END as is_assigned
 		FROM devices d
-		INNER JOIN assignments a ON d.id = a.device_id
-		WHERE a.user_id = $1 AND a.unassigned_at IS NULL
-		ORDER BY a.assigned_at DESC`
+		LEFT JOIN assignments a ON d.id = a.device_id AND a.unassigned_at IS NULL
+		WHERE d.certificate_not_after > $1 AND d.certificate_not_after <= $2
+		ORDER BY d.certificate_not_after ASC`
 
-	rows, err := r.db.Query(query, userID)
+	rows, err := r.db.Query(query, from, to)
 	if err != nil {
-		return nil, fmt.Errorf("failed to get devices by user ID: %w", err)
+		return nil, fmt.Errorf("failed to list expiring devices: %w", err)
 	}
 	defer rows.Close()
 
 	var devices []*models.DeviceWithAssignment
 	for rows.Next() {
 		device := &models.DeviceWithAssignment{}
-		err := rows.Scan(
-			&device.ID,
-			&device.CertificateSerialNumber,
-			&device.CertificateIssuerCN,
-			&device.CreatedAt,
+		err := scanDevice(rows, &device.Device,
 			&device.AssignmentID,
 			&device.UserID,
 			&device.AssignedAt,
@@ -0,0 +1,123 @@
+package database
+
+import (
+	"database/sql"
+	"fmt"
+
+	"device-assignment-api/internal/models"
+
+	"github.com/google/uuid"
+)
+
+// DeviceStatusRepositoryImpl implements the DeviceStatusRepository interface using PostgreSQL
+type DeviceStatusRepositoryImpl struct {
+	db *sql.DB
+}
+
+// NewDeviceStatusRepository creates a new DeviceStatusRepositoryImpl
+func NewDeviceStatusRepository(db *sql.DB) *DeviceStatusRepositoryImpl {
+	return &DeviceStatusRepositoryImpl{db: db}
+}
+
+// TransitionDeviceStatus moves the device to the transition's target state and records the
+// transition in the same transaction. The update only applies while the device is still in
+// the transition's starting state, so concurrent transitions cannot both succeed.
+// Decommissioning also ends the device's active assignment in the transaction; the updated
+// device row stays locked until commit, so an assignment cannot slip in after it.
+func (r *DeviceStatusRepositoryImpl) TransitionDeviceStatus(transition *models.DeviceStatusTransition) error {
+	tx, err := r.db.Begin()
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	result, err := tx.Exec(`UPDATE devices SET status = $2 WHERE id = $1 AND status = $3`,
+		transition.DeviceID,
+		transition.ToStatus,
+		transition.FromStatus,
+	)
+	if err != nil {
+		return fmt.Errorf("failed to update device status: %w", err)
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("failed to get rows affected: %w", err)
+	}
+
+	if rowsAffected == 0 {
+		return fmt.Errorf("device status changed concurrently")
+	}
+
+	query := `
+		INSERT INTO device_status_transitions (id, device_id, from_status, to_status, changed_by, reason, created_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7)`
+
+	_, err = tx.Exec(query,
+		transition.ID,
+		transition.DeviceID,
+		transition.FromStatus,
+		transition.ToStatus,
+		transition.ChangedBy,
+		transition.Reason,
+		transition.CreatedAt,
+	)
+	if err != nil {
+		return fmt.Errorf("failed to record device status transition: %w", err)
+	}
+
+	if transition.ToStatus == models.DeviceStatusDecommissioned {
+		_, err = tx.Exec(`
+			UPDATE assignments
+			SET unassigned_at = NOW()
+			WHERE device_id = $1 AND unassigned_at IS NULL`, transition.DeviceID)
+		if err != nil {
+			return fmt.Errorf("failed to unassign decommissioned device: %w", err)
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit device status transition: %w", err)
+	}
+
+	return nil
+}
+
+// GetStatusTransitionsByDeviceID retrieves every status transition of a device, newest first
+func (r *DeviceStatusRepositoryImpl) GetStatusTransitionsByDeviceID(deviceID uuid.UUID) ([]*models.DeviceStatusTransition, error) {
+	query := `
+		SELECT id, device_id, from_status, to_status, changed_by, reason, created_at
+		FROM device_status_transitions
+		WHERE device_id = $1
+		ORDER BY created_at DESC`
+
+	rows, err := r.db.Query(query, deviceID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get device status transitions: %w", err)
+	}
+	defer rows.Close()
+
+	var transitions []*models.DeviceStatusTransition
+	for rows.Next() {
+		transition := &models.DeviceStatusTransition{}
+		err := rows.Scan(
+			&transition.ID,
+			&transition.DeviceID,
+			&transition.FromStatus,
+			&transition.ToStatus,
+			&transition.ChangedBy,
+			&transition.Reason,
+			&transition.CreatedAt,
+		)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan device status transition: %w", err)
+		}
+		transitions = append(transitions, transition)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating over device status transitions: %w", err)
+	}
+
+	return transitions, nil
+}
@@ -44,6 +44,21 @@ func (p *PostgresDB) RunMigrations() error {
 		createDevicesTable,
 		createAssignmentsTable,
 		createIndexes,
+		addDeviceIssuerIdentity,
+		addDeviceIdentityBinding,
+		createUsersTable,
+		createSessionTables,
+		addSessionCertificateBinding,
+		createAPIKeysTable,
+		addDeviceSPIFFEID,
+		addDeviceCertificateValidity,
+		addDeviceMetadata,
+		addDeviceStatus,
+		addDevicePresence,
+		addDeviceListIndexes,
+		addDeviceRegistration,
+		scopeDeviceIdentityKeys,
+		addAllowlistSPKIFingerprint,
 	}
 
 	for _, migration := range migrations {
@@ -77,3 +92,206 @@ CREATE INDEX IF NOT EXISTS idx_devices_serial_number ON devices(certificate_seri
 CREATE INDEX IF NOT EXISTS idx_assignments_device_id ON assignments(device_id);
 CREATE INDEX IF NOT EXISTS idx_assignments_user_id ON assignments(user_id);
 CREATE INDEX IF NOT EXISTS idx_assignments_active ON assignments(device_id) WHERE unassigned_at IS NULL;`
+
+// addDeviceIssuerIdentity keys devices on (issuer DN, serial) instead of serial alone.
+// Existing rows are deliberately not backfilled: only the issuer CN was stored, and the DN
+// cannot be derived from it. They keep an empty issuer DN and are found by GetLegacyDevice on
+// issuer CN and serial number until the device next authenticates, which stores the issuer DN
+// of its certificate through UpdateDeviceCertificate. Rows of devices that never authenticate
+// again stay matched by issuer CN.
+const addDeviceIssuerIdentity = `
+ALTER TABLE devices ADD COLUMN IF NOT EXISTS certificate_issuer_dn TEXT NOT NULL DEFAULT '';
+ALTER TABLE devices ADD COLUMN IF NOT EXISTS certificate_authority_key_id VARCHAR(128) NOT NULL DEFAULT '';
+ALTER TABLE devices DROP CONSTRAINT IF EXISTS devices_certificate_serial_number_key;
+CREATE UNIQUE INDEX IF NOT EXISTS idx_devices_issuer_serial ON devices(certificate_issuer_dn, certificate_serial_number);`
+
+// addDeviceIdentityBinding adds the stable identity key used to keep a device across
+// certificate renewals, and the history of certificates each device has presented
+const addDeviceIdentityBinding = `
+ALTER TABLE devices ADD COLUMN IF NOT EXISTS identity_key TEXT NULL;
+CREATE UNIQUE INDEX IF NOT EXISTS idx_devices_identity_key ON devices(identity_key) WHERE identity_key IS NOT NULL;
+
+CREATE TABLE IF NOT EXISTS device_certificates (
+    id UUID PRIMARY KEY,
+    device_id UUID NOT NULL REFERENCES devices(id) ON DELETE CASCADE,
+    serial_number VARCHAR(255) NOT NULL,
+    issuer_dn TEXT NOT NULL,
+    not_before TIMESTAMP WITH TIME ZONE NOT NULL,
+    not_after TIMESTAMP WITH TIME ZONE NOT NULL,
+    fingerprint_sha256 CHAR(64) NOT NULL,
+    first_seen_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW(),
+    last_seen_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW(),
+    UNIQUE (device_id, fingerprint_sha256)
+);
+CREATE INDEX IF NOT EXISTS idx_device_certificates_device_id ON device_certificates(device_id);`
+
+// createUsersTable stores locally managed user accounts for password login
+const createUsersTable = `
+CREATE TABLE IF NOT EXISTS users (
+    id UUID PRIMARY KEY,
+    username VARCHAR(255) NOT NULL UNIQUE,
+    password_hash TEXT NOT NULL,
+    roles TEXT[] NOT NULL DEFAULT '{}',
+    created_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW(),
+    password_changed_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW()
+);`
+
+// createSessionTables stores login sessions, their rotating refresh tokens (hashed) and the
+// denylist of access tokens revoked before they expire
+const createSessionTables = `
+CREATE TABLE IF NOT EXISTS sessions (
+    id UUID PRIMARY KEY,
+    user_id VARCHAR(255) NOT NULL,
+    user_agent TEXT NOT NULL DEFAULT '',
+    ip_address VARCHAR(64) NOT NULL DEFAULT '',
+    created_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW(),
+    last_used_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW(),
+    expires_at TIMESTAMP WITH TIME ZONE NOT NULL,
+    revoked_at TIMESTAMP WITH TIME ZONE NULL
+);
+CREATE INDEX IF NOT EXISTS idx_sessions_user_id ON sessions(user_id);
+
+CREATE TABLE IF NOT EXISTS refresh_tokens (
+    id UUID PRIMARY KEY,
+    session_id UUID NOT NULL REFERENCES sessions(id) ON DELETE CASCADE,
+    token_hash CHAR(64) NOT NULL UNIQUE,
+    created_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW(),
+    expires_at TIMESTAMP WITH TIME ZONE NOT NULL,
+    used_at TIMESTAMP WITH TIME ZONE NULL
+);
+CREATE INDEX IF NOT EXISTS idx_refresh_tokens_session_id ON refresh_tokens(session_id);
+
+CREATE TABLE IF NOT EXISTS revoked_tokens (
+    token_id VARCHAR(255) PRIMARY KEY,
+    expires_at TIMESTAMP WITH TIME ZONE NOT NULL
+);`
+
+// addSessionCertificateBinding records the thumbprint of the client certificate a session's
+// tokens are bound to
+const addSessionCertificateBinding = `
+ALTER TABLE sessions ADD COLUMN IF NOT EXISTS certificate_thumbprint VARCHAR(64) NOT NULL DEFAULT '';`
+
+// createAPIKeysTable stores personal access tokens and service API keys (hashed). Names are
+// unique among a user's unrevoked keys.
+const createAPIKeysTable = `
+CREATE TABLE IF NOT EXISTS api_keys (
+    id UUID PRIMARY KEY,
+    kind VARCHAR(16) NOT NULL,
+    name VARCHAR(255) NOT NULL,
+    user_id VARCHAR(255) NOT NULL,
+    prefix VARCHAR(32) NOT NULL,
+    key_hash CHAR(64) NOT NULL UNIQUE,
+    scopes TEXT[] NOT NULL DEFAULT '{}',
+    created_by VARCHAR(255) NOT NULL,
+    created_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW(),
+    expires_at TIMESTAMP WITH TIME ZONE NULL,
+    last_used_at TIMESTAMP WITH TIME ZONE NULL,
+    revoked_at TIMESTAMP WITH TIME ZONE NULL
+);
+CREATE INDEX IF NOT EXISTS idx_api_keys_user_id ON api_keys(user_id);
+CREATE UNIQUE INDEX IF NOT EXISTS idx_api_keys_active_name ON api_keys(kind, user_id, name) WHERE revoked_at IS NULL;`
+
+// addDeviceSPIFFEID records the SPIFFE ID of devices authenticating with an X.509-SVID
+const addDeviceSPIFFEID = `
+ALTER TABLE devices ADD COLUMN IF NOT EXISTS spiffe_id TEXT NOT NULL DEFAULT '';
+CREATE INDEX IF NOT EXISTS idx_devices_spiffe_id ON devices(spiffe_id) WHERE spiffe_id <> '';`
+
+// addDeviceCertificateValidity records the validity period of each device's current
+// certificate. Rows created before this migration stay NULL until the device next authenticates.
+const addDeviceCertificateValidity = `
+ALTER TABLE devices ADD COLUMN IF NOT EXISTS certificate_not_before TIMESTAMP WITH TIME ZONE NULL;
+ALTER TABLE devices ADD COLUMN IF NOT EXISTS certificate_not_after TIMESTAMP WITH TIME ZONE NULL;
+CREATE INDEX IF NOT EXISTS idx_devices_certificate_not_after ON devices(certificate_not_after) WHERE certificate_not_after IS NOT NULL;`
+
+// addDeviceMetadata adds the user-editable name and labels and the device-reported model,
+// hardware revision and firmware version. The GIN index serves label containment (@>) filters.
+const addDeviceMetadata = `
+ALTER TABLE devices ADD COLUMN IF NOT EXISTS name TEXT NOT NULL DEFAULT '';
+ALTER TABLE devices ADD COLUMN IF NOT EXISTS model TEXT NOT NULL DEFAULT '';
+ALTER TABLE devices ADD COLUMN IF NOT EXISTS hardware_revision TEXT NOT NULL DEFAULT '';
+ALTER TABLE devices ADD COLUMN IF NOT EXISTS firmware_version TEXT NOT NULL DEFAULT '';
+ALTER TABLE devices ADD COLUMN IF NOT EXISTS labels JSONB NOT NULL DEFAULT '{}'::jsonb;
+CREATE INDEX IF NOT EXISTS idx_devices_labels ON devices USING GIN (labels jsonb_path_ops);`
+
+// addDeviceStatus adds the device lifecycle state, with existing devices left active, and the
+// history of status transitions
+const addDeviceStatus = `
+ALTER TABLE devices ADD COLUMN IF NOT EXISTS status TEXT NOT NULL DEFAULT 'active'
+    CHECK (status IN ('provisioned', 'active', 'suspended', 'lost', 'decommissioned'));
+CREATE INDEX IF NOT EXISTS idx_devices_status ON devices(status);
+
+CREATE TABLE IF NOT EXISTS device_status_transitions (
+    id UUID PRIMARY KEY,
+    device_id UUID NOT NULL REFERENCES devices(id) ON DELETE CASCADE,
+    from_status TEXT NOT NULL,
+    to_status TEXT NOT NULL,
+    changed_by TEXT NOT NULL,
+    reason TEXT NOT NULL,
+    created_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS idx_device_status_transitions_device_id ON device_status_transitions(device_id, created_at DESC);`
+
+// addDevicePresence adds the last heartbeat of each device: when and from where it was seen,
+// and the status it reported
+const addDevicePresence = `
+ALTER TABLE devices ADD COLUMN IF NOT EXISTS last_seen_at TIMESTAMP WITH TIME ZONE NULL;
+ALTER TABLE devices ADD COLUMN IF NOT EXISTS last_seen_ip TEXT NOT NULL DEFAULT '';
+ALTER TABLE devices ADD COLUMN IF NOT EXISTS reported_status TEXT NOT NULL DEFAULT '';`
+
+// addDeviceListIndexes supports paging device listings by creation time and name, and
+// filtering them by last sighting and issuer
+const addDeviceListIndexes = `
+CREATE INDEX IF NOT EXISTS idx_devices_created_at_id ON devices(created_at, id);
+CREATE INDEX IF NOT EXISTS idx_devices_name_id ON devices(name, id);
+CREATE INDEX IF NOT EXISTS idx_devices_last_seen_at ON devices(last_seen_at);
+CREATE INDEX IF NOT EXISTS idx_devices_certificate_issuer_cn ON devices(certificate_issuer_cn);`
+
+// addDeviceRegistration allows the pending and rejected states of registration approval and
+// adds the allowlist of certificates admitted in allowlist mode. The widened status check is
+// added NOT VALID, since every existing row already satisfies it.
+const addDeviceRegistration = `
+ALTER TABLE devices DROP CONSTRAINT IF EXISTS devices_status_check;
+ALTER TABLE devices ADD CONSTRAINT devices_status_check
+    CHECK (status IN ('pending', 'rejected', 'provisioned', 'active', 'suspended', 'lost', 'decommissioned')) NOT VALID;
+
+CREATE TABLE IF NOT EXISTS device_allowlist (
+    id UUID PRIMARY KEY,
+    serial_number TEXT NOT NULL DEFAULT '',
+    issuer_dn TEXT NOT NULL DEFAULT '',
+    fingerprint TEXT NOT NULL DEFAULT '',
+    note TEXT NOT NULL DEFAULT '',
+    created_by TEXT NOT NULL,
+    created_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW(),
+    CHECK (serial_number <> '' OR fingerprint <> '')
+);
+
+CREATE INDEX IF NOT EXISTS idx_device_allowlist_fingerprint ON device_allowlist(fingerprint) WHERE fingerprint <> '';`
+
+// scopeDeviceIdentityKeys scopes common name and SAN identity keys by the hex SHA-256 of the
+// device's issuer DN, so that devices of different CAs sharing a name no longer collide.
+// Unscoped keys use a ":" after the prefix and scoped keys a "/", so the update runs once.
+const scopeDeviceIdentityKeys = `
+UPDATE devices
+SET identity_key = CASE
+    WHEN identity_key LIKE 'cn:%' THEN
+        'cn/' || encode(sha256(convert_to(certificate_issuer_dn, 'UTF8')), 'hex') || ':' || substr(identity_key, 4)
+    ELSE
+        'san/' || encode(sha256(convert_to(certificate_issuer_dn, 'UTF8')), 'hex') || ':' || substr(identity_key, 5)
+    END
+WHERE (identity_key LIKE 'cn:%' OR identity_key LIKE 'san:%') AND certificate_issuer_dn <> '';`
+
+// addAllowlistSPKIFingerprint lets allowlist entries match the SHA-256 fingerprint of a
+// device's public key, which EST enrollment can check before a certificate is issued. The
+// unique entry index replaces the one without the column; it is created here rather than with
+// the table so that later runs of addDeviceRegistration do not bring the old index back.
+const addAllowlistSPKIFingerprint = `
+ALTER TABLE device_allowlist ADD COLUMN IF NOT EXISTS spki_fingerprint TEXT NOT NULL DEFAULT '';
+
+ALTER TABLE device_allowlist DROP CONSTRAINT IF EXISTS device_allowlist_check;
+ALTER TABLE device_allowlist ADD CONSTRAINT device_allowlist_check
+    CHECK (serial_number <> '' OR fingerprint <> '' OR spki_fingerprint <> '') NOT VALID;
+
+DROP INDEX IF EXISTS idx_device_allowlist_entry;
+CREATE UNIQUE INDEX IF NOT EXISTS idx_device_allowlist_certificate ON device_allowlist(serial_number, issuer_dn, fingerprint, spki_fingerprint);
+CREATE INDEX IF NOT EXISTS idx_device_allowlist_spki_fingerprint ON device_allowlist(spki_fingerprint) WHERE spki_fingerprint <> '';`
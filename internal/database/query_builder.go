@@ -0,0 +1,39 @@
+package database
+
+import (
+	"fmt"
+	"strings"
+)
+
+// queryBuilder accumulates the conditions of a WHERE clause together with their arguments,
+// numbering the placeholders as they are added
+type queryBuilder struct {
+	conditions []string
+	args       []any
+}
+
+// where adds a condition in which each ? is replaced by a placeholder for the next argument
+func (b *queryBuilder) where(condition string, args ...any) {
+	var clause strings.Builder
+	for i, part := range strings.Split(condition, "?") {
+		if i > 0 {
+			clause.WriteString(b.arg(args[i-1]))
+		}
+		clause.WriteString(part)
+	}
+	b.conditions = append(b.conditions, clause.String())
+}
+
+// arg adds an argument and returns its placeholder
+func (b *queryBuilder) arg(value any) string {
+	b.args = append(b.args, value)
+	return fmt.Sprintf("$%d", len(b.args))
+}
+
+// whereClause returns the conditions joined by AND, or nothing when there are none
+func (b *queryBuilder) whereClause() string {
+	if len(b.conditions) == 0 {
+		return ""
+	}
+	return "WHERE " + strings.Join(b.conditions, " AND ")
+}
@@ -0,0 +1,250 @@
+package database
+
+import (
+	"database/sql"
+	"fmt"
+	"time"
+
+	"device-assignment-api/internal/models"
+
+	"github.com/google/uuid"
+)
+
+// sessionColumns lists the session columns selected by every session query
+const sessionColumns = `id, user_id, user_agent, ip_address, certificate_thumbprint, created_at, last_used_at, expires_at, revoked_at`
+
+// scanSession scans the sessionColumns into a session
+func scanSession(row rowScanner, session *models.Session) error {
+	return row.Scan(
+		&session.ID,
+		&session.UserID,
+		&session.UserAgent,
+		&session.IPAddress,
+		&session.CertificateThumbprint,
+		&session.CreatedAt,
+		&session.LastUsedAt,
+		&session.ExpiresAt,
+		&session.RevokedAt,
+	)
+}
+
+// SessionRepositoryImpl implements the SessionRepository interface using PostgreSQL
+type SessionRepositoryImpl struct {
+	db *sql.DB
+}
+
+// NewSessionRepository creates a new SessionRepositoryImpl
+func NewSessionRepository(db *sql.DB) *SessionRepositoryImpl {
+	return &SessionRepositoryImpl{db: db}
+}
+
+// CreateSession stores a new session in the database
+func (r *SessionRepositoryImpl) CreateSession(session *models.Session) error {
+	query := `
+		INSERT INTO sessions (id, user_id, user_agent, ip_address, certificate_thumbprint, created_at, last_used_at, expires_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8)`
+
+	_, err := r.db.Exec(query,
+		session.ID,
+		session.UserID,
+		session.UserAgent,
+		session.IPAddress,
+		session.CertificateThumbprint,
+		session.CreatedAt,
+		session.LastUsedAt,
+		session.ExpiresAt,
+	)
+	if err != nil {
+		return fmt.Errorf("failed to create session: %w", err)
+	}
+
+	return nil
+}
+
+// GetSession retrieves a session by its UUID
+func (r *SessionRepositoryImpl) GetSession(id uuid.UUID) (*models.Session, error) {
+	query := `SELECT ` + sessionColumns + ` FROM sessions WHERE id = $1`
+
+	session := &models.Session{}
+	err := scanSession(r.db.QueryRow(query, id), session)
+
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, fmt.Errorf("session not found")
+		}
+		return nil, fmt.Errorf("failed to get session: %w", err)
+	}
+
+	return session, nil
+}
+
+// GetActiveSessionsByUserID retrieves a user's sessions that are neither revoked nor expired
+func (r *SessionRepositoryImpl) GetActiveSessionsByUserID(userID string) ([]*models.Session, error) {
+	query := `
+		SELECT ` + sessionColumns + `
+		FROM sessions
+		WHERE user_id = $1 AND revoked_at IS NULL AND expires_at > NOW()
+		ORDER BY last_used_at DESC`
+
+	rows, err := r.db.Query(query, userID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get sessions by user ID: %w", err)
+	}
+	defer rows.Close()
+
+	var sessions []*models.Session
+	for rows.Next() {
+		session := &models.Session{}
+		if err := scanSession(rows, session); err != nil {
+			return nil, fmt.Errorf("failed to scan session: %w", err)
+		}
+		sessions = append(sessions, session)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating over sessions: %w", err)
+	}
+
+	return sessions, nil
+}
+
+// ExtendSession records that a session was used and moves its expiry
+func (r *SessionRepositoryImpl) ExtendSession(id uuid.UUID, expiresAt time.Time) error {
+	query := `
+		UPDATE sessions
+		SET last_used_at = NOW(), expires_at = $2
+		WHERE id = $1`
+
+	if _, err := r.db.Exec(query, id, expiresAt); err != nil {
+		return fmt.Errorf("failed to extend session: %w", err)
+	}
+
+	return nil
+}
+
+// RevokeSession marks a session as revoked
+func (r *SessionRepositoryImpl) RevokeSession(id uuid.UUID) error {
+	query := `
+		UPDATE sessions
+		SET revoked_at = NOW()
+		WHERE id = $1 AND revoked_at IS NULL`
+
+	if _, err := r.db.Exec(query, id); err != nil {
+		return fmt.Errorf("failed to revoke session: %w", err)
+	}
+
+	return nil
+}
+
+// RevokeUserSessions marks every active session of a user as revoked, except the session with
+// exceptID
+func (r *SessionRepositoryImpl) RevokeUserSessions(userID string, exceptID uuid.UUID) error {
+	query := `
+		UPDATE sessions
+		SET revoked_at = NOW()
+		WHERE user_id = $1 AND id <> $2 AND revoked_at IS NULL`
+
+	if _, err := r.db.Exec(query, userID, exceptID); err != nil {
+		return fmt.Errorf("failed to revoke user sessions: %w", err)
+	}
+
+	return nil
+}
+
+// CreateRefreshToken stores a new refresh token
+func (r *SessionRepositoryImpl) CreateRefreshToken(token *models.RefreshToken) error {
+	query := `
+		INSERT INTO refresh_tokens (id, session_id, token_hash, created_at, expires_at)
+		VALUES ($1, $2, $3, $4, $5)`
+
+	_, err := r.db.Exec(query,
+		token.ID,
+		token.SessionID,
+		token.TokenHash,
+		token.CreatedAt,
+		token.ExpiresAt,
+	)
+	if err != nil {
+		return fmt.Errorf("failed to create refresh token: %w", err)
+	}
+
+	return nil
+}
+
+// GetRefreshTokenByHash retrieves a refresh token by the hash of its value
+func (r *SessionRepositoryImpl) GetRefreshTokenByHash(tokenHash string) (*models.RefreshToken, error) {
+	query := `
+		SELECT id, session_id, token_hash, created_at, expires_at, used_at
+		FROM refresh_tokens
+		WHERE token_hash = $1`
+
+	token := &models.RefreshToken{}
+	err := r.db.QueryRow(query, tokenHash).Scan(
+		&token.ID,
+		&token.SessionID,
+		&token.TokenHash,
+		&token.CreatedAt,
+		&token.ExpiresAt,
+		&token.UsedAt,
+	)
+
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, fmt.Errorf("refresh token not found")
+		}
+		return nil, fmt.Errorf("failed to get refresh token: %w", err)
+	}
+
+	return token, nil
+}
+
+// MarkRefreshTokenUsed marks a refresh token as used, reporting false if it was already used
+func (r *SessionRepositoryImpl) MarkRefreshTokenUsed(id uuid.UUID) (bool, error) {
+	query := `
+		UPDATE refresh_tokens
+		SET used_at = NOW()
+		WHERE id = $1 AND used_at IS NULL`
+
+	result, err := r.db.Exec(query, id)
+	if err != nil {
+		return false, fmt.Errorf("failed to mark refresh token used: %w", err)
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return false, fmt.Errorf("failed to get rows affected: %w", err)
+	}
+
+	return rowsAffected == 1, nil
+}
+
+// RevokeAccessToken adds an access token ID (jti) to the denylist until the token expires.
+// Entries for tokens that have expired anyway are pruned at the same time.
+func (r *SessionRepositoryImpl) RevokeAccessToken(tokenID string, expiresAt time.Time) error {
+	query := `
+		INSERT INTO revoked_tokens (token_id, expires_at)
+		VALUES ($1, $2)
+		ON CONFLICT (token_id) DO NOTHING`
+
+	if _, err := r.db.Exec(query, tokenID, expiresAt); err != nil {
+		return fmt.Errorf("failed to revoke access token: %w", err)
+	}
+
+	if _, err := r.db.Exec(`DELETE FROM revoked_tokens WHERE expires_at < NOW()`); err != nil {
+		return fmt.Errorf("failed to prune revoked tokens: %w", err)
+	}
+
+	return nil
+}
+
+// IsAccessTokenRevoked checks whether an access token ID is denylisted
+func (r *SessionRepositoryImpl) IsAccessTokenRevoked(tokenID string) (bool, error) {
+	query := `SELECT EXISTS(SELECT 1 FROM revoked_tokens WHERE token_id = $1)`
+
+	var revoked bool
+	if err := r.db.QueryRow(query, tokenID).Scan(&revoked); err != nil {
+		return false, fmt.Errorf("failed to check access token revocation: %w", err)
+	}
+
+	return revoked, nil
+}
@@ -0,0 +1,128 @@
+package database
+
+import (
+	"database/sql"
+	"fmt"
+
+	"device-assignment-api/internal/models"
+
+	"github.com/google/uuid"
+	"github.com/lib/pq"
+)
+
+// userColumns lists the user columns selected by every user query
+const userColumns = `id, username, password_hash, roles, created_at, password_changed_at`
+
+// scanUser scans the userColumns into a user
+func scanUser(row rowScanner, user *models.User) error {
+	return row.Scan(
+		&user.ID,
+		&user.Username,
+		&user.PasswordHash,
+		pq.Array(&user.Roles),
+		&user.CreatedAt,
+		&user.PasswordChangedAt,
+	)
+}
+
+// UserRepositoryImpl implements the UserRepository interface using PostgreSQL
+type UserRepositoryImpl struct {
+	db *sql.DB
+}
+
+// NewUserRepository creates a new UserRepositoryImpl
+func NewUserRepository(db *sql.DB) *UserRepositoryImpl {
+	return &UserRepositoryImpl{db: db}
+}
+
+// CreateUser stores a new user in the database
+func (r *UserRepositoryImpl) CreateUser(user *models.User) error {
+	query := `
+		INSERT INTO users (id, username, password_hash, roles, created_at, password_changed_at)
+		VALUES ($1, $2, $3, $4, $5, $6)`
+
+	_, err := r.db.Exec(query,
+		user.ID,
+		user.Username,
+		user.PasswordHash,
+		pq.Array(user.Roles),
+		user.CreatedAt,
+		user.PasswordChangedAt,
+	)
+	if err != nil {
+		if pqErr, ok := err.(*pq.Error); ok && pqErr.Code == "23505" {
+			return fmt.Errorf("username already exists")
+		}
+		return fmt.Errorf("failed to create user: %w", err)
+	}
+
+	return nil
+}
+
+// GetUserByID retrieves a user by its UUID
+func (r *UserRepositoryImpl) GetUserByID(id uuid.UUID) (*models.User, error) {
+	query := `SELECT ` + userColumns + ` FROM users WHERE id = $1`
+
+	user := &models.User{}
+	err := scanUser(r.db.QueryRow(query, id), user)
+
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, fmt.Errorf("user not found")
+		}
+		return nil, fmt.Errorf("failed to get user by ID: %w", err)
+	}
+
+	return user, nil
+}
+
+// GetUserByUsername retrieves a user by username
+func (r *UserRepositoryImpl) GetUserByUsername(username string) (*models.User, error) {
+	query := `SELECT ` + userColumns + ` FROM users WHERE username = $1`
+
+	user := &models.User{}
+	err := scanUser(r.db.QueryRow(query, username), user)
+
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, fmt.Errorf("user not found")
+		}
+		return nil, fmt.Errorf("failed to get user by username: %w", err)
+	}
+
+	return user, nil
+}
+
+// UpdatePassword replaces a user's password hash
+func (r *UserRepositoryImpl) UpdatePassword(id uuid.UUID, passwordHash string) error {
+	query := `
+		UPDATE users
+		SET password_hash = $2, password_changed_at = NOW()
+		WHERE id = $1`
+
+	result, err := r.db.Exec(query, id, passwordHash)
+	if err != nil {
+		return fmt.Errorf("failed to update password: %w", err)
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("failed to get rows affected: %w", err)
+	}
+
+	if rowsAffected == 0 {
+		return fmt.Errorf("user not found")
+	}
+
+	return nil
+}
+
+// CountUsers returns the number of user accounts
+func (r *UserRepositoryImpl) CountUsers() (int, error) {
+	var count int
+	if err := r.db.QueryRow(`SELECT COUNT(*) FROM users`).Scan(&count); err != nil {
+		return 0, fmt.Errorf("failed to count users: %w", err)
+	}
+
+	return count, nil
+}
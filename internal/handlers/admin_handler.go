@@ -0,0 +1,545 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"device-assignment-api/internal/middleware"
+	"device-assignment-api/internal/models"
+	"device-assignment-api/internal/services"
+	"device-assignment-api/pkg/auth"
+	"device-assignment-api/pkg/logger"
+
+	"github.com/google/uuid"
+	"github.com/gorilla/mux"
+)
+
+// maxAdminRequestSize bounds the size of an admin request body
+const maxAdminRequestSize = 4 * 1024
+
+const (
+	// maxAllowlistImportSize bounds the size of an allowlist import body
+	maxAllowlistImportSize = 1024 * 1024
+	// maxAllowlistImportEntries bounds the number of entries imported at once
+	maxAllowlistImportEntries = 1000
+)
+
+const (
+	// defaultExpiryWindow is the look-ahead of the expiring devices listing when none is given
+	defaultExpiryWindow = 30 * 24 * time.Hour
+	// maxExpiryWindow bounds the look-ahead of the expiring devices listing
+	maxExpiryWindow = 365 * 24 * time.Hour
+)
+
+// ChangeDeviceStatusRequest is the body of an admin device status change
+type ChangeDeviceStatusRequest struct {
+	Status string `json:"status"`
+	Reason string `json:"reason"`
+}
+
+// ReviewRegistrationRequest is the optional body of a registration approval or rejection
+type ReviewRegistrationRequest struct {
+	Reason string `json:"reason"`
+}
+
+// AllowlistEntryRequest admits a device by certificate serial number, optionally limited to
+// one issuer DN, by certificate fingerprint or by public key fingerprint
+type AllowlistEntryRequest struct {
+	SerialNumber    string `json:"serial_number"`
+	IssuerDN        string `json:"issuer_dn"`
+	Fingerprint     string `json:"fingerprint"`
+	SPKIFingerprint string `json:"spki_fingerprint"`
+	Note            string `json:"note"`
+}
+
+// ImportAllowlistRequest is the body of a device allowlist import
+type ImportAllowlistRequest struct {
+	Entries []AllowlistEntryRequest `json:"entries"`
+}
+
+// AssignDeviceRequest is the body of an admin assignment request
+type AssignDeviceRequest struct {
+	UserID string `json:"user_id"`
+}
+
+// AdminHandler handles administrative device and assignment requests
+type AdminHandler struct {
+	deviceService *services.DeviceService
+	certPolicy    *auth.PolicyEngine
+	logger        logger.Logger
+}
+
+// NewAdminHandler creates a new AdminHandler. The certificate policy engine may be nil when
+// no admission policy is configured.
+func NewAdminHandler(deviceService *services.DeviceService, certPolicy *auth.PolicyEngine, logger logger.Logger) *AdminHandler {
+	return &AdminHandler{
+		deviceService: deviceService,
+		certPolicy:    certPolicy,
+		logger:        logger,
+	}
+}
+
+// ListDevices handles the all-devices listing endpoint
+// GET /api/v1/admin/devices
+func (h *AdminHandler) ListDevices(w http.ResponseWriter, r *http.Request) {
+	query, err := deviceQuery(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	page, err := h.deviceService.ListDevices(query)
+	if err != nil {
+		h.logger.Error("Failed to list devices", "error", err)
+		http.Error(w, "Failed to retrieve devices", http.StatusInternalServerError)
+		return
+	}
+
+	// Return devices
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	if err := json.NewEncoder(w).Encode(page); err != nil {
+		h.logger.Error("Failed to encode response", "error", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+	}
+}
+
+// ListExpiringDevices lists devices whose certificate expires within the window given by the
+// within query parameter ("30d" or a duration such as "72h"), soonest first. Devices whose
+// certificate already expired are included with include_expired=true.
+// GET /api/v1/admin/devices/expiring
+func (h *AdminHandler) ListExpiringDevices(w http.ResponseWriter, r *http.Request) {
+	within := defaultExpiryWindow
+	if value := r.URL.Query().Get("within"); value != "" {
+		var err error
+		if within, err = parseExpiryWindow(value); err != nil {
+			h.logger.Warn("Invalid expiry window", "within", value, "error", err)
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+
+	includeExpired, _ := strconv.ParseBool(r.URL.Query().Get("include_expired"))
+
+	devices, err := h.deviceService.ListExpiringDevices(within, includeExpired)
+	if err != nil {
+		h.logger.Error("Failed to list expiring devices", "error", err)
+		http.Error(w, "Failed to retrieve devices", http.StatusInternalServerError)
+		return
+	}
+
+	// Return devices
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	if err := json.NewEncoder(w).Encode(map[string]interface{}{
+		"devices":     devices,
+		"count":       len(devices),
+		"within_days": int(within.Hours() / 24),
+	}); err != nil {
+		h.logger.Error("Failed to encode response", "error", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+	}
+}
+
+// AssignDevice assigns a device to the user in the request body, replacing any current assignment
+// POST /api/v1/admin/devices/{deviceId}/assign
+func (h *AdminHandler) AssignDevice(w http.ResponseWriter, r *http.Request) {
+	deviceID, ok := h.deviceID(w, r)
+	if !ok {
+		return
+	}
+
+	var req AssignDeviceRequest
+	r.Body = http.MaxBytesReader(w, r.Body, maxAdminRequestSize)
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || strings.TrimSpace(req.UserID) == "" {
+		h.logger.Warn("Invalid admin assignment request", "device_id", deviceID, "error", err)
+		http.Error(w, "Request body must contain a user_id", http.StatusBadRequest)
+		return
+	}
+
+	adminID, _ := middleware.GetUserIDFromContext(r.Context())
+
+	if err := h.deviceService.ReassignDevice(deviceID, req.UserID); err != nil {
+		h.logger.Warn("Failed to force-assign device",
+			"device_id", deviceID,
+			"user_id", req.UserID,
+			"admin_user_id", adminID,
+			"error", err)
+
+		if err.Error() == "device not found" {
+			http.Error(w, "Device not found", http.StatusNotFound)
+			return
+		}
+		if err.Error() == "device is not active" {
+			http.Error(w, "Device is not active", http.StatusConflict)
+			return
+		}
+
+		http.Error(w, "Failed to assign device", http.StatusInternalServerError)
+		return
+	}
+
+	h.logger.Info("Device force-assigned by admin",
+		"device_id", deviceID,
+		"user_id", req.UserID,
+		"admin_user_id", adminID)
+
+	w.WriteHeader(http.StatusOK)
+	w.Write([]byte(`{"message": "Device assigned successfully"}`))
+}
+
+// ChangeDeviceStatus moves a device to the lifecycle status in the request body, recording the
+// administrator and the reason given
+// POST /api/v1/admin/devices/{deviceId}/status
+func (h *AdminHandler) ChangeDeviceStatus(w http.ResponseWriter, r *http.Request) {
+	deviceID, ok := h.deviceID(w, r)
+	if !ok {
+		return
+	}
+
+	var req ChangeDeviceStatusRequest
+	r.Body = http.MaxBytesReader(w, r.Body, maxAdminRequestSize)
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		h.logger.Warn("Invalid device status request", "device_id", deviceID, "error", err)
+		http.Error(w, "Request body must contain a status and a reason", http.StatusBadRequest)
+		return
+	}
+
+	status, err := models.ParseDeviceStatus(req.Status)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	adminID, _ := middleware.GetUserIDFromContext(r.Context())
+
+	transition, err := h.deviceService.ChangeDeviceStatus(deviceID, status, adminID, req.Reason)
+	if err != nil {
+		switch {
+		case err.Error() == "device not found":
+			http.Error(w, "Device not found", http.StatusNotFound)
+		case strings.HasPrefix(err.Error(), "reason "):
+			http.Error(w, err.Error(), http.StatusBadRequest)
+		case strings.HasPrefix(err.Error(), "cannot change device status"),
+			err.Error() == "device status changed concurrently":
+			http.Error(w, err.Error(), http.StatusConflict)
+		default:
+			http.Error(w, "Failed to change device status", http.StatusInternalServerError)
+		}
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	if err := json.NewEncoder(w).Encode(transition); err != nil {
+		h.logger.Error("Failed to encode response", "error", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+	}
+}
+
+// ApproveDevice activates a device awaiting registration approval
+// POST /api/v1/admin/devices/{deviceId}/approve
+func (h *AdminHandler) ApproveDevice(w http.ResponseWriter, r *http.Request) {
+	h.reviewRegistration(w, r, h.deviceService.ApproveDevice)
+}
+
+// RejectDevice rejects the registration of a device awaiting approval
+// POST /api/v1/admin/devices/{deviceId}/reject
+func (h *AdminHandler) RejectDevice(w http.ResponseWriter, r *http.Request) {
+	h.reviewRegistration(w, r, h.deviceService.RejectDevice)
+}
+
+// reviewRegistration applies an approval or rejection, with the reason from the optional body
+func (h *AdminHandler) reviewRegistration(
+	w http.ResponseWriter,
+	r *http.Request,
+	review func(deviceID uuid.UUID, reviewedBy, reason string) (*models.DeviceStatusTransition, error),
+) {
+	deviceID, ok := h.deviceID(w, r)
+	if !ok {
+		return
+	}
+
+	var req ReviewRegistrationRequest
+	if r.ContentLength != 0 {
+		r.Body = http.MaxBytesReader(w, r.Body, maxAdminRequestSize)
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			h.logger.Warn("Invalid registration review request", "device_id", deviceID, "error", err)
+			http.Error(w, "Invalid request body", http.StatusBadRequest)
+			return
+		}
+	}
+
+	adminID, _ := middleware.GetUserIDFromContext(r.Context())
+
+	transition, err := review(deviceID, adminID, req.Reason)
+	if err != nil {
+		switch {
+		case err.Error() == "device not found":
+			http.Error(w, "Device not found", http.StatusNotFound)
+		case strings.HasPrefix(err.Error(), "reason "):
+			http.Error(w, err.Error(), http.StatusBadRequest)
+		case err.Error() == "device is not pending approval",
+			err.Error() == "device status changed concurrently":
+			http.Error(w, err.Error(), http.StatusConflict)
+		default:
+			http.Error(w, "Failed to review device registration", http.StatusInternalServerError)
+		}
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	if err := json.NewEncoder(w).Encode(transition); err != nil {
+		h.logger.Error("Failed to encode response", "error", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+	}
+}
+
+// GetDeviceStatusHistory lists every lifecycle status change of a device, newest first
+// GET /api/v1/admin/devices/{deviceId}/status-history
+func (h *AdminHandler) GetDeviceStatusHistory(w http.ResponseWriter, r *http.Request) {
+	deviceID, ok := h.deviceID(w, r)
+	if !ok {
+		return
+	}
+
+	if _, err := h.deviceService.GetDeviceByID(deviceID); err != nil {
+		http.Error(w, "Device not found", http.StatusNotFound)
+		return
+	}
+
+	transitions, err := h.deviceService.GetDeviceStatusHistory(deviceID)
+	if err != nil {
+		http.Error(w, "Failed to retrieve status history", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	if err := json.NewEncoder(w).Encode(map[string]interface{}{
+		"transitions": transitions,
+		"count":       len(transitions),
+	}); err != nil {
+		h.logger.Error("Failed to encode response", "error", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+	}
+}
+
+// UnassignDevice removes the current assignment of any device
+// DELETE /api/v1/admin/devices/{deviceId}/unassign
+func (h *AdminHandler) UnassignDevice(w http.ResponseWriter, r *http.Request) {
+	deviceID, ok := h.deviceID(w, r)
+	if !ok {
+		return
+	}
+
+	device, err := h.deviceService.GetDeviceWithAssignment(deviceID)
+	if err != nil {
+		h.logger.Warn("Device not found", "device_id", deviceID)
+		http.Error(w, "Device not found", http.StatusNotFound)
+		return
+	}
+
+	if !device.IsAssigned {
+		http.Error(w, "Device is not assigned", http.StatusConflict)
+		return
+	}
+
+	adminID, _ := middleware.GetUserIDFromContext(r.Context())
+
+	if err := h.deviceService.UnassignDevice(deviceID); err != nil {
+		h.logger.Error("Failed to force-unassign device",
+			"device_id", deviceID,
+			"admin_user_id", adminID,
+			"error", err)
+		http.Error(w, "Failed to unassign device", http.StatusInternalServerError)
+		return
+	}
+
+	h.logger.Info("Device force-unassigned by admin",
+		"device_id", deviceID,
+		"previous_user_id", *device.UserID,
+		"admin_user_id", adminID)
+
+	w.WriteHeader(http.StatusOK)
+	w.Write([]byte(`{"message": "Device unassigned successfully"}`))
+}
+
+// GetUserDevices handles retrieval of any user's devices
+// GET /api/v1/admin/users/{userId}/devices
+func (h *AdminHandler) GetUserDevices(w http.ResponseWriter, r *http.Request) {
+	userID := mux.Vars(r)["userId"]
+
+	query, err := deviceQuery(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	page, err := h.deviceService.GetUserDevices(userID, query)
+	if err != nil {
+		h.logger.Error("Failed to get user devices", "user_id", userID, "error", err)
+		http.Error(w, "Failed to retrieve devices", http.StatusInternalServerError)
+		return
+	}
+
+	// Return devices
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	if err := json.NewEncoder(w).Encode(page); err != nil {
+		h.logger.Error("Failed to encode response", "error", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+	}
+}
+
+// ImportAllowlistEntries adds certificates to the device allowlist. Every entry is validated
+// before any is stored, and entries already on the allowlist are skipped.
+// POST /api/v1/admin/device-allowlist
+func (h *AdminHandler) ImportAllowlistEntries(w http.ResponseWriter, r *http.Request) {
+	var req ImportAllowlistRequest
+	r.Body = http.MaxBytesReader(w, r.Body, maxAllowlistImportSize)
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		h.logger.Warn("Invalid allowlist import request", "error", err)
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	if len(req.Entries) == 0 || len(req.Entries) > maxAllowlistImportEntries {
+		http.Error(w, fmt.Sprintf("entries must contain between 1 and %d entries", maxAllowlistImportEntries), http.StatusBadRequest)
+		return
+	}
+
+	adminID, _ := middleware.GetUserIDFromContext(r.Context())
+
+	entries := make([]*models.AllowlistEntry, len(req.Entries))
+	for i, entry := range req.Entries {
+		var err error
+		entries[i], err = models.NewAllowlistEntry(entry.SerialNumber, entry.IssuerDN, entry.Fingerprint, entry.SPKIFingerprint, entry.Note, adminID)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("entry %d: %v", i, err), http.StatusBadRequest)
+			return
+		}
+	}
+
+	added, err := h.deviceService.ImportAllowlistEntries(entries)
+	if err != nil {
+		http.Error(w, "Failed to import allowlist entries", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	if err := json.NewEncoder(w).Encode(map[string]interface{}{
+		"imported": len(entries),
+		"added":    added,
+	}); err != nil {
+		h.logger.Error("Failed to encode response", "error", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+	}
+}
+
+// ListAllowlistEntries lists the device allowlist
+// GET /api/v1/admin/device-allowlist
+func (h *AdminHandler) ListAllowlistEntries(w http.ResponseWriter, r *http.Request) {
+	entries, err := h.deviceService.ListAllowlistEntries()
+	if err != nil {
+		http.Error(w, "Failed to retrieve allowlist entries", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	if err := json.NewEncoder(w).Encode(map[string]interface{}{
+		"entries": entries,
+		"count":   len(entries),
+	}); err != nil {
+		h.logger.Error("Failed to encode response", "error", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+	}
+}
+
+// DeleteAllowlistEntry removes a device allowlist entry
+// DELETE /api/v1/admin/device-allowlist/{entryId}
+func (h *AdminHandler) DeleteAllowlistEntry(w http.ResponseWriter, r *http.Request) {
+	entryIDStr := mux.Vars(r)["entryId"]
+
+	entryID, err := uuid.Parse(entryIDStr)
+	if err != nil {
+		http.Error(w, "Invalid allowlist entry ID", http.StatusBadRequest)
+		return
+	}
+
+	if err := h.deviceService.DeleteAllowlistEntry(entryID); err != nil {
+		if err.Error() == "allowlist entry not found" {
+			http.Error(w, "Allowlist entry not found", http.StatusNotFound)
+			return
+		}
+		http.Error(w, "Failed to delete allowlist entry", http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	w.Write([]byte(`{"message": "Allowlist entry deleted successfully"}`))
+}
+
+// GetCertificatePolicy returns the device certificate admission policy and how many
+// certificates it rejected, per rule
+// GET /api/v1/admin/certificate-policy
+func (h *AdminHandler) GetCertificatePolicy(w http.ResponseWriter, r *http.Request) {
+	if h.certPolicy == nil {
+		http.Error(w, "No certificate policy configured", http.StatusNotFound)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	if err := json.NewEncoder(w).Encode(map[string]interface{}{
+		"policy": h.certPolicy.Policy(),
+		"stats":  h.certPolicy.Stats(),
+	}); err != nil {
+		h.logger.Error("Failed to encode response", "error", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+	}
+}
+
+// deviceID parses the device ID path variable, writing a 400 response when it is invalid
+func (h *AdminHandler) deviceID(w http.ResponseWriter, r *http.Request) (uuid.UUID, bool) {
+	deviceIDStr := mux.Vars(r)["deviceId"]
+
+	deviceID, err := uuid.Parse(deviceIDStr)
+	if err != nil {
+		h.logger.Warn("Invalid device ID format", "device_id", deviceIDStr)
+		http.Error(w, "Invalid device ID", http.StatusBadRequest)
+		return uuid.Nil, false
+	}
+
+	return deviceID, true
+}
+
+// parseExpiryWindow parses a look-ahead window given in days ("30d") or as a duration ("72h")
+func parseExpiryWindow(value string) (time.Duration, error) {
+	var window time.Duration
+	if days, ok := strings.CutSuffix(value, "d"); ok {
+		n, err := strconv.Atoi(days)
+		if err != nil {
+			return 0, fmt.Errorf("within must be a number of days such as 30d, or a duration such as 72h")
+		}
+		window = time.Duration(n) * 24 * time.Hour
+	} else {
+		var err error
+		if window, err = time.ParseDuration(value); err != nil {
+			return 0, fmt.Errorf("within must be a number of days such as 30d, or a duration such as 72h")
+		}
+	}
+
+	if window <= 0 || window > maxExpiryWindow {
+		return 0, fmt.Errorf("within must be positive and at most 365d")
+	}
+
+	return window, nil
+}
@@ -0,0 +1,367 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"device-assignment-api/internal/models"
+	"device-assignment-api/pkg/auth"
+
+	"github.com/google/uuid"
+	"github.com/gorilla/mux"
+)
+
+func TestListExpiringDevices(t *testing.T) {
+	store := newMemoryStore()
+	now := time.Now()
+
+	withValidity := func(userID string, expiresIn time.Duration) *models.Device {
+		device := store.addDevice(userID)
+		device.ApplyCertificateValidity(now.Add(-24*time.Hour), now.Add(expiresIn))
+		store.UpdateDeviceCertificate(device)
+		return device
+	}
+
+	soon := withValidity("alice", 5*24*time.Hour)
+	later := withValidity("", 20*24*time.Hour)
+	withValidity("bob", 90*24*time.Hour)
+	expired := withValidity("carol", -2*24*time.Hour)
+	store.addDevice("dave")
+
+	handler := NewAdminHandler(newTestDeviceService(store), nil, testLogger())
+
+	tests := []struct {
+		name           string
+		query          string
+		expectedStatus int
+		expectedIDs    []string
+	}{
+		{"default window", "", http.StatusOK, []string{soon.ID.String(), later.ID.String()}},
+		{"window in days", "?within=7d", http.StatusOK, []string{soon.ID.String()}},
+		{"window as duration", "?within=360h", http.StatusOK, []string{soon.ID.String()}},
+		{"including expired", "?within=7d&include_expired=true", http.StatusOK, []string{expired.ID.String(), soon.ID.String()}},
+		{"invalid window", "?within=soon", http.StatusBadRequest, nil},
+		{"negative window", "?within=-1d", http.StatusBadRequest, nil},
+		{"window too long", "?within=400d", http.StatusBadRequest, nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := withUser(httptest.NewRequest(http.MethodGet, "/api/v1/admin/devices/expiring"+tt.query, nil), "admin", "admin")
+			rec := httptest.NewRecorder()
+
+			handler.ListExpiringDevices(rec, req)
+
+			if rec.Code != tt.expectedStatus {
+				t.Fatalf("Expected status %d, got %d: %s", tt.expectedStatus, rec.Code, rec.Body.String())
+			}
+			if rec.Code != http.StatusOK {
+				return
+			}
+
+			var body struct {
+				Devices []*models.DeviceWithAssignment `json:"devices"`
+			}
+			if err := json.Unmarshal(rec.Body.Bytes(), &body); err != nil {
+				t.Fatalf("Failed to decode response: %v", err)
+			}
+
+			if len(body.Devices) != len(tt.expectedIDs) {
+				t.Fatalf("Expected %d devices, got %d", len(tt.expectedIDs), len(body.Devices))
+			}
+			for i, device := range body.Devices {
+				if device.ID.String() != tt.expectedIDs[i] {
+					t.Errorf("Expected device %d to be %s, got %s", i, tt.expectedIDs[i], device.ID)
+				}
+				if device.CertificateExpiresInDays == nil {
+					t.Errorf("Expected device %s to report the days left on its certificate", device.ID)
+				}
+			}
+		})
+	}
+}
+
+func TestAdminAssignDevice(t *testing.T) {
+	store := newMemoryStore()
+	device := store.addDevice("alice")
+	handler := NewAdminHandler(newTestDeviceService(store), nil, testLogger())
+
+	assign := func(deviceID, userID string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, "/api/v1/admin/devices/"+deviceID+"/assign", strings.NewReader(`{"user_id": "`+userID+`"}`))
+		req = mux.SetURLVars(withUser(req, "admin", "admin"), map[string]string{"deviceId": deviceID})
+		rec := httptest.NewRecorder()
+		handler.AssignDevice(rec, req)
+		return rec
+	}
+
+	if rec := assign(device.ID.String(), "alice"); rec.Code != http.StatusOK {
+		t.Fatalf("Expected status %d for the current user, got %d: %s", http.StatusOK, rec.Code, rec.Body.String())
+	}
+	if rec := assign(uuid.NewString(), "bob"); rec.Code != http.StatusNotFound {
+		t.Errorf("Expected status %d for an unknown device, got %d", http.StatusNotFound, rec.Code)
+	}
+
+	// Concurrent reassignments must leave exactly one active assignment
+	var wg sync.WaitGroup
+	for _, userID := range []string{"bob", "carol", "dave", "erin"} {
+		wg.Add(1)
+		go func(userID string) {
+			defer wg.Done()
+			if rec := assign(device.ID.String(), userID); rec.Code != http.StatusOK {
+				t.Errorf("Expected status %d, got %d: %s", http.StatusOK, rec.Code, rec.Body.String())
+			}
+		}(userID)
+	}
+	wg.Wait()
+
+	active := 0
+	for _, assignment := range store.assignments {
+		if assignment.DeviceID == device.ID && assignment.IsActive() {
+			active++
+		}
+	}
+	if active != 1 {
+		t.Errorf("Expected one active assignment, got %d", active)
+	}
+
+	suspended := store.addDevice("")
+	suspended.Status = models.DeviceStatusSuspended
+	store.CreateDevice(suspended)
+	if rec := assign(suspended.ID.String(), "bob"); rec.Code != http.StatusConflict {
+		t.Errorf("Expected status %d for a suspended device, got %d", http.StatusConflict, rec.Code)
+	}
+}
+
+func TestChangeDeviceStatus(t *testing.T) {
+	store := newMemoryStore()
+	device := store.addDevice("alice")
+	deviceService := newTestDeviceService(store)
+	handler := NewAdminHandler(deviceService, nil, testLogger())
+
+	changeStatus := func(body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, "/api/v1/admin/devices/"+device.ID.String()+"/status", strings.NewReader(body))
+		req = mux.SetURLVars(withUser(req, "admin", "admin"), map[string]string{"deviceId": device.ID.String()})
+		rec := httptest.NewRecorder()
+		handler.ChangeDeviceStatus(rec, req)
+		return rec
+	}
+
+	tests := []struct {
+		name           string
+		body           string
+		expectedStatus int
+	}{
+		{"suspend", `{"status": "suspended", "reason": "Security review"}`, http.StatusOK},
+		{"missing reason", `{"status": "active"}`, http.StatusBadRequest},
+		{"unknown status", `{"status": "retired", "reason": "End of life"}`, http.StatusBadRequest},
+		{"transition not allowed", `{"status": "provisioned", "reason": "Reset"}`, http.StatusConflict},
+		{"decommission", `{"status": "decommissioned", "reason": "End of life"}`, http.StatusOK},
+		{"decommissioning is final", `{"status": "active", "reason": "Back in service"}`, http.StatusConflict},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if rec := changeStatus(tt.body); rec.Code != tt.expectedStatus {
+				t.Fatalf("Expected status %d, got %d: %s", tt.expectedStatus, rec.Code, rec.Body.String())
+			}
+		})
+	}
+
+	stored, _ := store.GetDeviceWithAssignment(device.ID)
+	if stored.Status != models.DeviceStatusDecommissioned {
+		t.Errorf("Expected the device to be decommissioned, got %s", stored.Status)
+	}
+	if stored.IsAssigned {
+		t.Error("Expected decommissioning to end the device's assignment")
+	}
+
+	if err := deviceService.AssignDeviceToUser(device.ID, "bob"); err == nil || err.Error() != "device is not active" {
+		t.Errorf("Expected a decommissioned device to be refused for assignment, got %v", err)
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "/api/v1/admin/devices/"+device.ID.String()+"/status-history", nil)
+	req = mux.SetURLVars(withUser(req, "admin", "admin"), map[string]string{"deviceId": device.ID.String()})
+	rec := httptest.NewRecorder()
+	handler.GetDeviceStatusHistory(rec, req)
+
+	var body struct {
+		Transitions []*models.DeviceStatusTransition `json:"transitions"`
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), &body); err != nil {
+		t.Fatalf("Failed to decode response: %v", err)
+	}
+	if len(body.Transitions) != 2 {
+		t.Fatalf("Expected 2 transitions, got %d", len(body.Transitions))
+	}
+	latest := body.Transitions[0]
+	if latest.FromStatus != models.DeviceStatusSuspended || latest.ToStatus != models.DeviceStatusDecommissioned ||
+		latest.ChangedBy != "admin" || latest.Reason != "End of life" {
+		t.Errorf("Expected the decommissioning to be recorded first, got %+v", latest)
+	}
+}
+
+func TestDecommissionWhileAssigning(t *testing.T) {
+	for i := 0; i < 20; i++ {
+		store := newMemoryStore()
+		device := store.addDevice("")
+		deviceService := newTestDeviceService(store)
+
+		// Users claiming the device concurrently with its decommissioning must never leave an
+		// active assignment on the decommissioned device
+		var wg sync.WaitGroup
+		for _, userID := range []string{"alice", "bob", "carol"} {
+			wg.Add(1)
+			go func(userID string) {
+				defer wg.Done()
+				deviceService.AssignDeviceToUser(device.ID, userID)
+			}(userID)
+		}
+		if _, err := deviceService.ChangeDeviceStatus(device.ID, models.DeviceStatusDecommissioned, "admin", "End of life"); err != nil {
+			t.Fatalf("Failed to decommission device: %v", err)
+		}
+		wg.Wait()
+
+		if assigned, _ := store.IsDeviceAssigned(device.ID); assigned {
+			t.Fatal("Expected the decommissioned device to have no active assignment")
+		}
+	}
+}
+
+func TestReviewDeviceRegistration(t *testing.T) {
+	store := newMemoryStore()
+	deviceService := newTestDeviceServiceWith(store, models.RegistrationModeApproval, nil)
+	handler := NewAdminHandler(deviceService, nil, testLogger())
+
+	register := func(serialNumber string) *models.Device {
+		device, err := deviceService.AuthenticateAndRegisterDevice(&auth.CertificateInfo{
+			SerialNumber: serialNumber, IssuerDN: "CN=Test CA", IssuerCN: "Test CA", IsValid: true,
+		})
+		if err != nil {
+			t.Fatalf("Failed to register device: %v", err)
+		}
+		return device
+	}
+	approved := register("1234")
+	rejected := register("5678")
+
+	review := func(review http.HandlerFunc, device *models.Device, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, "/api/v1/admin/devices/"+device.ID.String()+"/review", strings.NewReader(body))
+		req = mux.SetURLVars(withUser(req, "admin", "admin"), map[string]string{"deviceId": device.ID.String()})
+		rec := httptest.NewRecorder()
+		review(rec, req)
+		return rec
+	}
+
+	tests := []struct {
+		name           string
+		review         http.HandlerFunc
+		device         *models.Device
+		body           string
+		expectedStatus int
+	}{
+		{"approve", handler.ApproveDevice, approved, "", http.StatusOK},
+		{"approve again", handler.ApproveDevice, approved, "", http.StatusConflict},
+		{"reject an approved device", handler.RejectDevice, approved, "", http.StatusConflict},
+		{"reason too long", handler.RejectDevice, rejected, fmt.Sprintf(`{"reason": %q}`, strings.Repeat("r", 501)), http.StatusBadRequest},
+		{"reject", handler.RejectDevice, rejected, `{"reason": "Unknown hardware"}`, http.StatusOK},
+		{"device not pending", handler.ApproveDevice, store.addDevice(""), "", http.StatusConflict},
+		{"unknown device", handler.ApproveDevice, &models.Device{ID: uuid.New()}, "", http.StatusNotFound},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if rec := review(tt.review, tt.device, tt.body); rec.Code != tt.expectedStatus {
+				t.Fatalf("Expected status %d, got %d: %s", tt.expectedStatus, rec.Code, rec.Body.String())
+			}
+		})
+	}
+
+	if err := deviceService.AssignDeviceToUser(approved.ID, "alice"); err != nil {
+		t.Errorf("Expected an approved device to be assignable, got %v", err)
+	}
+
+	if err := deviceService.CheckDeviceStatus(rejected.ID.String()); err == nil || err.Error() != "device is rejected" {
+		t.Errorf("Expected a rejected device to be refused authentication, got %v", err)
+	}
+	history, _ := deviceService.GetDeviceStatusHistory(rejected.ID)
+	if len(history) != 1 || history[0].ToStatus != models.DeviceStatusRejected ||
+		history[0].ChangedBy != "admin" || history[0].Reason != "Unknown hardware" {
+		t.Errorf("Expected the rejection to be recorded, got %+v", history)
+	}
+}
+
+func TestDeviceAllowlist(t *testing.T) {
+	store := newMemoryStore()
+	handler := NewAdminHandler(newTestDeviceService(store), nil, testLogger())
+
+	importEntries := func(body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, "/api/v1/admin/device-allowlist", strings.NewReader(body))
+		rec := httptest.NewRecorder()
+		handler.ImportAllowlistEntries(rec, withUser(req, "admin", "admin"))
+		return rec
+	}
+
+	fingerprint := strings.Repeat("ab", 32)
+	rec := importEntries(fmt.Sprintf(`{"entries": [
+		{"serial_number": "12:34", "issuer_dn": "CN=Test CA", "note": "Batch 7"},
+		{"fingerprint": %q},
+		{"serial_number": "1234", "issuer_dn": "CN=Test CA"}
+	]}`, fingerprint))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("Expected status %d, got %d: %s", http.StatusOK, rec.Code, rec.Body.String())
+	}
+	var imported struct {
+		Imported int `json:"imported"`
+		Added    int `json:"added"`
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), &imported); err != nil {
+		t.Fatalf("Failed to decode response: %v", err)
+	}
+	if imported.Imported != 3 || imported.Added != 2 {
+		t.Errorf("Expected 3 entries imported and 2 added, got %+v", imported)
+	}
+
+	for _, body := range []string{`{"entries": []}`, `{"entries": [{"note": "Nothing to match"}]}`, `not json`} {
+		if rec := importEntries(body); rec.Code != http.StatusBadRequest {
+			t.Errorf("Expected status %d for %s, got %d", http.StatusBadRequest, body, rec.Code)
+		}
+	}
+
+	rec = httptest.NewRecorder()
+	handler.ListAllowlistEntries(rec, httptest.NewRequest(http.MethodGet, "/api/v1/admin/device-allowlist", nil))
+	var listed struct {
+		Entries []*models.AllowlistEntry `json:"entries"`
+		Count   int                      `json:"count"`
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), &listed); err != nil {
+		t.Fatalf("Failed to decode response: %v", err)
+	}
+	if listed.Count != 2 || listed.Entries[1].SerialNumber != "1234" || listed.Entries[1].CreatedBy != "admin" {
+		t.Fatalf("Unexpected allowlist %+v", listed.Entries)
+	}
+
+	deleteEntry := func(entryID string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodDelete, "/api/v1/admin/device-allowlist/"+entryID, nil)
+		req = mux.SetURLVars(req, map[string]string{"entryId": entryID})
+		rec := httptest.NewRecorder()
+		handler.DeleteAllowlistEntry(rec, req)
+		return rec
+	}
+
+	entryID := listed.Entries[0].ID.String()
+	if rec := deleteEntry(entryID); rec.Code != http.StatusOK {
+		t.Errorf("Expected status %d, got %d: %s", http.StatusOK, rec.Code, rec.Body.String())
+	}
+	if rec := deleteEntry(entryID); rec.Code != http.StatusNotFound {
+		t.Errorf("Expected status %d for a deleted entry, got %d", http.StatusNotFound, rec.Code)
+	}
+	if rec := deleteEntry("not-a-uuid"); rec.Code != http.StatusBadRequest {
+		t.Errorf("Expected status %d for an invalid ID, got %d", http.StatusBadRequest, rec.Code)
+	}
+}
@@ -0,0 +1,193 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"device-assignment-api/internal/middleware"
+	"device-assignment-api/internal/models"
+	"device-assignment-api/internal/services"
+	"device-assignment-api/pkg/logger"
+
+	"github.com/gorilla/mux"
+)
+
+// maxAPIKeyRequestSize bounds the size of an API key request body
+const maxAPIKeyRequestSize = 4 * 1024
+
+// CreateAPIKeyRequest is the body of a request to create a personal access token or a
+// service API key. ExpiresInDays defaults to 90 days for personal access tokens and to no
+// expiry for service keys.
+type CreateAPIKeyRequest struct {
+	Name          string   `json:"name"`
+	Scopes        []string `json:"scopes"`
+	ExpiresInDays int      `json:"expires_in_days"`
+}
+
+// APIKeyHandler handles personal access token and service API key management requests
+type APIKeyHandler struct {
+	apiKeyService *services.APIKeyService
+	logger        logger.Logger
+}
+
+// NewAPIKeyHandler creates a new APIKeyHandler
+func NewAPIKeyHandler(apiKeyService *services.APIKeyService, logger logger.Logger) *APIKeyHandler {
+	return &APIKeyHandler{
+		apiKeyService: apiKeyService,
+		logger:        logger,
+	}
+}
+
+// CreatePersonalToken creates a personal access token for the authenticated user. The
+// token is only returned in this response.
+// POST /api/v1/users/me/tokens
+func (h *APIKeyHandler) CreatePersonalToken(w http.ResponseWriter, r *http.Request) {
+	// Get user ID from context (added by JWT middleware)
+	userID, err := middleware.GetUserIDFromContext(r.Context())
+	if err != nil {
+		h.logger.Error("Failed to get user ID from context", "error", err)
+		http.Error(w, "Authentication required", http.StatusUnauthorized)
+		return
+	}
+
+	var req CreateAPIKeyRequest
+	if !h.decode(w, r, &req) {
+		return
+	}
+
+	key, err := h.apiKeyService.CreatePersonalToken(userID, req.Name, req.Scopes, req.ExpiresInDays)
+	h.writeCreated(w, key, err)
+}
+
+// ListPersonalTokens lists the authenticated user's personal access tokens
+// GET /api/v1/users/me/tokens
+func (h *APIKeyHandler) ListPersonalTokens(w http.ResponseWriter, r *http.Request) {
+	// Get user ID from context (added by JWT middleware)
+	userID, err := middleware.GetUserIDFromContext(r.Context())
+	if err != nil {
+		h.logger.Error("Failed to get user ID from context", "error", err)
+		http.Error(w, "Authentication required", http.StatusUnauthorized)
+		return
+	}
+
+	keys, err := h.apiKeyService.ListPersonalTokens(userID)
+	h.writeKeys(w, keys, err)
+}
+
+// RevokePersonalToken revokes one of the authenticated user's personal access tokens
+// DELETE /api/v1/users/me/tokens/{tokenId}
+func (h *APIKeyHandler) RevokePersonalToken(w http.ResponseWriter, r *http.Request) {
+	// Get user ID from context (added by JWT middleware)
+	userID, err := middleware.GetUserIDFromContext(r.Context())
+	if err != nil {
+		h.logger.Error("Failed to get user ID from context", "error", err)
+		http.Error(w, "Authentication required", http.StatusUnauthorized)
+		return
+	}
+
+	err = h.apiKeyService.RevokePersonalToken(userID, mux.Vars(r)["tokenId"])
+	h.writeRevoked(w, err)
+}
+
+// CreateServiceKey creates a service API key. The key is only returned in this response.
+// POST /api/v1/admin/api-keys
+func (h *APIKeyHandler) CreateServiceKey(w http.ResponseWriter, r *http.Request) {
+	var req CreateAPIKeyRequest
+	if !h.decode(w, r, &req) {
+		return
+	}
+
+	adminID, _ := middleware.GetUserIDFromContext(r.Context())
+
+	key, err := h.apiKeyService.CreateServiceKey(adminID, req.Name, req.Scopes, req.ExpiresInDays)
+	h.writeCreated(w, key, err)
+}
+
+// ListServiceKeys lists all service API keys
+// GET /api/v1/admin/api-keys
+func (h *APIKeyHandler) ListServiceKeys(w http.ResponseWriter, r *http.Request) {
+	keys, err := h.apiKeyService.ListServiceKeys()
+	h.writeKeys(w, keys, err)
+}
+
+// RevokeServiceKey revokes a service API key
+// DELETE /api/v1/admin/api-keys/{keyId}
+func (h *APIKeyHandler) RevokeServiceKey(w http.ResponseWriter, r *http.Request) {
+	err := h.apiKeyService.RevokeServiceKey(mux.Vars(r)["keyId"])
+	h.writeRevoked(w, err)
+}
+
+// writeCreated writes the response to a create request
+func (h *APIKeyHandler) writeCreated(w http.ResponseWriter, key *services.CreatedAPIKey, err error) {
+	if err != nil {
+		switch err.Error() {
+		case "invalid name":
+			http.Error(w, "Request body must contain a name of at most 100 characters", http.StatusBadRequest)
+		case "invalid scope":
+			http.Error(w, "Request body must contain one or more valid scopes", http.StatusBadRequest)
+		case "invalid expiry":
+			http.Error(w, "Invalid expires_in_days", http.StatusBadRequest)
+		case "api key name already exists":
+			http.Error(w, "A key with this name already exists", http.StatusConflict)
+		default:
+			http.Error(w, "Failed to create key", http.StatusInternalServerError)
+		}
+		return
+	}
+
+	// The key is shown once and must not be cached by intermediaries
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(http.StatusCreated)
+	if err := json.NewEncoder(w).Encode(key); err != nil {
+		h.logger.Error("Failed to encode response", "error", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+	}
+}
+
+// writeKeys writes the response to a list request
+func (h *APIKeyHandler) writeKeys(w http.ResponseWriter, keys []*models.APIKey, err error) {
+	if err != nil {
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	// Return empty array instead of null if no keys
+	if keys == nil {
+		keys = []*models.APIKey{}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	if err := json.NewEncoder(w).Encode(keys); err != nil {
+		h.logger.Error("Failed to encode response", "error", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+	}
+}
+
+// writeRevoked writes the response to a revoke request
+func (h *APIKeyHandler) writeRevoked(w http.ResponseWriter, err error) {
+	if err != nil {
+		if err.Error() == "api key not found" {
+			http.Error(w, "Key not found", http.StatusNotFound)
+			return
+		}
+
+		http.Error(w, "Failed to revoke key", http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	w.Write([]byte(`{"message": "Key revoked successfully"}`))
+}
+
+// decode reads a JSON request body, writing a 400 response when it is invalid
+func (h *APIKeyHandler) decode(w http.ResponseWriter, r *http.Request, target interface{}) bool {
+	r.Body = http.MaxBytesReader(w, r.Body, maxAPIKeyRequestSize)
+	if err := json.NewDecoder(r.Body).Decode(target); err != nil {
+		h.logger.Warn("Invalid request body", "path", r.URL.Path, "error", err)
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return false
+	}
+	return true
+}
@@ -0,0 +1,217 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"device-assignment-api/internal/middleware"
+	"device-assignment-api/internal/services"
+	"device-assignment-api/pkg/auth"
+
+	"github.com/gorilla/mux"
+)
+
+// apiKeyFixture wires the API key handler and the JWT middleware to an in-memory store
+type apiKeyFixture struct {
+	handler    *APIKeyHandler
+	middleware *middleware.JWTAuthMiddleware
+}
+
+func newTestAPIKeyHandler() *apiKeyFixture {
+	apiKeyService := services.NewAPIKeyService(newMemoryAPIKeyStore(), testLogger())
+	jwtManager := auth.NewJWTManager("secret", time.Hour, "test")
+
+	return &apiKeyFixture{
+		handler:    NewAPIKeyHandler(apiKeyService, testLogger()),
+		middleware: middleware.NewJWTAuthMiddleware(jwtManager, auth.NewRBAC(nil), nil, apiKeyService, testLogger()),
+	}
+}
+
+// create calls a create endpoint as the user and returns the response
+func (f *apiKeyFixture) create(handler http.HandlerFunc, userID, body string, roles ...string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodPost, "/api/v1/users/me/tokens", strings.NewReader(body))
+	rec := httptest.NewRecorder()
+
+	handler(rec, withUser(req, userID, roles...))
+	return rec
+}
+
+// createKey creates a key through the endpoint and returns it, failing the test on error
+func (f *apiKeyFixture) createKey(t *testing.T, handler http.HandlerFunc, userID, body string, roles ...string) *services.CreatedAPIKey {
+	t.Helper()
+
+	rec := f.create(handler, userID, body, roles...)
+	if rec.Code != http.StatusCreated {
+		t.Fatalf("Expected status %d, got %d: %s", http.StatusCreated, rec.Code, rec.Body.String())
+	}
+
+	var key services.CreatedAPIKey
+	if err := json.Unmarshal(rec.Body.Bytes(), &key); err != nil {
+		t.Fatalf("Failed to decode response: %v", err)
+	}
+	return &key
+}
+
+// call sends a request with the bearer token through a protected route, returning the
+// status and the user ID the handler saw
+func call(protected func(http.Handler) http.Handler, token string) (int, string) {
+	var userID string
+	handler := protected(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		userID, _ = middleware.GetUserIDFromContext(r.Context())
+		w.WriteHeader(http.StatusOK)
+	}))
+
+	req := httptest.NewRequest(http.MethodGet, "/api/v1/devices/x", nil)
+	req.Header.Set("Authorization", "Bearer "+token)
+	rec := httptest.NewRecorder()
+
+	handler.ServeHTTP(rec, req)
+	return rec.Code, userID
+}
+
+func TestCreatePersonalToken(t *testing.T) {
+	f := newTestAPIKeyHandler()
+
+	tests := []struct {
+		name           string
+		body           string
+		expectedStatus int
+	}{
+		{"valid token", `{"name": "inventory sync", "scopes": ["devices:read"]}`, http.StatusCreated},
+		{"duplicate name", `{"name": "inventory sync", "scopes": ["devices:read"]}`, http.StatusConflict},
+		{"missing name", `{"scopes": ["devices:read"]}`, http.StatusBadRequest},
+		{"missing scopes", `{"name": "no scopes"}`, http.StatusBadRequest},
+		{"unknown scope", `{"name": "unknown", "scopes": ["devices:delete"]}`, http.StatusBadRequest},
+		{"admin scope", `{"name": "admin", "scopes": ["admin:devices:read"]}`, http.StatusBadRequest},
+		{"expiry too long", `{"name": "forever", "scopes": ["devices:read"], "expires_in_days": 400}`, http.StatusBadRequest},
+		{"malformed body", `{"name":`, http.StatusBadRequest},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := f.create(f.handler.CreatePersonalToken, "alice", tt.body)
+			if rec.Code != tt.expectedStatus {
+				t.Errorf("Expected status %d, got %d: %s", tt.expectedStatus, rec.Code, rec.Body.String())
+			}
+		})
+	}
+
+	key := f.createKey(t, f.handler.CreatePersonalToken, "alice", `{"name": "ci", "scopes": ["devices:read"]}`)
+	if !strings.HasPrefix(key.Key, auth.PersonalAccessTokenPrefix) || !strings.HasPrefix(key.Key, key.Prefix) {
+		t.Errorf("Expected a personal access token starting with its display prefix, got %q and %q", key.Key, key.Prefix)
+	}
+	if key.ExpiresAt == nil || key.ExpiresAt.Before(time.Now().AddDate(0, 0, 89)) {
+		t.Errorf("Expected the token to expire after 90 days by default, got %v", key.ExpiresAt)
+	}
+
+	// Listings never contain the token itself
+	req := httptest.NewRequest(http.MethodGet, "/api/v1/users/me/tokens", nil)
+	rec := httptest.NewRecorder()
+	f.handler.ListPersonalTokens(rec, withUser(req, "alice"))
+
+	if rec.Code != http.StatusOK {
+		t.Fatalf("Expected status %d, got %d", http.StatusOK, rec.Code)
+	}
+	if strings.Contains(rec.Body.String(), key.Key) || strings.Contains(rec.Body.String(), `"key"`) {
+		t.Error("Expected the listing not to contain the token")
+	}
+
+	var listed []map[string]interface{}
+	if err := json.Unmarshal(rec.Body.Bytes(), &listed); err != nil || len(listed) != 2 {
+		t.Errorf("Expected two listed tokens, got %s", rec.Body.String())
+	}
+}
+
+func TestPersonalTokenAuthentication(t *testing.T) {
+	f := newTestAPIKeyHandler()
+	reader := f.createKey(t, f.handler.CreatePersonalToken, "alice", `{"name": "reader", "scopes": ["devices:read"]}`)
+
+	requireScope := func(scope auth.Scope) func(http.Handler) http.Handler {
+		return func(next http.Handler) http.Handler { return f.middleware.RequireScope(scope, next) }
+	}
+
+	tests := []struct {
+		name           string
+		protected      func(http.Handler) http.Handler
+		token          string
+		expectedStatus int
+	}{
+		{"granted scope", requireScope(auth.ScopeDevicesRead), reader.Key, http.StatusOK},
+		{"scope not granted", requireScope(auth.ScopeAssignmentsWrite), reader.Key, http.StatusForbidden},
+		{"JWT-only route", f.middleware.Authenticate, reader.Key, http.StatusUnauthorized},
+		{"unknown token", requireScope(auth.ScopeDevicesRead), auth.PersonalAccessTokenPrefix + "unknown", http.StatusUnauthorized},
+		{"service key prefix", requireScope(auth.ScopeDevicesRead), auth.ServiceAPIKeyPrefix + strings.TrimPrefix(reader.Key, auth.PersonalAccessTokenPrefix), http.StatusUnauthorized},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			status, userID := call(tt.protected, tt.token)
+			if status != tt.expectedStatus {
+				t.Errorf("Expected status %d, got %d", tt.expectedStatus, status)
+			}
+			if status == http.StatusOK && userID != "alice" {
+				t.Errorf("Expected the token to act as alice, got %q", userID)
+			}
+		})
+	}
+
+	// Other users cannot revoke the token
+	revoke := func(userID string) int {
+		req := httptest.NewRequest(http.MethodDelete, "/api/v1/users/me/tokens/"+reader.ID.String(), nil)
+		req = mux.SetURLVars(withUser(req, userID), map[string]string{"tokenId": reader.ID.String()})
+		rec := httptest.NewRecorder()
+
+		f.handler.RevokePersonalToken(rec, req)
+		return rec.Code
+	}
+
+	if status := revoke("mallory"); status != http.StatusNotFound {
+		t.Errorf("Expected status %d revoking another user's token, got %d", http.StatusNotFound, status)
+	}
+	if status := revoke("alice"); status != http.StatusOK {
+		t.Errorf("Expected status %d revoking the token, got %d", http.StatusOK, status)
+	}
+	if status, _ := call(requireScope(auth.ScopeDevicesRead), reader.Key); status != http.StatusUnauthorized {
+		t.Errorf("Expected a revoked token to be rejected, got %d", status)
+	}
+}
+
+func TestServiceKeyAuthentication(t *testing.T) {
+	f := newTestAPIKeyHandler()
+	key := f.createKey(t, f.handler.CreateServiceKey, "root", `{"name": "inventory", "scopes": ["admin:devices:read"]}`, "admin")
+
+	if !strings.HasPrefix(key.Key, auth.ServiceAPIKeyPrefix) || key.ExpiresAt != nil {
+		t.Errorf("Expected a non-expiring service key, got %q expiring %v", key.Key, key.ExpiresAt)
+	}
+	if key.CreatedBy != "root" || key.UserID != "service:inventory" {
+		t.Errorf("Expected a key for service:inventory created by root, got %q by %q", key.UserID, key.CreatedBy)
+	}
+
+	status, userID := call(func(next http.Handler) http.Handler {
+		return f.middleware.RequireScope(auth.ScopeAdminDevicesRead, next)
+	}, key.Key)
+	if status != http.StatusOK || userID != "service:inventory" {
+		t.Errorf("Expected the key to act as service:inventory, got status %d and %q", status, userID)
+	}
+
+	status, _ = call(func(next http.Handler) http.Handler {
+		return f.middleware.RequireScope(auth.ScopeAdminAssignmentsWrite, next)
+	}, key.Key)
+	if status != http.StatusForbidden {
+		t.Errorf("Expected status %d for a scope the key lacks, got %d", http.StatusForbidden, status)
+	}
+
+	// Service keys are not managed through the personal token endpoints
+	req := httptest.NewRequest(http.MethodDelete, "/api/v1/users/me/tokens/"+key.ID.String(), nil)
+	req = mux.SetURLVars(withUser(req, "service:inventory"), map[string]string{"tokenId": key.ID.String()})
+	rec := httptest.NewRecorder()
+	f.handler.RevokePersonalToken(rec, req)
+
+	if rec.Code != http.StatusNotFound {
+		t.Errorf("Expected status %d, got %d", http.StatusNotFound, rec.Code)
+	}
+}
@@ -0,0 +1,297 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+
+	"device-assignment-api/internal/middleware"
+	"device-assignment-api/internal/models"
+	"device-assignment-api/internal/services"
+	"device-assignment-api/pkg/auth"
+	"device-assignment-api/pkg/logger"
+
+	"github.com/gorilla/mux"
+)
+
+// maxAuthRequestSize bounds the size of a login or password request body
+const maxAuthRequestSize = 4 * 1024
+
+// LoginRequest is the body of a login request
+type LoginRequest struct {
+	Username string `json:"username"`
+	Password string `json:"password"`
+}
+
+// RefreshRequest is the body of a token refresh request
+type RefreshRequest struct {
+	RefreshToken string `json:"refresh_token"`
+}
+
+// ChangePasswordRequest is the body of a password change request
+type ChangePasswordRequest struct {
+	CurrentPassword string `json:"current_password"`
+	NewPassword     string `json:"new_password"`
+}
+
+// CreateUserRequest is the body of an admin request to create a local user
+type CreateUserRequest struct {
+	Username string   `json:"username"`
+	Password string   `json:"password"`
+	Roles    []string `json:"roles"`
+}
+
+// AuthHandler handles local user login and account requests
+type AuthHandler struct {
+	userService    *services.UserService
+	sessionService *services.SessionService
+	logger         logger.Logger
+}
+
+// NewAuthHandler creates a new AuthHandler
+func NewAuthHandler(userService *services.UserService, sessionService *services.SessionService, logger logger.Logger) *AuthHandler {
+	return &AuthHandler{
+		userService:    userService,
+		sessionService: sessionService,
+		logger:         logger,
+	}
+}
+
+// Login exchanges a username and password for an access token and a refresh token
+// POST /api/v1/auth/login
+func (h *AuthHandler) Login(w http.ResponseWriter, r *http.Request) {
+	var req LoginRequest
+	if !h.decode(w, r, &req) {
+		return
+	}
+
+	tokens, err := h.userService.Login(req.Username, req.Password, clientInfo(r))
+	if err != nil {
+		switch err.Error() {
+		case "invalid credentials":
+			http.Error(w, "Invalid username or password", http.StatusUnauthorized)
+			return
+		case "client certificate required":
+			http.Error(w, "Client certificate required", http.StatusUnauthorized)
+			return
+		}
+
+		http.Error(w, "Login failed", http.StatusInternalServerError)
+		return
+	}
+
+	h.writeTokens(w, tokens)
+}
+
+// Refresh exchanges a refresh token for a new access token and refresh token
+// POST /api/v1/auth/refresh
+func (h *AuthHandler) Refresh(w http.ResponseWriter, r *http.Request) {
+	var req RefreshRequest
+	if !h.decode(w, r, &req) {
+		return
+	}
+
+	tokens, err := h.sessionService.Refresh(req.RefreshToken, clientInfo(r))
+	if err != nil {
+		if err.Error() == "invalid refresh token" {
+			http.Error(w, "Invalid refresh token", http.StatusUnauthorized)
+			return
+		}
+
+		http.Error(w, "Token refresh failed", http.StatusInternalServerError)
+		return
+	}
+
+	h.writeTokens(w, tokens)
+}
+
+// Logout revokes the session of the presented access token, and the token itself
+// POST /api/v1/auth/logout
+func (h *AuthHandler) Logout(w http.ResponseWriter, r *http.Request) {
+	claims, err := middleware.GetClaimsFromContext(r.Context())
+	if err != nil {
+		h.logger.Error("Failed to get claims from context", "error", err)
+		http.Error(w, "Authentication required", http.StatusUnauthorized)
+		return
+	}
+
+	if err := h.sessionService.Logout(claims); err != nil {
+		http.Error(w, "Logout failed", http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	w.Write([]byte(`{"message": "Logged out successfully"}`))
+}
+
+// GetSessions lists the authenticated user's active sessions
+// GET /api/v1/users/me/sessions
+func (h *AuthHandler) GetSessions(w http.ResponseWriter, r *http.Request) {
+	// Get user ID from context (added by JWT middleware)
+	userID, err := middleware.GetUserIDFromContext(r.Context())
+	if err != nil {
+		h.logger.Error("Failed to get user ID from context", "error", err)
+		http.Error(w, "Authentication required", http.StatusUnauthorized)
+		return
+	}
+
+	sessions, err := h.sessionService.GetUserSessions(userID)
+	if err != nil {
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	// Return empty array instead of null if no sessions
+	if sessions == nil {
+		sessions = []*models.Session{}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	if err := json.NewEncoder(w).Encode(sessions); err != nil {
+		h.logger.Error("Failed to encode response", "error", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+	}
+}
+
+// RevokeSession revokes one of the authenticated user's sessions, signing out that client
+// DELETE /api/v1/users/me/sessions/{sessionId}
+func (h *AuthHandler) RevokeSession(w http.ResponseWriter, r *http.Request) {
+	// Get user ID from context (added by JWT middleware)
+	userID, err := middleware.GetUserIDFromContext(r.Context())
+	if err != nil {
+		h.logger.Error("Failed to get user ID from context", "error", err)
+		http.Error(w, "Authentication required", http.StatusUnauthorized)
+		return
+	}
+
+	sessionID := mux.Vars(r)["sessionId"]
+	if err := h.sessionService.RevokeSession(userID, sessionID); err != nil {
+		if err.Error() == "session not found" {
+			http.Error(w, "Session not found", http.StatusNotFound)
+			return
+		}
+
+		http.Error(w, "Failed to revoke session", http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	w.Write([]byte(`{"message": "Session revoked successfully"}`))
+}
+
+// ChangePassword replaces the authenticated user's password and signs out the user's other
+// sessions
+// POST /api/v1/users/me/password
+func (h *AuthHandler) ChangePassword(w http.ResponseWriter, r *http.Request) {
+	// Get user ID from context (added by JWT middleware)
+	userID, err := middleware.GetUserIDFromContext(r.Context())
+	if err != nil {
+		h.logger.Error("Failed to get user ID from context", "error", err)
+		http.Error(w, "Authentication required", http.StatusUnauthorized)
+		return
+	}
+
+	var req ChangePasswordRequest
+	if !h.decode(w, r, &req) {
+		return
+	}
+
+	// The session of the presenting token stays signed in
+	var sessionID string
+	if claims, err := middleware.GetClaimsFromContext(r.Context()); err == nil {
+		sessionID = claims.SessionID
+	}
+
+	if err := h.userService.ChangePassword(userID, sessionID, req.CurrentPassword, req.NewPassword); err != nil {
+		switch {
+		case err.Error() == "user not found":
+			http.Error(w, "Password login is not available for this user", http.StatusNotFound)
+		case err.Error() == "invalid credentials":
+			http.Error(w, "Current password is incorrect", http.StatusForbidden)
+		case strings.HasPrefix(err.Error(), "password must be"):
+			http.Error(w, err.Error(), http.StatusBadRequest)
+		case strings.HasPrefix(err.Error(), "failed to revoke sessions"):
+			http.Error(w, "Password changed, but other sessions could not be signed out", http.StatusInternalServerError)
+		default:
+			http.Error(w, "Failed to change password", http.StatusInternalServerError)
+		}
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	w.Write([]byte(`{"message": "Password changed successfully"}`))
+}
+
+// CreateUser creates a local user account
+// POST /api/v1/admin/users
+func (h *AuthHandler) CreateUser(w http.ResponseWriter, r *http.Request) {
+	var req CreateUserRequest
+	if !h.decode(w, r, &req) {
+		return
+	}
+
+	adminID, _ := middleware.GetUserIDFromContext(r.Context())
+
+	user, err := h.userService.CreateUser(req.Username, req.Password, req.Roles)
+	if err != nil {
+		switch {
+		case err.Error() == "username already exists":
+			http.Error(w, "Username already exists", http.StatusConflict)
+		case err.Error() == "username is required",
+			strings.HasPrefix(err.Error(), "password must be"),
+			strings.HasPrefix(err.Error(), "unknown role"):
+			http.Error(w, err.Error(), http.StatusBadRequest)
+		default:
+			http.Error(w, "Failed to create user", http.StatusInternalServerError)
+		}
+		return
+	}
+
+	h.logger.Info("User created by admin", "user_id", user.ID, "admin_user_id", adminID)
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	if err := json.NewEncoder(w).Encode(user); err != nil {
+		h.logger.Error("Failed to encode response", "error", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+	}
+}
+
+// writeTokens writes a token response
+func (h *AuthHandler) writeTokens(w http.ResponseWriter, tokens *services.TokenResponse) {
+	// Tokens must not be cached by intermediaries
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(http.StatusOK)
+	if err := json.NewEncoder(w).Encode(tokens); err != nil {
+		h.logger.Error("Failed to encode response", "error", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+	}
+}
+
+// decode reads a JSON request body, writing a 400 response when it is invalid
+func (h *AuthHandler) decode(w http.ResponseWriter, r *http.Request, target interface{}) bool {
+	r.Body = http.MaxBytesReader(w, r.Body, maxAuthRequestSize)
+	if err := json.NewDecoder(r.Body).Decode(target); err != nil {
+		h.logger.Warn("Invalid request body", "path", r.URL.Path, "error", err)
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return false
+	}
+	return true
+}
+
+// clientInfo describes the client that sent the request, including the thumbprint of its
+// verified client certificate for binding tokens to it
+func clientInfo(r *http.Request) services.ClientInfo {
+	client := services.ClientInfo{
+		UserAgent: r.UserAgent(),
+		IPAddress: middleware.ClientIP(r),
+	}
+
+	if cert := middleware.VerifiedClientCertificate(r); cert != nil {
+		client.CertificateThumbprint = auth.CertificateThumbprint(cert)
+	}
+
+	return client
+}
@@ -0,0 +1,450 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"device-assignment-api/internal/middleware"
+	"device-assignment-api/internal/services"
+	"device-assignment-api/pkg/auth"
+
+	"github.com/gorilla/mux"
+)
+
+const testPassword = "correct horse battery staple"
+
+// authFixture wires the auth handler to in-memory user and session stores
+type authFixture struct {
+	handler    *AuthHandler
+	users      *services.UserService
+	sessions   *services.SessionService
+	jwtManager *auth.JWTManager
+}
+
+func newTestAuthHandler(t *testing.T) *authFixture {
+	t.Helper()
+
+	userStore := newMemoryUserStore()
+	jwtManager := auth.NewJWTManager("secret", time.Hour, "test")
+	sessionService := services.NewSessionService(newMemorySessionStore(), userStore, jwtManager, 24*time.Hour, false, testLogger())
+	userService, err := services.NewUserService(userStore, sessionService, testLogger())
+	if err != nil {
+		t.Fatalf("Failed to create user service: %v", err)
+	}
+
+	return &authFixture{
+		handler:    NewAuthHandler(userService, sessionService, testLogger()),
+		users:      userService,
+		sessions:   sessionService,
+		jwtManager: jwtManager,
+	}
+}
+
+// login logs a user in and returns the issued tokens
+func (f *authFixture) login(t *testing.T, username string) *services.TokenResponse {
+	t.Helper()
+
+	tokens, err := f.users.Login(username, testPassword, services.ClientInfo{UserAgent: "test-agent", IPAddress: "192.0.2.1"})
+	if err != nil {
+		t.Fatalf("Failed to log in: %v", err)
+	}
+	return tokens
+}
+
+// refresh calls the refresh endpoint and returns the response
+func (f *authFixture) refresh(refreshToken string) *httptest.ResponseRecorder {
+	body := `{"refresh_token": "` + refreshToken + `"}`
+	req := httptest.NewRequest(http.MethodPost, "/api/v1/auth/refresh", strings.NewReader(body))
+	rec := httptest.NewRecorder()
+
+	f.handler.Refresh(rec, req)
+	return rec
+}
+
+// isRevoked reports whether the denylist rejects an access token
+func (f *authFixture) isRevoked(t *testing.T, accessToken string) bool {
+	t.Helper()
+
+	claims, err := f.jwtManager.ValidateToken(accessToken)
+	if err != nil {
+		t.Fatalf("Failed to validate access token: %v", err)
+	}
+
+	revoked, err := f.sessions.IsTokenRevoked(claims)
+	if err != nil {
+		t.Fatalf("Failed to check revocation: %v", err)
+	}
+	return revoked
+}
+
+func TestLogin(t *testing.T) {
+	f := newTestAuthHandler(t)
+	if err := f.users.BootstrapAdmin("root", testPassword); err != nil {
+		t.Fatalf("Failed to bootstrap admin: %v", err)
+	}
+
+	tests := []struct {
+		name           string
+		body           string
+		expectedStatus int
+	}{
+		{"valid credentials", `{"username": "root", "password": "` + testPassword + `"}`, http.StatusOK},
+		{"wrong password", `{"username": "root", "password": "wrong password!"}`, http.StatusUnauthorized},
+		{"unknown user", `{"username": "nobody", "password": "` + testPassword + `"}`, http.StatusUnauthorized},
+		{"malformed body", `{"username":`, http.StatusBadRequest},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodPost, "/api/v1/auth/login", strings.NewReader(tt.body))
+			rec := httptest.NewRecorder()
+
+			f.handler.Login(rec, req)
+
+			if rec.Code != tt.expectedStatus {
+				t.Fatalf("Expected status %d, got %d: %s", tt.expectedStatus, rec.Code, rec.Body.String())
+			}
+			if rec.Code != http.StatusOK {
+				return
+			}
+
+			var token services.TokenResponse
+			if err := json.Unmarshal(rec.Body.Bytes(), &token); err != nil {
+				t.Fatalf("Failed to decode response: %v", err)
+			}
+
+			claims, err := f.jwtManager.ValidateToken(token.AccessToken)
+			if err != nil {
+				t.Fatalf("Expected a valid access token, got %v", err)
+			}
+			if !auth.NewRBAC(nil).Principal(claims).HasPermission(auth.PermissionAdminUsersManage) {
+				t.Errorf("Expected the bootstrapped user to be an admin, got roles %v", claims.Roles)
+			}
+			if token.ExpiresIn != 3600 {
+				t.Errorf("Expected expires_in 3600, got %d", token.ExpiresIn)
+			}
+			if token.RefreshToken == "" || claims.SessionID == "" {
+				t.Errorf("Expected a refresh token and a session-bound access token, got %q and session %q", token.RefreshToken, claims.SessionID)
+			}
+		})
+	}
+}
+
+func TestBootstrapAdminOnlyOnce(t *testing.T) {
+	f := newTestAuthHandler(t)
+
+	if err := f.users.BootstrapAdmin("root", testPassword); err != nil {
+		t.Fatalf("Failed to bootstrap admin: %v", err)
+	}
+	if err := f.users.BootstrapAdmin("intruder", testPassword); err != nil {
+		t.Fatalf("Expected repeated bootstrap to be a no-op, got %v", err)
+	}
+	if _, err := f.users.Login("intruder", testPassword, services.ClientInfo{}); err == nil {
+		t.Error("Expected bootstrap to be skipped once a user exists")
+	}
+}
+
+func TestChangePassword(t *testing.T) {
+	f := newTestAuthHandler(t)
+	user, err := f.users.CreateUser("alice", testPassword, nil)
+	if err != nil {
+		t.Fatalf("Failed to create user: %v", err)
+	}
+
+	tests := []struct {
+		name           string
+		userID         string
+		body           string
+		expectedStatus int
+	}{
+		{"wrong current password", user.ID.String(), `{"current_password": "wrong password!", "new_password": "another long password"}`, http.StatusForbidden},
+		{"new password too short", user.ID.String(), `{"current_password": "` + testPassword + `", "new_password": "short"}`, http.StatusBadRequest},
+		{"identity provider user", "idp-user", `{"current_password": "x", "new_password": "another long password"}`, http.StatusNotFound},
+		{"valid change", user.ID.String(), `{"current_password": "` + testPassword + `", "new_password": "another long password"}`, http.StatusOK},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodPost, "/api/v1/users/me/password", strings.NewReader(tt.body))
+			rec := httptest.NewRecorder()
+
+			f.handler.ChangePassword(rec, withUser(req, tt.userID))
+
+			if rec.Code != tt.expectedStatus {
+				t.Errorf("Expected status %d, got %d: %s", tt.expectedStatus, rec.Code, rec.Body.String())
+			}
+		})
+	}
+
+	if _, err := f.users.Login("alice", "another long password", services.ClientInfo{}); err != nil {
+		t.Errorf("Expected login with the new password to succeed, got %v", err)
+	}
+	if _, err := f.users.Login("alice", testPassword, services.ClientInfo{}); err == nil {
+		t.Error("Expected login with the old password to fail")
+	}
+}
+
+func TestChangePasswordRevokesOtherSessions(t *testing.T) {
+	f := newTestAuthHandler(t)
+	if _, err := f.users.CreateUser("alice", testPassword, nil); err != nil {
+		t.Fatalf("Failed to create user: %v", err)
+	}
+	current := f.login(t, "alice")
+	stolen := f.login(t, "alice")
+
+	claims, err := f.jwtManager.ValidateToken(current.AccessToken)
+	if err != nil {
+		t.Fatalf("Failed to validate access token: %v", err)
+	}
+
+	req := httptest.NewRequest(http.MethodPost, "/api/v1/users/me/password",
+		strings.NewReader(`{"current_password": "`+testPassword+`", "new_password": "another long password"}`))
+	req = withUser(req, claims.UserID)
+	req = req.WithContext(context.WithValue(req.Context(), middleware.ClaimsContextKey, claims))
+	rec := httptest.NewRecorder()
+
+	f.handler.ChangePassword(rec, req)
+
+	if rec.Code != http.StatusOK {
+		t.Fatalf("Expected status %d, got %d: %s", http.StatusOK, rec.Code, rec.Body.String())
+	}
+	if !f.isRevoked(t, stolen.AccessToken) {
+		t.Error("Expected access tokens of other sessions to be revoked")
+	}
+	if rec := f.refresh(stolen.RefreshToken); rec.Code != http.StatusUnauthorized {
+		t.Errorf("Expected refresh tokens of other sessions to be revoked, got %d", rec.Code)
+	}
+	if f.isRevoked(t, current.AccessToken) {
+		t.Error("Expected the session changing the password to stay signed in")
+	}
+}
+
+func TestCreateUser(t *testing.T) {
+	f := newTestAuthHandler(t)
+
+	tests := []struct {
+		name           string
+		body           string
+		expectedStatus int
+	}{
+		{"valid user", `{"username": "bob", "password": "` + testPassword + `", "roles": ["user"]}`, http.StatusCreated},
+		{"duplicate username", `{"username": "bob", "password": "` + testPassword + `"}`, http.StatusConflict},
+		{"unknown role", `{"username": "carol", "password": "` + testPassword + `", "roles": ["root"]}`, http.StatusBadRequest},
+		{"short password", `{"username": "dave", "password": "short"}`, http.StatusBadRequest},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodPost, "/api/v1/admin/users", strings.NewReader(tt.body))
+			rec := httptest.NewRecorder()
+
+			f.handler.CreateUser(rec, withUser(req, "admin", "admin"))
+
+			if rec.Code != tt.expectedStatus {
+				t.Errorf("Expected status %d, got %d: %s", tt.expectedStatus, rec.Code, rec.Body.String())
+			}
+			if strings.Contains(rec.Body.String(), "argon2id") {
+				t.Error("Expected the password hash never to be returned")
+			}
+		})
+	}
+}
+
+func TestRefreshRotatesTokens(t *testing.T) {
+	f := newTestAuthHandler(t)
+	if _, err := f.users.CreateUser("alice", testPassword, nil); err != nil {
+		t.Fatalf("Failed to create user: %v", err)
+	}
+	tokens := f.login(t, "alice")
+
+	rec := f.refresh(tokens.RefreshToken)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("Expected status %d, got %d: %s", http.StatusOK, rec.Code, rec.Body.String())
+	}
+
+	var rotated services.TokenResponse
+	if err := json.Unmarshal(rec.Body.Bytes(), &rotated); err != nil {
+		t.Fatalf("Failed to decode response: %v", err)
+	}
+	if rotated.RefreshToken == tokens.RefreshToken {
+		t.Error("Expected a new refresh token")
+	}
+	if f.isRevoked(t, rotated.AccessToken) {
+		t.Error("Expected the new access token to be valid")
+	}
+
+	if rec := f.refresh(rotated.RefreshToken); rec.Code != http.StatusOK {
+		t.Errorf("Expected the rotated refresh token to work, got %d", rec.Code)
+	}
+	if rec := f.refresh("not-a-refresh-token"); rec.Code != http.StatusUnauthorized {
+		t.Errorf("Expected an unknown refresh token to be rejected, got %d", rec.Code)
+	}
+}
+
+func TestRefreshTokenReuseRevokesSession(t *testing.T) {
+	f := newTestAuthHandler(t)
+	if _, err := f.users.CreateUser("alice", testPassword, nil); err != nil {
+		t.Fatalf("Failed to create user: %v", err)
+	}
+	tokens := f.login(t, "alice")
+
+	rec := f.refresh(tokens.RefreshToken)
+	var rotated services.TokenResponse
+	json.Unmarshal(rec.Body.Bytes(), &rotated)
+
+	// Replaying the first refresh token looks like theft, so the whole session is revoked
+	if rec := f.refresh(tokens.RefreshToken); rec.Code != http.StatusUnauthorized {
+		t.Fatalf("Expected a reused refresh token to be rejected, got %d", rec.Code)
+	}
+	if rec := f.refresh(rotated.RefreshToken); rec.Code != http.StatusUnauthorized {
+		t.Errorf("Expected the rest of the token family to be revoked, got %d", rec.Code)
+	}
+	if !f.isRevoked(t, rotated.AccessToken) {
+		t.Error("Expected access tokens of the revoked session to be rejected")
+	}
+}
+
+func TestLogout(t *testing.T) {
+	f := newTestAuthHandler(t)
+	if _, err := f.users.CreateUser("alice", testPassword, nil); err != nil {
+		t.Fatalf("Failed to create user: %v", err)
+	}
+	tokens := f.login(t, "alice")
+	other := f.login(t, "alice")
+
+	claims, err := f.jwtManager.ValidateToken(tokens.AccessToken)
+	if err != nil {
+		t.Fatalf("Failed to validate access token: %v", err)
+	}
+
+	req := httptest.NewRequest(http.MethodPost, "/api/v1/auth/logout", nil)
+	req = req.WithContext(context.WithValue(req.Context(), middleware.ClaimsContextKey, claims))
+	rec := httptest.NewRecorder()
+
+	f.handler.Logout(rec, req)
+
+	if rec.Code != http.StatusOK {
+		t.Fatalf("Expected status %d, got %d: %s", http.StatusOK, rec.Code, rec.Body.String())
+	}
+	if !f.isRevoked(t, tokens.AccessToken) {
+		t.Error("Expected the access token to be revoked after logout")
+	}
+	if rec := f.refresh(tokens.RefreshToken); rec.Code != http.StatusUnauthorized {
+		t.Errorf("Expected the refresh token to be revoked after logout, got %d", rec.Code)
+	}
+	if f.isRevoked(t, other.AccessToken) {
+		t.Error("Expected other sessions to stay signed in")
+	}
+}
+
+func TestSessions(t *testing.T) {
+	f := newTestAuthHandler(t)
+	alice, err := f.users.CreateUser("alice", testPassword, nil)
+	if err != nil {
+		t.Fatalf("Failed to create user: %v", err)
+	}
+	bob, err := f.users.CreateUser("bob", testPassword, nil)
+	if err != nil {
+		t.Fatalf("Failed to create user: %v", err)
+	}
+	stolen := f.login(t, "alice")
+	f.login(t, "alice")
+	f.login(t, "bob")
+
+	req := httptest.NewRequest(http.MethodGet, "/api/v1/users/me/sessions", nil)
+	rec := httptest.NewRecorder()
+	f.handler.GetSessions(rec, withUser(req, alice.ID.String()))
+
+	var sessions []map[string]interface{}
+	if err := json.Unmarshal(rec.Body.Bytes(), &sessions); err != nil {
+		t.Fatalf("Failed to decode response: %v", err)
+	}
+	if len(sessions) != 2 {
+		t.Fatalf("Expected 2 sessions, got %d", len(sessions))
+	}
+	if sessions[0]["user_agent"] != "test-agent" {
+		t.Errorf("Expected the session user agent to be recorded, got %v", sessions[0]["user_agent"])
+	}
+
+	claims, _ := f.jwtManager.ValidateToken(stolen.AccessToken)
+
+	tests := []struct {
+		name           string
+		userID         string
+		sessionID      string
+		expectedStatus int
+	}{
+		{"other user's session", bob.ID.String(), claims.SessionID, http.StatusNotFound},
+		{"invalid session ID", alice.ID.String(), "not-a-uuid", http.StatusNotFound},
+		{"own session", alice.ID.String(), claims.SessionID, http.StatusOK},
+		{"already revoked", alice.ID.String(), claims.SessionID, http.StatusNotFound},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodDelete, "/api/v1/users/me/sessions/"+tt.sessionID, nil)
+			req = mux.SetURLVars(withUser(req, tt.userID), map[string]string{"sessionId": tt.sessionID})
+			rec := httptest.NewRecorder()
+
+			f.handler.RevokeSession(rec, req)
+
+			if rec.Code != tt.expectedStatus {
+				t.Errorf("Expected status %d, got %d: %s", tt.expectedStatus, rec.Code, rec.Body.String())
+			}
+		})
+	}
+
+	if !f.isRevoked(t, stolen.AccessToken) {
+		t.Error("Expected the revoked session's access token to be rejected")
+	}
+}
+
+func TestCertificateBoundSession(t *testing.T) {
+	userStore := newMemoryUserStore()
+	jwtManager := auth.NewJWTManager("secret", time.Hour, "test")
+	sessionService := services.NewSessionService(newMemorySessionStore(), userStore, jwtManager, 24*time.Hour, true, testLogger())
+	userService, err := services.NewUserService(userStore, sessionService, testLogger())
+	if err != nil {
+		t.Fatalf("Failed to create user service: %v", err)
+	}
+	if _, err := userService.CreateUser("alice", testPassword, nil); err != nil {
+		t.Fatalf("Failed to create user: %v", err)
+	}
+
+	if _, err := userService.Login("alice", testPassword, services.ClientInfo{}); err == nil || err.Error() != "client certificate required" {
+		t.Errorf("Expected login without a client certificate to be rejected, got %v", err)
+	}
+
+	laptop := services.ClientInfo{CertificateThumbprint: "laptop-thumbprint"}
+	tokens, err := userService.Login("alice", testPassword, laptop)
+	if err != nil {
+		t.Fatalf("Failed to log in: %v", err)
+	}
+
+	claims, err := jwtManager.ValidateToken(tokens.AccessToken)
+	if err != nil {
+		t.Fatalf("Failed to validate access token: %v", err)
+	}
+	if !claims.Confirmation.IsCertificateBound() || claims.Confirmation.X509Thumbprint != "laptop-thumbprint" {
+		t.Errorf("Expected the access token to be bound to the login certificate, got %+v", claims.Confirmation)
+	}
+
+	if _, err := sessionService.Refresh(tokens.RefreshToken, services.ClientInfo{CertificateThumbprint: "other-thumbprint"}); err == nil {
+		t.Error("Expected refresh with another certificate to be rejected")
+	}
+
+	refreshed, err := sessionService.Refresh(tokens.RefreshToken, laptop)
+	if err != nil {
+		t.Fatalf("Expected refresh with the session certificate to succeed, got %v", err)
+	}
+
+	claims, _ = jwtManager.ValidateToken(refreshed.AccessToken)
+	if claims.Confirmation == nil || claims.Confirmation.X509Thumbprint != "laptop-thumbprint" {
+		t.Errorf("Expected the refreshed access token to stay bound, got %+v", claims.Confirmation)
+	}
+}
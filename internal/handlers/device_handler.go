@@ -2,9 +2,14 @@ package handlers
 
 import (
 	"encoding/json"
+	"fmt"
 	"net/http"
+	"strconv"
+	"strings"
+	"time"
 
 	"device-assignment-api/internal/middleware"
+	"device-assignment-api/internal/models"
 	"device-assignment-api/internal/services"
 	"device-assignment-api/pkg/logger"
 
@@ -12,16 +17,50 @@ import (
 	"github.com/gorilla/mux"
 )
 
+// maxDeviceRequestSize bounds the size of a device update request body
+const maxDeviceRequestSize = 8 * 1024
+
+// DeviceAuthenticationResponse is the authenticated device, with a device access token
+// when token issuance is enabled
+type DeviceAuthenticationResponse struct {
+	*models.Device
+	*services.TokenResponse
+}
+
+// UpdateDeviceRequest is the body of a user's device update. Omitted fields are left
+// unchanged, and labels replace every existing label.
+type UpdateDeviceRequest struct {
+	Name   *string       `json:"name"`
+	Labels models.Labels `json:"labels"`
+}
+
+// ReportDeviceRequest is the body of a device reporting its own details. Omitted fields are
+// left unchanged.
+type ReportDeviceRequest struct {
+	Model            *string `json:"model"`
+	HardwareRevision *string `json:"hardware_revision"`
+	FirmwareVersion  *string `json:"firmware_version"`
+}
+
+// HeartbeatRequest is the optional body of a device heartbeat. An omitted status leaves the
+// previously reported one unchanged.
+type HeartbeatRequest struct {
+	Status string `json:"status"`
+}
+
 // DeviceHandler handles device-related HTTP requests
 type DeviceHandler struct {
 	deviceService *services.DeviceService
+	deviceTokens  *services.DeviceTokenService
 	logger        logger.Logger
 }
 
-// NewDeviceHandler creates a new DeviceHandler
-func NewDeviceHandler(deviceService *services.DeviceService, logger logger.Logger) *DeviceHandler {
+// NewDeviceHandler creates a new DeviceHandler. The device token service may be nil to
+// disable issuing device access tokens.
+func NewDeviceHandler(deviceService *services.DeviceService, deviceTokens *services.DeviceTokenService, logger logger.Logger) *DeviceHandler {
 	return &DeviceHandler{
 		deviceService: deviceService,
+		deviceTokens:  deviceTokens,
 		logger:        logger,
 	}
 }
@@ -40,6 +79,10 @@ func (h *DeviceHandler) AuthenticateDevice(w http.ResponseWriter, r *http.Reques
 	// Authenticate and register device
 	device, err := h.deviceService.AuthenticateAndRegisterDevice(certInfo)
 	if err != nil {
+		if err.Error() == "device is not allowlisted" {
+			http.Error(w, "Device is not permitted to register", http.StatusForbidden)
+			return
+		}
 		h.logger.Error("Device authentication failed", "error", err)
 		http.Error(w, "Authentication failed", http.StatusUnauthorized)
 		return
@@ -47,7 +90,51 @@ func (h *DeviceHandler) AuthenticateDevice(w http.ResponseWriter, r *http.Reques
 
 	h.logger.Info("Device authenticated successfully", "device_id", device.ID)
 
+	response := DeviceAuthenticationResponse{Device: device}
+	if h.deviceTokens != nil {
+		response.TokenResponse, err = h.deviceTokens.IssueToken(device, certInfo)
+		if err != nil {
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+			return
+		}
+
+		// Tokens must not be cached by intermediaries
+		w.Header().Set("Cache-Control", "no-store")
+	}
+
 	// Return device information
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	if err := json.NewEncoder(w).Encode(response); err != nil {
+		h.logger.Error("Failed to encode response", "error", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+	}
+}
+
+// GetCurrentDevice returns the authenticated device's own record
+// GET /api/v1/devices/me
+func (h *DeviceHandler) GetCurrentDevice(w http.ResponseWriter, r *http.Request) {
+	// Get device ID from context (added by device auth middleware)
+	deviceIDStr, err := middleware.GetDeviceIDFromContext(r.Context())
+	if err != nil {
+		h.logger.Error("Failed to get device ID from context", "error", err)
+		http.Error(w, "Authentication required", http.StatusUnauthorized)
+		return
+	}
+
+	deviceID, err := uuid.Parse(deviceIDStr)
+	if err != nil {
+		h.logger.Warn("Invalid device ID format", "device_id", deviceIDStr)
+		http.Error(w, "Invalid device ID", http.StatusUnauthorized)
+		return
+	}
+
+	device, err := h.deviceService.GetDeviceByID(deviceID)
+	if err != nil {
+		http.Error(w, "Device not found", http.StatusNotFound)
+		return
+	}
+
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(http.StatusOK)
 	if err := json.NewEncoder(w).Encode(device); err != nil {
@@ -56,6 +143,82 @@ func (h *DeviceHandler) AuthenticateDevice(w http.ResponseWriter, r *http.Reques
 	}
 }
 
+// ReportCurrentDevice records the model, hardware revision and firmware version the
+// authenticated device reports about itself
+// PATCH /api/v1/devices/me
+func (h *DeviceHandler) ReportCurrentDevice(w http.ResponseWriter, r *http.Request) {
+	// Get device ID from context (added by device auth middleware)
+	deviceIDStr, err := middleware.GetDeviceIDFromContext(r.Context())
+	if err != nil {
+		h.logger.Error("Failed to get device ID from context", "error", err)
+		http.Error(w, "Authentication required", http.StatusUnauthorized)
+		return
+	}
+
+	deviceID, err := uuid.Parse(deviceIDStr)
+	if err != nil {
+		h.logger.Warn("Invalid device ID format", "device_id", deviceIDStr)
+		http.Error(w, "Invalid device ID", http.StatusUnauthorized)
+		return
+	}
+
+	var req ReportDeviceRequest
+	if !h.decode(w, r, &req) {
+		return
+	}
+
+	device, err := h.deviceService.UpdateDeviceMetadata(deviceID, models.DeviceMetadataUpdate{
+		Model:            req.Model,
+		HardwareRevision: req.HardwareRevision,
+		FirmwareVersion:  req.FirmwareVersion,
+	})
+	if err != nil {
+		h.writeUpdateError(w, deviceID, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	if err := json.NewEncoder(w).Encode(device); err != nil {
+		h.logger.Error("Failed to encode response", "error", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+	}
+}
+
+// Heartbeat records that the authenticated device is alive, with the address it connected
+// from and the status it optionally reports
+// POST /api/v1/devices/me/heartbeat
+func (h *DeviceHandler) Heartbeat(w http.ResponseWriter, r *http.Request) {
+	// Get device ID from context (added by device auth middleware)
+	deviceIDStr, err := middleware.GetDeviceIDFromContext(r.Context())
+	if err != nil {
+		h.logger.Error("Failed to get device ID from context", "error", err)
+		http.Error(w, "Authentication required", http.StatusUnauthorized)
+		return
+	}
+
+	deviceID, err := uuid.Parse(deviceIDStr)
+	if err != nil {
+		h.logger.Warn("Invalid device ID format", "device_id", deviceIDStr)
+		http.Error(w, "Invalid device ID", http.StatusUnauthorized)
+		return
+	}
+
+	// The body is optional
+	var req HeartbeatRequest
+	if r.ContentLength != 0 && !h.decode(w, r, &req) {
+		return
+	}
+
+	if err := h.deviceService.RecordHeartbeat(deviceID, middleware.ClientIP(r), req.Status); err != nil {
+		h.logger.Warn("Invalid heartbeat", "device_id", deviceID, "error", err)
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
 // GetDevice handles device retrieval endpoint
 // GET /api/v1/devices/{deviceId}
 func (h *DeviceHandler) GetDevice(w http.ResponseWriter, r *http.Request) {
@@ -70,15 +233,86 @@ func (h *DeviceHandler) GetDevice(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// Get device with assignment information
-	deviceWithAssignment, err := h.deviceService.GetDeviceWithAssignment(deviceID)
+	// Get the authenticated user's roles and permissions (added by JWT middleware)
+	principal, err := middleware.GetPrincipalFromContext(r.Context())
+	if err != nil {
+		h.logger.Error("Failed to get principal from context", "error", err)
+		http.Error(w, "Authentication required", http.StatusUnauthorized)
+		return
+	}
+
+	// Get device with assignment information, hiding devices the user may not see
+	deviceWithAssignment, access, err := h.deviceService.GetDeviceForPrincipal(deviceID, principal)
 	if err != nil {
-		h.logger.Warn("Device not found", "device_id", deviceID)
+		h.logger.Warn("Device not found", "device_id", deviceID, "user_id", principal.UserID)
 		http.Error(w, "Device not found", http.StatusNotFound)
 		return
 	}
 
+	var response interface{} = deviceWithAssignment
+	if access == services.DeviceAccessClaimable {
+		response = deviceWithAssignment.ClaimableView()
+	}
+
 	// Return device information
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	if err := json.NewEncoder(w).Encode(response); err != nil {
+		h.logger.Error("Failed to encode response", "error", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+	}
+}
+
+// UpdateDevice changes the name and labels of a device assigned to the user
+// PATCH /api/v1/devices/{deviceId}
+func (h *DeviceHandler) UpdateDevice(w http.ResponseWriter, r *http.Request) {
+	// Extract device ID from URL
+	vars := mux.Vars(r)
+	deviceIDStr := vars["deviceId"]
+
+	deviceID, err := uuid.Parse(deviceIDStr)
+	if err != nil {
+		h.logger.Warn("Invalid device ID format", "device_id", deviceIDStr)
+		http.Error(w, "Invalid device ID", http.StatusBadRequest)
+		return
+	}
+
+	// Get the authenticated user's roles and permissions (added by JWT middleware)
+	principal, err := middleware.GetPrincipalFromContext(r.Context())
+	if err != nil {
+		h.logger.Error("Failed to get principal from context", "error", err)
+		http.Error(w, "Authentication required", http.StatusUnauthorized)
+		return
+	}
+
+	// Only users with full access (the assigned user or an administrator) may edit a device
+	deviceWithAssignment, access, err := h.deviceService.GetDeviceForPrincipal(deviceID, principal)
+	if err != nil || access != services.DeviceAccessFull {
+		h.logger.Warn("User attempted to update device they don't own",
+			"device_id", deviceID,
+			"user_id", principal.UserID)
+		http.Error(w, "Device not found or not assigned to you", http.StatusNotFound)
+		return
+	}
+
+	var req UpdateDeviceRequest
+	if !h.decode(w, r, &req) {
+		return
+	}
+
+	device, err := h.deviceService.UpdateDeviceMetadata(deviceID, models.DeviceMetadataUpdate{
+		Name:   req.Name,
+		Labels: req.Labels,
+	})
+	if err != nil {
+		h.writeUpdateError(w, deviceID, err)
+		return
+	}
+
+	h.logger.Info("Device updated by user", "device_id", deviceID, "user_id", principal.UserID)
+
+	deviceWithAssignment.Device = *device
+
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(http.StatusOK)
 	if err := json.NewEncoder(w).Encode(deviceWithAssignment); err != nil {
@@ -87,6 +321,63 @@ func (h *DeviceHandler) GetDevice(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
+// GetDeviceCertificates handles device certificate history endpoint
+// GET /api/v1/devices/{deviceId}/certificates
+func (h *DeviceHandler) GetDeviceCertificates(w http.ResponseWriter, r *http.Request) {
+	// Extract device ID from URL
+	vars := mux.Vars(r)
+	deviceIDStr := vars["deviceId"]
+
+	deviceID, err := uuid.Parse(deviceIDStr)
+	if err != nil {
+		h.logger.Warn("Invalid device ID format", "device_id", deviceIDStr)
+		http.Error(w, "Invalid device ID", http.StatusBadRequest)
+		return
+	}
+
+	// Get the authenticated user's roles and permissions (added by JWT middleware)
+	principal, err := middleware.GetPrincipalFromContext(r.Context())
+	if err != nil {
+		h.logger.Error("Failed to get principal from context", "error", err)
+		http.Error(w, "Authentication required", http.StatusUnauthorized)
+		return
+	}
+
+	// Only users with full access (the assigned user or an administrator) may see a
+	// device's certificate history
+	_, access, err := h.deviceService.GetDeviceForPrincipal(deviceID, principal)
+	if err != nil && err.Error() != "device not found" {
+		h.logger.Error("Failed to get device", "device_id", deviceID, "error", err)
+		http.Error(w, "Failed to retrieve certificates", http.StatusInternalServerError)
+		return
+	}
+	if err != nil || access != services.DeviceAccessFull {
+		h.logger.Warn("User attempted to view certificates of device they don't own",
+			"device_id", deviceID,
+			"user_id", principal.UserID)
+		http.Error(w, "Device not found or not assigned to you", http.StatusNotFound)
+		return
+	}
+
+	certificates, err := h.deviceService.GetDeviceCertificates(deviceID)
+	if err != nil {
+		h.logger.Error("Failed to get device certificates", "device_id", deviceID, "error", err)
+		http.Error(w, "Failed to retrieve certificates", http.StatusInternalServerError)
+		return
+	}
+
+	// Return certificate history
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	if err := json.NewEncoder(w).Encode(map[string]interface{}{
+		"certificates": certificates,
+		"count":        len(certificates),
+	}); err != nil {
+		h.logger.Error("Failed to encode response", "error", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+	}
+}
+
 // AssignDevice handles device assignment endpoint
 // POST /api/v1/devices/{deviceId}/assign
 func (h *DeviceHandler) AssignDevice(w http.ResponseWriter, r *http.Request) {
@@ -124,6 +415,10 @@ func (h *DeviceHandler) AssignDevice(w http.ResponseWriter, r *http.Request) {
 			http.Error(w, "Device is already assigned", http.StatusConflict)
 			return
 		}
+		if err.Error() == "device is not active" {
+			http.Error(w, "Device is not active", http.StatusConflict)
+			return
+		}
 		
 		http.Error(w, "Failed to assign device", http.StatusInternalServerError)
 		return
@@ -203,24 +498,186 @@ func (h *DeviceHandler) GetUserDevices(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	query, err := deviceQuery(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
 	// Get user's devices
-	devices, err := h.deviceService.GetUserDevices(userID)
+	page, err := h.deviceService.GetUserDevices(userID, query)
 	if err != nil {
 		h.logger.Error("Failed to get user devices", "user_id", userID, "error", err)
 		http.Error(w, "Failed to retrieve devices", http.StatusInternalServerError)
 		return
 	}
 
-	h.logger.Debug("Retrieved user devices", "user_id", userID, "count", len(devices))
+	h.logger.Debug("Retrieved user devices", "user_id", userID, "count", page.Count)
 
 	// Return devices
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(http.StatusOK)
-	if err := json.NewEncoder(w).Encode(map[string]interface{}{
-		"devices": devices,
-		"count":   len(devices),
-	}); err != nil {
+	if err := json.NewEncoder(w).Encode(page); err != nil {
 		h.logger.Error("Failed to encode response", "error", err)
 		http.Error(w, "Internal server error", http.StatusInternalServerError)
 	}
 }
+
+// ListDevices lists a page of the devices the authenticated user may list: every device for
+// administrators, otherwise the devices assigned to the user
+// GET /api/v1/devices
+func (h *DeviceHandler) ListDevices(w http.ResponseWriter, r *http.Request) {
+	principal, err := middleware.GetPrincipalFromContext(r.Context())
+	if err != nil {
+		h.logger.Error("Failed to get principal from context", "error", err)
+		http.Error(w, "Authentication required", http.StatusUnauthorized)
+		return
+	}
+
+	query, err := deviceQuery(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	page, err := h.deviceService.ListDevicesForPrincipal(principal, query)
+	if err != nil {
+		h.logger.Error("Failed to list devices", "user_id", principal.UserID, "error", err)
+		http.Error(w, "Failed to retrieve devices", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	if err := json.NewEncoder(w).Encode(page); err != nil {
+		h.logger.Error("Failed to encode response", "error", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+	}
+}
+
+// decode reads a JSON device update body, writing a 400 response when it is invalid. Unknown
+// fields are rejected, so that callers cannot mistake fields they may not set for ignored ones.
+func (h *DeviceHandler) decode(w http.ResponseWriter, r *http.Request, target interface{}) bool {
+	r.Body = http.MaxBytesReader(w, r.Body, maxDeviceRequestSize)
+
+	decoder := json.NewDecoder(r.Body)
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(target); err != nil {
+		h.logger.Warn("Invalid request body", "path", r.URL.Path, "error", err)
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return false
+	}
+	return true
+}
+
+// writeUpdateError writes the response for a failed device metadata update
+func (h *DeviceHandler) writeUpdateError(w http.ResponseWriter, deviceID uuid.UUID, err error) {
+	switch {
+	case err.Error() == "device not found":
+		http.Error(w, "Device not found", http.StatusNotFound)
+	case strings.HasPrefix(err.Error(), "invalid device metadata"):
+		h.logger.Warn("Invalid device metadata", "device_id", deviceID, "error", err)
+		http.Error(w, err.Error(), http.StatusBadRequest)
+	default:
+		http.Error(w, "Failed to update device", http.StatusInternalServerError)
+	}
+}
+
+// labelSelector parses the label query parameters, each of the form key=value, into a
+// selector matching devices that carry every listed label
+func labelSelector(r *http.Request) (models.Labels, error) {
+	values := r.URL.Query()["label"]
+	if len(values) == 0 {
+		return nil, nil
+	}
+
+	selector := make(models.Labels, len(values))
+	for _, value := range values {
+		key, labelValue, ok := strings.Cut(value, "=")
+		if !ok {
+			return nil, fmt.Errorf("label filter %q must be of the form key=value", value)
+		}
+		if err := models.ValidateLabel(key, labelValue); err != nil {
+			return nil, err
+		}
+		selector[key] = labelValue
+	}
+
+	return selector, nil
+}
+
+// deviceQuery parses the filters, order and page of a device listing from the query parameters
+func deviceQuery(r *http.Request) (*models.DeviceQuery, error) {
+	params := r.URL.Query()
+	query := models.NewDeviceQuery()
+
+	if value := params.Get("limit"); value != "" {
+		limit, err := strconv.Atoi(value)
+		if err != nil || limit < 1 || limit > models.MaxDevicePageSize {
+			return nil, fmt.Errorf("limit must be between 1 and %d", models.MaxDevicePageSize)
+		}
+		query.Limit = limit
+	}
+
+	if value := params.Get("sort"); value != "" {
+		var err error
+		if query.Sort, query.Descending, err = models.ParseDeviceSort(value); err != nil {
+			return nil, err
+		}
+	}
+
+	if value := params.Get("cursor"); value != "" {
+		var err error
+		if query.After, err = models.ParseDeviceCursor(value, query.Sort, query.Descending); err != nil {
+			return nil, err
+		}
+	}
+
+	if value := params.Get("assigned"); value != "" {
+		assigned, err := strconv.ParseBool(value)
+		if err != nil {
+			return nil, fmt.Errorf("assigned must be true or false")
+		}
+		query.Assigned = &assigned
+	}
+
+	query.Issuer = params.Get("issuer")
+
+	for _, value := range params["status"] {
+		status, err := models.ParseDeviceStatus(value)
+		if err != nil {
+			return nil, err
+		}
+		query.Statuses = append(query.Statuses, status)
+	}
+
+	var err error
+	if query.Labels, err = labelSelector(r); err != nil {
+		return nil, err
+	}
+
+	for name, target := range map[string]**time.Time{
+		"created_after":    &query.CreatedAfter,
+		"created_before":   &query.CreatedBefore,
+		"last_seen_after":  &query.LastSeenAfter,
+		"last_seen_before": &query.LastSeenBefore,
+	} {
+		if value := params.Get(name); value != "" {
+			t, err := time.Parse(time.RFC3339, value)
+			if err != nil {
+				return nil, fmt.Errorf("%s must be an RFC 3339 timestamp", name)
+			}
+			*target = &t
+		}
+	}
+
+	if value := params.Get("include_total"); value != "" {
+		includeTotal, err := strconv.ParseBool(value)
+		if err != nil {
+			return nil, fmt.Errorf("include_total must be true or false")
+		}
+		query.IncludeTotal = includeTotal
+	}
+
+	return query, nil
+}
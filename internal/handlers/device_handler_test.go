@@ -0,0 +1,676 @@
+package handlers
+
+import (
+	"context"
+	"crypto/x509"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"device-assignment-api/internal/middleware"
+	"device-assignment-api/internal/models"
+	"device-assignment-api/internal/services"
+	"device-assignment-api/pkg/auth"
+
+	"github.com/google/uuid"
+	"github.com/gorilla/mux"
+)
+
+func TestGetDeviceAuthorization(t *testing.T) {
+	store := newMemoryStore()
+	owned := store.addDevice("alice")
+	unassigned := store.addDevice("")
+
+	handler := NewDeviceHandler(newTestDeviceService(store), nil, testLogger())
+
+	tests := []struct {
+		name           string
+		deviceID       string
+		userID         string
+		roles          []string
+		expectedStatus int
+		expectFull     bool
+	}{
+		{"owner sees full detail", owned.ID.String(), "alice", nil, http.StatusOK, true},
+		{"other user gets not found", owned.ID.String(), "bob", nil, http.StatusNotFound, false},
+		{"admin sees assigned device", owned.ID.String(), "carol", []string{"admin"}, http.StatusOK, true},
+		{"user sees claimable view of unassigned device", unassigned.ID.String(), "bob", nil, http.StatusOK, false},
+		{"admin sees full unassigned device", unassigned.ID.String(), "carol", []string{"admin"}, http.StatusOK, true},
+		{"user without assign permission gets not found", unassigned.ID.String(), "dave", []string{"auditor"}, http.StatusNotFound, false},
+		{"missing device gets not found", "3f1c1e9e-0000-4000-8000-000000000000", "alice", nil, http.StatusNotFound, false},
+		{"invalid device ID", "not-a-uuid", "alice", nil, http.StatusBadRequest, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "/api/v1/devices/"+tt.deviceID, nil)
+			req = mux.SetURLVars(withUser(req, tt.userID, tt.roles...), map[string]string{"deviceId": tt.deviceID})
+			rec := httptest.NewRecorder()
+
+			handler.GetDevice(rec, req)
+
+			if rec.Code != tt.expectedStatus {
+				t.Fatalf("Expected status %d, got %d: %s", tt.expectedStatus, rec.Code, rec.Body.String())
+			}
+			if rec.Code != http.StatusOK {
+				return
+			}
+
+			var body map[string]interface{}
+			if err := json.Unmarshal(rec.Body.Bytes(), &body); err != nil {
+				t.Fatalf("Failed to decode response: %v", err)
+			}
+
+			_, hasSerial := body["certificate_serial_number"]
+			if hasSerial != tt.expectFull {
+				t.Errorf("Expected full detail %v, got body %v", tt.expectFull, body)
+			}
+			if body["id"] != tt.deviceID {
+				t.Errorf("Expected device ID %s, got %v", tt.deviceID, body["id"])
+			}
+		})
+	}
+}
+
+func TestGetDeviceCertificatesAuthorization(t *testing.T) {
+	store := newMemoryStore()
+	owned := store.addDevice("alice")
+	unassigned := store.addDevice("")
+
+	handler := NewDeviceHandler(newTestDeviceService(store), nil, testLogger())
+
+	tests := []struct {
+		name           string
+		deviceID       string
+		userID         string
+		roles          []string
+		expectedStatus int
+	}{
+		{"owner", owned.ID.String(), "alice", nil, http.StatusOK},
+		{"admin", owned.ID.String(), "carol", []string{"admin"}, http.StatusOK},
+		{"other user", owned.ID.String(), "bob", nil, http.StatusNotFound},
+		{"claimable device", unassigned.ID.String(), "bob", nil, http.StatusNotFound},
+		{"unknown device", uuid.NewString(), "carol", []string{"admin"}, http.StatusNotFound},
+	}
+
+	getCertificates := func(deviceID, userID string, roles ...string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, "/api/v1/devices/"+deviceID+"/certificates", nil)
+		req = mux.SetURLVars(withUser(req, userID, roles...), map[string]string{"deviceId": deviceID})
+		rec := httptest.NewRecorder()
+		handler.GetDeviceCertificates(rec, req)
+		return rec
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if rec := getCertificates(tt.deviceID, tt.userID, tt.roles...); rec.Code != tt.expectedStatus {
+				t.Errorf("Expected status %d, got %d: %s", tt.expectedStatus, rec.Code, rec.Body.String())
+			}
+		})
+	}
+
+	// A failing lookup is a server error, not a missing device
+	store.lookupErr = fmt.Errorf("connection refused")
+	if rec := getCertificates(owned.ID.String(), "alice"); rec.Code != http.StatusInternalServerError {
+		t.Errorf("Expected status %d when the lookup fails, got %d", http.StatusInternalServerError, rec.Code)
+	}
+}
+
+func TestDeviceTokenAuthentication(t *testing.T) {
+	store := newMemoryStore()
+	deviceService := newTestDeviceService(store)
+	jwtManager := auth.NewJWTManager("secret", time.Hour, "test")
+	handler := NewDeviceHandler(deviceService, services.NewDeviceTokenService(jwtManager, 5*time.Minute, false, testLogger()), testLogger())
+
+	certInfo := &auth.CertificateInfo{
+		SerialNumber: "1234",
+		IssuerDN:     "CN=Test CA",
+		IssuerCN:     "Test CA",
+		Fingerprint:  "ab12",
+		IsValid:      true,
+	}
+
+	req := httptest.NewRequest(http.MethodPost, "/api/v1/devices/authenticate", nil)
+	req = req.WithContext(context.WithValue(req.Context(), middleware.CertificateInfoContextKey, certInfo))
+	rec := httptest.NewRecorder()
+
+	handler.AuthenticateDevice(rec, req)
+
+	if rec.Code != http.StatusOK {
+		t.Fatalf("Expected status %d, got %d: %s", http.StatusOK, rec.Code, rec.Body.String())
+	}
+
+	var response struct {
+		ID          string `json:"id"`
+		AccessToken string `json:"access_token"`
+		ExpiresIn   int    `json:"expires_in"`
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), &response); err != nil {
+		t.Fatalf("Failed to decode response: %v", err)
+	}
+	if response.ID == "" || response.AccessToken == "" || response.ExpiresIn != 300 {
+		t.Fatalf("Expected the device record with a 5 minute token, got %s", rec.Body.String())
+	}
+
+	claims, err := jwtManager.ValidateDeviceToken(response.AccessToken)
+	if err != nil {
+		t.Fatalf("Expected a valid device token, got %v", err)
+	}
+	if claims.DeviceID != response.ID || claims.CertificateThumbprint != "ab12" {
+		t.Errorf("Expected claims for device %s with thumbprint ab12, got %+v", response.ID, claims)
+	}
+
+	userToken, _ := jwtManager.GenerateToken("alice")
+	deviceMiddleware := middleware.NewDeviceAuthMiddleware(
+		middleware.NewCertificateAuthMiddleware(nil, nil, auth.IdentityConfig{}, nil, testLogger()),
+		jwtManager,
+		deviceService,
+		testLogger(),
+	)
+	protected := deviceMiddleware.Authenticate(http.HandlerFunc(handler.GetCurrentDevice))
+
+	tests := []struct {
+		name           string
+		authorization  string
+		expectedStatus int
+	}{
+		{"device token", "Bearer " + response.AccessToken, http.StatusOK},
+		{"user token", "Bearer " + userToken, http.StatusUnauthorized},
+		{"malformed header", response.AccessToken, http.StatusUnauthorized},
+		{"no token or certificate", "", http.StatusBadRequest},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "/api/v1/devices/me", nil)
+			if tt.authorization != "" {
+				req.Header.Set("Authorization", tt.authorization)
+			}
+			rec := httptest.NewRecorder()
+
+			protected.ServeHTTP(rec, req)
+
+			if rec.Code != tt.expectedStatus {
+				t.Fatalf("Expected status %d, got %d: %s", tt.expectedStatus, rec.Code, rec.Body.String())
+			}
+			if rec.Code == http.StatusOK && !strings.Contains(rec.Body.String(), response.ID) {
+				t.Errorf("Expected the authenticated device's record, got %s", rec.Body.String())
+			}
+		})
+	}
+}
+
+func TestAuthenticateDeviceWithoutTokens(t *testing.T) {
+	handler := NewDeviceHandler(newTestDeviceService(newMemoryStore()), nil, testLogger())
+
+	certInfo := &auth.CertificateInfo{SerialNumber: "1234", IssuerDN: "CN=Test CA", IssuerCN: "Test CA", IsValid: true}
+	req := httptest.NewRequest(http.MethodPost, "/api/v1/devices/authenticate", nil)
+	req = req.WithContext(context.WithValue(req.Context(), middleware.CertificateInfoContextKey, certInfo))
+	rec := httptest.NewRecorder()
+
+	handler.AuthenticateDevice(rec, req)
+
+	if rec.Code != http.StatusOK {
+		t.Fatalf("Expected status %d, got %d: %s", http.StatusOK, rec.Code, rec.Body.String())
+	}
+	if strings.Contains(rec.Body.String(), "access_token") {
+		t.Errorf("Expected no device token when issuance is disabled, got %s", rec.Body.String())
+	}
+}
+
+func TestCheckCertificateDeviceStatusFailsClosed(t *testing.T) {
+	store := newMemoryStore()
+	deviceService := newTestDeviceService(store)
+
+	suspended := store.addDevice("")
+	deviceService.ChangeDeviceStatus(suspended.ID, models.DeviceStatusSuspended, "admin", "Security review")
+
+	certInfo := func(serialNumber string) *auth.CertificateInfo {
+		return &auth.CertificateInfo{SerialNumber: serialNumber, IssuerDN: "CN=Test CA", IssuerCN: "Test CA", IsValid: true}
+	}
+
+	if err := deviceService.CheckCertificateDeviceStatus(certInfo("1234")); err != nil {
+		t.Errorf("Expected an unregistered device to be accepted, got %v", err)
+	}
+	if err := deviceService.CheckCertificateDeviceStatus(certInfo(suspended.CertificateSerialNumber)); err == nil || !strings.HasPrefix(err.Error(), "device is ") {
+		t.Errorf("Expected a suspended device to be refused, got %v", err)
+	}
+
+	// A failing lookup must not pass a suspended device off as unregistered
+	store.lookupErr = fmt.Errorf("connection refused")
+	err := deviceService.CheckCertificateDeviceStatus(certInfo(suspended.CertificateSerialNumber))
+	if err == nil || strings.HasPrefix(err.Error(), "device is ") {
+		t.Errorf("Expected the failed lookup to be reported, got %v", err)
+	}
+	if _, err := deviceService.AuthenticateAndRegisterDevice(certInfo("5678")); err == nil {
+		t.Error("Expected authentication to fail when the lookup fails")
+	}
+	store.lookupErr = nil
+	if len(store.devices) != 1 {
+		t.Errorf("Expected no device to be registered while lookups fail, got %d devices", len(store.devices))
+	}
+
+	identity, _ := auth.NewIdentityConfig("subject_cn", "", nil)
+	registration := services.RegistrationPolicy{Mode: models.RegistrationModeOpen, InitialStatus: models.DeviceStatusActive, Allowlist: store}
+	bySubject := services.NewDeviceService(store, store, store, store, identity, services.OwnershipPolicy{}, registration, newTestPresenceTracker(store), testLogger())
+	if err := bySubject.CheckCertificateDeviceStatus(certInfo("1234")); err == nil || !strings.HasPrefix(err.Error(), "device is ") {
+		t.Errorf("Expected a certificate without an identity to be refused, got %v", err)
+	}
+}
+
+func TestUpdateDevice(t *testing.T) {
+	store := newMemoryStore()
+	owned := store.addDevice("alice")
+	unassigned := store.addDevice("")
+
+	handler := NewDeviceHandler(newTestDeviceService(store), nil, testLogger())
+
+	tests := []struct {
+		name           string
+		deviceID       string
+		userID         string
+		roles          []string
+		body           string
+		expectedStatus int
+	}{
+		{"owner renames and labels", owned.ID.String(), "alice", nil, `{"name": " Lab printer ", "labels": {"env": "prod", "site/floor": "3"}}`, http.StatusOK},
+		{"admin renames", owned.ID.String(), "carol", []string{"admin"}, `{"name": "Lab printer"}`, http.StatusOK},
+		{"other user gets not found", owned.ID.String(), "bob", nil, `{"name": "Mine now"}`, http.StatusNotFound},
+		{"claimable device gets not found", unassigned.ID.String(), "bob", nil, `{"name": "Mine now"}`, http.StatusNotFound},
+		{"reported field rejected", owned.ID.String(), "alice", nil, `{"firmware_version": "1.0.0"}`, http.StatusBadRequest},
+		{"invalid label key", owned.ID.String(), "alice", nil, `{"labels": {"Env": "prod"}}`, http.StatusBadRequest},
+		{"name too long", owned.ID.String(), "alice", nil, `{"name": "` + strings.Repeat("a", 101) + `"}`, http.StatusBadRequest},
+		{"invalid body", owned.ID.String(), "alice", nil, `{`, http.StatusBadRequest},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodPatch, "/api/v1/devices/"+tt.deviceID, strings.NewReader(tt.body))
+			req = mux.SetURLVars(withUser(req, tt.userID, tt.roles...), map[string]string{"deviceId": tt.deviceID})
+			rec := httptest.NewRecorder()
+
+			handler.UpdateDevice(rec, req)
+
+			if rec.Code != tt.expectedStatus {
+				t.Fatalf("Expected status %d, got %d: %s", tt.expectedStatus, rec.Code, rec.Body.String())
+			}
+		})
+	}
+
+	device, _ := store.GetDeviceByID(owned.ID)
+	if device.Name != "Lab printer" {
+		t.Errorf("Expected the trimmed name to be stored, got %q", device.Name)
+	}
+	if device.Labels["env"] != "prod" || device.Labels["site/floor"] != "3" {
+		t.Errorf("Expected the labels to be kept by the later rename, got %v", device.Labels)
+	}
+}
+
+func TestReportCurrentDevice(t *testing.T) {
+	store := newMemoryStore()
+	device := store.addDevice("alice")
+
+	handler := NewDeviceHandler(newTestDeviceService(store), nil, testLogger())
+
+	report := func(body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPatch, "/api/v1/devices/me", strings.NewReader(body))
+		req = req.WithContext(context.WithValue(req.Context(), middleware.DeviceIDContextKey, device.ID.String()))
+		rec := httptest.NewRecorder()
+		handler.ReportCurrentDevice(rec, req)
+		return rec
+	}
+
+	rec := report(`{"model": "RPi 4B", "hardware_revision": "1.4", "firmware_version": "2.3.1"}`)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("Expected status %d, got %d: %s", http.StatusOK, rec.Code, rec.Body.String())
+	}
+
+	if rec := report(`{"name": "Renamed by device"}`); rec.Code != http.StatusBadRequest {
+		t.Errorf("Expected a device to be refused setting its name, got %d", rec.Code)
+	}
+
+	if rec := report(`{"firmware_version": "2.3.1\n"}`); rec.Code != http.StatusOK {
+		t.Errorf("Expected surrounding whitespace to be trimmed, got %d: %s", rec.Code, rec.Body.String())
+	}
+
+	if rec := report(`{"firmware_version": "2.3\u00001"}`); rec.Code != http.StatusBadRequest {
+		t.Errorf("Expected control characters to be rejected, got %d", rec.Code)
+	}
+
+	stored, _ := store.GetDeviceByID(device.ID)
+	if stored.Model != "RPi 4B" || stored.HardwareRevision != "1.4" || stored.FirmwareVersion != "2.3.1" || stored.Name != "" {
+		t.Errorf("Expected the reported details to be stored, got %+v", stored)
+	}
+}
+
+func TestGetUserDevicesLabelFilter(t *testing.T) {
+	store := newMemoryStore()
+	labelled := func(labels models.Labels) *models.Device {
+		device := store.addDevice("alice")
+		update := models.DeviceMetadataUpdate{Labels: labels}
+		device.ApplyMetadata(update)
+		store.UpdateDeviceMetadata(device, update)
+		return device
+	}
+
+	prod := labelled(models.Labels{"env": "prod", "team": "qa"})
+	labelled(models.Labels{"env": "staging", "team": "qa"})
+	store.addDevice("alice")
+
+	handler := NewDeviceHandler(newTestDeviceService(store), nil, testLogger())
+
+	tests := []struct {
+		name           string
+		query          string
+		expectedStatus int
+		expectedCount  int
+	}{
+		{"no filter", "", http.StatusOK, 3},
+		{"single label", "?label=team=qa", http.StatusOK, 2},
+		{"every label must match", "?label=team=qa&label=env=prod", http.StatusOK, 1},
+		{"no match", "?label=env=dev", http.StatusOK, 0},
+		{"missing value separator", "?label=env", http.StatusBadRequest, 0},
+		{"invalid key", "?label=Env=prod", http.StatusBadRequest, 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := withUser(httptest.NewRequest(http.MethodGet, "/api/v1/users/me/devices"+tt.query, nil), "alice")
+			rec := httptest.NewRecorder()
+
+			handler.GetUserDevices(rec, req)
+
+			if rec.Code != tt.expectedStatus {
+				t.Fatalf("Expected status %d, got %d: %s", tt.expectedStatus, rec.Code, rec.Body.String())
+			}
+			if rec.Code != http.StatusOK {
+				return
+			}
+
+			var body struct {
+				Devices []*models.DeviceWithAssignment `json:"devices"`
+				Count   int                            `json:"count"`
+			}
+			if err := json.Unmarshal(rec.Body.Bytes(), &body); err != nil {
+				t.Fatalf("Failed to decode response: %v", err)
+			}
+			if body.Count != tt.expectedCount {
+				t.Errorf("Expected %d devices, got %d", tt.expectedCount, body.Count)
+			}
+			if tt.expectedCount == 1 && body.Devices[0].ID != prod.ID {
+				t.Errorf("Expected device %s, got %s", prod.ID, body.Devices[0].ID)
+			}
+		})
+	}
+}
+
+func TestHeartbeat(t *testing.T) {
+	store := newMemoryStore()
+	device := store.addDevice("alice")
+	silent := store.addDevice("alice")
+
+	presence := newTestPresenceTracker(store)
+	deviceService := newTestDeviceServiceWithPresence(store, presence)
+	handler := NewDeviceHandler(deviceService, nil, testLogger())
+
+	heartbeat := func(body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, "/api/v1/devices/me/heartbeat", strings.NewReader(body))
+		req.RemoteAddr = "198.51.100.7:51234"
+		req = req.WithContext(context.WithValue(req.Context(), middleware.DeviceIDContextKey, device.ID.String()))
+		rec := httptest.NewRecorder()
+		handler.Heartbeat(rec, req)
+		return rec
+	}
+
+	if rec := heartbeat(`{"status": "charging"}`); rec.Code != http.StatusNoContent {
+		t.Fatalf("Expected status %d, got %d: %s", http.StatusNoContent, rec.Code, rec.Body.String())
+	}
+	if rec := heartbeat(""); rec.Code != http.StatusNoContent {
+		t.Fatalf("Expected a heartbeat without a body to be accepted, got %d", rec.Code)
+	}
+	if rec := heartbeat(`{"status": "` + strings.Repeat("s", 65) + `"}`); rec.Code != http.StatusBadRequest {
+		t.Errorf("Expected a reported status that is too long to be rejected, got %d", rec.Code)
+	}
+	if rec := heartbeat(`{"battery": 80}`); rec.Code != http.StatusBadRequest {
+		t.Errorf("Expected unknown fields to be rejected, got %d", rec.Code)
+	}
+
+	// Pending heartbeats are reflected before they are written
+	page, err := deviceService.GetUserDevices("alice", models.NewDeviceQuery())
+	if err != nil {
+		t.Fatalf("Failed to list devices: %v", err)
+	}
+	for _, listed := range page.Devices {
+		switch listed.ID {
+		case device.ID:
+			if !listed.Online || listed.LastSeenAt == nil || listed.LastSeenIP != "198.51.100.7" || listed.ReportedStatus != "charging" {
+				t.Errorf("Expected the device to be online with its pending heartbeat, got %+v", listed.Device)
+			}
+		case silent.ID:
+			if listed.Online || listed.LastSeenAt != nil {
+				t.Errorf("Expected a device that never sent a heartbeat to be offline, got %+v", listed.Device)
+			}
+		}
+	}
+
+	if store.heartbeatWrites != 0 {
+		t.Fatalf("Expected heartbeats to be held until flushed, got %d writes", store.heartbeatWrites)
+	}
+	if err := presence.Flush(); err != nil {
+		t.Fatalf("Failed to flush heartbeats: %v", err)
+	}
+	if store.heartbeatWrites != 1 {
+		t.Errorf("Expected the heartbeats to be written in a single batch, got %d writes", store.heartbeatWrites)
+	}
+
+	stored, _ := store.GetDeviceByID(device.ID)
+	if stored.LastSeenAt == nil || stored.LastSeenIP != "198.51.100.7" || stored.ReportedStatus != "charging" {
+		t.Errorf("Expected the coalesced heartbeat to be stored, got %+v", stored)
+	}
+
+	// A device last seen longer ago than the timeout is offline
+	stale := time.Now().Add(-time.Hour)
+	stored.LastSeenAt = &stale
+	store.UpdateDeviceCertificate(stored)
+
+	withAssignment, err := deviceService.GetDeviceWithAssignment(device.ID)
+	if err != nil {
+		t.Fatalf("Failed to get device: %v", err)
+	}
+	if withAssignment.Online {
+		t.Error("Expected a device whose last heartbeat is older than the timeout to be offline")
+	}
+
+	// Behind a trusted proxy, the address it forwarded for the device is recorded
+	proxies, _ := auth.NewTrustedProxies([]string{"192.0.2.0/24"}, nil)
+	forwarded := middleware.NewForwardedCertificateMiddleware("X-Forwarded-Client-Cert", auth.ForwardedCertXFCC, proxies,
+		func() *x509.CertPool { return x509.NewCertPool() }, testLogger())
+
+	req := httptest.NewRequest(http.MethodPost, "/api/v1/devices/me/heartbeat", nil)
+	req.RemoteAddr = "192.0.2.10:443"
+	req.Header.Set("X-Forwarded-For", "203.0.113.9")
+	req = req.WithContext(context.WithValue(req.Context(), middleware.DeviceIDContextKey, device.ID.String()))
+	rec := httptest.NewRecorder()
+	forwarded.Handler(http.HandlerFunc(handler.Heartbeat)).ServeHTTP(rec, req)
+	if rec.Code != http.StatusNoContent {
+		t.Fatalf("Expected status %d, got %d: %s", http.StatusNoContent, rec.Code, rec.Body.String())
+	}
+
+	withAssignment, _ = deviceService.GetDeviceWithAssignment(device.ID)
+	if withAssignment.LastSeenIP != "203.0.113.9" {
+		t.Errorf("Expected the forwarded client address to be recorded, got %s", withAssignment.LastSeenIP)
+	}
+}
+
+func TestListDevices(t *testing.T) {
+	store := newMemoryStore()
+	var devices []*models.Device
+	for i, userID := range []string{"alice", "alice", "bob", "", ""} {
+		device := store.addDevice(userID)
+		name := fmt.Sprintf("device-%d", i)
+		update := models.DeviceMetadataUpdate{Name: &name}
+		device.ApplyMetadata(update)
+		store.UpdateDeviceMetadata(device, update)
+		devices = append(devices, device)
+	}
+
+	deviceService := newTestDeviceService(store)
+	deviceService.ChangeDeviceStatus(devices[4].ID, models.DeviceStatusSuspended, "admin", "Security review")
+
+	handler := NewDeviceHandler(deviceService, nil, testLogger())
+
+	list := func(userID string, roles []string, query string) (*httptest.ResponseRecorder, *models.DevicePage) {
+		req := withUser(httptest.NewRequest(http.MethodGet, "/api/v1/devices"+query, nil), userID, roles...)
+		rec := httptest.NewRecorder()
+		handler.ListDevices(rec, req)
+
+		var page models.DevicePage
+		if rec.Code == http.StatusOK {
+			if err := json.Unmarshal(rec.Body.Bytes(), &page); err != nil {
+				t.Fatalf("Failed to decode response: %v", err)
+			}
+		}
+		return rec, &page
+	}
+
+	t.Run("pages through every device in order", func(t *testing.T) {
+		var names []string
+		query := "?sort=name&limit=2&include_total=true"
+		for pages := 0; ; pages++ {
+			if pages > len(devices) {
+				t.Fatal("Expected paging to end")
+			}
+
+			rec, page := list("admin", []string{"admin"}, query)
+			if rec.Code != http.StatusOK {
+				t.Fatalf("Expected status %d, got %d: %s", http.StatusOK, rec.Code, rec.Body.String())
+			}
+			if page.TotalCount == nil || *page.TotalCount != len(devices) {
+				t.Errorf("Expected a total count of %d, got %v", len(devices), page.TotalCount)
+			}
+			for _, device := range page.Devices {
+				names = append(names, device.Name)
+			}
+
+			if page.NextCursor == "" {
+				break
+			}
+			query = "?sort=name&limit=2&include_total=true&cursor=" + page.NextCursor
+		}
+
+		expected := "device-0,device-1,device-2,device-3,device-4"
+		if got := strings.Join(names, ","); got != expected {
+			t.Errorf("Expected %s, got %s", expected, got)
+		}
+	})
+
+	tests := []struct {
+		name           string
+		userID         string
+		roles          []string
+		query          string
+		expectedStatus int
+		expectedCount  int
+	}{
+		{"users only list their own devices", "alice", nil, "", http.StatusOK, 2},
+		{"users cannot list unassigned devices", "alice", nil, "?assigned=false", http.StatusOK, 0},
+		{"unassigned", "admin", []string{"admin"}, "?assigned=false", http.StatusOK, 2},
+		{"assigned", "admin", []string{"admin"}, "?assigned=true", http.StatusOK, 3},
+		{"status", "admin", []string{"admin"}, "?status=suspended", http.StatusOK, 1},
+		{"several statuses", "admin", []string{"admin"}, "?status=suspended&status=active", http.StatusOK, 5},
+		{"issuer CN", "admin", []string{"admin"}, "?issuer=Test+CA", http.StatusOK, 5},
+		{"other issuer", "admin", []string{"admin"}, "?issuer=Other+CA", http.StatusOK, 0},
+		{"created range", "admin", []string{"admin"}, "?created_after=2000-01-01T00:00:00Z&created_before=2100-01-01T00:00:00Z", http.StatusOK, 5},
+		{"never seen", "admin", []string{"admin"}, "?last_seen_after=2000-01-01T00:00:00Z", http.StatusOK, 0},
+		{"limit", "admin", []string{"admin"}, "?limit=3", http.StatusOK, 3},
+		{"limit too large", "admin", []string{"admin"}, "?limit=201", http.StatusBadRequest, 0},
+		{"limit not a number", "admin", []string{"admin"}, "?limit=all", http.StatusBadRequest, 0},
+		{"unknown sort", "admin", []string{"admin"}, "?sort=serial", http.StatusBadRequest, 0},
+		{"unknown status", "admin", []string{"admin"}, "?status=retired", http.StatusBadRequest, 0},
+		{"invalid assigned", "admin", []string{"admin"}, "?assigned=maybe", http.StatusBadRequest, 0},
+		{"invalid timestamp", "admin", []string{"admin"}, "?created_after=yesterday", http.StatusBadRequest, 0},
+		{"invalid cursor", "admin", []string{"admin"}, "?cursor=abc", http.StatusBadRequest, 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec, page := list(tt.userID, tt.roles, tt.query)
+			if rec.Code != tt.expectedStatus {
+				t.Fatalf("Expected status %d, got %d: %s", tt.expectedStatus, rec.Code, rec.Body.String())
+			}
+			if rec.Code == http.StatusOK && page.Count != tt.expectedCount {
+				t.Errorf("Expected %d devices, got %d", tt.expectedCount, page.Count)
+			}
+		})
+	}
+
+	t.Run("cursor must match the sort", func(t *testing.T) {
+		_, page := list("admin", []string{"admin"}, "?sort=name&limit=1")
+		if rec, _ := list("admin", []string{"admin"}, "?sort=-name&cursor="+page.NextCursor); rec.Code != http.StatusBadRequest {
+			t.Errorf("Expected status %d, got %d", http.StatusBadRequest, rec.Code)
+		}
+	})
+}
+
+func TestAuthenticateDeviceRegistrationModes(t *testing.T) {
+	authenticate := func(handler *DeviceHandler, serialNumber string) *httptest.ResponseRecorder {
+		certInfo := &auth.CertificateInfo{SerialNumber: serialNumber, IssuerDN: "CN=Test CA", IssuerCN: "Test CA", IsValid: true}
+		req := httptest.NewRequest(http.MethodPost, "/api/v1/devices/authenticate", nil)
+		req = req.WithContext(context.WithValue(req.Context(), middleware.CertificateInfoContextKey, certInfo))
+		rec := httptest.NewRecorder()
+		handler.AuthenticateDevice(rec, req)
+		return rec
+	}
+
+	t.Run("allowlist", func(t *testing.T) {
+		store := newMemoryStore()
+		deviceService := newTestDeviceServiceWith(store, models.RegistrationModeAllowlist, nil)
+		handler := NewDeviceHandler(deviceService, nil, testLogger())
+
+		if rec := authenticate(handler, "1234"); rec.Code != http.StatusForbidden {
+			t.Fatalf("Expected status %d for a device not allowlisted, got %d: %s", http.StatusForbidden, rec.Code, rec.Body.String())
+		}
+		if page, _ := deviceService.ListDevices(models.NewDeviceQuery()); page.Count != 0 {
+			t.Errorf("Expected the refused device not to be registered, got %d devices", page.Count)
+		}
+
+		entry, _ := models.NewAllowlistEntry("1234", "CN=Test CA", "", "", "", "admin")
+		if _, err := deviceService.ImportAllowlistEntries([]*models.AllowlistEntry{entry}); err != nil {
+			t.Fatalf("Failed to import allowlist entry: %v", err)
+		}
+
+		rec := authenticate(handler, "1234")
+		if rec.Code != http.StatusOK {
+			t.Fatalf("Expected status %d, got %d: %s", http.StatusOK, rec.Code, rec.Body.String())
+		}
+		if !strings.Contains(rec.Body.String(), `"status":"active"`) {
+			t.Errorf("Expected the allowlisted device to be registered as active, got %s", rec.Body.String())
+		}
+	})
+
+	t.Run("approval", func(t *testing.T) {
+		store := newMemoryStore()
+		deviceService := newTestDeviceServiceWith(store, models.RegistrationModeApproval, nil)
+		handler := NewDeviceHandler(deviceService, nil, testLogger())
+
+		rec := authenticate(handler, "1234")
+		if rec.Code != http.StatusOK {
+			t.Fatalf("Expected status %d, got %d: %s", http.StatusOK, rec.Code, rec.Body.String())
+		}
+
+		var body DeviceAuthenticationResponse
+		if err := json.Unmarshal(rec.Body.Bytes(), &body); err != nil {
+			t.Fatalf("Failed to decode response: %v", err)
+		}
+		if body.Device.Status != models.DeviceStatusPending {
+			t.Errorf("Expected the device to await approval, got %s", body.Device.Status)
+		}
+		if err := deviceService.AssignDeviceToUser(body.Device.ID, "alice"); err == nil || err.Error() != "device is not active" {
+			t.Errorf("Expected a pending device to be refused for assignment, got %v", err)
+		}
+	})
+}
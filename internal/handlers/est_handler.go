@@ -0,0 +1,247 @@
+package handlers
+
+import (
+	"bytes"
+	"crypto/subtle"
+	"crypto/x509"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"net/http"
+	"slices"
+	"strings"
+
+	"device-assignment-api/internal/middleware"
+	"device-assignment-api/internal/services"
+	"device-assignment-api/pkg/auth"
+	"device-assignment-api/pkg/logger"
+)
+
+// maxCSRSize bounds the size of an EST certificate request body
+const maxCSRSize = 64 * 1024
+
+// ESTHandler implements the EST (RFC 7030) enrollment endpoints
+type ESTHandler struct {
+	ca                *auth.CertificateAuthority
+	deviceService     *services.DeviceService
+	bootstrapUsername string
+	bootstrapPassword string
+	logger            logger.Logger
+}
+
+// NewESTHandler creates a new ESTHandler
+func NewESTHandler(
+	ca *auth.CertificateAuthority,
+	deviceService *services.DeviceService,
+	bootstrapUsername string,
+	bootstrapPassword string,
+	logger logger.Logger,
+) *ESTHandler {
+	return &ESTHandler{
+		ca:                ca,
+		deviceService:     deviceService,
+		bootstrapUsername: bootstrapUsername,
+		bootstrapPassword: bootstrapPassword,
+		logger:            logger,
+	}
+}
+
+// CACerts returns the issuing CA certificates
+// GET /.well-known/est/cacerts
+func (h *ESTHandler) CACerts(w http.ResponseWriter, r *http.Request) {
+	var certs [][]byte
+	for _, cert := range h.ca.Chain() {
+		certs = append(certs, cert.Raw)
+	}
+
+	h.writeCertsOnly(w, certs)
+}
+
+// SimpleEnroll issues a first certificate to a device holding the bootstrap credential. It only
+// enrolls new devices; a registered device renews its certificate by re-enrolling.
+// POST /.well-known/est/simpleenroll
+func (h *ESTHandler) SimpleEnroll(w http.ResponseWriter, r *http.Request) {
+	if !h.authorizeBootstrap(r) {
+		h.logger.Warn("EST enrollment rejected: invalid bootstrap credential", "remote_addr", r.RemoteAddr)
+		w.Header().Set("WWW-Authenticate", `Basic realm="est"`)
+		http.Error(w, "Bootstrap credential required", http.StatusUnauthorized)
+		return
+	}
+
+	csr, err := readCSR(r)
+	if err != nil {
+		h.logger.Warn("Invalid EST certificate request", "error", err)
+		http.Error(w, "Invalid certificate request", http.StatusBadRequest)
+		return
+	}
+
+	requested, err := h.ca.RequestInfo(csr)
+	if err != nil {
+		h.logger.Warn("Invalid EST certificate request", "error", err)
+		http.Error(w, "Invalid certificate request", http.StatusBadRequest)
+		return
+	}
+
+	// Decide before signing, so that no certificate is issued for a refused enrollment
+	if err := h.deviceService.CheckEnrollment(requested); err != nil {
+		h.enrollmentFailed(w, requested, err)
+		return
+	}
+
+	cert, err := h.ca.SignCSR(csr)
+	if err != nil {
+		h.logger.Warn("Failed to sign EST certificate request", "error", err)
+		http.Error(w, "Invalid certificate request", http.StatusBadRequest)
+		return
+	}
+
+	device, err := h.deviceService.RegisterEnrolledDevice(auth.ExtractCertificateInfo(cert))
+	if err != nil {
+		h.enrollmentFailed(w, requested, err)
+		return
+	}
+
+	h.logger.Info("Device enrolled via EST",
+		"device_id", device.ID,
+		"serial_number", device.CertificateSerialNumber,
+		"subject", cert.Subject.String())
+
+	h.writeCertsOnly(w, [][]byte{cert.Raw})
+}
+
+// SimpleReenroll renews the certificate of a device authenticated with its current certificate
+// POST /.well-known/est/simplereenroll
+func (h *ESTHandler) SimpleReenroll(w http.ResponseWriter, r *http.Request) {
+	// Get certificate info from context (added by certificate middleware)
+	certInfo, err := middleware.GetCertificateInfoFromContext(r.Context())
+	if err != nil {
+		h.logger.Error("Failed to get certificate info from context", "error", err)
+		http.Error(w, "Authentication failed", http.StatusUnauthorized)
+		return
+	}
+
+	csr, err := readCSR(r)
+	if err != nil {
+		h.logger.Warn("Invalid EST certificate request", "error", err)
+		http.Error(w, "Invalid certificate request", http.StatusBadRequest)
+		return
+	}
+
+	requested, err := h.ca.RequestInfo(csr)
+	if err != nil {
+		h.logger.Warn("Invalid EST certificate request", "error", err)
+		http.Error(w, "Invalid certificate request", http.StatusBadRequest)
+		return
+	}
+
+	// RFC 7030 section 4.2.2: the subject and subject alternative names must match the
+	// certificate being renewed, so the device keeps its identity
+	if !sameSubject(certInfo, requested) {
+		h.logger.Warn("EST re-enrollment subject mismatch",
+			"current_subject", certInfo.SubjectDN,
+			"requested_subject", requested.SubjectDN,
+			"current_dns_names", certInfo.DNSNames,
+			"requested_dns_names", requested.DNSNames)
+		http.Error(w, "Certificate request subject and subject alternative names must match the current certificate", http.StatusBadRequest)
+		return
+	}
+
+	cert, err := h.ca.SignCSR(csr)
+	if err != nil {
+		h.logger.Warn("Failed to sign EST certificate request", "error", err)
+		http.Error(w, "Invalid certificate request", http.StatusBadRequest)
+		return
+	}
+
+	device, err := h.deviceService.LinkRenewedCertificate(certInfo, auth.ExtractCertificateInfo(cert))
+	if err != nil {
+		h.logger.Error("Failed to link renewed certificate", "error", err)
+		http.Error(w, "Re-enrollment failed", http.StatusInternalServerError)
+		return
+	}
+
+	h.logger.Info("Device re-enrolled via EST",
+		"device_id", device.ID,
+		"previous_serial_number", certInfo.SerialNumber,
+		"serial_number", device.CertificateSerialNumber)
+
+	h.writeCertsOnly(w, [][]byte{cert.Raw})
+}
+
+// enrollmentFailed reports a refused or failed enrollment
+func (h *ESTHandler) enrollmentFailed(w http.ResponseWriter, requested *auth.CertificateInfo, err error) {
+	switch {
+	case err.Error() == "device identity is already registered":
+		h.logger.Warn("EST enrollment rejected: device identity is already registered",
+			"subject", requested.SubjectDN,
+			"dns_names", requested.DNSNames)
+		http.Error(w, "Device identity is already registered", http.StatusConflict)
+	case err.Error() == "device is not allowlisted":
+		h.logger.Warn("EST enrollment rejected: device is not allowlisted",
+			"subject", requested.SubjectDN,
+			"spki_fingerprint", requested.SPKIFingerprint)
+		http.Error(w, "Device is not permitted to register", http.StatusForbidden)
+	case strings.HasPrefix(err.Error(), "failed to derive device identity"):
+		h.logger.Warn("EST enrollment rejected: no device identity", "subject", requested.SubjectDN, "error", err)
+		http.Error(w, "Invalid certificate request", http.StatusBadRequest)
+	default:
+		h.logger.Error("Failed to register enrolled device", "error", err)
+		http.Error(w, "Enrollment failed", http.StatusInternalServerError)
+	}
+}
+
+// sameSubject reports whether a requested certificate has the subject and subject
+// alternative names of the current one
+func sameSubject(current, requested *auth.CertificateInfo) bool {
+	return requested.SubjectDN == current.SubjectDN &&
+		slices.Equal(requested.DNSNames, current.DNSNames) &&
+		slices.Equal(requested.EmailAddresses, current.EmailAddresses) &&
+		slices.Equal(requested.URIs, current.URIs)
+}
+
+// authorizeBootstrap checks the HTTP Basic bootstrap credential in constant time
+func (h *ESTHandler) authorizeBootstrap(r *http.Request) bool {
+	username, password, ok := r.BasicAuth()
+	if !ok || h.bootstrapUsername == "" || h.bootstrapPassword == "" {
+		return false
+	}
+
+	usernameMatch := subtle.ConstantTimeCompare([]byte(username), []byte(h.bootstrapUsername))
+	passwordMatch := subtle.ConstantTimeCompare([]byte(password), []byte(h.bootstrapPassword))
+	return usernameMatch&passwordMatch == 1
+}
+
+// writeCertsOnly writes a base64-encoded PKCS#7 certs-only response
+func (h *ESTHandler) writeCertsOnly(w http.ResponseWriter, certs [][]byte) {
+	encoded, err := auth.EncodeCertsOnlyPKCS7(certs)
+	if err != nil {
+		h.logger.Error("Failed to encode PKCS#7 response", "error", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/pkcs7-mime; smime-type=certs-only")
+	w.Header().Set("Content-Transfer-Encoding", "base64")
+	w.WriteHeader(http.StatusOK)
+	w.Write([]byte(base64.StdEncoding.EncodeToString(encoded)))
+}
+
+// readCSR parses a base64-encoded PKCS#10 request body, accepting raw DER as a fallback
+func readCSR(r *http.Request) (*x509.CertificateRequest, error) {
+	body, err := io.ReadAll(io.LimitReader(r.Body, maxCSRSize))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read request body: %w", err)
+	}
+
+	der, err := base64.StdEncoding.DecodeString(string(bytes.Join(bytes.Fields(body), nil)))
+	if err != nil {
+		der = body
+	}
+
+	csr, err := x509.ParseCertificateRequest(der)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse certificate request: %w", err)
+	}
+
+	return csr, nil
+}
@@ -0,0 +1,260 @@
+package handlers
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"encoding/hex"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"device-assignment-api/internal/middleware"
+	"device-assignment-api/internal/models"
+	"device-assignment-api/internal/services"
+	"device-assignment-api/pkg/auth"
+)
+
+// estFixture is an EST handler issuing certificates from a throwaway CA, with devices
+// identified by subject common name
+type estFixture struct {
+	store   *memoryStore
+	handler *ESTHandler
+}
+
+func newESTFixture(t *testing.T, mode models.RegistrationMode) *estFixture {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("Failed to generate key: %v", err)
+	}
+
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "Device CA"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(24 * time.Hour),
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageDigitalSignature,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, key.Public(), key)
+	if err != nil {
+		t.Fatalf("Failed to create CA certificate: %v", err)
+	}
+	caCert, _ := x509.ParseCertificate(der)
+
+	ca, err := auth.NewCertificateAuthority([]*x509.Certificate{caCert}, key, time.Hour)
+	if err != nil {
+		t.Fatalf("Failed to create certificate authority: %v", err)
+	}
+
+	identity, err := auth.NewIdentityConfig("subject_cn", "", nil)
+	if err != nil {
+		t.Fatalf("Failed to create identity config: %v", err)
+	}
+
+	store := newMemoryStore()
+	registration := services.RegistrationPolicy{Mode: mode, InitialStatus: models.DeviceStatusActive, Allowlist: store}
+	deviceService := services.NewDeviceService(store, store, store, store, identity, services.OwnershipPolicy{}, registration, newTestPresenceTracker(store), testLogger())
+
+	return &estFixture{
+		store:   store,
+		handler: NewESTHandler(ca, deviceService, "bootstrap", "secret", testLogger()),
+	}
+}
+
+// newCSR returns a base64-encoded certificate request for a new key
+func newCSR(t *testing.T, template *x509.CertificateRequest) string {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("Failed to generate key: %v", err)
+	}
+
+	der, err := x509.CreateCertificateRequest(rand.Reader, template, key)
+	if err != nil {
+		t.Fatalf("Failed to create CSR: %v", err)
+	}
+	return base64.StdEncoding.EncodeToString(der)
+}
+
+func (f *estFixture) enroll(csr string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodPost, "/.well-known/est/simpleenroll", strings.NewReader(csr))
+	req.SetBasicAuth("bootstrap", "secret")
+	rec := httptest.NewRecorder()
+	f.handler.SimpleEnroll(rec, req)
+	return rec
+}
+
+func (f *estFixture) reenroll(current *x509.Certificate, csr string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodPost, "/.well-known/est/simplereenroll", strings.NewReader(csr))
+	req = req.WithContext(context.WithValue(req.Context(), middleware.CertificateInfoContextKey, auth.ExtractCertificateInfo(current)))
+	rec := httptest.NewRecorder()
+	f.handler.SimpleReenroll(rec, req)
+	return rec
+}
+
+// issuedCertificate decodes the certificate of a certs-only EST response
+func issuedCertificate(t *testing.T, rec *httptest.ResponseRecorder) *x509.Certificate {
+	t.Helper()
+
+	data, err := base64.StdEncoding.DecodeString(rec.Body.String())
+	if err != nil {
+		t.Fatalf("Failed to decode response: %v", err)
+	}
+	certs, err := auth.DecodeCertsOnlyPKCS7(data)
+	if err != nil || len(certs) != 1 {
+		t.Fatalf("Failed to decode PKCS#7 response: %v", err)
+	}
+	cert, err := x509.ParseCertificate(certs[0])
+	if err != nil {
+		t.Fatalf("Failed to parse issued certificate: %v", err)
+	}
+	return cert
+}
+
+func TestSimpleEnroll(t *testing.T) {
+	f := newESTFixture(t, models.RegistrationModeOpen)
+	deviceCSR := &x509.CertificateRequest{
+		Subject:  pkix.Name{CommonName: "device-1"},
+		DNSNames: []string{"device-1.devices.example.com"},
+	}
+
+	req := httptest.NewRequest(http.MethodPost, "/.well-known/est/simpleenroll", strings.NewReader(newCSR(t, deviceCSR)))
+	rec := httptest.NewRecorder()
+	f.handler.SimpleEnroll(rec, req)
+	if rec.Code != http.StatusUnauthorized {
+		t.Fatalf("Expected status %d without the bootstrap credential, got %d", http.StatusUnauthorized, rec.Code)
+	}
+
+	rec = f.enroll(newCSR(t, deviceCSR))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("Expected status %d, got %d: %s", http.StatusOK, rec.Code, rec.Body.String())
+	}
+	cert := issuedCertificate(t, rec)
+	victim, err := f.store.GetDeviceByIssuerAndSerial(cert.Issuer.String(), auth.ExtractCertificateInfo(cert).SerialNumber)
+	if err != nil {
+		t.Fatalf("Expected the enrolled device to be registered, got %v", err)
+	}
+	f.store.CreateAssignment(models.NewAssignment(victim.ID, "alice"))
+
+	spiffeID, _ := url.Parse("spiffe://devices.example.com/device-2")
+
+	tests := []struct {
+		name           string
+		csr            *x509.CertificateRequest
+		expectedStatus int
+	}{
+		// Another key asking for the registered device's common name must not take it over
+		{"identity of a registered device", deviceCSR, http.StatusConflict},
+		{"URI SAN", &x509.CertificateRequest{Subject: pkix.Name{CommonName: "device-2"}, URIs: []*url.URL{spiffeID}}, http.StatusBadRequest},
+		{"no common name", &x509.CertificateRequest{DNSNames: []string{"device-2.devices.example.com"}}, http.StatusBadRequest},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if rec := f.enroll(newCSR(t, tt.csr)); rec.Code != tt.expectedStatus {
+				t.Fatalf("Expected status %d, got %d: %s", tt.expectedStatus, rec.Code, rec.Body.String())
+			}
+		})
+	}
+
+	if len(f.store.devices) != 1 {
+		t.Errorf("Expected refused enrollments not to register devices, got %d devices", len(f.store.devices))
+	}
+	stored, _ := f.store.GetDeviceByID(victim.ID)
+	if stored.CertificateSerialNumber != victim.CertificateSerialNumber {
+		t.Error("Expected the registered device to keep its certificate")
+	}
+	if assigned, _ := f.store.IsDeviceAssignedToUser(victim.ID, "alice"); !assigned {
+		t.Error("Expected the registered device to keep its assignment")
+	}
+}
+
+func TestSimpleEnrollAllowlist(t *testing.T) {
+	f := newESTFixture(t, models.RegistrationModeAllowlist)
+	deviceCSR := &x509.CertificateRequest{Subject: pkix.Name{CommonName: "device-1"}}
+
+	rec := f.enroll(newCSR(t, deviceCSR))
+	if rec.Code != http.StatusForbidden {
+		t.Fatalf("Expected status %d for a key not allowlisted, got %d: %s", http.StatusForbidden, rec.Code, rec.Body.String())
+	}
+	if len(f.store.devices) != 0 {
+		t.Errorf("Expected a refused enrollment not to register a device, got %d devices", len(f.store.devices))
+	}
+
+	csr := newCSR(t, deviceCSR)
+	der, _ := base64.StdEncoding.DecodeString(csr)
+	parsed, err := x509.ParseCertificateRequest(der)
+	if err != nil {
+		t.Fatalf("Failed to parse CSR: %v", err)
+	}
+	spki := sha256.Sum256(parsed.RawSubjectPublicKeyInfo)
+	entry, err := models.NewAllowlistEntry("", "", "", hex.EncodeToString(spki[:]), "", "admin")
+	if err != nil {
+		t.Fatalf("Failed to create allowlist entry: %v", err)
+	}
+	f.store.CreateAllowlistEntries([]*models.AllowlistEntry{entry})
+
+	rec = f.enroll(csr)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("Expected status %d for an allowlisted key, got %d: %s", http.StatusOK, rec.Code, rec.Body.String())
+	}
+	cert := issuedCertificate(t, rec)
+	device, err := f.store.GetDeviceByIssuerAndSerial(cert.Issuer.String(), auth.ExtractCertificateInfo(cert).SerialNumber)
+	if err != nil {
+		t.Fatalf("Expected the enrolled device to be registered, got %v", err)
+	}
+	if device.Status != models.DeviceStatusActive {
+		t.Errorf("Expected status %s, got %s", models.DeviceStatusActive, device.Status)
+	}
+}
+
+func TestSimpleReenroll(t *testing.T) {
+	f := newESTFixture(t, models.RegistrationModeOpen)
+	deviceCSR := &x509.CertificateRequest{
+		Subject:  pkix.Name{CommonName: "device-1"},
+		DNSNames: []string{"device-1.devices.example.com"},
+	}
+
+	rec := f.enroll(newCSR(t, deviceCSR))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("Expected status %d, got %d: %s", http.StatusOK, rec.Code, rec.Body.String())
+	}
+	current := issuedCertificate(t, rec)
+
+	tests := []struct {
+		name           string
+		csr            *x509.CertificateRequest
+		expectedStatus int
+	}{
+		{"different subject", &x509.CertificateRequest{Subject: pkix.Name{CommonName: "device-2"}, DNSNames: deviceCSR.DNSNames}, http.StatusBadRequest},
+		{"different DNS SAN", &x509.CertificateRequest{Subject: deviceCSR.Subject, DNSNames: []string{"device-2.devices.example.com"}}, http.StatusBadRequest},
+		{"additional email SAN", &x509.CertificateRequest{Subject: deviceCSR.Subject, DNSNames: deviceCSR.DNSNames, EmailAddresses: []string{"device@example.com"}}, http.StatusBadRequest},
+		{"same subject and SANs", deviceCSR, http.StatusOK},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if rec := f.reenroll(current, newCSR(t, tt.csr)); rec.Code != tt.expectedStatus {
+				t.Fatalf("Expected status %d, got %d: %s", tt.expectedStatus, rec.Code, rec.Body.String())
+			}
+		})
+	}
+
+	if len(f.store.devices) != 1 {
+		t.Errorf("Expected re-enrollment to keep the device, got %d devices", len(f.store.devices))
+	}
+}
@@ -0,0 +1,873 @@
+package handlers
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"device-assignment-api/internal/middleware"
+	"device-assignment-api/internal/models"
+	"device-assignment-api/internal/services"
+	"device-assignment-api/pkg/auth"
+	"device-assignment-api/pkg/logger"
+
+	"github.com/google/uuid"
+)
+
+func testLogger() logger.Logger {
+	return logger.NewWithLevel(slog.LevelError + 1)
+}
+
+// memoryStore is an in-memory implementation of the device, assignment, certificate,
+// device status, device presence and device allowlist repositories
+type memoryStore struct {
+	mu              sync.Mutex
+	devices         map[uuid.UUID]*models.Device
+	assignments     []*models.Assignment
+	certificates    []*models.DeviceCertificate
+	transitions     []*models.DeviceStatusTransition
+	heartbeatWrites int
+	allowlist       []*models.AllowlistEntry
+
+	// lookupErr, when set, is returned by device and certificate lookups to simulate a
+	// database failure
+	lookupErr error
+}
+
+func newMemoryStore() *memoryStore {
+	return &memoryStore{devices: make(map[uuid.UUID]*models.Device)}
+}
+
+// newTestDeviceService wires a DeviceService to the store with the default ownership policy
+func newTestDeviceService(store *memoryStore) *services.DeviceService {
+	return newTestDeviceServiceWithPresence(store, newTestPresenceTracker(store))
+}
+
+// newTestPresenceTracker creates a presence tracker writing to the store, which is only
+// flushed when the test calls Flush
+func newTestPresenceTracker(store *memoryStore) *services.PresenceTracker {
+	return services.NewPresenceTracker(store, 5*time.Minute, time.Hour, testLogger())
+}
+
+// newTestDeviceServiceWithPresence wires a DeviceService in open registration mode to the
+// store and presence tracker
+func newTestDeviceServiceWithPresence(store *memoryStore, presence *services.PresenceTracker) *services.DeviceService {
+	return newTestDeviceServiceWith(store, models.RegistrationModeOpen, presence)
+}
+
+// newTestDeviceServiceWith wires a DeviceService registering devices in the mode to the store,
+// which also holds its allowlist
+func newTestDeviceServiceWith(store *memoryStore, mode models.RegistrationMode, presence *services.PresenceTracker) *services.DeviceService {
+	registration := services.RegistrationPolicy{
+		Mode:          mode,
+		InitialStatus: models.DeviceStatusActive,
+		Allowlist:     store,
+	}
+	return services.NewDeviceService(store, store, store, store, auth.IdentityConfig{}, services.OwnershipPolicy{}, registration, presence, testLogger())
+}
+
+// addDevice registers a device, optionally assigned to a user
+func (s *memoryStore) addDevice(userID string) *models.Device {
+	device := models.NewDevice(uuid.NewString(), "CN=Test CA", "Test CA", "")
+	s.CreateDevice(device)
+	if userID != "" {
+		s.CreateAssignment(models.NewAssignment(device.ID, userID))
+	}
+	return device
+}
+
+func (s *memoryStore) CreateDevice(device *models.Device) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	copied := *device
+	s.devices[device.ID] = &copied
+	return nil
+}
+
+func (s *memoryStore) GetDeviceByID(id uuid.UUID) (*models.Device, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.lookupErr != nil {
+		return nil, s.lookupErr
+	}
+
+	device, ok := s.devices[id]
+	if !ok {
+		return nil, fmt.Errorf("device not found")
+	}
+	copied := *device
+	return &copied, nil
+}
+
+func (s *memoryStore) findDevice(match func(*models.Device) bool) (*models.Device, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.lookupErr != nil {
+		return nil, s.lookupErr
+	}
+
+	for _, device := range s.devices {
+		if match(device) {
+			copied := *device
+			return &copied, nil
+		}
+	}
+	return nil, fmt.Errorf("device not found")
+}
+
+func (s *memoryStore) GetDeviceByIssuerAndSerial(issuerDN, serialNumber string) (*models.Device, error) {
+	return s.findDevice(func(d *models.Device) bool {
+		return d.CertificateIssuerDN == issuerDN && d.CertificateSerialNumber == serialNumber
+	})
+}
+
+func (s *memoryStore) GetLegacyDevice(issuerCN, serialNumber string) (*models.Device, error) {
+	return s.findDevice(func(d *models.Device) bool {
+		return d.CertificateIssuerDN == "" && d.CertificateIssuerCN == issuerCN && d.CertificateSerialNumber == serialNumber
+	})
+}
+
+func (s *memoryStore) GetDeviceByIdentityKey(identityKey string) (*models.Device, error) {
+	return s.findDevice(func(d *models.Device) bool {
+		return d.IdentityKey == identityKey
+	})
+}
+
+func (s *memoryStore) UpdateDeviceCertificate(device *models.Device) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.devices[device.ID]; !ok {
+		return fmt.Errorf("device not found")
+	}
+	copied := *device
+	s.devices[device.ID] = &copied
+	return nil
+}
+
+func (s *memoryStore) UpdateDeviceMetadata(device *models.Device, fields models.DeviceMetadataUpdate) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	stored, ok := s.devices[device.ID]
+	if !ok {
+		return fmt.Errorf("device not found")
+	}
+	if fields.Name != nil {
+		stored.Name = device.Name
+	}
+	if fields.Model != nil {
+		stored.Model = device.Model
+	}
+	if fields.HardwareRevision != nil {
+		stored.HardwareRevision = device.HardwareRevision
+	}
+	if fields.FirmwareVersion != nil {
+		stored.FirmwareVersion = device.FirmwareVersion
+	}
+	if fields.Labels != nil {
+		stored.Labels = device.Labels
+	}
+	return nil
+}
+
+func (s *memoryStore) GetDeviceWithAssignment(id uuid.UUID) (*models.DeviceWithAssignment, error) {
+	device, err := s.GetDeviceByID(id)
+	if err != nil {
+		return nil, err
+	}
+	return s.withAssignment(device), nil
+}
+
+// withAssignment joins a device with its active assignment
+func (s *memoryStore) withAssignment(device *models.Device) *models.DeviceWithAssignment {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	result := &models.DeviceWithAssignment{Device: *device}
+	for _, assignment := range s.assignments {
+		if assignment.DeviceID == device.ID && assignment.IsActive() {
+			a := *assignment
+			result.AssignmentID = &a.ID
+			result.UserID = &a.UserID
+			result.AssignedAt = &a.AssignedAt
+			result.IsAssigned = true
+		}
+	}
+	return result
+}
+
+func (s *memoryStore) DeviceExists(issuerDN, serialNumber string) (bool, error) {
+	_, err := s.GetDeviceByIssuerAndSerial(issuerDN, serialNumber)
+	return err == nil, nil
+}
+
+func (s *memoryStore) QueryDevices(query *models.DeviceQuery) ([]*models.DeviceWithAssignment, error) {
+	devices := s.matchDevices(query)
+
+	sort.Slice(devices, func(i, j int) bool {
+		return listedBefore(query,
+			query.Sort.Key(&devices[i].Device), devices[i].ID.String(),
+			query.Sort.Key(&devices[j].Device), devices[j].ID.String())
+	})
+
+	var result []*models.DeviceWithAssignment
+	for _, device := range devices {
+		if query.After != nil && !listedBefore(query,
+			query.After.Key, query.After.ID.String(),
+			query.Sort.Key(&device.Device), device.ID.String()) {
+			continue
+		}
+		if len(result) == query.Limit {
+			break
+		}
+		result = append(result, device)
+	}
+	return result, nil
+}
+
+func (s *memoryStore) CountDevices(query *models.DeviceQuery) (int, error) {
+	return len(s.matchDevices(query)), nil
+}
+
+// matchDevices returns every device matching the query's filters, in no particular order
+func (s *memoryStore) matchDevices(query *models.DeviceQuery) []*models.DeviceWithAssignment {
+	s.mu.Lock()
+	var devices []*models.Device
+	for _, device := range s.devices {
+		copied := *device
+		devices = append(devices, &copied)
+	}
+	s.mu.Unlock()
+
+	var result []*models.DeviceWithAssignment
+	for _, device := range devices {
+		withAssignment := s.withAssignment(device)
+		if matchesQuery(query, withAssignment) {
+			result = append(result, withAssignment)
+		}
+	}
+	return result
+}
+
+// matchesQuery applies the query's filters to a device
+func matchesQuery(query *models.DeviceQuery, device *models.DeviceWithAssignment) bool {
+	if query.UserID != "" && (!device.IsAssigned || *device.UserID != query.UserID) {
+		return false
+	}
+	if query.Assigned != nil && device.IsAssigned != *query.Assigned {
+		return false
+	}
+	if query.Issuer != "" && device.CertificateIssuerDN != query.Issuer && device.CertificateIssuerCN != query.Issuer {
+		return false
+	}
+	if len(query.Statuses) > 0 {
+		found := false
+		for _, status := range query.Statuses {
+			found = found || device.Status == status
+		}
+		if !found {
+			return false
+		}
+	}
+	if !hasLabels(&device.Device, query.Labels) {
+		return false
+	}
+	if (query.CreatedAfter != nil && !device.CreatedAt.After(*query.CreatedAfter)) ||
+		(query.CreatedBefore != nil && !device.CreatedAt.Before(*query.CreatedBefore)) {
+		return false
+	}
+	if (query.LastSeenAfter != nil && (device.LastSeenAt == nil || !device.LastSeenAt.After(*query.LastSeenAfter))) ||
+		(query.LastSeenBefore != nil && (device.LastSeenAt == nil || !device.LastSeenAt.Before(*query.LastSeenBefore))) {
+		return false
+	}
+	return true
+}
+
+// listedBefore reports whether the device with sort key and ID a is listed before the one with
+// sort key and ID b in the query's order, breaking ties by ID like the database does
+func listedBefore(query *models.DeviceQuery, keyA, idA, keyB, idB string) bool {
+	if keyA == keyB {
+		keyA, keyB = idA, idB
+	}
+	if query.Descending {
+		return keyA > keyB
+	}
+	return keyA < keyB
+}
+
+func (s *memoryStore) ListDevicesExpiringBetween(from, to time.Time) ([]*models.DeviceWithAssignment, error) {
+	all := s.matchDevices(&models.DeviceQuery{})
+
+	var devices []*models.DeviceWithAssignment
+	for _, device := range all {
+		if notAfter := device.CertificateNotAfter; notAfter != nil && notAfter.After(from) && !notAfter.After(to) {
+			devices = append(devices, device)
+		}
+	}
+
+	sort.Slice(devices, func(i, j int) bool {
+		return devices[i].CertificateNotAfter.Before(*devices[j].CertificateNotAfter)
+	})
+	return devices, nil
+}
+
+// hasLabels reports whether the device carries every label in the selector
+func hasLabels(device *models.Device, selector models.Labels) bool {
+	for key, value := range selector {
+		if device.Labels[key] != value {
+			return false
+		}
+	}
+	return true
+}
+
+func (s *memoryStore) CreateAssignment(assignment *models.Assignment) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	copied := *assignment
+	s.assignments = append(s.assignments, &copied)
+	return nil
+}
+
+func (s *memoryStore) GetActiveAssignmentByDeviceID(deviceID uuid.UUID) (*models.Assignment, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, assignment := range s.assignments {
+		if assignment.DeviceID == deviceID && assignment.IsActive() {
+			copied := *assignment
+			return &copied, nil
+		}
+	}
+	return nil, fmt.Errorf("no active assignment found for device")
+}
+
+func (s *memoryStore) GetAssignmentsByUserID(userID string) ([]*models.Assignment, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var assignments []*models.Assignment
+	for _, assignment := range s.assignments {
+		if assignment.UserID == userID && assignment.IsActive() {
+			copied := *assignment
+			assignments = append(assignments, &copied)
+		}
+	}
+	return assignments, nil
+}
+
+func (s *memoryStore) UnassignDevice(deviceID uuid.UUID) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, assignment := range s.assignments {
+		if assignment.DeviceID == deviceID && assignment.IsActive() {
+			assignment.Unassign()
+			return nil
+		}
+	}
+	return fmt.Errorf("no active assignment found to unassign")
+}
+
+func (s *memoryStore) AssignDevice(assignment *models.Assignment) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	device, ok := s.devices[assignment.DeviceID]
+	if !ok {
+		return fmt.Errorf("device not found")
+	}
+	if device.Status != models.DeviceStatusActive {
+		return fmt.Errorf("device is not active")
+	}
+
+	for _, current := range s.assignments {
+		if current.DeviceID == assignment.DeviceID && current.IsActive() {
+			return fmt.Errorf("device is already assigned to another user")
+		}
+	}
+
+	copied := *assignment
+	s.assignments = append(s.assignments, &copied)
+	return nil
+}
+
+func (s *memoryStore) ReassignDevice(assignment *models.Assignment) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	device, ok := s.devices[assignment.DeviceID]
+	if !ok {
+		return false, fmt.Errorf("device not found")
+	}
+	if device.Status != models.DeviceStatusActive {
+		return false, fmt.Errorf("device is not active")
+	}
+
+	replaced := false
+	for _, current := range s.assignments {
+		if current.DeviceID == assignment.DeviceID && current.IsActive() {
+			if current.UserID == assignment.UserID {
+				return false, fmt.Errorf("device is already assigned to the user")
+			}
+			current.Unassign()
+			replaced = true
+		}
+	}
+
+	copied := *assignment
+	s.assignments = append(s.assignments, &copied)
+	return replaced, nil
+}
+
+func (s *memoryStore) IsDeviceAssigned(deviceID uuid.UUID) (bool, error) {
+	_, err := s.GetActiveAssignmentByDeviceID(deviceID)
+	return err == nil, nil
+}
+
+func (s *memoryStore) IsDeviceAssignedToUser(deviceID uuid.UUID, userID string) (bool, error) {
+	assignment, err := s.GetActiveAssignmentByDeviceID(deviceID)
+	return err == nil && assignment.UserID == userID, nil
+}
+
+func (s *memoryStore) RecordCertificate(certificate *models.DeviceCertificate) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	copied := *certificate
+	s.certificates = append(s.certificates, &copied)
+	return nil
+}
+
+func (s *memoryStore) GetCertificatesByDeviceID(deviceID uuid.UUID) ([]*models.DeviceCertificate, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.lookupErr != nil {
+		return nil, s.lookupErr
+	}
+
+	var certificates []*models.DeviceCertificate
+	for _, certificate := range s.certificates {
+		if certificate.DeviceID == deviceID {
+			copied := *certificate
+			certificates = append(certificates, &copied)
+		}
+	}
+	return certificates, nil
+}
+
+func (s *memoryStore) TransitionDeviceStatus(transition *models.DeviceStatusTransition) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	device, ok := s.devices[transition.DeviceID]
+	if !ok || device.Status != transition.FromStatus {
+		return fmt.Errorf("device status changed concurrently")
+	}
+	device.Status = transition.ToStatus
+
+	if transition.ToStatus == models.DeviceStatusDecommissioned {
+		for _, assignment := range s.assignments {
+			if assignment.DeviceID == transition.DeviceID && assignment.IsActive() {
+				assignment.Unassign()
+			}
+		}
+	}
+
+	copied := *transition
+	s.transitions = append(s.transitions, &copied)
+	return nil
+}
+
+func (s *memoryStore) GetStatusTransitionsByDeviceID(deviceID uuid.UUID) ([]*models.DeviceStatusTransition, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var transitions []*models.DeviceStatusTransition
+	for i := len(s.transitions) - 1; i >= 0; i-- {
+		if s.transitions[i].DeviceID == deviceID {
+			copied := *s.transitions[i]
+			transitions = append(transitions, &copied)
+		}
+	}
+	return transitions, nil
+}
+
+func (s *memoryStore) RecordHeartbeats(heartbeats []*models.DeviceHeartbeat) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, heartbeat := range heartbeats {
+		if device, ok := s.devices[heartbeat.DeviceID]; ok {
+			device.ApplyHeartbeat(heartbeat)
+		}
+	}
+	s.heartbeatWrites++
+	return nil
+}
+
+func (s *memoryStore) CreateAllowlistEntries(entries []*models.AllowlistEntry) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	added := 0
+	for _, entry := range entries {
+		duplicate := false
+		for _, existing := range s.allowlist {
+			duplicate = duplicate || (existing.SerialNumber == entry.SerialNumber &&
+				existing.IssuerDN == entry.IssuerDN && existing.Fingerprint == entry.Fingerprint &&
+				existing.SPKIFingerprint == entry.SPKIFingerprint)
+		}
+		if !duplicate {
+			copied := *entry
+			s.allowlist = append(s.allowlist, &copied)
+			added++
+		}
+	}
+	return added, nil
+}
+
+func (s *memoryStore) ListAllowlistEntries() ([]*models.AllowlistEntry, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var entries []*models.AllowlistEntry
+	for i := len(s.allowlist) - 1; i >= 0; i-- {
+		copied := *s.allowlist[i]
+		entries = append(entries, &copied)
+	}
+	return entries, nil
+}
+
+func (s *memoryStore) DeleteAllowlistEntry(id uuid.UUID) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for i, entry := range s.allowlist {
+		if entry.ID == id {
+			s.allowlist = append(s.allowlist[:i], s.allowlist[i+1:]...)
+			return nil
+		}
+	}
+	return fmt.Errorf("allowlist entry not found")
+}
+
+func (s *memoryStore) IsAllowlisted(issuerDN, serialNumber, fingerprint, spkiFingerprint string) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, entry := range s.allowlist {
+		if entry.Matches(issuerDN, serialNumber, fingerprint, spkiFingerprint) {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// withUser returns the request as if the JWT middleware had authenticated the user with the roles
+func withUser(r *http.Request, userID string, roles ...string) *http.Request {
+	principal := auth.NewRBAC(nil).Principal(&auth.Claims{UserID: userID, Roles: roles})
+	ctx := context.WithValue(r.Context(), middleware.UserIDContextKey, userID)
+	ctx = context.WithValue(ctx, middleware.PrincipalContextKey, principal)
+	return r.WithContext(ctx)
+}
+
+// memoryUserStore is an in-memory implementation of the user repository
+type memoryUserStore struct {
+	mu    sync.Mutex
+	users map[uuid.UUID]*models.User
+}
+
+func newMemoryUserStore() *memoryUserStore {
+	return &memoryUserStore{users: make(map[uuid.UUID]*models.User)}
+}
+
+func (s *memoryUserStore) CreateUser(user *models.User) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, existing := range s.users {
+		if existing.Username == user.Username {
+			return fmt.Errorf("username already exists")
+		}
+	}
+	copied := *user
+	s.users[user.ID] = &copied
+	return nil
+}
+
+func (s *memoryUserStore) GetUserByID(id uuid.UUID) (*models.User, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	user, ok := s.users[id]
+	if !ok {
+		return nil, fmt.Errorf("user not found")
+	}
+	copied := *user
+	return &copied, nil
+}
+
+func (s *memoryUserStore) GetUserByUsername(username string) (*models.User, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, user := range s.users {
+		if user.Username == username {
+			copied := *user
+			return &copied, nil
+		}
+	}
+	return nil, fmt.Errorf("user not found")
+}
+
+func (s *memoryUserStore) UpdatePassword(id uuid.UUID, passwordHash string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	user, ok := s.users[id]
+	if !ok {
+		return fmt.Errorf("user not found")
+	}
+	user.PasswordHash = passwordHash
+	return nil
+}
+
+func (s *memoryUserStore) CountUsers() (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return len(s.users), nil
+}
+
+// memorySessionStore is an in-memory implementation of the session repository
+type memorySessionStore struct {
+	mu            sync.Mutex
+	sessions      map[uuid.UUID]*models.Session
+	refreshTokens map[uuid.UUID]*models.RefreshToken
+	revoked       map[string]time.Time
+}
+
+func newMemorySessionStore() *memorySessionStore {
+	return &memorySessionStore{
+		sessions:      make(map[uuid.UUID]*models.Session),
+		refreshTokens: make(map[uuid.UUID]*models.RefreshToken),
+		revoked:       make(map[string]time.Time),
+	}
+}
+
+func (s *memorySessionStore) CreateSession(session *models.Session) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	copied := *session
+	s.sessions[session.ID] = &copied
+	return nil
+}
+
+func (s *memorySessionStore) GetSession(id uuid.UUID) (*models.Session, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	session, ok := s.sessions[id]
+	if !ok {
+		return nil, fmt.Errorf("session not found")
+	}
+	copied := *session
+	return &copied, nil
+}
+
+func (s *memorySessionStore) GetActiveSessionsByUserID(userID string) ([]*models.Session, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var sessions []*models.Session
+	for _, session := range s.sessions {
+		if session.UserID == userID && session.IsActive() {
+			copied := *session
+			sessions = append(sessions, &copied)
+		}
+	}
+	return sessions, nil
+}
+
+func (s *memorySessionStore) ExtendSession(id uuid.UUID, expiresAt time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if session, ok := s.sessions[id]; ok {
+		session.LastUsedAt = time.Now()
+		session.ExpiresAt = expiresAt
+	}
+	return nil
+}
+
+func (s *memorySessionStore) RevokeSession(id uuid.UUID) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if session, ok := s.sessions[id]; ok && session.RevokedAt == nil {
+		now := time.Now()
+		session.RevokedAt = &now
+	}
+	return nil
+}
+
+func (s *memorySessionStore) RevokeUserSessions(userID string, exceptID uuid.UUID) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	for id, session := range s.sessions {
+		if session.UserID == userID && id != exceptID && session.RevokedAt == nil {
+			session.RevokedAt = &now
+		}
+	}
+	return nil
+}
+
+func (s *memorySessionStore) CreateRefreshToken(token *models.RefreshToken) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	copied := *token
+	s.refreshTokens[token.ID] = &copied
+	return nil
+}
+
+func (s *memorySessionStore) GetRefreshTokenByHash(tokenHash string) (*models.RefreshToken, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, token := range s.refreshTokens {
+		if token.TokenHash == tokenHash {
+			copied := *token
+			return &copied, nil
+		}
+	}
+	return nil, fmt.Errorf("refresh token not found")
+}
+
+func (s *memorySessionStore) MarkRefreshTokenUsed(id uuid.UUID) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	token, ok := s.refreshTokens[id]
+	if !ok || token.UsedAt != nil {
+		return false, nil
+	}
+	now := time.Now()
+	token.UsedAt = &now
+	return true, nil
+}
+
+func (s *memorySessionStore) RevokeAccessToken(tokenID string, expiresAt time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.revoked[tokenID] = expiresAt
+	return nil
+}
+
+func (s *memorySessionStore) IsAccessTokenRevoked(tokenID string) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	_, ok := s.revoked[tokenID]
+	return ok, nil
+}
+
+// memoryAPIKeyStore is an in-memory implementation of the API key repository
+type memoryAPIKeyStore struct {
+	mu   sync.Mutex
+	keys map[uuid.UUID]*models.APIKey
+}
+
+func newMemoryAPIKeyStore() *memoryAPIKeyStore {
+	return &memoryAPIKeyStore{keys: make(map[uuid.UUID]*models.APIKey)}
+}
+
+func (s *memoryAPIKeyStore) CreateAPIKey(key *models.APIKey) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, existing := range s.keys {
+		if existing.Kind == key.Kind && existing.UserID == key.UserID && existing.Name == key.Name && existing.RevokedAt == nil {
+			return fmt.Errorf("api key name already exists")
+		}
+	}
+	copied := *key
+	s.keys[key.ID] = &copied
+	return nil
+}
+
+func (s *memoryAPIKeyStore) GetAPIKey(id uuid.UUID) (*models.APIKey, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	key, ok := s.keys[id]
+	if !ok {
+		return nil, fmt.Errorf("api key not found")
+	}
+	copied := *key
+	return &copied, nil
+}
+
+func (s *memoryAPIKeyStore) GetAPIKeyByHash(keyHash string) (*models.APIKey, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, key := range s.keys {
+		if key.KeyHash == keyHash {
+			copied := *key
+			return &copied, nil
+		}
+	}
+	return nil, fmt.Errorf("api key not found")
+}
+
+func (s *memoryAPIKeyStore) ListAPIKeys(kind models.APIKeyKind, userID string) ([]*models.APIKey, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var keys []*models.APIKey
+	for _, key := range s.keys {
+		if key.Kind == kind && (userID == "" || key.UserID == userID) && key.RevokedAt == nil {
+			copied := *key
+			keys = append(keys, &copied)
+		}
+	}
+	return keys, nil
+}
+
+func (s *memoryAPIKeyStore) TouchAPIKey(id uuid.UUID) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if key, ok := s.keys[id]; ok {
+		now := time.Now()
+		key.LastUsedAt = &now
+	}
+	return nil
+}
+
+func (s *memoryAPIKeyStore) RevokeAPIKey(id uuid.UUID) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if key, ok := s.keys[id]; ok && key.RevokedAt == nil {
+		now := time.Now()
+		key.RevokedAt = &now
+	}
+	return nil
+}
@@ -0,0 +1,35 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"device-assignment-api/pkg/auth"
+	"device-assignment-api/pkg/logger"
+)
+
+// JWKSHandler publishes the public keys used to verify tokens issued by the API
+type JWKSHandler struct {
+	jwtManager *auth.JWTManager
+	logger     logger.Logger
+}
+
+// NewJWKSHandler creates a new JWKSHandler
+func NewJWKSHandler(jwtManager *auth.JWTManager, logger logger.Logger) *JWKSHandler {
+	return &JWKSHandler{
+		jwtManager: jwtManager,
+		logger:     logger,
+	}
+}
+
+// GetJWKS handles the JSON Web Key Set endpoint
+// GET /.well-known/jwks.json
+func (h *JWKSHandler) GetJWKS(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "public, max-age=300")
+	w.WriteHeader(http.StatusOK)
+	if err := json.NewEncoder(w).Encode(h.jwtManager.JWKS()); err != nil {
+		h.logger.Error("Failed to encode response", "error", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+	}
+}
@@ -2,6 +2,8 @@ package middleware
 
 import (
 	"context"
+	"crypto/x509"
+	"errors"
 	"fmt"
 	"net/http"
 	"strings"
@@ -16,26 +18,54 @@ type ContextKey string
 const (
 	// UserIDContextKey is the context key for storing user ID
 	UserIDContextKey ContextKey = "user_id"
+	// PrincipalContextKey is the context key for storing the authenticated user's roles and permissions
+	PrincipalContextKey ContextKey = "principal"
+	// ClaimsContextKey is the context key for storing the validated token claims
+	ClaimsContextKey ContextKey = "claims"
 	// CertificateInfoContextKey is the context key for storing certificate info
 	CertificateInfoContextKey ContextKey = "certificate_info"
+	// DeviceIDContextKey is the context key for storing the authenticated device's ID
+	DeviceIDContextKey ContextKey = "device_id"
 )
 
-// JWTAuthMiddleware provides JWT authentication middleware
+// JWTAuthMiddleware provides JWT authentication and permission-based authorization
+// middleware. Routes registered with a scope also accept API credentials.
 type JWTAuthMiddleware struct {
-	jwtManager *auth.JWTManager
-	logger     logger.Logger
+	validator   auth.TokenValidator
+	rbac        *auth.RBAC
+	denylist    auth.TokenDenylist
+	credentials auth.APICredentialValidator
+	logger      logger.Logger
 }
 
-// NewJWTAuthMiddleware creates a new JWT authentication middleware
-func NewJWTAuthMiddleware(jwtManager *auth.JWTManager, logger logger.Logger) *JWTAuthMiddleware {
+// NewJWTAuthMiddleware creates a new JWT authentication middleware. The validator is
+// typically the local JWTManager, optionally combined with an OIDC verifier. The denylist
+// may be nil to disable server-side token revocation, and the credentials validator may be
+// nil to disable personal access tokens and service API keys.
+func NewJWTAuthMiddleware(
+	validator auth.TokenValidator,
+	rbac *auth.RBAC,
+	denylist auth.TokenDenylist,
+	credentials auth.APICredentialValidator,
+	logger logger.Logger,
+) *JWTAuthMiddleware {
 	return &JWTAuthMiddleware{
-		jwtManager: jwtManager,
-		logger:     logger,
+		validator:   validator,
+		rbac:        rbac,
+		denylist:    denylist,
+		credentials: credentials,
+		logger:      logger,
 	}
 }
 
 // Authenticate validates JWT tokens and adds user information to the request context
 func (m *JWTAuthMiddleware) Authenticate(next http.Handler) http.Handler {
+	return m.authenticate(false, next)
+}
+
+// authenticate validates the bearer token and adds user information to the request context.
+// When acceptCredentials is set, API credentials are accepted next to JWTs.
+func (m *JWTAuthMiddleware) authenticate(acceptCredentials bool, next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		// Extract token from Authorization header
 		authHeader := r.Header.Get("Authorization")
@@ -55,58 +85,207 @@ func (m *JWTAuthMiddleware) Authenticate(next http.Handler) http.Handler {
 
 		tokenString := parts[1]
 
+		// API credentials are opaque and recognised by their prefix
+		if auth.IsAPICredential(tokenString) {
+			if !acceptCredentials || m.credentials == nil {
+				m.logger.Warn("API credential presented on a route that does not accept it", "path", r.URL.Path)
+				http.Error(w, "Invalid token", http.StatusUnauthorized)
+				return
+			}
+
+			m.authenticateCredential(w, r, tokenString, next)
+			return
+		}
+
 		// Validate the token
-		claims, err := m.jwtManager.ValidateToken(tokenString)
+		claims, err := m.validator.ValidateToken(tokenString)
 		if err != nil {
 			m.logger.Warn("Token validation failed", "error", err)
 			http.Error(w, "Invalid token", http.StatusUnauthorized)
 			return
 		}
 
-		// Add user ID to request context
+		// Reject certificate-bound tokens presented without their certificate (RFC 8705)
+		if err := auth.VerifyCertificateBinding(claims.Confirmation, VerifiedClientCertificate(r)); err != nil {
+			m.logger.Warn("Token certificate binding check failed", "user_id", claims.UserID, "error", err)
+			http.Error(w, "Invalid token", http.StatusUnauthorized)
+			return
+		}
+
+		// Reject tokens revoked before they expired (logout or a revoked session)
+		if m.denylist != nil {
+			revoked, err := m.denylist.IsTokenRevoked(claims)
+			if err != nil {
+				m.logger.Error("Token revocation check failed", "user_id", claims.UserID, "error", err)
+				http.Error(w, "Service unavailable", http.StatusServiceUnavailable)
+				return
+			}
+
+			if revoked {
+				m.logger.Warn("Revoked token presented", "user_id", claims.UserID, "session_id", claims.SessionID)
+				http.Error(w, "Invalid token", http.StatusUnauthorized)
+				return
+			}
+		}
+
+		// Add user ID, resolved permissions and claims to request context
+		principal := m.rbac.Principal(claims)
 		ctx := context.WithValue(r.Context(), UserIDContextKey, claims.UserID)
+		ctx = context.WithValue(ctx, PrincipalContextKey, principal)
+		ctx = context.WithValue(ctx, ClaimsContextKey, claims)
 		r = r.WithContext(ctx)
 
-		m.logger.Debug("JWT authentication successful", "user_id", claims.UserID)
+		m.logger.Debug("JWT authentication successful", "user_id", claims.UserID, "roles", principal.Roles)
+		next.ServeHTTP(w, r)
+	})
+}
+
+// authenticateCredential validates an API credential and adds the user it acts as and its
+// scoped permissions to the request context
+func (m *JWTAuthMiddleware) authenticateCredential(w http.ResponseWriter, r *http.Request, token string, next http.Handler) {
+	credential, err := m.credentials.ValidateAPICredential(token)
+	if err != nil {
+		if err.Error() == "invalid api key" {
+			m.logger.Warn("API credential validation failed")
+			http.Error(w, "Invalid token", http.StatusUnauthorized)
+			return
+		}
+
+		m.logger.Error("API credential validation failed", "error", err)
+		http.Error(w, "Service unavailable", http.StatusServiceUnavailable)
+		return
+	}
+
+	principal := m.rbac.CredentialPrincipal(credential)
+	ctx := context.WithValue(r.Context(), UserIDContextKey, credential.UserID)
+	ctx = context.WithValue(ctx, PrincipalContextKey, principal)
+	r = r.WithContext(ctx)
+
+	m.logger.Debug("API credential authentication successful",
+		"user_id", credential.UserID,
+		"key_id", credential.ID,
+		"scopes", credential.Scopes)
+	next.ServeHTTP(w, r)
+}
+
+// RequirePermission authenticates the request with a JWT and rejects users lacking the permission
+func (m *JWTAuthMiddleware) RequirePermission(permission auth.Permission, next http.Handler) http.Handler {
+	return m.authenticate(false, m.authorize(permission, next))
+}
+
+// RequireScope authenticates the request with a JWT or an API credential and rejects callers
+// lacking the permission the scope grants. API credentials must also have been granted the scope.
+func (m *JWTAuthMiddleware) RequireScope(scope auth.Scope, next http.Handler) http.Handler {
+	return m.authenticate(true, m.authorize(auth.ScopePermissions[scope], next))
+}
+
+// authorize rejects authenticated callers lacking the permission
+func (m *JWTAuthMiddleware) authorize(permission auth.Permission, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		principal, err := GetPrincipalFromContext(r.Context())
+		if err != nil {
+			m.logger.Error("Failed to get principal from context", "error", err)
+			http.Error(w, "Authentication required", http.StatusUnauthorized)
+			return
+		}
+
+		if !principal.HasPermission(permission) {
+			m.logger.Warn("Permission denied",
+				"user_id", principal.UserID,
+				"permission", permission,
+				"path", r.URL.Path)
+			http.Error(w, "Forbidden", http.StatusForbidden)
+			return
+		}
+
 		next.ServeHTTP(w, r)
 	})
 }
 
+// DeviceStatusChecker reports whether the registered device a client certificate belongs to
+// may authenticate in its current lifecycle status
+type DeviceStatusChecker interface {
+	// CheckCertificateDeviceStatus returns an error starting with "device is" when the device
+	// may not authenticate, and accepts certificates of devices that are not registered yet
+	CheckCertificateDeviceStatus(certInfo *auth.CertificateInfo) error
+}
+
 // CertificateAuthMiddleware provides mTLS certificate authentication middleware
 type CertificateAuthMiddleware struct {
-	logger logger.Logger
+	revocationChecker auth.RevocationChecker
+	policy            *auth.PolicyEngine
+	identity          auth.IdentityConfig
+	devices           DeviceStatusChecker
+	logger            logger.Logger
 }
 
-// NewCertificateAuthMiddleware creates a new certificate authentication middleware
-func NewCertificateAuthMiddleware(logger logger.Logger) *CertificateAuthMiddleware {
+// NewCertificateAuthMiddleware creates a new certificate authentication middleware.
+// The revocation checker may be nil to disable revocation checking, the policy engine
+// may be nil to admit any certificate issued by the client CA, and the device status checker
+// may be nil to admit devices regardless of their lifecycle status. In SPIFFE identity mode,
+// SVIDs must also chain to the trust bundle of their trust domain.
+func NewCertificateAuthMiddleware(
+	revocationChecker auth.RevocationChecker,
+	policy *auth.PolicyEngine,
+	identity auth.IdentityConfig,
+	devices DeviceStatusChecker,
+	logger logger.Logger,
+) *CertificateAuthMiddleware {
 	return &CertificateAuthMiddleware{
-		logger: logger,
+		revocationChecker: revocationChecker,
+		policy:            policy,
+		identity:          identity,
+		devices:           devices,
+		logger:            logger,
 	}
 }
 
 // Authenticate validates client certificates and adds certificate info to the request context
 func (m *CertificateAuthMiddleware) Authenticate(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		// Check if TLS connection exists
-		if r.TLS == nil {
-			m.logger.Error("No TLS connection found")
-			http.Error(w, "TLS connection required", http.StatusBadRequest)
+		clientCert, verifiedChains, ok := m.clientCertificate(w, r)
+		if !ok {
 			return
 		}
 
-		// Check for peer certificates
-		if len(r.TLS.PeerCertificates) == 0 {
-			m.logger.Warn("No client certificate provided")
-			http.Error(w, "Client certificate required", http.StatusUnauthorized)
+		// Validate the certificate
+		if err := auth.ValidateCertificate(clientCert); err != nil {
+			m.logger.Warn("Certificate validation failed", "error", err)
+			http.Error(w, "Invalid client certificate", http.StatusUnauthorized)
 			return
 		}
 
-		// Get the first (leaf) certificate
-		clientCert := r.TLS.PeerCertificates[0]
+		// Reject certificates the admission policy does not allow
+		if m.policy != nil {
+			if err := m.policy.Evaluate(clientCert); err != nil {
+				var rejection *auth.PolicyRejection
+				if errors.As(err, &rejection) {
+					m.logger.Warn("Certificate rejected by policy",
+						"serial_number", clientCert.SerialNumber.String(),
+						"issuer_dn", clientCert.Issuer.String(),
+						"rules", rejection.Rules(),
+						"violations", rejection.Violations)
+				}
+				http.Error(w, "Invalid client certificate", http.StatusUnauthorized)
+				return
+			}
+		}
 
-		// Validate the certificate
-		if err := auth.ValidateCertificate(clientCert); err != nil {
-			m.logger.Warn("Certificate validation failed", "error", err)
+		// Reject revoked certificates
+		if err := m.checkRevocation(verifiedChains); err != nil {
+			m.logger.Warn("Certificate revocation check failed",
+				"serial_number", clientCert.SerialNumber.String(),
+				"error", err)
+			http.Error(w, "Invalid client certificate", http.StatusUnauthorized)
+			return
+		}
+
+		// Reject SVIDs not issued by their own trust domain's CA
+		if err := m.identity.VerifyTrustDomain(clientCert, verifiedChains); err != nil {
+			m.logger.Warn("Certificate rejected for its SPIFFE trust domain",
+				"serial_number", clientCert.SerialNumber.String(),
+				"issuer_dn", clientCert.Issuer.String(),
+				"error", err)
 			http.Error(w, "Invalid client certificate", http.StatusUnauthorized)
 			return
 		}
@@ -119,18 +298,199 @@ func (m *CertificateAuthMiddleware) Authenticate(next http.Handler) http.Handler
 			return
 		}
 
+		// Reject suspended and decommissioned devices
+		if m.devices != nil {
+			if err := m.devices.CheckCertificateDeviceStatus(certInfo); err != nil {
+				writeDeviceStatusError(w, m.logger, err,
+					"serial_number", certInfo.SerialNumber,
+					"issuer_dn", certInfo.IssuerDN)
+				return
+			}
+		}
+
 		// Add certificate info to request context
 		ctx := context.WithValue(r.Context(), CertificateInfoContextKey, certInfo)
 		r = r.WithContext(ctx)
 
 		m.logger.Debug("Certificate authentication successful", 
 			"serial_number", certInfo.SerialNumber,
-			"issuer_cn", certInfo.IssuerCN)
+			"issuer_dn", certInfo.IssuerDN)
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// clientCertificate returns the verified client certificate of the request and its verified
+// chains, writing an error response when there is none. A certificate forwarded by a trusted
+// proxy takes the place of the TLS connection's.
+func (m *CertificateAuthMiddleware) clientCertificate(w http.ResponseWriter, r *http.Request) (*x509.Certificate, [][]*x509.Certificate, bool) {
+	if forwarded, ok := getForwardedCertificate(r.Context()); ok {
+		if forwarded.leaf == nil {
+			m.logger.Warn("No client certificate forwarded by proxy", "remote_addr", r.RemoteAddr)
+			http.Error(w, "Client certificate required", http.StatusUnauthorized)
+			return nil, nil, false
+		}
+		return forwarded.leaf, forwarded.chains, true
+	}
+
+	// Check if TLS connection exists
+	if r.TLS == nil {
+		m.logger.Error("No TLS connection found")
+		http.Error(w, "TLS connection required", http.StatusBadRequest)
+		return nil, nil, false
+	}
+
+	// Check for peer certificates
+	if len(r.TLS.PeerCertificates) == 0 {
+		m.logger.Warn("No client certificate provided")
+		http.Error(w, "Client certificate required", http.StatusUnauthorized)
+		return nil, nil, false
+	}
+
+	// Client certificates are optional at the handshake on shared listeners, so insist
+	// that this one was verified against the client CA pool
+	if len(r.TLS.VerifiedChains) == 0 {
+		m.logger.Warn("Client certificate was not verified")
+		http.Error(w, "Client certificate not verified", http.StatusUnauthorized)
+		return nil, nil, false
+	}
+
+	// Use the first (leaf) certificate
+	return r.TLS.PeerCertificates[0], r.TLS.VerifiedChains, true
+}
+
+// checkRevocation checks the verified chains of the client certificate
+func (m *CertificateAuthMiddleware) checkRevocation(verifiedChains [][]*x509.Certificate) error {
+	if m.revocationChecker == nil {
+		return nil
+	}
+
+	return auth.VerifyPeerRevocation(m.revocationChecker)(nil, verifiedChains)
+}
+
+// DeviceResolver finds the registered device a client certificate belongs to, and checks
+// that a device authenticating with a device token may still authenticate
+type DeviceResolver interface {
+	DeviceIDForCertificate(certInfo *auth.CertificateInfo) (string, error)
+	CheckDeviceStatus(deviceID string) error
+}
+
+// DeviceAuthMiddleware authenticates registered devices on device endpoints, either by
+// client certificate or by a device access token issued after certificate authentication
+type DeviceAuthMiddleware struct {
+	certMiddleware *CertificateAuthMiddleware
+	tokens         auth.DeviceTokenValidator
+	devices        DeviceResolver
+	logger         logger.Logger
+}
+
+// NewDeviceAuthMiddleware creates a new device authentication middleware. The token
+// validator may be nil to accept client certificates only.
+func NewDeviceAuthMiddleware(
+	certMiddleware *CertificateAuthMiddleware,
+	tokens auth.DeviceTokenValidator,
+	devices DeviceResolver,
+	logger logger.Logger,
+) *DeviceAuthMiddleware {
+	return &DeviceAuthMiddleware{
+		certMiddleware: certMiddleware,
+		tokens:         tokens,
+		devices:        devices,
+		logger:         logger,
+	}
+}
+
+// Authenticate accepts a device access token in the Authorization header, or falls back to
+// the client certificate, and adds the device ID to the request context. Certificate
+// authentication also adds the certificate info.
+func (m *DeviceAuthMiddleware) Authenticate(next http.Handler) http.Handler {
+	byCertificate := m.certMiddleware.Authenticate(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		certInfo, err := GetCertificateInfoFromContext(r.Context())
+		if err != nil {
+			m.logger.Error("Failed to get certificate info from context", "error", err)
+			http.Error(w, "Authentication failed", http.StatusUnauthorized)
+			return
+		}
+
+		deviceID, err := m.devices.DeviceIDForCertificate(certInfo)
+		if err != nil {
+			m.logger.Warn("Certificate does not belong to a registered device",
+				"serial_number", certInfo.SerialNumber,
+				"issuer_dn", certInfo.IssuerDN,
+				"error", err)
+			http.Error(w, "Device not registered", http.StatusUnauthorized)
+			return
+		}
+
+		ctx := context.WithValue(r.Context(), DeviceIDContextKey, deviceID)
+		next.ServeHTTP(w, r.WithContext(ctx))
+	}))
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		authHeader := r.Header.Get("Authorization")
+		if authHeader == "" || m.tokens == nil {
+			byCertificate.ServeHTTP(w, r)
+			return
+		}
+
+		// Check for Bearer token format
+		parts := strings.Split(authHeader, " ")
+		if len(parts) != 2 || parts[0] != "Bearer" {
+			m.logger.Warn("Invalid Authorization header format")
+			http.Error(w, "Invalid Authorization header format", http.StatusUnauthorized)
+			return
+		}
 
+		claims, err := m.tokens.ValidateDeviceToken(parts[1])
+		if err != nil {
+			m.logger.Warn("Device token validation failed", "error", err)
+			http.Error(w, "Invalid token", http.StatusUnauthorized)
+			return
+		}
+
+		if err := auth.VerifyCertificateBinding(claims.Confirmation, VerifiedClientCertificate(r)); err != nil {
+			m.logger.Warn("Device token certificate binding check failed", "device_id", claims.DeviceID, "error", err)
+			http.Error(w, "Invalid token", http.StatusUnauthorized)
+			return
+		}
+
+		// Tokens issued before a device was suspended or decommissioned stop working
+		if err := m.devices.CheckDeviceStatus(claims.DeviceID); err != nil {
+			writeDeviceStatusError(w, m.logger, err, "device_id", claims.DeviceID)
+			return
+		}
+
+		ctx := context.WithValue(r.Context(), DeviceIDContextKey, claims.DeviceID)
+		r = r.WithContext(ctx)
+
+		m.logger.Debug("Device token authentication successful", "device_id", claims.DeviceID)
 		next.ServeHTTP(w, r)
 	})
 }
 
+// writeDeviceStatusError writes the response for a device that may not authenticate, or
+// whose status could not be checked
+func writeDeviceStatusError(w http.ResponseWriter, log logger.Logger, err error, args ...any) {
+	if strings.HasPrefix(err.Error(), "device is ") {
+		log.Warn("Device rejected by lifecycle status", append(args, "error", err)...)
+		http.Error(w, "Device is not permitted to authenticate", http.StatusForbidden)
+		return
+	}
+
+	log.Error("Device status check failed", append(args, "error", err)...)
+	http.Error(w, "Service unavailable", http.StatusServiceUnavailable)
+}
+
+// VerifiedClientCertificate returns the leaf client certificate of the request when it was
+// verified against the client CA pool, or nil. Behind a trusted proxy this is the forwarded
+// certificate.
+func VerifiedClientCertificate(r *http.Request) *x509.Certificate {
+	if forwarded, ok := getForwardedCertificate(r.Context()); ok {
+		return forwarded.leaf
+	}
+	return tlsClientCertificate(r)
+}
+
 // GetUserIDFromContext extracts the user ID from the request context
 func GetUserIDFromContext(ctx context.Context) (string, error) {
 	userID, ok := ctx.Value(UserIDContextKey).(string)
@@ -140,6 +500,39 @@ func GetUserIDFromContext(ctx context.Context) (string, error) {
 	return userID, nil
 }
 
+// GetPrincipalFromContext extracts the authenticated user's roles and permissions from the request context
+func GetPrincipalFromContext(ctx context.Context) (*auth.Principal, error) {
+	principal, ok := ctx.Value(PrincipalContextKey).(*auth.Principal)
+	if !ok || principal == nil {
+		return nil, fmt.Errorf("principal not found in context")
+	}
+	return principal, nil
+}
+
+// GetClaimsFromContext extracts the validated token claims from the request context
+func GetClaimsFromContext(ctx context.Context) (*auth.Claims, error) {
+	claims, ok := ctx.Value(ClaimsContextKey).(*auth.Claims)
+	if !ok || claims == nil {
+		return nil, fmt.Errorf("claims not found in context")
+	}
+	return claims, nil
+}
+
+// HasPermission reports whether the authenticated user in the context holds the permission
+func HasPermission(ctx context.Context, permission auth.Permission) bool {
+	principal, err := GetPrincipalFromContext(ctx)
+	return err == nil && principal.HasPermission(permission)
+}
+
+// GetDeviceIDFromContext extracts the authenticated device's ID from the request context
+func GetDeviceIDFromContext(ctx context.Context) (string, error) {
+	deviceID, ok := ctx.Value(DeviceIDContextKey).(string)
+	if !ok || deviceID == "" {
+		return "", fmt.Errorf("device ID not found in context")
+	}
+	return deviceID, nil
+}
+
 // GetCertificateInfoFromContext extracts the certificate info from the request context
 func GetCertificateInfoFromContext(ctx context.Context) (*auth.CertificateInfo, error) {
 	certInfo, ok := ctx.Value(CertificateInfoContextKey).(*auth.CertificateInfo)
@@ -0,0 +1,407 @@
+package middleware
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"fmt"
+	"log/slog"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"device-assignment-api/pkg/auth"
+	"device-assignment-api/pkg/logger"
+)
+
+func testLogger() logger.Logger {
+	return logger.NewWithLevel(slog.LevelError + 1)
+}
+
+// testPKI is a throwaway CA issuing the server and client certificates of a test
+type testPKI struct {
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+}
+
+func newTestPKI(t *testing.T) *testPKI {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("Failed to generate key: %v", err)
+	}
+
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "Test CA"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageDigitalSignature,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, template, key.Public(), key)
+	if err != nil {
+		t.Fatalf("Failed to create CA certificate: %v", err)
+	}
+	cert, _ := x509.ParseCertificate(der)
+
+	return &testPKI{cert: cert, key: key}
+}
+
+// issue returns a leaf certificate signed by the CA
+func (p *testPKI) issue(t *testing.T, serial int64, commonName string, usage x509.ExtKeyUsage) tls.Certificate {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("Failed to generate key: %v", err)
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, &x509.Certificate{
+		SerialNumber: big.NewInt(serial),
+		Subject:      pkix.Name{CommonName: commonName},
+		IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{usage},
+	}, p.cert, key.Public(), p.key)
+	if err != nil {
+		t.Fatalf("Failed to create certificate: %v", err)
+	}
+	cert, _ := x509.ParseCertificate(der)
+
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key, Leaf: cert}
+}
+
+// newBindingServer starts an HTTPS server that asks for, but does not require, client
+// certificates, like the shared listener
+func newBindingServer(t *testing.T, p *testPKI, handler http.Handler) *httptest.Server {
+	t.Helper()
+
+	clientCAs := x509.NewCertPool()
+	clientCAs.AddCert(p.cert)
+
+	server := httptest.NewUnstartedServer(handler)
+	server.TLS = &tls.Config{
+		Certificates: []tls.Certificate{p.issue(t, 100, "server", x509.ExtKeyUsageServerAuth)},
+		ClientAuth:   tls.VerifyClientCertIfGiven,
+		ClientCAs:    clientCAs,
+		MinVersion:   tls.VersionTLS12,
+	}
+	server.StartTLS()
+	t.Cleanup(server.Close)
+
+	return server
+}
+
+// get calls the server with the bearer token, presenting the client certificate if given
+func get(t *testing.T, server *httptest.Server, p *testPKI, clientCert *tls.Certificate, token string) int {
+	t.Helper()
+
+	roots := x509.NewCertPool()
+	roots.AddCert(p.cert)
+
+	tlsConfig := &tls.Config{RootCAs: roots}
+	if clientCert != nil {
+		tlsConfig.Certificates = []tls.Certificate{*clientCert}
+	}
+	client := &http.Client{Transport: &http.Transport{TLSClientConfig: tlsConfig}}
+
+	req, _ := http.NewRequest(http.MethodGet, server.URL, nil)
+	req.Header.Set("Authorization", "Bearer "+token)
+
+	resp, err := client.Do(req)
+	if err != nil {
+		t.Fatalf("Request failed: %v", err)
+	}
+	resp.Body.Close()
+
+	return resp.StatusCode
+}
+
+// stubDevices resolves each certificate to a device ID equal to its subject CN, with the
+// statuses by device ID
+type stubDevices map[string]string
+
+func (d stubDevices) DeviceIDForCertificate(certInfo *auth.CertificateInfo) (string, error) {
+	return certInfo.SubjectCN, nil
+}
+
+func (d stubDevices) CheckDeviceStatus(deviceID string) error {
+	if status, ok := d[deviceID]; ok && status != "active" {
+		return fmt.Errorf("device is %s", status)
+	}
+	return nil
+}
+
+func (d stubDevices) CheckCertificateDeviceStatus(certInfo *auth.CertificateInfo) error {
+	return d.CheckDeviceStatus(certInfo.SubjectCN)
+}
+
+func TestCertificateBoundUserTokens(t *testing.T) {
+	p := newTestPKI(t)
+	laptop := p.issue(t, 2, "alice-laptop", x509.ExtKeyUsageClientAuth)
+	other := p.issue(t, 3, "mallory", x509.ExtKeyUsageClientAuth)
+
+	jwtManager := auth.NewJWTManager("secret", time.Hour, "test")
+	jwtMiddleware := NewJWTAuthMiddleware(jwtManager, auth.NewRBAC(nil), nil, nil, testLogger())
+	server := newBindingServer(t, p, jwtMiddleware.Authenticate(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	})))
+
+	cnf := auth.NewCertificateConfirmation(auth.CertificateThumbprint(laptop.Leaf))
+	bound, err := jwtManager.GenerateSessionToken("alice", nil, "", cnf)
+	if err != nil {
+		t.Fatalf("Failed to generate token: %v", err)
+	}
+	unbound, _ := jwtManager.GenerateToken("alice")
+
+	tests := []struct {
+		name           string
+		clientCert     *tls.Certificate
+		token          string
+		expectedStatus int
+	}{
+		{"bound token with its certificate", &laptop, bound, http.StatusOK},
+		{"bound token with another certificate", &other, bound, http.StatusUnauthorized},
+		{"bound token without a certificate", nil, bound, http.StatusUnauthorized},
+		{"unbound token without a certificate", nil, unbound, http.StatusOK},
+		{"unbound token with a certificate", &other, unbound, http.StatusOK},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if status := get(t, server, p, tt.clientCert, tt.token); status != tt.expectedStatus {
+				t.Errorf("Expected status %d, got %d", tt.expectedStatus, status)
+			}
+		})
+	}
+}
+
+func TestCertificateBoundDeviceTokens(t *testing.T) {
+	p := newTestPKI(t)
+	device := p.issue(t, 2, "device-1", x509.ExtKeyUsageClientAuth)
+	other := p.issue(t, 3, "device-2", x509.ExtKeyUsageClientAuth)
+
+	jwtManager := auth.NewJWTManager("secret", time.Hour, "test")
+	deviceMiddleware := NewDeviceAuthMiddleware(NewCertificateAuthMiddleware(nil, nil, auth.IdentityConfig{}, nil, testLogger()), jwtManager, stubDevices{}, testLogger())
+	server := newBindingServer(t, p, deviceMiddleware.Authenticate(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if _, err := GetDeviceIDFromContext(r.Context()); err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	})))
+
+	certInfo := auth.ExtractCertificateInfo(device.Leaf)
+	bound, err := jwtManager.GenerateDeviceToken("device-1", certInfo.Fingerprint, auth.NewCertificateConfirmation(certInfo.Thumbprint), time.Minute)
+	if err != nil {
+		t.Fatalf("Failed to generate device token: %v", err)
+	}
+	unbound, _ := jwtManager.GenerateDeviceToken("device-1", certInfo.Fingerprint, nil, time.Minute)
+
+	tests := []struct {
+		name           string
+		clientCert     *tls.Certificate
+		token          string
+		expectedStatus int
+	}{
+		{"bound token with its certificate", &device, bound, http.StatusOK},
+		{"bound token with another certificate", &other, bound, http.StatusUnauthorized},
+		{"bound token without a certificate", nil, bound, http.StatusUnauthorized},
+		{"unbound token without a certificate", nil, unbound, http.StatusOK},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if status := get(t, server, p, tt.clientCert, tt.token); status != tt.expectedStatus {
+				t.Errorf("Expected status %d, got %d", tt.expectedStatus, status)
+			}
+		})
+	}
+}
+
+func TestCertificatePolicyAdmission(t *testing.T) {
+	p := newTestPKI(t)
+	device := p.issue(t, 2, "device-1", x509.ExtKeyUsageClientAuth)
+	laptop := p.issue(t, 3, "laptop-1", x509.ExtKeyUsageClientAuth)
+
+	policy, err := auth.NewPolicyEngine(&auth.CertificatePolicy{
+		RequiredExtKeyUsages: []string{"clientAuth"},
+		SubjectCNPattern:     `device-[0-9]+`,
+	})
+	if err != nil {
+		t.Fatalf("Failed to create policy engine: %v", err)
+	}
+
+	certMiddleware := NewCertificateAuthMiddleware(nil, policy, auth.IdentityConfig{}, nil, testLogger())
+	server := newBindingServer(t, p, certMiddleware.Authenticate(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	})))
+
+	if status := get(t, server, p, &device, ""); status != http.StatusOK {
+		t.Errorf("Expected a compliant certificate to be admitted, got %d", status)
+	}
+	if status := get(t, server, p, &laptop, ""); status != http.StatusUnauthorized {
+		t.Errorf("Expected a non-compliant certificate to be rejected, got %d", status)
+	}
+
+	if stats := policy.Stats(); stats.Rejected != 1 || stats.Violations[auth.PolicyRuleSubject] != 1 {
+		t.Errorf("Expected one subject rejection to be counted, got %+v", stats)
+	}
+}
+
+func TestDeviceLifecycleStatus(t *testing.T) {
+	p := newTestPKI(t)
+	active := p.issue(t, 2, "device-1", x509.ExtKeyUsageClientAuth)
+	suspended := p.issue(t, 3, "device-2", x509.ExtKeyUsageClientAuth)
+	devices := stubDevices{"device-1": "active", "device-2": "suspended"}
+
+	ok := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	})
+
+	certMiddleware := NewCertificateAuthMiddleware(nil, nil, auth.IdentityConfig{}, devices, testLogger())
+	certServer := newBindingServer(t, p, certMiddleware.Authenticate(ok))
+
+	if status := get(t, certServer, p, &active, ""); status != http.StatusOK {
+		t.Errorf("Expected an active device to be admitted, got %d", status)
+	}
+	if status := get(t, certServer, p, &suspended, ""); status != http.StatusForbidden {
+		t.Errorf("Expected a suspended device to be rejected, got %d", status)
+	}
+
+	jwtManager := auth.NewJWTManager("secret", time.Hour, "test")
+	deviceMiddleware := NewDeviceAuthMiddleware(certMiddleware, jwtManager, devices, testLogger())
+	tokenServer := newBindingServer(t, p, deviceMiddleware.Authenticate(ok))
+
+	activeToken, _ := jwtManager.GenerateDeviceToken("device-1", "ab12", nil, time.Minute)
+	suspendedToken, _ := jwtManager.GenerateDeviceToken("device-2", "cd34", nil, time.Minute)
+
+	if status := get(t, tokenServer, p, nil, activeToken); status != http.StatusOK {
+		t.Errorf("Expected an active device's token to be accepted, got %d", status)
+	}
+	if status := get(t, tokenServer, p, nil, suspendedToken); status != http.StatusForbidden {
+		t.Errorf("Expected a token issued before suspension to be rejected, got %d", status)
+	}
+}
+
+func TestForwardedClientCertificates(t *testing.T) {
+	p := newTestPKI(t)
+	device := p.issue(t, 2, "device-1", x509.ExtKeyUsageClientAuth)
+	foreign := newTestPKI(t).issue(t, 3, "device-2", x509.ExtKeyUsageClientAuth)
+
+	clientCAs := x509.NewCertPool()
+	clientCAs.AddCert(p.cert)
+
+	proxies, err := auth.NewTrustedProxies([]string{"192.0.2.0/24"}, nil)
+	if err != nil {
+		t.Fatalf("Failed to create trusted proxies: %v", err)
+	}
+
+	forwardedMiddleware := NewForwardedCertificateMiddleware("X-Forwarded-Client-Cert", auth.ForwardedCertXFCC, proxies,
+		func() *x509.CertPool { return clientCAs }, testLogger())
+	certMiddleware := NewCertificateAuthMiddleware(nil, nil, auth.IdentityConfig{}, nil, testLogger())
+	handler := forwardedMiddleware.Handler(certMiddleware.Authenticate(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if cert := VerifiedClientCertificate(r); cert == nil || cert.SerialNumber.Int64() != 2 {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	})))
+
+	xfcc := func(cert tls.Certificate) string {
+		encoded := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: cert.Leaf.Raw})
+		return `Hash=abc;Cert="` + strings.ReplaceAll(url.QueryEscape(string(encoded)), "+", "%20") + `"`
+	}
+
+	tests := []struct {
+		name           string
+		remoteAddr     string
+		header         string
+		expectedStatus int
+	}{
+		{"trusted proxy forwards a verified certificate", "192.0.2.10:5000", xfcc(device), http.StatusOK},
+		{"trusted proxy forwards a certificate from another CA", "192.0.2.10:5000", xfcc(foreign), http.StatusUnauthorized},
+		{"trusted proxy forwards an invalid header", "192.0.2.10:5000", "Hash=abc", http.StatusUnauthorized},
+		{"trusted proxy forwards no certificate", "192.0.2.10:5000", "", http.StatusUnauthorized},
+		{"untrusted peer sends the header", "203.0.113.5:5000", xfcc(device), http.StatusBadRequest},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodPost, "/api/v1/devices/authenticate", nil)
+			req.RemoteAddr = tt.remoteAddr
+			if tt.header != "" {
+				req.Header.Set("X-Forwarded-Client-Cert", tt.header)
+			}
+
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, req)
+
+			if rec.Code != tt.expectedStatus {
+				t.Errorf("Expected status %d, got %d", tt.expectedStatus, rec.Code)
+			}
+		})
+	}
+}
+
+func TestClientIP(t *testing.T) {
+	proxies, err := auth.NewTrustedProxies([]string{"192.0.2.0/24"}, nil)
+	if err != nil {
+		t.Fatalf("Failed to create trusted proxies: %v", err)
+	}
+
+	forwardedMiddleware := NewForwardedCertificateMiddleware("X-Forwarded-Client-Cert", auth.ForwardedCertXFCC, proxies,
+		func() *x509.CertPool { return x509.NewCertPool() }, testLogger())
+
+	var clientIP string
+	handler := forwardedMiddleware.Handler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		clientIP = ClientIP(r)
+	}))
+
+	tests := []struct {
+		name         string
+		remoteAddr   string
+		forwardedFor []string
+		expected     string
+	}{
+		{"direct client", "203.0.113.5:5000", nil, "203.0.113.5"},
+		{"untrusted peer claims an address", "203.0.113.5:5000", []string{"198.51.100.7"}, "203.0.113.5"},
+		{"trusted proxy", "192.0.2.10:5000", []string{"198.51.100.7"}, "198.51.100.7"},
+		{"trusted proxy appends to a client supplied list", "192.0.2.10:5000", []string{"10.0.0.1, 198.51.100.7"}, "198.51.100.7"},
+		{"trusted proxy adds a header line", "192.0.2.10:5000", []string{"10.0.0.1", "198.51.100.7"}, "198.51.100.7"},
+		{"trusted proxy forwards no address", "192.0.2.10:5000", nil, "192.0.2.10"},
+		{"trusted proxy forwards an invalid address", "192.0.2.10:5000", []string{"unknown"}, "192.0.2.10"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "/api/v1/devices/me", nil)
+			req.RemoteAddr = tt.remoteAddr
+			for _, value := range tt.forwardedFor {
+				req.Header.Add("X-Forwarded-For", value)
+			}
+
+			clientIP = ""
+			handler.ServeHTTP(httptest.NewRecorder(), req)
+
+			if clientIP != tt.expected {
+				t.Errorf("Expected client IP %s, got %s", tt.expected, clientIP)
+			}
+		})
+	}
+}
@@ -0,0 +1,152 @@
+package middleware
+
+import (
+	"context"
+	"crypto/x509"
+	"net"
+	"net/http"
+	"strings"
+
+	"device-assignment-api/pkg/auth"
+	"device-assignment-api/pkg/logger"
+)
+
+// forwardedCertificateContextKey is the context key for the client certificate forwarded by a
+// trusted proxy
+const forwardedCertificateContextKey ContextKey = "forwarded_certificate"
+
+// forwardedForHeader lists the addresses a request was forwarded for, each proxy appending
+// the address of the peer it accepted the request from
+const forwardedForHeader = "X-Forwarded-For"
+
+// forwardedCertificate is the client certificate a trusted proxy forwarded and its verified
+// chains, together with the client address it forwarded. The leaf is nil when the proxy
+// forwarded no certificate, and the client IP is empty when it forwarded no address.
+type forwardedCertificate struct {
+	leaf     *x509.Certificate
+	chains   [][]*x509.Certificate
+	clientIP string
+}
+
+// ForwardedCertificateMiddleware accepts client certificates forwarded in a header by a
+// TLS-terminating proxy. Forwarded chains are re-verified against the client CA pool, and the
+// header is ignored unless the request comes from a trusted proxy.
+type ForwardedCertificateMiddleware struct {
+	header    string
+	format    auth.ForwardedCertFormat
+	proxies   *auth.TrustedProxies
+	clientCAs func() *x509.CertPool
+	logger    logger.Logger
+}
+
+// NewForwardedCertificateMiddleware creates a new forwarded certificate middleware. The client
+// CA pool is looked up per request so reloaded CAs take effect.
+func NewForwardedCertificateMiddleware(
+	header string,
+	format auth.ForwardedCertFormat,
+	proxies *auth.TrustedProxies,
+	clientCAs func() *x509.CertPool,
+	logger logger.Logger,
+) *ForwardedCertificateMiddleware {
+	return &ForwardedCertificateMiddleware{
+		header:    header,
+		format:    format,
+		proxies:   proxies,
+		clientCAs: clientCAs,
+		logger:    logger,
+	}
+}
+
+// Handler verifies the forwarded client certificate of requests from trusted proxies and adds
+// it to the request context. Requests from a trusted proxy are only ever authenticated by the
+// forwarded certificate, never by the proxy's own.
+func (m *ForwardedCertificateMiddleware) Handler(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		value := r.Header.Get(m.header)
+
+		if !m.proxies.Trusts(r.RemoteAddr, tlsClientCertificate(r)) {
+			// Clients must not be able to claim a certificate by sending the header themselves
+			if value != "" {
+				m.logger.Warn("Ignoring forwarded client certificate from untrusted peer",
+					"remote_addr", r.RemoteAddr,
+					"header", m.header)
+				r.Header.Del(m.header)
+			}
+
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		forwarded := &forwardedCertificate{clientIP: forwardedClientIP(r)}
+		if value != "" {
+			certs, err := auth.ParseForwardedCertificates(m.format, value)
+			if err != nil {
+				m.logger.Warn("Invalid forwarded client certificate", "remote_addr", r.RemoteAddr, "error", err)
+				http.Error(w, "Invalid client certificate", http.StatusUnauthorized)
+				return
+			}
+
+			chains, err := auth.VerifyForwardedChain(certs, m.clientCAs())
+			if err != nil {
+				m.logger.Warn("Forwarded client certificate was not verified",
+					"remote_addr", r.RemoteAddr,
+					"serial_number", certs[0].SerialNumber.String(),
+					"error", err)
+				http.Error(w, "Client certificate not verified", http.StatusUnauthorized)
+				return
+			}
+
+			forwarded.leaf = certs[0]
+			forwarded.chains = chains
+		}
+
+		ctx := context.WithValue(r.Context(), forwardedCertificateContextKey, forwarded)
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+// forwardedClientIP returns the last address in the X-Forwarded-For header, which the trusted
+// proxy appended for the peer it accepted the request from, or nothing when it is missing or
+// invalid. Earlier entries are sent by the client and cannot be trusted.
+func forwardedClientIP(r *http.Request) string {
+	values := r.Header.Values(forwardedForHeader)
+	if len(values) == 0 {
+		return ""
+	}
+
+	entries := strings.Split(values[len(values)-1], ",")
+	ip := net.ParseIP(strings.TrimSpace(entries[len(entries)-1]))
+	if ip == nil {
+		return ""
+	}
+	return ip.String()
+}
+
+// ClientIP returns the IP address of the client that sent the request. Behind a trusted proxy
+// this is the client address the proxy forwarded rather than the proxy's own.
+func ClientIP(r *http.Request) string {
+	if forwarded, ok := getForwardedCertificate(r.Context()); ok && forwarded.clientIP != "" {
+		return forwarded.clientIP
+	}
+
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// tlsClientCertificate returns the verified leaf certificate of the TLS connection itself, or nil
+func tlsClientCertificate(r *http.Request) *x509.Certificate {
+	if r.TLS == nil || len(r.TLS.VerifiedChains) == 0 || len(r.TLS.PeerCertificates) == 0 {
+		return nil
+	}
+	return r.TLS.PeerCertificates[0]
+}
+
+// getForwardedCertificate returns the certificate forwarded by a trusted proxy, and whether
+// the request came through one
+func getForwardedCertificate(ctx context.Context) (*forwardedCertificate, bool) {
+	forwarded, ok := ctx.Value(forwardedCertificateContextKey).(*forwardedCertificate)
+	return forwarded, ok && forwarded != nil
+}
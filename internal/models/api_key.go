@@ -0,0 +1,84 @@
+package models
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// APIKeyKind distinguishes personal access tokens from service API keys
+type APIKeyKind string
+
+const (
+	// APIKeyKindPersonal is a personal access token acting as the user who created it
+	APIKeyKindPersonal APIKeyKind = "personal"
+	// APIKeyKindService is a service API key created by an admin for an automation
+	APIKeyKindService APIKeyKind = "service"
+)
+
+// APIKey is a named, scoped credential for non-interactive clients. Only the SHA-256 hash of
+// the key is stored; the key itself is shown once when it is created. Prefix holds the first
+// characters of the key so it can be recognised in listings.
+type APIKey struct {
+	ID         uuid.UUID  `json:"id" db:"id"`
+	Kind       APIKeyKind `json:"kind" db:"kind"`
+	Name       string     `json:"name" db:"name"`
+	UserID     string     `json:"user_id" db:"user_id"`
+	Prefix     string     `json:"prefix" db:"prefix"`
+	KeyHash    string     `json:"-" db:"key_hash"`
+	Scopes     []string   `json:"scopes" db:"scopes"`
+	CreatedBy  string     `json:"created_by" db:"created_by"`
+	CreatedAt  time.Time  `json:"created_at" db:"created_at"`
+	ExpiresAt  *time.Time `json:"expires_at,omitempty" db:"expires_at"`
+	LastUsedAt *time.Time `json:"last_used_at,omitempty" db:"last_used_at"`
+	RevokedAt  *time.Time `json:"revoked_at,omitempty" db:"revoked_at"`
+}
+
+// NewAPIKey creates a new APIKey instance with a generated UUID. A nil expiry means the key
+// does not expire.
+func NewAPIKey(kind APIKeyKind, name, userID, prefix, keyHash string, scopes []string, createdBy string, expiresAt *time.Time) *APIKey {
+	if expiresAt != nil {
+		utc := expiresAt.UTC()
+		expiresAt = &utc
+	}
+
+	return &APIKey{
+		ID:        uuid.New(),
+		Kind:      kind,
+		Name:      name,
+		UserID:    userID,
+		Prefix:    prefix,
+		KeyHash:   keyHash,
+		Scopes:    scopes,
+		CreatedBy: createdBy,
+		CreatedAt: time.Now().UTC(),
+		ExpiresAt: expiresAt,
+	}
+}
+
+// IsActive returns true if the key has been neither revoked nor expired
+func (k *APIKey) IsActive() bool {
+	return k.RevokedAt == nil && (k.ExpiresAt == nil || time.Now().Before(*k.ExpiresAt))
+}
+
+// APIKeyRepository defines the interface for API key operations
+type APIKeyRepository interface {
+	// CreateAPIKey stores a new API key in the database
+	CreateAPIKey(key *APIKey) error
+
+	// GetAPIKey retrieves an API key by its UUID
+	GetAPIKey(id uuid.UUID) (*APIKey, error)
+
+	// GetAPIKeyByHash retrieves an API key by the hash of its value
+	GetAPIKeyByHash(keyHash string) (*APIKey, error)
+
+	// ListAPIKeys retrieves the unrevoked keys of a kind, limited to one user's keys when
+	// userID is not empty
+	ListAPIKeys(kind APIKeyKind, userID string) ([]*APIKey, error)
+
+	// TouchAPIKey records that an API key was used
+	TouchAPIKey(id uuid.UUID) error
+
+	// RevokeAPIKey marks an API key as revoked
+	RevokeAPIKey(id uuid.UUID) error
+}
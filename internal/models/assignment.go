@@ -49,6 +49,17 @@ type AssignmentRepository interface {
 	
 	// UnassignDevice marks the current assignment for a device as unassigned
 	UnassignDevice(deviceID uuid.UUID) error
+
+	// AssignDevice atomically stores the assignment of an unassigned device. The device must be
+	// active ("device is not active") and have no active assignment ("device is already
+	// assigned to another user").
+	AssignDevice(assignment *Assignment) error
+
+	// ReassignDevice atomically ends the device's active assignment, if any, and stores the new
+	// assignment. It reports whether an assignment was replaced. The device must be active
+	// ("device is not active") and not already assigned to the new assignment's user
+	// ("device is already assigned to the user").
+	ReassignDevice(assignment *Assignment) (bool, error)
 	
 	// IsDeviceAssigned checks if a device is currently assigned to any user
 	IsDeviceAssigned(deviceID uuid.UUID) (bool, error)
@@ -1,36 +1,127 @@
 package models
 
 import (
+	"math"
 	"time"
 
 	"github.com/google/uuid"
 )
 
 // Device represents a client device that can be authenticated via certificate
+// A device is identified by its certificate issuer DN together with the serial number,
+// since serial numbers are only unique per issuing CA. When an identity mode other than
+// issuer/serial is configured, IdentityKey binds the device across certificate renewals.
+// SPIFFEID is the workload identity of devices authenticating with an X.509-SVID.
+// The validity period is that of the certificate the device last authenticated with.
+// Name and Labels are set by the assigned user, while Model, HardwareRevision and
+// FirmwareVersion are reported by the device itself. Status is the device's lifecycle state.
+// LastSeenAt, LastSeenIP and ReportedStatus come from the device's most recent heartbeat.
 type Device struct {
-	ID                       uuid.UUID `json:"id" db:"id"`
-	CertificateSerialNumber  string    `json:"certificate_serial_number" db:"certificate_serial_number"`
-	CertificateIssuerCN      string    `json:"certificate_issuer_cn" db:"certificate_issuer_cn"`
-	CreatedAt               time.Time `json:"created_at" db:"created_at"`
+	ID                        uuid.UUID    `json:"id" db:"id"`
+	CertificateSerialNumber   string       `json:"certificate_serial_number" db:"certificate_serial_number"`
+	CertificateIssuerDN       string       `json:"certificate_issuer_dn" db:"certificate_issuer_dn"`
+	CertificateIssuerCN       string       `json:"certificate_issuer_cn" db:"certificate_issuer_cn"`
+	CertificateAuthorityKeyID string       `json:"certificate_authority_key_id,omitempty" db:"certificate_authority_key_id"`
+	IdentityKey               string       `json:"identity_key,omitempty" db:"identity_key"`
+	SPIFFEID                  string       `json:"spiffe_id,omitempty" db:"spiffe_id"`
+	CertificateNotBefore      *time.Time   `json:"certificate_not_before,omitempty" db:"certificate_not_before"`
+	CertificateNotAfter       *time.Time   `json:"certificate_not_after,omitempty" db:"certificate_not_after"`
+	CertificateExpiresInDays  *int         `json:"certificate_expires_in_days,omitempty" db:"-"`
+	Name                      string       `json:"name" db:"name"`
+	Model                     string       `json:"model,omitempty" db:"model"`
+	HardwareRevision          string       `json:"hardware_revision,omitempty" db:"hardware_revision"`
+	FirmwareVersion           string       `json:"firmware_version,omitempty" db:"firmware_version"`
+	Labels                    Labels       `json:"labels,omitempty" db:"labels"`
+	Status                    DeviceStatus `json:"status" db:"status"`
+	LastSeenAt                *time.Time   `json:"last_seen_at,omitempty" db:"last_seen_at"`
+	LastSeenIP                string       `json:"last_seen_ip,omitempty" db:"last_seen_ip"`
+	ReportedStatus            string       `json:"reported_status,omitempty" db:"reported_status"`
+	CreatedAt                 time.Time    `json:"created_at" db:"created_at"`
 }
 
-// NewDevice creates a new Device instance with a generated UUID
-func NewDevice(serialNumber, issuerCN string) *Device {
+// NewDevice creates a new active Device instance with a generated UUID
+func NewDevice(serialNumber, issuerDN, issuerCN, authorityKeyID string) *Device {
 	return &Device{
-		ID:                      uuid.New(),
-		CertificateSerialNumber: serialNumber,
-		CertificateIssuerCN:     issuerCN,
-		CreatedAt:              time.Now().UTC(),
+		ID:                        uuid.New(),
+		CertificateSerialNumber:   serialNumber,
+		CertificateIssuerDN:       issuerDN,
+		CertificateIssuerCN:       issuerCN,
+		CertificateAuthorityKeyID: authorityKeyID,
+		Status:                    DeviceStatusActive,
+		CreatedAt:                 time.Now().UTC(),
 	}
 }
 
-// DeviceWithAssignment represents a device with its current assignment information
+// ApplyCertificate updates the device's current certificate identity, reporting whether anything changed
+func (d *Device) ApplyCertificate(serialNumber, issuerDN, issuerCN, authorityKeyID, identityKey, spiffeID string) bool {
+	changed := d.CertificateSerialNumber != serialNumber ||
+		d.CertificateIssuerDN != issuerDN ||
+		d.CertificateIssuerCN != issuerCN ||
+		d.CertificateAuthorityKeyID != authorityKeyID ||
+		d.IdentityKey != identityKey ||
+		d.SPIFFEID != spiffeID
+
+	d.CertificateSerialNumber = serialNumber
+	d.CertificateIssuerDN = issuerDN
+	d.CertificateIssuerCN = issuerCN
+	d.CertificateAuthorityKeyID = authorityKeyID
+	d.IdentityKey = identityKey
+	d.SPIFFEID = spiffeID
+
+	return changed
+}
+
+// ApplyCertificateValidity records the validity period of the device's current certificate,
+// reporting whether it changed
+func (d *Device) ApplyCertificateValidity(notBefore, notAfter time.Time) bool {
+	changed := d.CertificateNotBefore == nil || !d.CertificateNotBefore.Equal(notBefore) ||
+		d.CertificateNotAfter == nil || !d.CertificateNotAfter.Equal(notAfter)
+
+	notBefore, notAfter = notBefore.UTC(), notAfter.UTC()
+	d.CertificateNotBefore = &notBefore
+	d.CertificateNotAfter = &notAfter
+
+	return changed
+}
+
+// SetCertificateExpiresIn derives the number of whole days left until the device's certificate
+// expires, which is negative once it has expired. It is left unset when the validity period
+// is unknown.
+func (d *Device) SetCertificateExpiresIn(now time.Time) {
+	if d.CertificateNotAfter == nil {
+		d.CertificateExpiresInDays = nil
+		return
+	}
+
+	days := int(math.Floor(d.CertificateNotAfter.Sub(now).Hours() / 24))
+	d.CertificateExpiresInDays = &days
+}
+
+// DeviceWithAssignment represents a device with its current assignment information.
+// Online reports whether the device sent a heartbeat within the configured timeout.
 type DeviceWithAssignment struct {
 	Device
 	AssignmentID    *uuid.UUID `json:"assignment_id,omitempty" db:"assignment_id"`
 	UserID          *string    `json:"user_id,omitempty" db:"user_id"`
 	AssignedAt      *time.Time `json:"assigned_at,omitempty" db:"assigned_at"`
 	IsAssigned      bool       `json:"is_assigned" db:"is_assigned"`
+	Online          bool       `json:"online" db:"-"`
+}
+
+// ClaimableDevice is the limited view of an unassigned device, omitting its certificate details
+type ClaimableDevice struct {
+	ID         uuid.UUID `json:"id"`
+	IsAssigned bool      `json:"is_assigned"`
+	CreatedAt  time.Time `json:"created_at"`
+}
+
+// ClaimableView returns the limited view of the device shown to users who may claim it
+func (d *DeviceWithAssignment) ClaimableView() *ClaimableDevice {
+	return &ClaimableDevice{
+		ID:         d.ID,
+		IsAssigned: d.IsAssigned,
+		CreatedAt:  d.CreatedAt,
+	}
 }
 
 // DeviceRepository defines the interface for device data operations
@@ -41,15 +132,37 @@ type DeviceRepository interface {
 	// GetDeviceByID retrieves a device by its UUID
 	GetDeviceByID(id uuid.UUID) (*Device, error)
 	
-	// GetDeviceBySerialNumber retrieves a device by its certificate serial number
-	GetDeviceBySerialNumber(serialNumber string) (*Device, error)
+	// GetDeviceByIssuerAndSerial retrieves a device by its certificate issuer DN and serial number
+	GetDeviceByIssuerAndSerial(issuerDN, serialNumber string) (*Device, error)
+	
+	// GetLegacyDevice retrieves a device registered before issuer DNs were recorded
+	GetLegacyDevice(issuerCN, serialNumber string) (*Device, error)
+	
+	// GetDeviceByIdentityKey retrieves a device by its stable identity key
+	GetDeviceByIdentityKey(identityKey string) (*Device, error)
+	
+	// UpdateDeviceCertificate records the device's current certificate identity, validity, identity key and SPIFFE ID
+	UpdateDeviceCertificate(device *Device) error
+	
+	// UpdateDeviceMetadata records the device's values of the metadata fields set in the update
+	// (name, labels and reported model, hardware revision and firmware version), leaving the
+	// other fields as they are stored
+	UpdateDeviceMetadata(device *Device, fields DeviceMetadataUpdate) error
 	
 	// GetDeviceWithAssignment retrieves a device with its assignment information
 	GetDeviceWithAssignment(id uuid.UUID) (*DeviceWithAssignment, error)
 	
-	// DeviceExists checks if a device exists by serial number
-	DeviceExists(serialNumber string) (bool, error)
+	// DeviceExists checks if a device exists by certificate issuer DN and serial number
+	DeviceExists(issuerDN, serialNumber string) (bool, error)
+	
+	// QueryDevices retrieves at most query.Limit devices matching the query's filters, with
+	// their current assignment information, in the query's order starting after its cursor
+	QueryDevices(query *DeviceQuery) ([]*DeviceWithAssignment, error)
+	
+	// CountDevices counts every device matching the query's filters, ignoring its cursor and limit
+	CountDevices(query *DeviceQuery) (int, error)
 	
-	// GetDevicesByUserID retrieves all devices assigned to a specific user
-	GetDevicesByUserID(userID string) ([]*DeviceWithAssignment, error)
+	// ListDevicesExpiringBetween retrieves devices whose certificate expires after from and no
+	// later than to, soonest first
+	ListDevicesExpiringBetween(from, to time.Time) ([]*DeviceWithAssignment, error)
 }
@@ -0,0 +1,45 @@
+package models
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// DeviceCertificate records a certificate that a device has presented
+type DeviceCertificate struct {
+	ID           uuid.UUID `json:"id" db:"id"`
+	DeviceID     uuid.UUID `json:"device_id" db:"device_id"`
+	SerialNumber string    `json:"serial_number" db:"serial_number"`
+	IssuerDN     string    `json:"issuer_dn" db:"issuer_dn"`
+	NotBefore    time.Time `json:"not_before" db:"not_before"`
+	NotAfter     time.Time `json:"not_after" db:"not_after"`
+	Fingerprint  string    `json:"fingerprint_sha256" db:"fingerprint_sha256"`
+	FirstSeenAt  time.Time `json:"first_seen_at" db:"first_seen_at"`
+	LastSeenAt   time.Time `json:"last_seen_at" db:"last_seen_at"`
+}
+
+// NewDeviceCertificate creates a new DeviceCertificate seen for the first time now
+func NewDeviceCertificate(deviceID uuid.UUID, serialNumber, issuerDN, fingerprint string, notBefore, notAfter time.Time) *DeviceCertificate {
+	now := time.Now().UTC()
+	return &DeviceCertificate{
+		ID:           uuid.New(),
+		DeviceID:     deviceID,
+		SerialNumber: serialNumber,
+		IssuerDN:     issuerDN,
+		NotBefore:    notBefore.UTC(),
+		NotAfter:     notAfter.UTC(),
+		Fingerprint:  fingerprint,
+		FirstSeenAt:  now,
+		LastSeenAt:   now,
+	}
+}
+
+// DeviceCertificateRepository defines the interface for device certificate history operations
+type DeviceCertificateRepository interface {
+	// RecordCertificate stores a certificate, or updates its last seen time if already recorded
+	RecordCertificate(certificate *DeviceCertificate) error
+
+	// GetCertificatesByDeviceID retrieves every certificate a device has presented, newest first
+	GetCertificatesByDeviceID(deviceID uuid.UUID) ([]*DeviceCertificate, error)
+}
@@ -0,0 +1,32 @@
+package models
+
+import (
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+func TestNewDeviceCertificate(t *testing.T) {
+	deviceID := uuid.New()
+	notBefore := time.Now().Add(-time.Hour)
+	notAfter := time.Now().Add(365 * 24 * time.Hour)
+
+	certificate := NewDeviceCertificate(deviceID, "ABC123", "CN=Test CA", "deadbeef", notBefore, notAfter)
+
+	if certificate.ID == uuid.Nil {
+		t.Error("Expected certificate ID to be generated, got nil UUID")
+	}
+
+	if certificate.DeviceID != deviceID {
+		t.Errorf("Expected device ID %s, got %s", deviceID, certificate.DeviceID)
+	}
+
+	if !certificate.NotBefore.Equal(notBefore) || !certificate.NotAfter.Equal(notAfter) {
+		t.Error("Expected validity period to be preserved")
+	}
+
+	if certificate.FirstSeenAt.IsZero() || !certificate.FirstSeenAt.Equal(certificate.LastSeenAt) {
+		t.Error("Expected FirstSeenAt and LastSeenAt to be set to the same time")
+	}
+}
@@ -0,0 +1,194 @@
+package models
+
+import (
+	"database/sql/driver"
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// Limits on device metadata
+const (
+	maxDeviceNameLength    = 100
+	maxReportedFieldLength = 64
+	maxDeviceLabels        = 32
+	maxLabelKeyLength      = 63
+	maxLabelValueLength    = 128
+)
+
+// labelKeyPattern matches label keys: lowercase letters, digits, '.', '_', '-' and '/',
+// starting and ending with a letter or digit
+var labelKeyPattern = regexp.MustCompile(`^[a-z0-9]([a-z0-9._/-]*[a-z0-9])?$`)
+
+// Labels are free-form key/value pairs attached to a device, stored as a JSONB object
+type Labels map[string]string
+
+// Value implements driver.Valuer, storing nil labels as an empty object
+func (l Labels) Value() (driver.Value, error) {
+	if l == nil {
+		return "{}", nil
+	}
+
+	data, err := json.Marshal(map[string]string(l))
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode labels: %w", err)
+	}
+	return string(data), nil
+}
+
+// Scan implements sql.Scanner
+func (l *Labels) Scan(src any) error {
+	var data []byte
+	switch v := src.(type) {
+	case nil:
+		*l = nil
+		return nil
+	case []byte:
+		data = v
+	case string:
+		data = []byte(v)
+	default:
+		return fmt.Errorf("cannot scan %T into labels", src)
+	}
+
+	var labels map[string]string
+	if err := json.Unmarshal(data, &labels); err != nil {
+		return fmt.Errorf("failed to decode labels: %w", err)
+	}
+	if len(labels) == 0 {
+		labels = nil
+	}
+
+	*l = labels
+	return nil
+}
+
+// Validate checks the number of labels and the format of each key and value
+func (l Labels) Validate() error {
+	if len(l) > maxDeviceLabels {
+		return fmt.Errorf("at most %d labels are allowed", maxDeviceLabels)
+	}
+
+	for key, value := range l {
+		if err := ValidateLabel(key, value); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// ValidateLabel checks the format of a single label key and value
+func ValidateLabel(key, value string) error {
+	if len(key) > maxLabelKeyLength || !labelKeyPattern.MatchString(key) {
+		return fmt.Errorf("label key %q must be at most %d lowercase letters, digits, '.', '_', '-' or '/', starting and ending with a letter or digit",
+			key, maxLabelKeyLength)
+	}
+
+	if err := validateText(value, maxLabelValueLength); err != nil {
+		return fmt.Errorf("label %q value %w", key, err)
+	}
+
+	return nil
+}
+
+// DeviceMetadataUpdate lists the descriptive device fields to change. Nil fields are left
+// unchanged, and non-nil Labels replace every existing label; an empty map removes them all.
+type DeviceMetadataUpdate struct {
+	Name             *string
+	Model            *string
+	HardwareRevision *string
+	FirmwareVersion  *string
+	Labels           Labels
+}
+
+// ApplyMetadata validates the update and applies it to the device, reporting whether
+// anything changed. Nothing is applied when the update is invalid.
+func (d *Device) ApplyMetadata(update DeviceMetadataUpdate) (bool, error) {
+	fields := []struct {
+		name      string
+		value     *string
+		target    *string
+		maxLength int
+	}{
+		{"name", update.Name, &d.Name, maxDeviceNameLength},
+		{"model", update.Model, &d.Model, maxReportedFieldLength},
+		{"hardware_revision", update.HardwareRevision, &d.HardwareRevision, maxReportedFieldLength},
+		{"firmware_version", update.FirmwareVersion, &d.FirmwareVersion, maxReportedFieldLength},
+	}
+
+	for _, field := range fields {
+		if field.value == nil {
+			continue
+		}
+		if err := validateText(strings.TrimSpace(*field.value), field.maxLength); err != nil {
+			return false, fmt.Errorf("%s %w", field.name, err)
+		}
+	}
+
+	if update.Labels != nil {
+		if err := update.Labels.Validate(); err != nil {
+			return false, err
+		}
+	}
+
+	changed := false
+	for _, field := range fields {
+		if field.value == nil {
+			continue
+		}
+		if value := strings.TrimSpace(*field.value); *field.target != value {
+			*field.target = value
+			changed = true
+		}
+	}
+
+	if update.Labels != nil && !labelsEqual(d.Labels, update.Labels) {
+		d.Labels = nil
+		if len(update.Labels) > 0 {
+			d.Labels = make(Labels, len(update.Labels))
+			for key, value := range update.Labels {
+				d.Labels[key] = value
+			}
+		}
+		changed = true
+	}
+
+	return changed, nil
+}
+
+// validateText checks that a free-text value is valid UTF-8 of at most maxLength characters
+// without control characters
+func validateText(value string, maxLength int) error {
+	if !utf8.ValidString(value) {
+		return fmt.Errorf("must be valid UTF-8")
+	}
+
+	if utf8.RuneCountInString(value) > maxLength {
+		return fmt.Errorf("must be at most %d characters", maxLength)
+	}
+
+	if strings.IndexFunc(value, unicode.IsControl) >= 0 {
+		return fmt.Errorf("must not contain control characters")
+	}
+
+	return nil
+}
+
+// labelsEqual reports whether two label sets hold the same pairs
+func labelsEqual(a, b Labels) bool {
+	if len(a) != len(b) {
+		return false
+	}
+
+	for key, value := range a {
+		if other, ok := b[key]; !ok || other != value {
+			return false
+		}
+	}
+
+	return true
+}
@@ -0,0 +1,99 @@
+package models
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestLabelsValidate(t *testing.T) {
+	tooMany := Labels{}
+	for i := 0; i <= maxDeviceLabels; i++ {
+		tooMany[strings.Repeat("k", i+1)] = "v"
+	}
+
+	tests := []struct {
+		name    string
+		labels  Labels
+		wantErr bool
+	}{
+		{"empty", Labels{}, false},
+		{"valid", Labels{"env": "prod", "example.com/rack": "a-12", "owner_team": ""}, false},
+		{"uppercase key", Labels{"Env": "prod"}, true},
+		{"empty key", Labels{"": "prod"}, true},
+		{"key ending in separator", Labels{"env-": "prod"}, true},
+		{"key too long", Labels{strings.Repeat("k", maxLabelKeyLength+1): "v"}, true},
+		{"value too long", Labels{"env": strings.Repeat("v", maxLabelValueLength+1)}, true},
+		{"control character in value", Labels{"env": "pr\tod"}, true},
+		{"too many labels", tooMany, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := tt.labels.Validate(); (err != nil) != tt.wantErr {
+				t.Errorf("Expected error %v, got %v", tt.wantErr, err)
+			}
+		})
+	}
+}
+
+func TestLabelsValueAndScan(t *testing.T) {
+	value, err := Labels(nil).Value()
+	if err != nil || value != "{}" {
+		t.Errorf("Expected nil labels to be stored as an empty object, got %v, %v", value, err)
+	}
+
+	value, err = Labels{"env": "prod"}.Value()
+	if err != nil {
+		t.Fatalf("Failed to encode labels: %v", err)
+	}
+
+	var scanned Labels
+	if err := scanned.Scan([]byte(value.(string))); err != nil {
+		t.Fatalf("Failed to scan labels: %v", err)
+	}
+	if scanned["env"] != "prod" || len(scanned) != 1 {
+		t.Errorf("Expected the labels to round-trip, got %v", scanned)
+	}
+
+	if err := scanned.Scan("{}"); err != nil || scanned != nil {
+		t.Errorf("Expected an empty object to scan as nil labels, got %v, %v", scanned, err)
+	}
+
+	if err := scanned.Scan(42); err == nil {
+		t.Error("Expected an error scanning a non-JSON value")
+	}
+}
+
+func TestDeviceApplyMetadata(t *testing.T) {
+	device := NewDevice("01", "CN=Test CA", "Test CA", "AA")
+	name, firmware := "  Lab printer ", "1.2.0"
+
+	changed, err := device.ApplyMetadata(DeviceMetadataUpdate{Name: &name, FirmwareVersion: &firmware, Labels: Labels{"env": "prod"}})
+	if err != nil || !changed {
+		t.Fatalf("Expected the first update to change the device, got %v, %v", changed, err)
+	}
+	if device.Name != "Lab printer" || device.FirmwareVersion != "1.2.0" || device.Labels["env"] != "prod" {
+		t.Errorf("Expected the update to be applied, got %+v", device)
+	}
+
+	changed, err = device.ApplyMetadata(DeviceMetadataUpdate{Name: &name, Labels: Labels{"env": "prod"}})
+	if err != nil || changed {
+		t.Errorf("Expected an identical update to change nothing, got %v, %v", changed, err)
+	}
+
+	invalid := strings.Repeat("n", maxDeviceNameLength+1)
+	if _, err := device.ApplyMetadata(DeviceMetadataUpdate{Name: &invalid, Labels: Labels{}}); err == nil {
+		t.Error("Expected an error for a name that is too long")
+	}
+	if device.Name != "Lab printer" || len(device.Labels) != 1 {
+		t.Errorf("Expected an invalid update to apply nothing, got %+v", device)
+	}
+
+	changed, err = device.ApplyMetadata(DeviceMetadataUpdate{Labels: Labels{}})
+	if err != nil || !changed || device.Labels != nil {
+		t.Errorf("Expected empty labels to remove every label, got %v, %v, %v", changed, err, device.Labels)
+	}
+	if device.Name != "Lab printer" {
+		t.Errorf("Expected fields left out of the update to be unchanged, got %q", device.Name)
+	}
+}
@@ -0,0 +1,78 @@
+package models
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// DeviceHeartbeat is a sign of life sent by a device, with the address it was sent from and
+// the status the device optionally reports about itself
+type DeviceHeartbeat struct {
+	DeviceID       uuid.UUID
+	SeenAt         time.Time
+	SourceIP       string
+	ReportedStatus string
+}
+
+// NewDeviceHeartbeat validates a heartbeat the device sent from sourceIP at seenAt. An empty
+// reported status leaves the previously reported one unchanged.
+func NewDeviceHeartbeat(deviceID uuid.UUID, seenAt time.Time, sourceIP, reportedStatus string) (*DeviceHeartbeat, error) {
+	reportedStatus = strings.TrimSpace(reportedStatus)
+	if err := validateText(reportedStatus, maxReportedFieldLength); err != nil {
+		return nil, fmt.Errorf("status %w", err)
+	}
+
+	return &DeviceHeartbeat{
+		DeviceID:       deviceID,
+		SeenAt:         seenAt.UTC(),
+		SourceIP:       sourceIP,
+		ReportedStatus: reportedStatus,
+	}, nil
+}
+
+// Merge folds a later heartbeat of the same device into this one, keeping the most recent
+// sighting and the most recently reported status
+func (h *DeviceHeartbeat) Merge(later *DeviceHeartbeat) {
+	if later.SeenAt.Before(h.SeenAt) {
+		if h.ReportedStatus == "" {
+			h.ReportedStatus = later.ReportedStatus
+		}
+		return
+	}
+
+	h.SeenAt = later.SeenAt
+	h.SourceIP = later.SourceIP
+	if later.ReportedStatus != "" {
+		h.ReportedStatus = later.ReportedStatus
+	}
+}
+
+// ApplyHeartbeat records a heartbeat on the device unless it has already been seen since
+func (d *Device) ApplyHeartbeat(heartbeat *DeviceHeartbeat) {
+	if d.LastSeenAt != nil && d.LastSeenAt.After(heartbeat.SeenAt) {
+		return
+	}
+
+	seenAt := heartbeat.SeenAt
+	d.LastSeenAt = &seenAt
+	d.LastSeenIP = heartbeat.SourceIP
+	if heartbeat.ReportedStatus != "" {
+		d.ReportedStatus = heartbeat.ReportedStatus
+	}
+}
+
+// SetOnline derives whether the device is online, that is whether it was last seen no longer
+// than timeout ago
+func (d *DeviceWithAssignment) SetOnline(now time.Time, timeout time.Duration) {
+	d.Online = d.LastSeenAt != nil && now.Sub(*d.LastSeenAt) <= timeout
+}
+
+// DevicePresenceRepository defines the interface for recording device heartbeats
+type DevicePresenceRepository interface {
+	// RecordHeartbeats stores the last sighting of each device in a single write. A heartbeat
+	// older than the device's stored last sighting is ignored.
+	RecordHeartbeats(heartbeats []*DeviceHeartbeat) error
+}
@@ -0,0 +1,90 @@
+package models
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+func TestNewDeviceHeartbeat(t *testing.T) {
+	deviceID := uuid.New()
+	seenAt := time.Date(2024, 5, 1, 12, 0, 0, 0, time.FixedZone("CEST", 2*60*60))
+
+	heartbeat, err := NewDeviceHeartbeat(deviceID, seenAt, "192.0.2.10", "  idle ")
+	if err != nil {
+		t.Fatalf("Failed to create heartbeat: %v", err)
+	}
+	if heartbeat.DeviceID != deviceID || !heartbeat.SeenAt.Equal(seenAt) || heartbeat.SeenAt.Location() != time.UTC ||
+		heartbeat.SourceIP != "192.0.2.10" || heartbeat.ReportedStatus != "idle" {
+		t.Errorf("Unexpected heartbeat %+v", heartbeat)
+	}
+
+	if _, err := NewDeviceHeartbeat(deviceID, seenAt, "192.0.2.10", ""); err != nil {
+		t.Errorf("Expected the reported status to be optional, got %v", err)
+	}
+	if _, err := NewDeviceHeartbeat(deviceID, seenAt, "192.0.2.10", strings.Repeat("s", maxReportedFieldLength+1)); err == nil {
+		t.Error("Expected an error for a reported status that is too long")
+	}
+	if _, err := NewDeviceHeartbeat(deviceID, seenAt, "192.0.2.10", "ok\x07"); err == nil {
+		t.Error("Expected an error for a reported status with control characters")
+	}
+}
+
+func TestDeviceHeartbeatMerge(t *testing.T) {
+	now := time.Now().UTC()
+	heartbeat := &DeviceHeartbeat{SeenAt: now, SourceIP: "192.0.2.1", ReportedStatus: "idle"}
+
+	heartbeat.Merge(&DeviceHeartbeat{SeenAt: now.Add(time.Minute), SourceIP: "192.0.2.2"})
+	if !heartbeat.SeenAt.Equal(now.Add(time.Minute)) || heartbeat.SourceIP != "192.0.2.2" || heartbeat.ReportedStatus != "idle" {
+		t.Errorf("Expected the later sighting to be kept with the earlier status, got %+v", heartbeat)
+	}
+
+	heartbeat.Merge(&DeviceHeartbeat{SeenAt: now, SourceIP: "192.0.2.3", ReportedStatus: "busy"})
+	if heartbeat.SourceIP != "192.0.2.2" || heartbeat.ReportedStatus != "idle" {
+		t.Errorf("Expected an earlier heartbeat not to replace a later one, got %+v", heartbeat)
+	}
+}
+
+func TestDeviceApplyHeartbeat(t *testing.T) {
+	device := NewDevice("01", "CN=Test CA", "Test CA", "AA")
+	now := time.Now().UTC()
+
+	device.ApplyHeartbeat(&DeviceHeartbeat{SeenAt: now, SourceIP: "192.0.2.1", ReportedStatus: "idle"})
+	if device.LastSeenAt == nil || !device.LastSeenAt.Equal(now) || device.LastSeenIP != "192.0.2.1" || device.ReportedStatus != "idle" {
+		t.Fatalf("Expected the heartbeat to be applied, got %+v", device)
+	}
+
+	device.ApplyHeartbeat(&DeviceHeartbeat{SeenAt: now.Add(-time.Minute), SourceIP: "192.0.2.2"})
+	if !device.LastSeenAt.Equal(now) || device.LastSeenIP != "192.0.2.1" {
+		t.Error("Expected an older heartbeat to be ignored")
+	}
+
+	device.ApplyHeartbeat(&DeviceHeartbeat{SeenAt: now.Add(time.Minute), SourceIP: "192.0.2.3"})
+	if device.LastSeenIP != "192.0.2.3" || device.ReportedStatus != "idle" {
+		t.Errorf("Expected a heartbeat without a status to keep the reported status, got %+v", device)
+	}
+}
+
+func TestDeviceSetOnline(t *testing.T) {
+	now := time.Now().UTC()
+	device := &DeviceWithAssignment{}
+
+	device.SetOnline(now, 5*time.Minute)
+	if device.Online {
+		t.Error("Expected a device that was never seen to be offline")
+	}
+
+	lastSeen := now.Add(-5 * time.Minute)
+	device.LastSeenAt = &lastSeen
+	device.SetOnline(now, 5*time.Minute)
+	if !device.Online {
+		t.Error("Expected a device seen exactly at the timeout to be online")
+	}
+
+	device.SetOnline(now.Add(time.Second), 5*time.Minute)
+	if device.Online {
+		t.Error("Expected a device seen longer ago than the timeout to be offline")
+	}
+}
@@ -0,0 +1,143 @@
+package models
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Device listing page sizes
+const (
+	DefaultDevicePageSize = 50
+	MaxDevicePageSize     = 200
+)
+
+// cursorTimeFormat is a fixed-width UTC timestamp, so that sort keys of times compare in
+// the same order as the times themselves
+const cursorTimeFormat = "2006-01-02T15:04:05.000000000Z"
+
+// DeviceSort is a field device listings can be ordered by
+type DeviceSort string
+
+// Device listing sort fields. Ties are broken by device ID.
+const (
+	DeviceSortCreatedAt DeviceSort = "created_at"
+	// DeviceSortLastSeenAt orders devices that never sent a heartbeat as if last seen at the Unix epoch
+	DeviceSortLastSeenAt DeviceSort = "last_seen_at"
+	DeviceSortName       DeviceSort = "name"
+)
+
+// ParseDeviceSort parses a sort field, optionally prefixed with "-" for descending order
+func ParseDeviceSort(value string) (DeviceSort, bool, error) {
+	field, descending := strings.CutPrefix(value, "-")
+
+	switch sort := DeviceSort(field); sort {
+	case DeviceSortCreatedAt, DeviceSortLastSeenAt, DeviceSortName:
+		return sort, descending, nil
+	default:
+		return "", false, fmt.Errorf("sort must be one of created_at, last_seen_at or name, optionally prefixed with -")
+	}
+}
+
+// Key returns the value the device is ordered by, in a form that compares like the value
+func (s DeviceSort) Key(device *Device) string {
+	switch s {
+	case DeviceSortName:
+		return device.Name
+	case DeviceSortLastSeenAt:
+		if device.LastSeenAt == nil {
+			return time.Unix(0, 0).UTC().Format(cursorTimeFormat)
+		}
+		return device.LastSeenAt.UTC().Format(cursorTimeFormat)
+	default:
+		return device.CreatedAt.UTC().Format(cursorTimeFormat)
+	}
+}
+
+// DeviceCursor marks the last device of a page; the next page starts after it in the same order
+type DeviceCursor struct {
+	Sort       DeviceSort `json:"s"`
+	Descending bool       `json:"d,omitempty"`
+	Key        string     `json:"k"`
+	ID         uuid.UUID  `json:"id"`
+}
+
+// NewDeviceCursor creates the cursor continuing a listing in the given order after the device
+func NewDeviceCursor(sort DeviceSort, descending bool, device *Device) *DeviceCursor {
+	return &DeviceCursor{
+		Sort:       sort,
+		Descending: descending,
+		Key:        sort.Key(device),
+		ID:         device.ID,
+	}
+}
+
+// Encode returns the opaque form of the cursor handed to clients
+func (c *DeviceCursor) Encode() string {
+	data, _ := json.Marshal(c)
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+// ParseDeviceCursor decodes a cursor, which must have been issued for the same order
+func ParseDeviceCursor(value string, sort DeviceSort, descending bool) (*DeviceCursor, error) {
+	data, err := base64.RawURLEncoding.DecodeString(value)
+	if err != nil {
+		return nil, fmt.Errorf("invalid cursor")
+	}
+
+	var cursor DeviceCursor
+	if err := json.Unmarshal(data, &cursor); err != nil || cursor.ID == uuid.Nil {
+		return nil, fmt.Errorf("invalid cursor")
+	}
+
+	if cursor.Sort != sort || cursor.Descending != descending {
+		return nil, fmt.Errorf("cursor does not match the requested sort")
+	}
+
+	return &cursor, nil
+}
+
+// DeviceQuery selects, orders and pages a device listing. Zero-valued filters match every
+// device; time ranges are exclusive.
+type DeviceQuery struct {
+	// UserID restricts the listing to devices currently assigned to the user
+	UserID   string
+	Assigned *bool
+	// Issuer matches either the certificate issuer DN or CN
+	Issuer         string
+	Statuses       []DeviceStatus
+	Labels         Labels
+	CreatedAfter   *time.Time
+	CreatedBefore  *time.Time
+	LastSeenAfter  *time.Time
+	LastSeenBefore *time.Time
+
+	Sort       DeviceSort
+	Descending bool
+	Limit      int
+	After      *DeviceCursor
+
+	// IncludeTotal requests the number of devices matching the filters across every page
+	IncludeTotal bool
+}
+
+// NewDeviceQuery creates a query for the first page of every device, newest first
+func NewDeviceQuery() *DeviceQuery {
+	return &DeviceQuery{
+		Sort:       DeviceSortCreatedAt,
+		Descending: true,
+		Limit:      DefaultDevicePageSize,
+	}
+}
+
+// DevicePage is one page of a device listing. NextCursor is empty on the last page.
+type DevicePage struct {
+	Devices    []*DeviceWithAssignment `json:"devices"`
+	Count      int                     `json:"count"`
+	NextCursor string                  `json:"next_cursor,omitempty"`
+	TotalCount *int                    `json:"total_count,omitempty"`
+}
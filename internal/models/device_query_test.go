@@ -0,0 +1,78 @@
+package models
+
+import (
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+func TestParseDeviceSort(t *testing.T) {
+	tests := []struct {
+		value      string
+		sort       DeviceSort
+		descending bool
+		valid      bool
+	}{
+		{"created_at", DeviceSortCreatedAt, false, true},
+		{"-created_at", DeviceSortCreatedAt, true, true},
+		{"-last_seen_at", DeviceSortLastSeenAt, true, true},
+		{"name", DeviceSortName, false, true},
+		{"serial", "", false, false},
+		{"--name", "", false, false},
+		{"", "", false, false},
+	}
+
+	for _, tt := range tests {
+		sort, descending, err := ParseDeviceSort(tt.value)
+		if (err == nil) != tt.valid || sort != tt.sort || descending != tt.descending {
+			t.Errorf("ParseDeviceSort(%q) = %q, %v, %v", tt.value, sort, descending, err)
+		}
+	}
+}
+
+func TestDeviceSortKey(t *testing.T) {
+	earlier := &Device{CreatedAt: time.Date(2024, 1, 1, 9, 0, 0, 0, time.UTC)}
+	later := &Device{CreatedAt: time.Date(2024, 1, 1, 9, 0, 0, 500, time.UTC)}
+	if DeviceSortCreatedAt.Key(earlier) >= DeviceSortCreatedAt.Key(later) {
+		t.Errorf("Expected creation time keys to compare like the times, got %q and %q",
+			DeviceSortCreatedAt.Key(earlier), DeviceSortCreatedAt.Key(later))
+	}
+
+	seen := time.Date(2024, 1, 1, 9, 0, 0, 0, time.UTC)
+	if DeviceSortLastSeenAt.Key(&Device{}) >= DeviceSortLastSeenAt.Key(&Device{LastSeenAt: &seen}) {
+		t.Error("Expected devices that were never seen to sort before seen devices")
+	}
+
+	if DeviceSortName.Key(&Device{Name: "printer"}) != "printer" {
+		t.Error("Expected the name to be the name sort key")
+	}
+}
+
+func TestDeviceCursor(t *testing.T) {
+	device := NewDevice("01", "CN=Test CA", "Test CA", "AA")
+	device.Name = "Lab printer"
+
+	encoded := NewDeviceCursor(DeviceSortName, true, device).Encode()
+
+	cursor, err := ParseDeviceCursor(encoded, DeviceSortName, true)
+	if err != nil {
+		t.Fatalf("Failed to parse cursor: %v", err)
+	}
+	if cursor.Key != "Lab printer" || cursor.ID != device.ID {
+		t.Errorf("Unexpected cursor %+v", cursor)
+	}
+
+	if _, err := ParseDeviceCursor(encoded, DeviceSortName, false); err == nil {
+		t.Error("Expected an error for a cursor issued for another order")
+	}
+	if _, err := ParseDeviceCursor(encoded, DeviceSortCreatedAt, true); err == nil {
+		t.Error("Expected an error for a cursor issued for another sort field")
+	}
+	if _, err := ParseDeviceCursor("not a cursor", DeviceSortName, true); err == nil {
+		t.Error("Expected an error for a malformed cursor")
+	}
+	if _, err := ParseDeviceCursor((&DeviceCursor{Sort: DeviceSortName, ID: uuid.Nil}).Encode(), DeviceSortName, false); err == nil {
+		t.Error("Expected an error for a cursor without a device ID")
+	}
+}
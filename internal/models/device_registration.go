@@ -0,0 +1,152 @@
+package models
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// RegistrationMode decides which unknown devices may register when they first authenticate
+type RegistrationMode string
+
+// Device registration modes
+const (
+	// RegistrationModeOpen registers every device with a certificate from a trusted CA
+	RegistrationModeOpen RegistrationMode = "open"
+	// RegistrationModeAllowlist only registers devices whose certificate was allowlisted
+	RegistrationModeAllowlist RegistrationMode = "allowlist"
+	// RegistrationModeApproval registers unknown devices as pending until an administrator
+	// approves or rejects them
+	RegistrationModeApproval RegistrationMode = "approval"
+)
+
+// ParseRegistrationMode parses a device registration mode
+func ParseRegistrationMode(value string) (RegistrationMode, error) {
+	switch mode := RegistrationMode(value); mode {
+	case RegistrationModeOpen, RegistrationModeAllowlist, RegistrationModeApproval:
+		return mode, nil
+	default:
+		return "", fmt.Errorf("unknown registration mode %q", value)
+	}
+}
+
+// maxAllowlistNoteLength bounds the note recorded with an allowlist entry
+const maxAllowlistNoteLength = 200
+
+var (
+	// serialNumberPattern matches certificate serial numbers in hex, as formatted by auth.CertificateInfo
+	serialNumberPattern = regexp.MustCompile(`^[0-9A-F]{1,40}$`)
+	// fingerprintPattern matches lowercase hex SHA-256 certificate and public key fingerprints
+	fingerprintPattern = regexp.MustCompile(`^[0-9a-f]{64}$`)
+)
+
+// AllowlistEntry admits the registration of a device by its certificate serial number,
+// optionally limited to one issuer DN, by the SHA-256 fingerprint of its certificate, or by
+// the SHA-256 fingerprint of its public key. Only public key entries can admit an EST
+// enrollment, since they are known before the certificate is issued.
+type AllowlistEntry struct {
+	ID              uuid.UUID `json:"id" db:"id"`
+	SerialNumber    string    `json:"serial_number,omitempty" db:"serial_number"`
+	IssuerDN        string    `json:"issuer_dn,omitempty" db:"issuer_dn"`
+	Fingerprint     string    `json:"fingerprint,omitempty" db:"fingerprint"`
+	SPKIFingerprint string    `json:"spki_fingerprint,omitempty" db:"spki_fingerprint"`
+	Note            string    `json:"note,omitempty" db:"note"`
+	CreatedBy       string    `json:"created_by" db:"created_by"`
+	CreatedAt       time.Time `json:"created_at" db:"created_at"`
+}
+
+// NewAllowlistEntry validates an allowlist entry imported by createdBy. Serial numbers and
+// fingerprints may be given in either case and with colon separators; they are stored in the
+// form certificates are matched in.
+func NewAllowlistEntry(serialNumber, issuerDN, fingerprint, spkiFingerprint, note, createdBy string) (*AllowlistEntry, error) {
+	serialNumber = normalizeSerialNumber(serialNumber)
+	fingerprint = normalizeFingerprint(fingerprint)
+	spkiFingerprint = normalizeFingerprint(spkiFingerprint)
+	issuerDN = strings.TrimSpace(issuerDN)
+	note = strings.TrimSpace(note)
+
+	if serialNumber == "" && fingerprint == "" && spkiFingerprint == "" {
+		return nil, fmt.Errorf("serial_number, fingerprint or spki_fingerprint is required")
+	}
+
+	if serialNumber != "" && !serialNumberPattern.MatchString(serialNumber) {
+		return nil, fmt.Errorf("serial_number must be a hexadecimal certificate serial number")
+	}
+
+	if fingerprint != "" && !fingerprintPattern.MatchString(fingerprint) {
+		return nil, fmt.Errorf("fingerprint must be a hexadecimal SHA-256 digest")
+	}
+
+	if spkiFingerprint != "" && !fingerprintPattern.MatchString(spkiFingerprint) {
+		return nil, fmt.Errorf("spki_fingerprint must be a hexadecimal SHA-256 digest")
+	}
+
+	if issuerDN != "" && serialNumber == "" {
+		return nil, fmt.Errorf("issuer_dn is only allowed together with serial_number")
+	}
+
+	if err := validateText(note, maxAllowlistNoteLength); err != nil {
+		return nil, fmt.Errorf("note %w", err)
+	}
+
+	return &AllowlistEntry{
+		ID:              uuid.New(),
+		SerialNumber:    serialNumber,
+		IssuerDN:        issuerDN,
+		Fingerprint:     fingerprint,
+		SPKIFingerprint: spkiFingerprint,
+		Note:            note,
+		CreatedBy:       createdBy,
+		CreatedAt:       time.Now().UTC(),
+	}, nil
+}
+
+// Matches reports whether the entry admits a certificate with the issuer DN, serial number,
+// fingerprint and public key fingerprint
+func (e *AllowlistEntry) Matches(issuerDN, serialNumber, fingerprint, spkiFingerprint string) bool {
+	if e.Fingerprint != "" && e.Fingerprint == fingerprint {
+		return true
+	}
+	if e.SPKIFingerprint != "" && e.SPKIFingerprint == spkiFingerprint {
+		return true
+	}
+	return e.SerialNumber != "" && e.SerialNumber == serialNumber && (e.IssuerDN == "" || e.IssuerDN == issuerDN)
+}
+
+// normalizeFingerprint converts a fingerprint to lowercase hex without separators
+func normalizeFingerprint(fingerprint string) string {
+	return strings.ToLower(strings.ReplaceAll(strings.TrimSpace(fingerprint), ":", ""))
+}
+
+// normalizeSerialNumber converts a serial number to uppercase hex without separators or
+// leading zeros
+func normalizeSerialNumber(serialNumber string) string {
+	serialNumber = strings.ToUpper(strings.ReplaceAll(strings.TrimSpace(serialNumber), ":", ""))
+	if serialNumber == "" {
+		return ""
+	}
+	if trimmed := strings.TrimLeft(serialNumber, "0"); trimmed != "" {
+		return trimmed
+	}
+	return "0"
+}
+
+// DeviceAllowlistRepository defines the interface for device allowlist operations
+type DeviceAllowlistRepository interface {
+	// CreateAllowlistEntries stores the entries, skipping any that duplicate an existing entry,
+	// and reports how many were added
+	CreateAllowlistEntries(entries []*AllowlistEntry) (int, error)
+
+	// ListAllowlistEntries retrieves every allowlist entry, newest first
+	ListAllowlistEntries() ([]*AllowlistEntry, error)
+
+	// DeleteAllowlistEntry removes an allowlist entry
+	DeleteAllowlistEntry(id uuid.UUID) error
+
+	// IsAllowlisted reports whether any entry matches a certificate with the issuer DN, serial
+	// number, fingerprint and public key fingerprint
+	IsAllowlisted(issuerDN, serialNumber, fingerprint, spkiFingerprint string) (bool, error)
+}
@@ -0,0 +1,109 @@
+package models
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestParseRegistrationMode(t *testing.T) {
+	for _, value := range []string{"open", "allowlist", "approval"} {
+		if mode, err := ParseRegistrationMode(value); err != nil || string(mode) != value {
+			t.Errorf("Expected %q to parse, got %q, %v", value, mode, err)
+		}
+	}
+
+	for _, value := range []string{"", "Open", "closed"} {
+		if _, err := ParseRegistrationMode(value); err == nil {
+			t.Errorf("Expected an error for %q", value)
+		}
+	}
+}
+
+func TestNewAllowlistEntry(t *testing.T) {
+	fingerprint := strings.Repeat("ab", 32)
+
+	entry, err := NewAllowlistEntry(" 00:0a:1b ", " CN=Test CA ", "", "", " Batch 7 ", "admin")
+	if err != nil {
+		t.Fatalf("Failed to create entry: %v", err)
+	}
+	if entry.SerialNumber != "A1B" || entry.IssuerDN != "CN=Test CA" || entry.Note != "Batch 7" || entry.CreatedBy != "admin" {
+		t.Errorf("Unexpected entry %+v", entry)
+	}
+
+	entry, err = NewAllowlistEntry("", "", strings.ToUpper(fingerprint[:2])+":"+fingerprint[2:], "", "", "admin")
+	if err != nil {
+		t.Fatalf("Failed to create entry: %v", err)
+	}
+	if entry.Fingerprint != fingerprint {
+		t.Errorf("Expected fingerprint %s, got %s", fingerprint, entry.Fingerprint)
+	}
+
+	entry, err = NewAllowlistEntry("", "", "", strings.ToUpper(fingerprint[:2])+":"+fingerprint[2:], "", "admin")
+	if err != nil {
+		t.Fatalf("Failed to create entry: %v", err)
+	}
+	if entry.SPKIFingerprint != fingerprint || entry.Fingerprint != "" {
+		t.Errorf("Expected public key fingerprint %s, got %+v", fingerprint, entry)
+	}
+
+	if entry, err := NewAllowlistEntry("000", "", "", "", "", "admin"); err != nil || entry.SerialNumber != "0" {
+		t.Errorf("Expected a zero serial number to be kept, got %+v, %v", entry, err)
+	}
+
+	tests := []struct {
+		name                                                       string
+		serialNumber, issuerDN, fingerprint, spkiFingerprint, note string
+	}{
+		{"nothing to match", "", "", "", "", ""},
+		{"serial number not hex", "12G4", "", "", "", ""},
+		{"serial number too long", strings.Repeat("1", 41), "", "", "", ""},
+		{"fingerprint too short", "", "", "abcd", "", ""},
+		{"public key fingerprint not hex", "", "", "", strings.Repeat("xy", 32), ""},
+		{"issuer without serial number", "", "CN=Test CA", fingerprint, "", ""},
+		{"issuer with only a public key fingerprint", "", "CN=Test CA", "", fingerprint, ""},
+		{"note too long", "1234", "", "", "", strings.Repeat("n", maxAllowlistNoteLength+1)},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := NewAllowlistEntry(tt.serialNumber, tt.issuerDN, tt.fingerprint, tt.spkiFingerprint, tt.note, "admin"); err == nil {
+				t.Error("Expected an error")
+			}
+		})
+	}
+}
+
+func TestAllowlistEntryMatches(t *testing.T) {
+	fingerprint := strings.Repeat("ab", 32)
+
+	anyIssuer := &AllowlistEntry{SerialNumber: "1234"}
+	oneIssuer := &AllowlistEntry{SerialNumber: "1234", IssuerDN: "CN=Test CA"}
+	byFingerprint := &AllowlistEntry{Fingerprint: fingerprint}
+	byPublicKey := &AllowlistEntry{SPKIFingerprint: fingerprint}
+
+	tests := []struct {
+		name                                                 string
+		entry                                                *AllowlistEntry
+		issuerDN, serialNumber, fingerprint, spkiFingerprint string
+		matches                                              bool
+	}{
+		{"serial number from any issuer", anyIssuer, "CN=Other CA", "1234", "", "", true},
+		{"other serial number", anyIssuer, "CN=Test CA", "5678", "", "", false},
+		{"serial number from the issuer", oneIssuer, "CN=Test CA", "1234", "", "", true},
+		{"serial number from another issuer", oneIssuer, "CN=Other CA", "1234", "", "", false},
+		{"fingerprint", byFingerprint, "CN=Test CA", "1234", fingerprint, "", true},
+		{"other fingerprint", byFingerprint, "CN=Test CA", "1234", strings.Repeat("cd", 32), "", false},
+		{"empty fingerprint", byFingerprint, "CN=Test CA", "1234", "", "", false},
+		{"public key fingerprint", byPublicKey, "", "", "", fingerprint, true},
+		{"public key fingerprint given as certificate fingerprint", byPublicKey, "", "", fingerprint, "", false},
+		{"other public key fingerprint", byPublicKey, "", "", "", strings.Repeat("cd", 32), false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.entry.Matches(tt.issuerDN, tt.serialNumber, tt.fingerprint, tt.spkiFingerprint); got != tt.matches {
+				t.Errorf("Expected match %v, got %v", tt.matches, got)
+			}
+		})
+	}
+}
@@ -0,0 +1,117 @@
+package models
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// DeviceStatus is the lifecycle state of a device
+type DeviceStatus string
+
+// Device lifecycle states
+const (
+	// DeviceStatusPending is an unknown device awaiting registration approval; it may
+	// authenticate but not be assigned
+	DeviceStatusPending DeviceStatus = "pending"
+	// DeviceStatusRejected is a device whose registration an administrator rejected
+	DeviceStatusRejected DeviceStatus = "rejected"
+	// DeviceStatusProvisioned is a registered device waiting to be activated by an administrator
+	DeviceStatusProvisioned DeviceStatus = "provisioned"
+	// DeviceStatusActive is a device in service, which may be assigned to users
+	DeviceStatusActive DeviceStatus = "active"
+	// DeviceStatusSuspended is a device temporarily barred from authenticating
+	DeviceStatusSuspended DeviceStatus = "suspended"
+	// DeviceStatusLost is a device reported missing; it may still authenticate so it can be located
+	DeviceStatusLost DeviceStatus = "lost"
+	// DeviceStatusDecommissioned is a device permanently retired from service
+	DeviceStatusDecommissioned DeviceStatus = "decommissioned"
+)
+
+// deviceStatusTransitions lists the states each state may move to. Decommissioning is final
+// and possible from every other state, while a rejected registration may still be approved.
+var deviceStatusTransitions = map[DeviceStatus][]DeviceStatus{
+	DeviceStatusPending:     {DeviceStatusActive, DeviceStatusRejected, DeviceStatusDecommissioned},
+	DeviceStatusRejected:    {DeviceStatusActive, DeviceStatusDecommissioned},
+	DeviceStatusProvisioned: {DeviceStatusActive, DeviceStatusSuspended, DeviceStatusDecommissioned},
+	DeviceStatusActive:      {DeviceStatusSuspended, DeviceStatusLost, DeviceStatusDecommissioned},
+	DeviceStatusSuspended:   {DeviceStatusActive, DeviceStatusLost, DeviceStatusDecommissioned},
+	DeviceStatusLost:        {DeviceStatusActive, DeviceStatusSuspended, DeviceStatusDecommissioned},
+}
+
+// maxStatusReasonLength bounds the reason recorded with a status transition
+const maxStatusReasonLength = 500
+
+// ParseDeviceStatus parses a device lifecycle state
+func ParseDeviceStatus(value string) (DeviceStatus, error) {
+	status := DeviceStatus(value)
+	if _, ok := deviceStatusTransitions[status]; !ok && status != DeviceStatusDecommissioned {
+		return "", fmt.Errorf("unknown device status %q", value)
+	}
+	return status, nil
+}
+
+// CanTransitionTo reports whether a device may move from this state to the next
+func (s DeviceStatus) CanTransitionTo(next DeviceStatus) bool {
+	for _, allowed := range deviceStatusTransitions[s] {
+		if allowed == next {
+			return true
+		}
+	}
+	return false
+}
+
+// CanAuthenticate reports whether a device in this state may authenticate
+func (s DeviceStatus) CanAuthenticate() bool {
+	return s != DeviceStatusSuspended && s != DeviceStatusDecommissioned && s != DeviceStatusRejected
+}
+
+// DeviceStatusTransition records a change of a device's lifecycle state, who made it and why
+type DeviceStatusTransition struct {
+	ID         uuid.UUID    `json:"id" db:"id"`
+	DeviceID   uuid.UUID    `json:"device_id" db:"device_id"`
+	FromStatus DeviceStatus `json:"from_status" db:"from_status"`
+	ToStatus   DeviceStatus `json:"to_status" db:"to_status"`
+	ChangedBy  string       `json:"changed_by" db:"changed_by"`
+	Reason     string       `json:"reason" db:"reason"`
+	CreatedAt  time.Time    `json:"created_at" db:"created_at"`
+}
+
+// NewDeviceStatusTransition validates a transition of the device to the next state, made by
+// changedBy for the given reason
+func NewDeviceStatusTransition(device *Device, next DeviceStatus, changedBy, reason string) (*DeviceStatusTransition, error) {
+	if !device.Status.CanTransitionTo(next) {
+		return nil, fmt.Errorf("cannot change device status from %s to %s", device.Status, next)
+	}
+
+	if reason == "" {
+		return nil, fmt.Errorf("reason is required")
+	}
+
+	if err := validateText(reason, maxStatusReasonLength); err != nil {
+		return nil, fmt.Errorf("reason %w", err)
+	}
+
+	return &DeviceStatusTransition{
+		ID:         uuid.New(),
+		DeviceID:   device.ID,
+		FromStatus: device.Status,
+		ToStatus:   next,
+		ChangedBy:  changedBy,
+		Reason:     reason,
+		CreatedAt:  time.Now().UTC(),
+	}, nil
+}
+
+// DeviceStatusRepository defines the interface for device lifecycle operations
+type DeviceStatusRepository interface {
+	// TransitionDeviceStatus moves the device to the transition's target state and records the
+	// transition. It fails with "device status changed concurrently" when the device is no
+	// longer in the transition's starting state. Decommissioning also ends the device's active
+	// assignment, atomically with the status change.
+	TransitionDeviceStatus(transition *DeviceStatusTransition) error
+
+	// GetStatusTransitionsByDeviceID retrieves every status transition of a device, newest first
+	GetStatusTransitionsByDeviceID(deviceID uuid.UUID) ([]*DeviceStatusTransition, error)
+}
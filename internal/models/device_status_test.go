@@ -0,0 +1,92 @@
+package models
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestDeviceStatusTransitions(t *testing.T) {
+	tests := []struct {
+		from, to DeviceStatus
+		allowed  bool
+	}{
+		{DeviceStatusProvisioned, DeviceStatusActive, true},
+		{DeviceStatusProvisioned, DeviceStatusLost, false},
+		{DeviceStatusActive, DeviceStatusSuspended, true},
+		{DeviceStatusActive, DeviceStatusLost, true},
+		{DeviceStatusActive, DeviceStatusProvisioned, false},
+		{DeviceStatusActive, DeviceStatusActive, false},
+		{DeviceStatusSuspended, DeviceStatusActive, true},
+		{DeviceStatusLost, DeviceStatusActive, true},
+		{DeviceStatusLost, DeviceStatusDecommissioned, true},
+		{DeviceStatusDecommissioned, DeviceStatusActive, false},
+		{DeviceStatusDecommissioned, DeviceStatusProvisioned, false},
+		{DeviceStatusPending, DeviceStatusActive, true},
+		{DeviceStatusPending, DeviceStatusRejected, true},
+		{DeviceStatusPending, DeviceStatusSuspended, false},
+		{DeviceStatusPending, DeviceStatusDecommissioned, true},
+		{DeviceStatusRejected, DeviceStatusActive, true},
+		{DeviceStatusRejected, DeviceStatusDecommissioned, true},
+		{DeviceStatusRejected, DeviceStatusPending, false},
+		{DeviceStatusActive, DeviceStatusPending, false},
+		{DeviceStatusDecommissioned, DeviceStatusPending, false},
+		{DeviceStatusDecommissioned, DeviceStatusRejected, false},
+	}
+
+	for _, tt := range tests {
+		if got := tt.from.CanTransitionTo(tt.to); got != tt.allowed {
+			t.Errorf("Expected %s -> %s allowed to be %v, got %v", tt.from, tt.to, tt.allowed, got)
+		}
+	}
+
+	for _, status := range []DeviceStatus{DeviceStatusProvisioned, DeviceStatusActive, DeviceStatusLost, DeviceStatusPending} {
+		if !status.CanAuthenticate() {
+			t.Errorf("Expected %s devices to authenticate", status)
+		}
+	}
+	for _, status := range []DeviceStatus{DeviceStatusSuspended, DeviceStatusDecommissioned, DeviceStatusRejected} {
+		if status.CanAuthenticate() {
+			t.Errorf("Expected %s devices to be refused", status)
+		}
+	}
+}
+
+func TestParseDeviceStatus(t *testing.T) {
+	for _, value := range []string{"provisioned", "active", "suspended", "lost", "decommissioned", "pending", "rejected"} {
+		if status, err := ParseDeviceStatus(value); err != nil || string(status) != value {
+			t.Errorf("Expected %q to parse, got %q, %v", value, status, err)
+		}
+	}
+
+	for _, value := range []string{"", "Active", "retired"} {
+		if _, err := ParseDeviceStatus(value); err == nil {
+			t.Errorf("Expected an error for %q", value)
+		}
+	}
+}
+
+func TestNewDeviceStatusTransition(t *testing.T) {
+	device := NewDevice("01", "CN=Test CA", "Test CA", "AA")
+
+	transition, err := NewDeviceStatusTransition(device, DeviceStatusLost, "admin", "Left on a train")
+	if err != nil {
+		t.Fatalf("Failed to create transition: %v", err)
+	}
+	if transition.DeviceID != device.ID || transition.FromStatus != DeviceStatusActive || transition.ToStatus != DeviceStatusLost ||
+		transition.ChangedBy != "admin" || transition.Reason != "Left on a train" {
+		t.Errorf("Unexpected transition %+v", transition)
+	}
+	if device.Status != DeviceStatusActive {
+		t.Error("Expected the device to be left unchanged until the transition is stored")
+	}
+
+	if _, err := NewDeviceStatusTransition(device, DeviceStatusProvisioned, "admin", "Reset"); err == nil {
+		t.Error("Expected an error for a transition that is not allowed")
+	}
+	if _, err := NewDeviceStatusTransition(device, DeviceStatusLost, "admin", ""); err == nil {
+		t.Error("Expected an error without a reason")
+	}
+	if _, err := NewDeviceStatusTransition(device, DeviceStatusLost, "admin", strings.Repeat("r", maxStatusReasonLength+1)); err == nil {
+		t.Error("Expected an error for a reason that is too long")
+	}
+}
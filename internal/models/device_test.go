@@ -9,9 +9,11 @@ import (
 
 func TestNewDevice(t *testing.T) {
 	serialNumber := "ABC123456789"
+	issuerDN := "CN=Test CA,O=Example"
 	issuerCN := "Test CA"
+	authorityKeyID := "0A1B2C"
 
-	device := NewDevice(serialNumber, issuerCN)
+	device := NewDevice(serialNumber, issuerDN, issuerCN, authorityKeyID)
 
 	if device.ID == uuid.Nil {
 		t.Error("Expected device ID to be generated, got nil UUID")
@@ -21,10 +23,18 @@ func TestNewDevice(t *testing.T) {
 		t.Errorf("Expected serial number %s, got %s", serialNumber, device.CertificateSerialNumber)
 	}
 
+	if device.CertificateIssuerDN != issuerDN {
+		t.Errorf("Expected issuer DN %s, got %s", issuerDN, device.CertificateIssuerDN)
+	}
+
 	if device.CertificateIssuerCN != issuerCN {
 		t.Errorf("Expected issuer CN %s, got %s", issuerCN, device.CertificateIssuerCN)
 	}
 
+	if device.CertificateAuthorityKeyID != authorityKeyID {
+		t.Errorf("Expected authority key ID %s, got %s", authorityKeyID, device.CertificateAuthorityKeyID)
+	}
+
 	if device.CreatedAt.IsZero() {
 		t.Error("Expected CreatedAt to be set, got zero time")
 	}
@@ -34,3 +44,84 @@ func TestNewDevice(t *testing.T) {
 		t.Error("Expected CreatedAt to be recent")
 	}
 }
+
+func TestDeviceApplyCertificate(t *testing.T) {
+	device := NewDevice("01", "CN=Test CA", "Test CA", "AA")
+
+	if !device.ApplyCertificate("01", "CN=Test CA", "Test CA", "AA", "spki:abc", "") {
+		t.Error("Expected a change when an identity key is first bound")
+	}
+
+	if device.ApplyCertificate("01", "CN=Test CA", "Test CA", "AA", "spki:abc", "") {
+		t.Error("Expected no change when the same certificate is applied")
+	}
+
+	if !device.ApplyCertificate("02", "CN=Test CA", "Test CA", "AA", "spki:abc", "") {
+		t.Error("Expected a change when a renewed certificate is applied")
+	}
+
+	if device.CertificateSerialNumber != "02" {
+		t.Errorf("Expected serial number 02, got %s", device.CertificateSerialNumber)
+	}
+
+	if !device.ApplyCertificate("02", "CN=Test CA", "Test CA", "AA", "spki:abc", "spiffe://example.org/device/1") {
+		t.Error("Expected a change when a SPIFFE ID is first recorded")
+	}
+}
+
+func TestDeviceCertificateValidity(t *testing.T) {
+	device := NewDevice("01", "CN=Test CA", "Test CA", "AA")
+	now := time.Date(2026, 1, 1, 12, 0, 0, 0, time.UTC)
+
+	device.SetCertificateExpiresIn(now)
+	if device.CertificateExpiresInDays != nil {
+		t.Error("Expected no expiry for a device without a recorded validity period")
+	}
+
+	notBefore, notAfter := now.Add(-24*time.Hour), now.Add(10*24*time.Hour+time.Hour)
+	if !device.ApplyCertificateValidity(notBefore, notAfter) {
+		t.Error("Expected a change when the validity period is first recorded")
+	}
+
+	if device.ApplyCertificateValidity(notBefore, notAfter) {
+		t.Error("Expected no change when the same validity period is applied")
+	}
+
+	tests := []struct {
+		now  time.Time
+		want int
+	}{
+		{now, 10},
+		{notAfter.Add(-time.Hour), 0},
+		{notAfter.Add(time.Hour), -1},
+	}
+
+	for _, tt := range tests {
+		device.SetCertificateExpiresIn(tt.now)
+		if device.CertificateExpiresInDays == nil || *device.CertificateExpiresInDays != tt.want {
+			t.Errorf("Expected %d days left at %s, got %v", tt.want, tt.now, device.CertificateExpiresInDays)
+		}
+	}
+
+	if !device.ApplyCertificateValidity(notAfter, notAfter.Add(365*24*time.Hour)) {
+		t.Error("Expected a change when a renewed certificate's validity is applied")
+	}
+}
+
+func TestDeviceWithAssignmentClaimableView(t *testing.T) {
+	device := &DeviceWithAssignment{Device: *NewDevice("01", "CN=Test CA", "Test CA", "AA")}
+
+	view := device.ClaimableView()
+
+	if view.ID != device.ID {
+		t.Errorf("Expected device ID %s, got %s", device.ID, view.ID)
+	}
+
+	if view.IsAssigned {
+		t.Error("Expected claimable view of an unassigned device")
+	}
+
+	if !view.CreatedAt.Equal(device.CreatedAt) {
+		t.Errorf("Expected CreatedAt %v, got %v", device.CreatedAt, view.CreatedAt)
+	}
+}
@@ -0,0 +1,99 @@
+package models
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Session represents a login session: a family of rotating refresh tokens issued to one
+// client. Access tokens issued in a session carry its ID, so revoking the session also
+// invalidates them. A session bound to a client certificate records its thumbprint.
+type Session struct {
+	ID                    uuid.UUID  `json:"id" db:"id"`
+	UserID                string     `json:"user_id" db:"user_id"`
+	UserAgent             string     `json:"user_agent" db:"user_agent"`
+	IPAddress             string     `json:"ip_address" db:"ip_address"`
+	CertificateThumbprint string     `json:"certificate_thumbprint,omitempty" db:"certificate_thumbprint"`
+	CreatedAt             time.Time  `json:"created_at" db:"created_at"`
+	LastUsedAt            time.Time  `json:"last_used_at" db:"last_used_at"`
+	ExpiresAt             time.Time  `json:"expires_at" db:"expires_at"`
+	RevokedAt             *time.Time `json:"revoked_at,omitempty" db:"revoked_at"`
+}
+
+// NewSession creates a new Session instance with a generated UUID
+func NewSession(userID, userAgent, ipAddress string, expiresAt time.Time) *Session {
+	now := time.Now().UTC()
+	return &Session{
+		ID:         uuid.New(),
+		UserID:     userID,
+		UserAgent:  userAgent,
+		IPAddress:  ipAddress,
+		CreatedAt:  now,
+		LastUsedAt: now,
+		ExpiresAt:  expiresAt.UTC(),
+	}
+}
+
+// IsActive returns true if the session has been neither revoked nor expired
+func (s *Session) IsActive() bool {
+	return s.RevokedAt == nil && time.Now().Before(s.ExpiresAt)
+}
+
+// RefreshToken is a single-use refresh token. Only the SHA-256 hash of the token is stored.
+type RefreshToken struct {
+	ID        uuid.UUID  `json:"id" db:"id"`
+	SessionID uuid.UUID  `json:"session_id" db:"session_id"`
+	TokenHash string     `json:"-" db:"token_hash"`
+	CreatedAt time.Time  `json:"created_at" db:"created_at"`
+	ExpiresAt time.Time  `json:"expires_at" db:"expires_at"`
+	UsedAt    *time.Time `json:"used_at,omitempty" db:"used_at"`
+}
+
+// NewRefreshToken creates a new RefreshToken instance with a generated UUID
+func NewRefreshToken(sessionID uuid.UUID, tokenHash string, expiresAt time.Time) *RefreshToken {
+	return &RefreshToken{
+		ID:        uuid.New(),
+		SessionID: sessionID,
+		TokenHash: tokenHash,
+		CreatedAt: time.Now().UTC(),
+		ExpiresAt: expiresAt.UTC(),
+	}
+}
+
+// SessionRepository defines the interface for session, refresh token and access token revocation operations
+type SessionRepository interface {
+	// CreateSession stores a new session in the database
+	CreateSession(session *Session) error
+
+	// GetSession retrieves a session by its UUID
+	GetSession(id uuid.UUID) (*Session, error)
+
+	// GetActiveSessionsByUserID retrieves a user's sessions that are neither revoked nor expired
+	GetActiveSessionsByUserID(userID string) ([]*Session, error)
+
+	// ExtendSession records that a session was used and moves its expiry
+	ExtendSession(id uuid.UUID, expiresAt time.Time) error
+
+	// RevokeSession marks a session as revoked
+	RevokeSession(id uuid.UUID) error
+
+	// RevokeUserSessions marks every active session of a user as revoked, except the session
+	// with exceptID
+	RevokeUserSessions(userID string, exceptID uuid.UUID) error
+
+	// CreateRefreshToken stores a new refresh token
+	CreateRefreshToken(token *RefreshToken) error
+
+	// GetRefreshTokenByHash retrieves a refresh token by the hash of its value
+	GetRefreshTokenByHash(tokenHash string) (*RefreshToken, error)
+
+	// MarkRefreshTokenUsed marks a refresh token as used, reporting false if it was already used
+	MarkRefreshTokenUsed(id uuid.UUID) (bool, error)
+
+	// RevokeAccessToken adds an access token ID (jti) to the denylist until the token expires
+	RevokeAccessToken(tokenID string, expiresAt time.Time) error
+
+	// IsAccessTokenRevoked checks whether an access token ID is denylisted
+	IsAccessTokenRevoked(tokenID string) (bool, error)
+}
@@ -0,0 +1,49 @@
+package models
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// User represents a locally managed user account that can log in with a password.
+// The user's ID is the user ID carried in issued tokens and recorded on assignments.
+type User struct {
+	ID                uuid.UUID `json:"id" db:"id"`
+	Username          string    `json:"username" db:"username"`
+	PasswordHash      string    `json:"-" db:"password_hash"`
+	Roles             []string  `json:"roles" db:"roles"`
+	CreatedAt         time.Time `json:"created_at" db:"created_at"`
+	PasswordChangedAt time.Time `json:"password_changed_at" db:"password_changed_at"`
+}
+
+// NewUser creates a new User instance with a generated UUID
+func NewUser(username, passwordHash string, roles []string) *User {
+	now := time.Now().UTC()
+	return &User{
+		ID:                uuid.New(),
+		Username:          username,
+		PasswordHash:      passwordHash,
+		Roles:             roles,
+		CreatedAt:         now,
+		PasswordChangedAt: now,
+	}
+}
+
+// UserRepository defines the interface for user account operations
+type UserRepository interface {
+	// CreateUser stores a new user in the database
+	CreateUser(user *User) error
+
+	// GetUserByID retrieves a user by its UUID
+	GetUserByID(id uuid.UUID) (*User, error)
+
+	// GetUserByUsername retrieves a user by username
+	GetUserByUsername(username string) (*User, error)
+
+	// UpdatePassword replaces a user's password hash
+	UpdatePassword(id uuid.UUID, passwordHash string) error
+
+	// CountUsers returns the number of user accounts
+	CountUsers() (int, error)
+}
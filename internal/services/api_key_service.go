@@ -0,0 +1,262 @@
+package services
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"strings"
+	"time"
+
+	"device-assignment-api/internal/models"
+	"device-assignment-api/pkg/auth"
+	"device-assignment-api/pkg/logger"
+
+	"github.com/google/uuid"
+)
+
+const (
+	// apiKeyBytes is the amount of randomness in an API key
+	apiKeyBytes = 32
+	// apiKeyDisplayChars is the number of random characters kept in a key's display prefix
+	apiKeyDisplayChars = 8
+	// maxAPIKeyNameLength bounds the length of an API key name
+	maxAPIKeyNameLength = 100
+	// defaultPersonalTokenDays is the lifetime of a personal access token without an explicit expiry
+	defaultPersonalTokenDays = 90
+	// maxPersonalTokenDays is the longest lifetime a personal access token may be given
+	maxPersonalTokenDays = 365
+	// serviceKeyUserPrefix prefixes the name of a service key to form the user ID it acts as
+	serviceKeyUserPrefix = "service:"
+)
+
+// personalTokenScopes are the scopes a personal access token may be granted. Personal tokens
+// act as an ordinary user; admin scopes are reserved for service keys.
+var personalTokenScopes = map[auth.Scope]bool{
+	auth.ScopeDevicesRead:      true,
+	auth.ScopeDevicesWrite:     true,
+	auth.ScopeAssignmentsWrite: true,
+}
+
+// CreatedAPIKey is returned when an API key is created. It is the only response that
+// contains the key itself.
+type CreatedAPIKey struct {
+	*models.APIKey
+	Key string `json:"key"`
+}
+
+// APIKeyService manages personal access tokens and service API keys, and validates them for
+// the authentication middleware. Personal access tokens act as the user who created them;
+// service keys act as an admin service account. Either is limited to its scopes.
+type APIKeyService struct {
+	apiKeyRepo models.APIKeyRepository
+	logger     logger.Logger
+}
+
+// NewAPIKeyService creates a new APIKeyService
+func NewAPIKeyService(apiKeyRepo models.APIKeyRepository, logger logger.Logger) *APIKeyService {
+	return &APIKeyService{
+		apiKeyRepo: apiKeyRepo,
+		logger:     logger,
+	}
+}
+
+// CreatePersonalToken creates a personal access token for a user. Without an explicit
+// expiry the token expires after 90 days.
+func (s *APIKeyService) CreatePersonalToken(userID, name string, scopes []string, expiresInDays int) (*CreatedAPIKey, error) {
+	for _, scope := range scopes {
+		if !personalTokenScopes[auth.Scope(scope)] {
+			return nil, fmt.Errorf("invalid scope")
+		}
+	}
+
+	if expiresInDays == 0 {
+		expiresInDays = defaultPersonalTokenDays
+	}
+	if expiresInDays > maxPersonalTokenDays {
+		return nil, fmt.Errorf("invalid expiry")
+	}
+
+	return s.create(models.APIKeyKindPersonal, auth.PersonalAccessTokenPrefix, name, userID, scopes, userID, expiresInDays)
+}
+
+// CreateServiceKey creates a service API key on behalf of an admin. Without an explicit
+// expiry the key does not expire.
+func (s *APIKeyService) CreateServiceKey(adminID, name string, scopes []string, expiresInDays int) (*CreatedAPIKey, error) {
+	for _, scope := range scopes {
+		if !auth.IsValidScope(scope) {
+			return nil, fmt.Errorf("invalid scope")
+		}
+	}
+
+	name = strings.TrimSpace(name)
+	return s.create(models.APIKeyKindService, auth.ServiceAPIKeyPrefix, name, serviceKeyUserPrefix+name, scopes, adminID, expiresInDays)
+}
+
+// ListPersonalTokens returns a user's unrevoked personal access tokens
+func (s *APIKeyService) ListPersonalTokens(userID string) ([]*models.APIKey, error) {
+	return s.list(models.APIKeyKindPersonal, userID)
+}
+
+// ListServiceKeys returns all unrevoked service API keys
+func (s *APIKeyService) ListServiceKeys() ([]*models.APIKey, error) {
+	return s.list(models.APIKeyKindService, "")
+}
+
+// RevokePersonalToken revokes one of a user's personal access tokens. Tokens of other users
+// are reported as not found.
+func (s *APIKeyService) RevokePersonalToken(userID, tokenID string) error {
+	return s.revoke(tokenID, func(key *models.APIKey) bool {
+		return key.Kind == models.APIKeyKindPersonal && key.UserID == userID
+	})
+}
+
+// RevokeServiceKey revokes a service API key
+func (s *APIKeyService) RevokeServiceKey(keyID string) error {
+	return s.revoke(keyID, func(key *models.APIKey) bool {
+		return key.Kind == models.APIKeyKindService
+	})
+}
+
+// ValidateAPICredential implements auth.APICredentialValidator
+func (s *APIKeyService) ValidateAPICredential(token string) (*auth.APICredential, error) {
+	var kind models.APIKeyKind
+	var roles []string
+	switch {
+	case strings.HasPrefix(token, auth.PersonalAccessTokenPrefix):
+		kind, roles = models.APIKeyKindPersonal, []string{string(auth.RoleUser)}
+	case strings.HasPrefix(token, auth.ServiceAPIKeyPrefix):
+		kind, roles = models.APIKeyKindService, []string{string(auth.RoleAdmin)}
+	default:
+		return nil, fmt.Errorf("invalid api key")
+	}
+
+	key, err := s.apiKeyRepo.GetAPIKeyByHash(hashAPIKey(token))
+	if err != nil {
+		if err.Error() == "api key not found" {
+			return nil, fmt.Errorf("invalid api key")
+		}
+		return nil, err
+	}
+
+	if key.Kind != kind || !key.IsActive() {
+		return nil, fmt.Errorf("invalid api key")
+	}
+
+	// Failing to record the use does not fail the request
+	if err := s.apiKeyRepo.TouchAPIKey(key.ID); err != nil {
+		s.logger.Error("Failed to record API key use", "key_id", key.ID, "error", err)
+	}
+
+	scopes := make([]auth.Scope, 0, len(key.Scopes))
+	for _, scope := range key.Scopes {
+		scopes = append(scopes, auth.Scope(scope))
+	}
+
+	return &auth.APICredential{
+		ID:     key.ID.String(),
+		UserID: key.UserID,
+		Roles:  roles,
+		Scopes: scopes,
+	}, nil
+}
+
+// create generates and stores a new API key
+func (s *APIKeyService) create(
+	kind models.APIKeyKind,
+	prefix, name, userID string,
+	scopes []string,
+	createdBy string,
+	expiresInDays int,
+) (*CreatedAPIKey, error) {
+	name = strings.TrimSpace(name)
+	if name == "" || len(name) > maxAPIKeyNameLength {
+		return nil, fmt.Errorf("invalid name")
+	}
+
+	if len(scopes) == 0 {
+		return nil, fmt.Errorf("invalid scope")
+	}
+
+	if expiresInDays < 0 {
+		return nil, fmt.Errorf("invalid expiry")
+	}
+
+	var expiresAt *time.Time
+	if expiresInDays > 0 {
+		expiry := time.Now().AddDate(0, 0, expiresInDays)
+		expiresAt = &expiry
+	}
+
+	key, err := generateAPIKey(prefix)
+	if err != nil {
+		s.logger.Error("Failed to generate API key", "error", err)
+		return nil, err
+	}
+
+	apiKey := models.NewAPIKey(kind, name, userID, key[:len(prefix)+apiKeyDisplayChars], hashAPIKey(key), scopes, createdBy, expiresAt)
+	if err := s.apiKeyRepo.CreateAPIKey(apiKey); err != nil {
+		if err.Error() == "api key name already exists" {
+			return nil, err
+		}
+		s.logger.Error("Failed to create API key", "kind", kind, "user_id", userID, "error", err)
+		return nil, fmt.Errorf("failed to create api key: %w", err)
+	}
+
+	s.logger.Info("API key created",
+		"key_id", apiKey.ID,
+		"kind", kind,
+		"user_id", userID,
+		"created_by", createdBy,
+		"scopes", scopes)
+
+	return &CreatedAPIKey{APIKey: apiKey, Key: key}, nil
+}
+
+// list returns the unrevoked keys of a kind
+func (s *APIKeyService) list(kind models.APIKeyKind, userID string) ([]*models.APIKey, error) {
+	keys, err := s.apiKeyRepo.ListAPIKeys(kind, userID)
+	if err != nil {
+		s.logger.Error("Failed to list API keys", "kind", kind, "user_id", userID, "error", err)
+		return nil, fmt.Errorf("failed to list api keys: %w", err)
+	}
+
+	return keys, nil
+}
+
+// revoke revokes an active key the caller may manage, reporting any other key as not found
+func (s *APIKeyService) revoke(keyID string, mayManage func(*models.APIKey) bool) error {
+	id, err := uuid.Parse(keyID)
+	if err != nil {
+		return fmt.Errorf("api key not found")
+	}
+
+	key, err := s.apiKeyRepo.GetAPIKey(id)
+	if err != nil || !mayManage(key) || key.RevokedAt != nil {
+		return fmt.Errorf("api key not found")
+	}
+
+	if err := s.apiKeyRepo.RevokeAPIKey(key.ID); err != nil {
+		s.logger.Error("Failed to revoke API key", "key_id", key.ID, "error", err)
+		return fmt.Errorf("failed to revoke api key: %w", err)
+	}
+
+	s.logger.Info("API key revoked", "key_id", key.ID, "kind", key.Kind, "user_id", key.UserID)
+	return nil
+}
+
+// generateAPIKey returns a random, URL-safe API key with the prefix of its kind
+func generateAPIKey(prefix string) (string, error) {
+	buf := make([]byte, apiKeyBytes)
+	if _, err := rand.Read(buf); err != nil {
+		return "", fmt.Errorf("failed to generate api key: %w", err)
+	}
+	return prefix + base64.RawURLEncoding.EncodeToString(buf), nil
+}
+
+// hashAPIKey returns the hex SHA-256 hash under which an API key is stored
+func hashAPIKey(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:])
+}
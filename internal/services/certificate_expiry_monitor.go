@@ -0,0 +1,162 @@
+package services
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"device-assignment-api/internal/models"
+	"device-assignment-api/pkg/logger"
+
+	"github.com/google/uuid"
+)
+
+// CertificateExpiryEvent reports a device certificate entering the expiry warning window or expiring
+type CertificateExpiryEvent struct {
+	DeviceID uuid.UUID
+	UserID   string
+	NotAfter time.Time
+	Expired  bool
+}
+
+// CertificateExpiryMonitor periodically reports device certificates in the expiry warning
+// window that it has not reported yet, and those that expired since its previous check. The
+// time of the previous check and the reported certificates are only kept in memory: after a
+// restart, and on every instance of a replicated deployment, the first check reports the
+// whole window again and does not report certificates that expired while the monitor was not
+// running.
+type CertificateExpiryMonitor struct {
+	deviceRepo models.DeviceRepository
+	window     time.Duration
+	interval   time.Duration
+	logger     logger.Logger
+
+	mu        sync.Mutex
+	lastCheck time.Time
+	// warned holds the expiry of the certificate reported for each device in the window, so
+	// that a certificate is reported once however it entered the window: by time passing, or
+	// by a device registering or renewing with a certificate that is already close to expiry
+	warned map[uuid.UUID]time.Time
+
+	stop     chan struct{}
+	stopOnce sync.Once
+}
+
+// NewCertificateExpiryMonitor creates a monitor warning about certificates that expire within
+// the window, checking every interval
+func NewCertificateExpiryMonitor(deviceRepo models.DeviceRepository, window, interval time.Duration, logger logger.Logger) *CertificateExpiryMonitor {
+	return &CertificateExpiryMonitor{
+		deviceRepo: deviceRepo,
+		window:     window,
+		interval:   interval,
+		logger:     logger,
+		stop:       make(chan struct{}),
+	}
+}
+
+// Start runs a check immediately and then every interval until Stop is called. A
+// non-positive interval disables the monitor.
+func (m *CertificateExpiryMonitor) Start() {
+	if m.interval <= 0 {
+		return
+	}
+
+	go func() {
+		m.Check(time.Now())
+
+		ticker := time.NewTicker(m.interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case now := <-ticker.C:
+				m.Check(now)
+			case <-m.stop:
+				return
+			}
+		}
+	}()
+}
+
+// Stop halts the periodic checks
+func (m *CertificateExpiryMonitor) Stop() {
+	m.stopOnce.Do(func() {
+		close(m.stop)
+	})
+}
+
+// Check logs and returns the certificates in the warning window that were not reported
+// before, and those that expired since the previous check. The first check reports every
+// certificate already in the window. A failed check is retried over the same period next time.
+func (m *CertificateExpiryMonitor) Check(now time.Time) ([]CertificateExpiryEvent, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	now = now.UTC()
+
+	var events []CertificateExpiryEvent
+	if !m.lastCheck.IsZero() {
+		expired, err := m.deviceRepo.ListDevicesExpiringBetween(m.lastCheck, now)
+		if err != nil {
+			m.logger.Error("Certificate expiry check failed", "error", err)
+			return nil, fmt.Errorf("failed to list expired devices: %w", err)
+		}
+		events = appendExpiryEvents(events, expired, true)
+	}
+
+	expiring, err := m.deviceRepo.ListDevicesExpiringBetween(now, now.Add(m.window))
+	if err != nil {
+		m.logger.Error("Certificate expiry check failed", "error", err)
+		return nil, fmt.Errorf("failed to list expiring devices: %w", err)
+	}
+
+	// Devices that left the window are forgotten, so the map only holds the current window
+	warned := make(map[uuid.UUID]time.Time, len(expiring))
+	var unreported []*models.DeviceWithAssignment
+	for _, device := range expiring {
+		var notAfter time.Time
+		if device.CertificateNotAfter != nil {
+			notAfter = *device.CertificateNotAfter
+		}
+		if previous, ok := m.warned[device.ID]; !ok || !previous.Equal(notAfter) {
+			unreported = append(unreported, device)
+		}
+		warned[device.ID] = notAfter
+	}
+	events = appendExpiryEvents(events, unreported, false)
+
+	for _, event := range events {
+		if event.Expired {
+			m.logger.Warn("Device certificate expired",
+				"device_id", event.DeviceID,
+				"user_id", event.UserID,
+				"not_after", event.NotAfter)
+			continue
+		}
+
+		m.logger.Warn("Device certificate expiring soon",
+			"device_id", event.DeviceID,
+			"user_id", event.UserID,
+			"not_after", event.NotAfter,
+			"expires_in", event.NotAfter.Sub(now).Round(time.Minute))
+	}
+
+	m.lastCheck = now
+	m.warned = warned
+	return events, nil
+}
+
+// appendExpiryEvents appends an event for each device
+func appendExpiryEvents(events []CertificateExpiryEvent, devices []*models.DeviceWithAssignment, expired bool) []CertificateExpiryEvent {
+	for _, device := range devices {
+		event := CertificateExpiryEvent{DeviceID: device.ID, Expired: expired}
+		if device.CertificateNotAfter != nil {
+			event.NotAfter = *device.CertificateNotAfter
+		}
+		if device.UserID != nil {
+			event.UserID = *device.UserID
+		}
+		events = append(events, event)
+	}
+	return events
+}
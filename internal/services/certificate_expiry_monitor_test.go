@@ -0,0 +1,164 @@
+package services
+
+import (
+	"fmt"
+	"log/slog"
+	"testing"
+	"time"
+
+	"device-assignment-api/internal/models"
+	"device-assignment-api/pkg/logger"
+
+	"github.com/google/uuid"
+)
+
+func testLogger() logger.Logger {
+	return logger.NewWithLevel(slog.LevelError + 1)
+}
+
+// expiringDeviceRepository lists devices by certificate expiry. Other device repository
+// methods are not used by the monitor and panic.
+type expiringDeviceRepository struct {
+	models.DeviceRepository
+	devices []*models.DeviceWithAssignment
+	err     error
+}
+
+func (r *expiringDeviceRepository) ListDevicesExpiringBetween(from, to time.Time) ([]*models.DeviceWithAssignment, error) {
+	if r.err != nil {
+		return nil, r.err
+	}
+
+	var devices []*models.DeviceWithAssignment
+	for _, device := range r.devices {
+		if notAfter := device.CertificateNotAfter; notAfter != nil && notAfter.After(from) && !notAfter.After(to) {
+			devices = append(devices, device)
+		}
+	}
+	return devices, nil
+}
+
+func (r *expiringDeviceRepository) add(name string, notAfter time.Time) {
+	r.devices = append(r.devices, &models.DeviceWithAssignment{
+		Device: models.Device{ID: uuid.NewSHA1(uuid.Nil, []byte(name)), CertificateNotAfter: &notAfter},
+	})
+}
+
+func TestCertificateExpiryMonitorCheck(t *testing.T) {
+	start := time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC)
+	window := 24 * time.Hour
+
+	repo := &expiringDeviceRepository{}
+	repo.add("inside", start.Add(window-time.Minute))
+	repo.add("outside", start.Add(window+time.Minute))
+	repo.add("expired", start.Add(-time.Minute))
+	repo.add("expires soon", start.Add(90*time.Minute))
+
+	monitor := NewCertificateExpiryMonitor(repo, window, time.Hour, testLogger())
+
+	// Each check runs against the same monitor, in order
+	tests := []struct {
+		name     string
+		now      time.Time
+		failing  bool
+		expiring []string
+		expired  []string
+	}{
+		{"first check reports the window", start, false, []string{"expires soon", "inside"}, nil},
+		{"certificates in the window are not re-reported", start.Add(time.Second), false, nil, nil},
+		{"certificate entering the window", start.Add(time.Hour), false, []string{"outside"}, nil},
+		{"failed check", start.Add(2 * time.Hour), true, nil, nil},
+		{"retry covers the failed period", start.Add(3 * time.Hour), false, nil, []string{"expires soon"}},
+		{"expired certificates are reported once", start.Add(4 * time.Hour), false, nil, nil},
+		{"certificates expiring", start.Add(window + 2*time.Hour), false, nil, []string{"inside", "outside"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			repo.err = nil
+			if tt.failing {
+				repo.err = fmt.Errorf("connection refused")
+			}
+
+			events, err := monitor.Check(tt.now)
+			if tt.failing {
+				if err == nil {
+					t.Fatal("Expected the check to fail")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Check failed: %v", err)
+			}
+
+			var expiring, expired []uuid.UUID
+			for _, event := range events {
+				if event.Expired {
+					expired = append(expired, event.DeviceID)
+				} else {
+					expiring = append(expiring, event.DeviceID)
+				}
+			}
+			assertDeviceIDs(t, "expiring", expiring, tt.expiring)
+			assertDeviceIDs(t, "expired", expired, tt.expired)
+		})
+	}
+}
+
+// assertDeviceIDs checks that the events were reported for the named devices, in any order
+func assertDeviceIDs(t *testing.T, kind string, got []uuid.UUID, names []string) {
+	t.Helper()
+
+	want := make(map[uuid.UUID]string, len(names))
+	for _, name := range names {
+		want[uuid.NewSHA1(uuid.Nil, []byte(name))] = name
+	}
+
+	if len(got) != len(want) {
+		t.Fatalf("Expected %s events for %v, got %d events", kind, names, len(got))
+	}
+	for _, id := range got {
+		if _, ok := want[id]; !ok {
+			t.Errorf("Unexpected %s event for device %s", kind, id)
+		}
+	}
+}
+
+func TestCertificateExpiryMonitorReportsLateEntries(t *testing.T) {
+	start := time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC)
+	window := 24 * time.Hour
+
+	repo := &expiringDeviceRepository{}
+	repo.add("inside", start.Add(window-time.Hour))
+	monitor := NewCertificateExpiryMonitor(repo, window, time.Hour, testLogger())
+
+	if _, err := monitor.Check(start); err != nil {
+		t.Fatalf("Check failed: %v", err)
+	}
+
+	// A device registering with a certificate already deep inside the window, and a device
+	// renewing to a certificate that is still inside it, were never reported
+	repo.add("registered late", start.Add(2*time.Hour))
+	renewed := start.Add(window - time.Minute)
+	repo.devices[0].CertificateNotAfter = &renewed
+
+	events, err := monitor.Check(start.Add(time.Hour))
+	if err != nil {
+		t.Fatalf("Check failed: %v", err)
+	}
+	var expiring []uuid.UUID
+	for _, event := range events {
+		if !event.Expired {
+			expiring = append(expiring, event.DeviceID)
+		}
+	}
+	assertDeviceIDs(t, "expiring", expiring, []string{"registered late", "inside"})
+
+	events, err = monitor.Check(start.Add(90 * time.Minute))
+	if err != nil {
+		t.Fatalf("Check failed: %v", err)
+	}
+	if len(events) != 0 {
+		t.Errorf("Expected reported certificates not to be reported again, got %d events", len(events))
+	}
+}
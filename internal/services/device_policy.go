@@ -0,0 +1,52 @@
+package services
+
+import (
+	"device-assignment-api/internal/models"
+	"device-assignment-api/pkg/auth"
+)
+
+// DeviceAccess describes how much of a device a user may see
+type DeviceAccess int
+
+const (
+	// DeviceAccessNone hides the device entirely; callers report it as not found
+	DeviceAccessNone DeviceAccess = iota
+	// DeviceAccessClaimable shows the limited view of an unassigned device
+	DeviceAccessClaimable
+	// DeviceAccessFull shows every device detail, including certificate and assignment data
+	DeviceAccessFull
+)
+
+// DeviceAccessPolicy decides how much of a device a principal may see
+type DeviceAccessPolicy interface {
+	DeviceAccess(principal *auth.Principal, device *models.DeviceWithAssignment) DeviceAccess
+}
+
+// OwnershipPolicy grants full access to administrators and to the user a device is assigned
+// to, and a claimable view of unassigned active devices to users allowed to assign devices.
+// Devices assigned to someone else, and unassigned devices out of service, are hidden.
+type OwnershipPolicy struct{}
+
+// DeviceAccess implements DeviceAccessPolicy
+func (OwnershipPolicy) DeviceAccess(principal *auth.Principal, device *models.DeviceWithAssignment) DeviceAccess {
+	if principal == nil || device == nil {
+		return DeviceAccessNone
+	}
+
+	if principal.HasPermission(auth.PermissionAdminDevicesRead) {
+		return DeviceAccessFull
+	}
+
+	if device.IsAssigned {
+		if device.UserID != nil && *device.UserID == principal.UserID {
+			return DeviceAccessFull
+		}
+		return DeviceAccessNone
+	}
+
+	if device.Status == models.DeviceStatusActive && principal.HasPermission(auth.PermissionDevicesAssign) {
+		return DeviceAccessClaimable
+	}
+
+	return DeviceAccessNone
+}
@@ -2,6 +2,8 @@ package services
 
 import (
 	"fmt"
+	"strings"
+	"time"
 
 	"device-assignment-api/internal/models"
 	"device-assignment-api/pkg/auth"
@@ -12,54 +14,323 @@ import (
 
 // DeviceService handles device-related business logic
 type DeviceService struct {
-	deviceRepo     models.DeviceRepository
-	assignmentRepo models.AssignmentRepository
-	logger         logger.Logger
+	deviceRepo      models.DeviceRepository
+	assignmentRepo  models.AssignmentRepository
+	certificateRepo models.DeviceCertificateRepository
+	statusRepo      models.DeviceStatusRepository
+	identity        auth.IdentityConfig
+	accessPolicy    DeviceAccessPolicy
+	registration    RegistrationPolicy
+	presence        *PresenceTracker
+	logger          logger.Logger
 }
 
-// NewDeviceService creates a new DeviceService
+// NewDeviceService creates a new DeviceService. The registration policy decides which unknown
+// devices may register and in which status. Heartbeats are recorded by the presence tracker.
 func NewDeviceService(
 	deviceRepo models.DeviceRepository,
 	assignmentRepo models.AssignmentRepository,
+	certificateRepo models.DeviceCertificateRepository,
+	statusRepo models.DeviceStatusRepository,
+	identity auth.IdentityConfig,
+	accessPolicy DeviceAccessPolicy,
+	registration RegistrationPolicy,
+	presence *PresenceTracker,
 	logger logger.Logger,
 ) *DeviceService {
 	return &DeviceService{
-		deviceRepo:     deviceRepo,
-		assignmentRepo: assignmentRepo,
-		logger:         logger,
+		deviceRepo:      deviceRepo,
+		assignmentRepo:  assignmentRepo,
+		certificateRepo: certificateRepo,
+		statusRepo:      statusRepo,
+		identity:        identity,
+		accessPolicy:    accessPolicy,
+		registration:    registration,
+		presence:        presence,
+		logger:          logger,
 	}
 }
 
-// AuthenticateAndRegisterDevice handles device authentication and automatic registration
+// AuthenticateAndRegisterDevice handles device authentication and automatic registration.
+// A device presenting a renewed certificate keeps its identity when the configured identity
+// mode binds it to an attribute that survives renewal. Unknown devices only register when the
+// registration policy admits them.
 func (s *DeviceService) AuthenticateAndRegisterDevice(certInfo *auth.CertificateInfo) (*models.Device, error) {
 	if certInfo == nil || !certInfo.IsValid {
 		return nil, fmt.Errorf("invalid certificate information")
 	}
 
+	identityKey, err := s.identity.Key(certInfo)
+	if err != nil {
+		s.logger.Warn("Failed to derive device identity",
+			"serial_number", certInfo.SerialNumber,
+			"identity_mode", s.identity.Mode,
+			"error", err)
+		return nil, fmt.Errorf("failed to derive device identity: %w", err)
+	}
+
 	s.logger.Debug("Authenticating device", 
 		"serial_number", certInfo.SerialNumber,
-		"issuer_cn", certInfo.IssuerCN)
+		"issuer_dn", certInfo.IssuerDN,
+		"spiffe_id", certInfo.SPIFFEID,
+		"identity_key", identityKey)
 
-	// Check if device already exists
-	existingDevice, err := s.deviceRepo.GetDeviceBySerialNumber(certInfo.SerialNumber)
-	if err == nil {
-		s.logger.Debug("Device already registered", "device_id", existingDevice.ID)
-		return existingDevice, nil
+	device, err := s.findDevice(certInfo, identityKey)
+	if err != nil {
+		if err.Error() != "device not found" {
+			s.logger.Error("Failed to look up device", "serial_number", certInfo.SerialNumber, "error", err)
+			return nil, fmt.Errorf("failed to look up device: %w", err)
+		}
+
+		// Device doesn't exist, register it if the registration policy admits it
+		device, err = s.registerDevice(certInfo, identityKey)
+		if err != nil {
+			return nil, err
+		}
+	} else {
+		previousSerial := device.CertificateSerialNumber
+		certificateChanged := device.ApplyCertificate(certInfo.SerialNumber, certInfo.IssuerDN, certInfo.IssuerCN, certInfo.AuthorityKeyID, identityKey, certInfo.SPIFFEID)
+
+		// The validity is also recorded for devices registered before it was stored
+		validityChanged := device.ApplyCertificateValidity(certInfo.NotBefore, certInfo.NotAfter)
+
+		if certificateChanged || validityChanged {
+			if err := s.deviceRepo.UpdateDeviceCertificate(device); err != nil {
+				s.logger.Error("Failed to update device certificate", "device_id", device.ID, "error", err)
+				return nil, fmt.Errorf("failed to update device certificate: %w", err)
+			}
+		}
+
+		if certificateChanged {
+			s.logger.Info("Device certificate updated",
+				"device_id", device.ID,
+				"previous_serial_number", previousSerial,
+				"serial_number", certInfo.SerialNumber,
+				"issuer_dn", certInfo.IssuerDN,
+				"not_after", certInfo.NotAfter)
+		} else {
+			s.logger.Debug("Device already registered", "device_id", device.ID)
+		}
+	}
+
+	s.recordCertificate(device.ID, certInfo)
+
+	device.SetCertificateExpiresIn(time.Now())
+	return device, nil
+}
+
+// CheckEnrollment decides, before a certificate is issued, whether a device may enroll with
+// the requested certificate. Enrollment only ever registers new devices: a request whose
+// identity already belongs to a registered device is refused with "device identity is already
+// registered", so that an enrollment credential cannot be used to take over another device.
+// Requests the registration policy refuses are reported with "device is not allowlisted".
+func (s *DeviceService) CheckEnrollment(requested *auth.CertificateInfo) error {
+	identityKey, err := s.identity.Key(requested)
+	if err != nil {
+		return fmt.Errorf("failed to derive device identity: %w", err)
+	}
+
+	if err := s.checkIdentityAvailable(identityKey); err != nil {
+		return err
+	}
+
+	// The request has no serial number or certificate fingerprint yet, so in allowlist mode
+	// only an entry for its public key admits it; the issued certificate carries the same key
+	_, err = s.registration.admit(requested)
+	return err
+}
+
+// RegisterEnrolledDevice registers the device a certificate was just issued to by enrollment.
+// Unlike AuthenticateAndRegisterDevice it never binds the certificate to a registered device.
+func (s *DeviceService) RegisterEnrolledDevice(certInfo *auth.CertificateInfo) (*models.Device, error) {
+	if certInfo == nil || !certInfo.IsValid {
+		return nil, fmt.Errorf("invalid certificate information")
+	}
+
+	identityKey, err := s.identity.Key(certInfo)
+	if err != nil {
+		return nil, fmt.Errorf("failed to derive device identity: %w", err)
 	}
 
-	// Device doesn't exist, register it
-	s.logger.Info("Registering new device", 
+	if err := s.checkIdentityAvailable(identityKey); err != nil {
+		return nil, err
+	}
+
+	device, err := s.registerDevice(certInfo, identityKey)
+	if err != nil {
+		return nil, err
+	}
+
+	s.recordCertificate(device.ID, certInfo)
+
+	device.SetCertificateExpiresIn(time.Now())
+	return device, nil
+}
+
+// checkIdentityAvailable returns "device identity is already registered" when a registered
+// device holds the identity key
+func (s *DeviceService) checkIdentityAvailable(identityKey string) error {
+	if identityKey == "" {
+		return nil
+	}
+
+	_, err := s.deviceRepo.GetDeviceByIdentityKey(identityKey)
+	switch {
+	case err == nil:
+		return fmt.Errorf("device identity is already registered")
+	case err.Error() == "device not found":
+		return nil
+	default:
+		return fmt.Errorf("failed to check device identity: %w", err)
+	}
+}
+
+// registerDevice registers a new device for the certificate when the registration policy
+// admits it
+func (s *DeviceService) registerDevice(certInfo *auth.CertificateInfo, identityKey string) (*models.Device, error) {
+	status, err := s.registration.admit(certInfo)
+	if err != nil {
+		s.logger.Warn("Device registration refused",
+			"serial_number", certInfo.SerialNumber,
+			"issuer_dn", certInfo.IssuerDN,
+			"registration_mode", s.registration.Mode,
+			"error", err)
+		return nil, err
+	}
+
+	s.logger.Info("Registering new device",
 		"serial_number", certInfo.SerialNumber,
-		"issuer_cn", certInfo.IssuerCN)
+		"issuer_dn", certInfo.IssuerDN)
 
-	newDevice := models.NewDevice(certInfo.SerialNumber, certInfo.IssuerCN)
-	if err := s.deviceRepo.CreateDevice(newDevice); err != nil {
+	device := models.NewDevice(certInfo.SerialNumber, certInfo.IssuerDN, certInfo.IssuerCN, certInfo.AuthorityKeyID)
+	device.IdentityKey = identityKey
+	device.SPIFFEID = certInfo.SPIFFEID
+	device.Status = status
+	device.ApplyCertificateValidity(certInfo.NotBefore, certInfo.NotAfter)
+	if err := s.deviceRepo.CreateDevice(device); err != nil {
 		s.logger.Error("Failed to register new device", "error", err)
 		return nil, fmt.Errorf("failed to register device: %w", err)
 	}
 
-	s.logger.Info("Device registered successfully", "device_id", newDevice.ID)
-	return newDevice, nil
+	s.logger.Info("Device registered successfully", "device_id", device.ID, "status", device.Status)
+	return device, nil
+}
+
+// LinkRenewedCertificate moves the device authenticated by its current certificate onto a
+// newly issued certificate, so the device and its assignment survive renewal
+func (s *DeviceService) LinkRenewedCertificate(currentCertInfo, renewedCertInfo *auth.CertificateInfo) (*models.Device, error) {
+	if renewedCertInfo == nil || !renewedCertInfo.IsValid {
+		return nil, fmt.Errorf("invalid certificate information")
+	}
+
+	device, err := s.AuthenticateAndRegisterDevice(currentCertInfo)
+	if err != nil {
+		return nil, err
+	}
+
+	identityKey, err := s.identity.Key(renewedCertInfo)
+	if err != nil {
+		s.logger.Warn("Failed to derive identity for renewed certificate", "device_id", device.ID, "error", err)
+		return nil, fmt.Errorf("failed to derive device identity: %w", err)
+	}
+
+	previousSerial := device.CertificateSerialNumber
+	device.ApplyCertificate(
+		renewedCertInfo.SerialNumber,
+		renewedCertInfo.IssuerDN,
+		renewedCertInfo.IssuerCN,
+		renewedCertInfo.AuthorityKeyID,
+		identityKey,
+		renewedCertInfo.SPIFFEID,
+	)
+	device.ApplyCertificateValidity(renewedCertInfo.NotBefore, renewedCertInfo.NotAfter)
+	if err := s.deviceRepo.UpdateDeviceCertificate(device); err != nil {
+		s.logger.Error("Failed to link renewed certificate", "device_id", device.ID, "error", err)
+		return nil, fmt.Errorf("failed to link renewed certificate: %w", err)
+	}
+
+	s.recordCertificate(device.ID, renewedCertInfo)
+
+	s.logger.Info("Renewed certificate linked to device",
+		"device_id", device.ID,
+		"previous_serial_number", previousSerial,
+		"serial_number", renewedCertInfo.SerialNumber)
+
+	device.SetCertificateExpiresIn(time.Now())
+	return device, nil
+}
+
+// DeviceIDForCertificate returns the ID of the registered device a certificate belongs to,
+// without registering unknown devices
+func (s *DeviceService) DeviceIDForCertificate(certInfo *auth.CertificateInfo) (string, error) {
+	if certInfo == nil || !certInfo.IsValid {
+		return "", fmt.Errorf("invalid certificate information")
+	}
+
+	identityKey, err := s.identity.Key(certInfo)
+	if err != nil {
+		return "", fmt.Errorf("failed to derive device identity: %w", err)
+	}
+
+	device, err := s.findDevice(certInfo, identityKey)
+	if err != nil {
+		return "", fmt.Errorf("device not found")
+	}
+
+	return device.ID.String(), nil
+}
+
+// findDevice looks a device up by identity key, then by issuer DN and serial number, and
+// finally among devices registered before issuer DNs were recorded. It returns "device not
+// found" only when every lookup found nothing, and any other lookup error as is.
+func (s *DeviceService) findDevice(certInfo *auth.CertificateInfo, identityKey string) (*models.Device, error) {
+	if identityKey != "" {
+		device, err := s.deviceRepo.GetDeviceByIdentityKey(identityKey)
+		if err == nil {
+			return device, nil
+		}
+		if err.Error() != "device not found" {
+			return nil, err
+		}
+	}
+
+	device, err := s.deviceRepo.GetDeviceByIssuerAndSerial(certInfo.IssuerDN, certInfo.SerialNumber)
+	if err == nil {
+		return device, nil
+	}
+	if err.Error() != "device not found" {
+		return nil, err
+	}
+
+	return s.deviceRepo.GetLegacyDevice(certInfo.IssuerCN, certInfo.SerialNumber)
+}
+
+// recordCertificate adds the presented certificate to the device's history. Failures are
+// logged but do not fail authentication.
+func (s *DeviceService) recordCertificate(deviceID uuid.UUID, certInfo *auth.CertificateInfo) {
+	certificate := models.NewDeviceCertificate(
+		deviceID,
+		certInfo.SerialNumber,
+		certInfo.IssuerDN,
+		certInfo.Fingerprint,
+		certInfo.NotBefore,
+		certInfo.NotAfter,
+	)
+
+	if err := s.certificateRepo.RecordCertificate(certificate); err != nil {
+		s.logger.Error("Failed to record device certificate", "device_id", deviceID, "error", err)
+	}
+}
+
+// GetDeviceCertificates retrieves the certificate history of a device
+func (s *DeviceService) GetDeviceCertificates(deviceID uuid.UUID) ([]*models.DeviceCertificate, error) {
+	certificates, err := s.certificateRepo.GetCertificatesByDeviceID(deviceID)
+	if err != nil {
+		s.logger.Error("Failed to retrieve device certificates", "device_id", deviceID, "error", err)
+		return nil, fmt.Errorf("failed to retrieve device certificates: %w", err)
+	}
+
+	return certificates, nil
 }
 
 // GetDeviceByID retrieves a device by its ID
@@ -70,6 +341,8 @@ func (s *DeviceService) GetDeviceByID(deviceID uuid.UUID) (*models.Device, error
 		return nil, fmt.Errorf("device not found: %w", err)
 	}
 
+	device.SetCertificateExpiresIn(time.Now())
+	s.presence.apply(device)
 	return device, nil
 }
 
@@ -77,43 +350,82 @@ func (s *DeviceService) GetDeviceByID(deviceID uuid.UUID) (*models.Device, error
 func (s *DeviceService) GetDeviceWithAssignment(deviceID uuid.UUID) (*models.DeviceWithAssignment, error) {
 	deviceWithAssignment, err := s.deviceRepo.GetDeviceWithAssignment(deviceID)
 	if err != nil {
-		s.logger.Warn("Device with assignment not found", "device_id", deviceID, "error", err)
-		return nil, fmt.Errorf("device not found: %w", err)
+		if err.Error() == "device not found" {
+			s.logger.Warn("Device with assignment not found", "device_id", deviceID)
+			return nil, err
+		}
+		s.logger.Error("Failed to retrieve device with assignment", "device_id", deviceID, "error", err)
+		return nil, fmt.Errorf("failed to retrieve device: %w", err)
 	}
 
+	s.annotate([]*models.DeviceWithAssignment{deviceWithAssignment}, time.Now())
 	return deviceWithAssignment, nil
 }
 
-// AssignDeviceToUser assigns a device to a user
-func (s *DeviceService) AssignDeviceToUser(deviceID uuid.UUID, userID string) error {
-	s.logger.Debug("Attempting to assign device to user", 
-		"device_id", deviceID, 
-		"user_id", userID)
+// UpdateDeviceMetadata validates and applies a metadata update to a device. Invalid updates
+// are reported with an error starting with "invalid device metadata".
+func (s *DeviceService) UpdateDeviceMetadata(deviceID uuid.UUID, update models.DeviceMetadataUpdate) (*models.Device, error) {
+	device, err := s.deviceRepo.GetDeviceByID(deviceID)
+	if err != nil {
+		s.logger.Warn("Device not found for metadata update", "device_id", deviceID)
+		return nil, fmt.Errorf("device not found")
+	}
 
-	// Check if device exists
-	_, err := s.deviceRepo.GetDeviceByID(deviceID)
+	changed, err := device.ApplyMetadata(update)
 	if err != nil {
-		s.logger.Warn("Device not found for assignment", "device_id", deviceID)
-		return fmt.Errorf("device not found")
+		return nil, fmt.Errorf("invalid device metadata: %w", err)
+	}
+
+	if changed {
+		// Only the fields in the update are written, keeping concurrent updates of the others
+		if err := s.deviceRepo.UpdateDeviceMetadata(device, update); err != nil {
+			s.logger.Error("Failed to update device metadata", "device_id", deviceID, "error", err)
+			return nil, fmt.Errorf("failed to update device metadata: %w", err)
+		}
+
+		s.logger.Info("Device metadata updated", "device_id", deviceID)
 	}
 
-	// Check if device is already assigned
-	isAssigned, err := s.assignmentRepo.IsDeviceAssigned(deviceID)
+	device.SetCertificateExpiresIn(time.Now())
+	s.presence.apply(device)
+	return device, nil
+}
+
+// GetDeviceForPrincipal retrieves a device together with the access the principal has to it.
+// Devices the principal may not see are reported as not found, so their existence is not revealed.
+func (s *DeviceService) GetDeviceForPrincipal(deviceID uuid.UUID, principal *auth.Principal) (*models.DeviceWithAssignment, DeviceAccess, error) {
+	deviceWithAssignment, err := s.GetDeviceWithAssignment(deviceID)
 	if err != nil {
-		s.logger.Error("Failed to check device assignment status", "error", err)
-		return fmt.Errorf("failed to check assignment status: %w", err)
+		return nil, DeviceAccessNone, err
 	}
 
-	if isAssigned {
-		s.logger.Warn("Device is already assigned", "device_id", deviceID)
-		return fmt.Errorf("device is already assigned to another user")
+	access := s.accessPolicy.DeviceAccess(principal, deviceWithAssignment)
+	if access == DeviceAccessNone {
+		s.logger.Warn("Device access denied by policy", "device_id", deviceID, "user_id", principal.UserID)
+		return nil, DeviceAccessNone, fmt.Errorf("device not found")
 	}
 
-	// Create new assignment
+	return deviceWithAssignment, access, nil
+}
+
+// AssignDeviceToUser assigns a device to a user
+func (s *DeviceService) AssignDeviceToUser(deviceID uuid.UUID, userID string) error {
+	s.logger.Debug("Attempting to assign device to user", 
+		"device_id", deviceID, 
+		"user_id", userID)
+
+	// The device status and assignment are checked and the assignment stored in one
+	// transaction, so an assignment cannot race a decommissioning or another assignment
 	assignment := models.NewAssignment(deviceID, userID)
-	if err := s.assignmentRepo.CreateAssignment(assignment); err != nil {
-		s.logger.Error("Failed to create device assignment", "error", err)
-		return fmt.Errorf("failed to assign device: %w", err)
+	if err := s.assignmentRepo.AssignDevice(assignment); err != nil {
+		switch err.Error() {
+		case "device not found", "device is not active", "device is already assigned to another user":
+			s.logger.Warn("Refusing to assign device", "device_id", deviceID, "error", err)
+			return err
+		default:
+			s.logger.Error("Failed to create device assignment", "error", err)
+			return fmt.Errorf("failed to assign device: %w", err)
+		}
 	}
 
 	s.logger.Info("Device assigned successfully", 
@@ -145,20 +457,301 @@ func (s *DeviceService) UnassignDevice(deviceID uuid.UUID) error {
 	return nil
 }
 
-// GetUserDevices retrieves all devices assigned to a user
-func (s *DeviceService) GetUserDevices(userID string) ([]*models.DeviceWithAssignment, error) {
+// GetUserDevices retrieves a page of the devices assigned to a user that match the query
+func (s *DeviceService) GetUserDevices(userID string, query *models.DeviceQuery) (*models.DevicePage, error) {
 	s.logger.Debug("Retrieving devices for user", "user_id", userID)
 
-	devices, err := s.deviceRepo.GetDevicesByUserID(userID)
+	userQuery := *query
+	userQuery.UserID = userID
+	return s.ListDevices(&userQuery)
+}
+
+// ListDevicesForPrincipal retrieves a page of the devices the principal may list that match
+// the query. Administrators may list every device; other users only the devices assigned to them.
+func (s *DeviceService) ListDevicesForPrincipal(principal *auth.Principal, query *models.DeviceQuery) (*models.DevicePage, error) {
+	if principal.HasPermission(auth.PermissionAdminDevicesRead) {
+		return s.ListDevices(query)
+	}
+	return s.GetUserDevices(principal.UserID, query)
+}
+
+// ListDevices retrieves a page of the devices matching the query, with their current assignment
+// information. The page carries a cursor for the next page unless it is the last.
+func (s *DeviceService) ListDevices(query *models.DeviceQuery) (*models.DevicePage, error) {
+	// Read one device more than the page holds to learn whether another page follows
+	pageQuery := *query
+	pageQuery.Limit++
+
+	devices, err := s.deviceRepo.QueryDevices(&pageQuery)
+	if err != nil {
+		s.logger.Error("Failed to list devices", "error", err)
+		return nil, fmt.Errorf("failed to list devices: %w", err)
+	}
+
+	page := &models.DevicePage{}
+	if len(devices) > query.Limit {
+		devices = devices[:query.Limit]
+		last := devices[len(devices)-1]
+		page.NextCursor = models.NewDeviceCursor(query.Sort, query.Descending, &last.Device).Encode()
+	}
+
+	if query.IncludeTotal {
+		total, err := s.deviceRepo.CountDevices(query)
+		if err != nil {
+			s.logger.Error("Failed to count devices", "error", err)
+			return nil, fmt.Errorf("failed to count devices: %w", err)
+		}
+		page.TotalCount = &total
+	}
+
+	s.annotate(devices, time.Now())
+	page.Devices = devices
+	page.Count = len(devices)
+	return page, nil
+}
+
+// ListExpiringDevices retrieves devices whose certificate expires within the window, soonest
+// first. Devices whose certificate has already expired are included when includeExpired is set.
+func (s *DeviceService) ListExpiringDevices(within time.Duration, includeExpired bool) ([]*models.DeviceWithAssignment, error) {
+	now := time.Now().UTC()
+
+	from := now
+	if includeExpired {
+		from = time.Time{}
+	}
+
+	devices, err := s.deviceRepo.ListDevicesExpiringBetween(from, now.Add(within))
 	if err != nil {
-		s.logger.Error("Failed to retrieve user devices", "user_id", userID, "error", err)
-		return nil, fmt.Errorf("failed to retrieve user devices: %w", err)
+		s.logger.Error("Failed to list expiring devices", "within", within, "error", err)
+		return nil, fmt.Errorf("failed to list expiring devices: %w", err)
 	}
 
-	s.logger.Debug("Retrieved user devices", "user_id", userID, "count", len(devices))
+	s.annotate(devices, now)
 	return devices, nil
 }
 
+// annotate derives the days left on each device's certificate and whether the device is online
+func (s *DeviceService) annotate(devices []*models.DeviceWithAssignment, now time.Time) {
+	for _, device := range devices {
+		device.SetCertificateExpiresIn(now)
+		s.presence.annotate(device, now)
+	}
+}
+
+// RecordHeartbeat records a heartbeat of the device sent from sourceIP, with the status the
+// device optionally reports. Heartbeats are written in batches, so the stored last sighting may
+// lag behind by the flush interval. Invalid heartbeats are reported with an error starting with
+// "invalid heartbeat".
+func (s *DeviceService) RecordHeartbeat(deviceID uuid.UUID, sourceIP, reportedStatus string) error {
+	heartbeat, err := models.NewDeviceHeartbeat(deviceID, time.Now(), sourceIP, reportedStatus)
+	if err != nil {
+		return fmt.Errorf("invalid heartbeat: %w", err)
+	}
+
+	s.presence.Record(heartbeat)
+	return nil
+}
+
+// ReassignDevice assigns a device to a user, ending any current assignment first. Used by
+// administrators to correct a wrong assignment.
+func (s *DeviceService) ReassignDevice(deviceID uuid.UUID, userID string) error {
+	// The device status is checked, the current assignment ended and the new one stored in one
+	// transaction, so concurrent reassignments cannot leave two active assignments
+	assignment := models.NewAssignment(deviceID, userID)
+	replaced, err := s.assignmentRepo.ReassignDevice(assignment)
+	if err != nil {
+		switch err.Error() {
+		case "device is already assigned to the user":
+			s.logger.Debug("Device already assigned to user", "device_id", deviceID, "user_id", userID)
+			return nil
+		case "device not found", "device is not active":
+			s.logger.Warn("Refusing to reassign device", "device_id", deviceID, "error", err)
+			return err
+		default:
+			s.logger.Error("Failed to reassign device", "device_id", deviceID, "error", err)
+			return fmt.Errorf("failed to reassign device: %w", err)
+		}
+	}
+
+	s.logger.Info("Device reassigned successfully",
+		"device_id", deviceID,
+		"user_id", userID,
+		"assignment_id", assignment.ID,
+		"replaced_assignment", replaced)
+
+	return nil
+}
+
+// ChangeDeviceStatus moves a device to a new lifecycle status, recording who changed it and
+// why. Decommissioning a device also ends its current assignment, in the same transaction.
+func (s *DeviceService) ChangeDeviceStatus(deviceID uuid.UUID, status models.DeviceStatus, changedBy, reason string) (*models.DeviceStatusTransition, error) {
+	device, err := s.deviceRepo.GetDeviceByID(deviceID)
+	if err != nil {
+		s.logger.Warn("Device not found for status change", "device_id", deviceID)
+		return nil, fmt.Errorf("device not found")
+	}
+
+	transition, err := models.NewDeviceStatusTransition(device, status, changedBy, strings.TrimSpace(reason))
+	if err != nil {
+		s.logger.Warn("Invalid device status change",
+			"device_id", deviceID,
+			"status", device.Status,
+			"requested_status", status,
+			"error", err)
+		return nil, err
+	}
+
+	if err := s.statusRepo.TransitionDeviceStatus(transition); err != nil {
+		if err.Error() == "device status changed concurrently" {
+			return nil, err
+		}
+		s.logger.Error("Failed to change device status", "device_id", deviceID, "error", err)
+		return nil, fmt.Errorf("failed to change device status: %w", err)
+	}
+
+	s.logger.Info("Device status changed",
+		"device_id", deviceID,
+		"from_status", transition.FromStatus,
+		"to_status", transition.ToStatus,
+		"changed_by", changedBy,
+		"reason", transition.Reason)
+
+	return transition, nil
+}
+
+// GetDeviceStatusHistory retrieves every status transition of a device, newest first
+func (s *DeviceService) GetDeviceStatusHistory(deviceID uuid.UUID) ([]*models.DeviceStatusTransition, error) {
+	transitions, err := s.statusRepo.GetStatusTransitionsByDeviceID(deviceID)
+	if err != nil {
+		s.logger.Error("Failed to retrieve device status history", "device_id", deviceID, "error", err)
+		return nil, fmt.Errorf("failed to retrieve device status history: %w", err)
+	}
+
+	return transitions, nil
+}
+
+// ApproveDevice activates a device awaiting registration approval
+func (s *DeviceService) ApproveDevice(deviceID uuid.UUID, reviewedBy, reason string) (*models.DeviceStatusTransition, error) {
+	return s.reviewRegistration(deviceID, models.DeviceStatusActive, reviewedBy, reason, "Registration approved")
+}
+
+// RejectDevice rejects the registration of a device awaiting approval, refusing its further
+// authentication
+func (s *DeviceService) RejectDevice(deviceID uuid.UUID, reviewedBy, reason string) (*models.DeviceStatusTransition, error) {
+	return s.reviewRegistration(deviceID, models.DeviceStatusRejected, reviewedBy, reason, "Registration rejected")
+}
+
+// reviewRegistration moves a pending device to the reviewed status, with the default reason
+// when none is given
+func (s *DeviceService) reviewRegistration(deviceID uuid.UUID, status models.DeviceStatus, reviewedBy, reason, defaultReason string) (*models.DeviceStatusTransition, error) {
+	device, err := s.deviceRepo.GetDeviceByID(deviceID)
+	if err != nil {
+		s.logger.Warn("Device not found for registration review", "device_id", deviceID)
+		return nil, fmt.Errorf("device not found")
+	}
+
+	if device.Status != models.DeviceStatusPending {
+		return nil, fmt.Errorf("device is not pending approval")
+	}
+
+	if strings.TrimSpace(reason) == "" {
+		reason = defaultReason
+	}
+
+	return s.ChangeDeviceStatus(deviceID, status, reviewedBy, reason)
+}
+
+// ImportAllowlistEntries adds entries to the device allowlist, skipping duplicates of existing
+// entries, and reports how many were added
+func (s *DeviceService) ImportAllowlistEntries(entries []*models.AllowlistEntry) (int, error) {
+	added, err := s.registration.Allowlist.CreateAllowlistEntries(entries)
+	if err != nil {
+		s.logger.Error("Failed to import allowlist entries", "count", len(entries), "error", err)
+		return 0, fmt.Errorf("failed to import allowlist entries: %w", err)
+	}
+
+	s.logger.Info("Allowlist entries imported", "count", len(entries), "added", added)
+	return added, nil
+}
+
+// ListAllowlistEntries retrieves every device allowlist entry
+func (s *DeviceService) ListAllowlistEntries() ([]*models.AllowlistEntry, error) {
+	entries, err := s.registration.Allowlist.ListAllowlistEntries()
+	if err != nil {
+		s.logger.Error("Failed to list allowlist entries", "error", err)
+		return nil, fmt.Errorf("failed to list allowlist entries: %w", err)
+	}
+
+	return entries, nil
+}
+
+// DeleteAllowlistEntry removes a device allowlist entry. Devices it admitted stay registered.
+func (s *DeviceService) DeleteAllowlistEntry(entryID uuid.UUID) error {
+	if err := s.registration.Allowlist.DeleteAllowlistEntry(entryID); err != nil {
+		if err.Error() == "allowlist entry not found" {
+			return err
+		}
+		s.logger.Error("Failed to delete allowlist entry", "entry_id", entryID, "error", err)
+		return fmt.Errorf("failed to delete allowlist entry: %w", err)
+	}
+
+	s.logger.Info("Allowlist entry deleted", "entry_id", entryID)
+	return nil
+}
+
+// CheckCertificateDeviceStatus returns an error starting with "device is" when the device a
+// certificate belongs to may not authenticate in its current status, or when its identity
+// cannot be derived. Certificates of devices that are not registered yet are accepted. A
+// failed lookup is returned as an error, so that the certificate is refused.
+func (s *DeviceService) CheckCertificateDeviceStatus(certInfo *auth.CertificateInfo) error {
+	if certInfo == nil || !certInfo.IsValid {
+		return fmt.Errorf("invalid certificate information")
+	}
+
+	// Without its identity key, a suspended device could authenticate by serial number alone
+	identityKey, err := s.identity.Key(certInfo)
+	if err != nil {
+		return fmt.Errorf("device is not identifiable: %w", err)
+	}
+
+	device, err := s.findDevice(certInfo, identityKey)
+	if err != nil {
+		if err.Error() == "device not found" {
+			return nil
+		}
+		return fmt.Errorf("failed to check device status: %w", err)
+	}
+
+	return checkDeviceStatus(device)
+}
+
+// CheckDeviceStatus returns an error starting with "device is" when the device may not
+// authenticate in its current status
+func (s *DeviceService) CheckDeviceStatus(deviceID string) error {
+	id, err := uuid.Parse(deviceID)
+	if err != nil {
+		return fmt.Errorf("device is not registered")
+	}
+
+	device, err := s.deviceRepo.GetDeviceByID(id)
+	if err != nil {
+		if err.Error() == "device not found" {
+			return fmt.Errorf("device is not registered")
+		}
+		return fmt.Errorf("failed to check device status: %w", err)
+	}
+
+	return checkDeviceStatus(device)
+}
+
+// checkDeviceStatus returns an error when the device may not authenticate in its current status
+func checkDeviceStatus(device *models.Device) error {
+	if !device.Status.CanAuthenticate() {
+		return fmt.Errorf("device is %s", device.Status)
+	}
+	return nil
+}
+
 // CanUserAccessDevice checks if a user can access a specific device
 func (s *DeviceService) CanUserAccessDevice(deviceID uuid.UUID, userID string) (bool, error) {
 	isAssigned, err := s.assignmentRepo.IsDeviceAssignedToUser(deviceID, userID)
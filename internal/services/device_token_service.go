@@ -0,0 +1,53 @@
+package services
+
+import (
+	"fmt"
+	"time"
+
+	"device-assignment-api/internal/models"
+	"device-assignment-api/pkg/auth"
+	"device-assignment-api/pkg/logger"
+)
+
+// DeviceTokenService issues short-lived device access tokens to devices that authenticated
+// with their client certificate, so they can call device endpoints without a handshake.
+// With certificate binding, the tokens are only accepted together with that certificate.
+type DeviceTokenService struct {
+	jwtManager      *auth.JWTManager
+	tokenDuration   time.Duration
+	bindCertificate bool
+	logger          logger.Logger
+}
+
+// NewDeviceTokenService creates a new DeviceTokenService
+func NewDeviceTokenService(jwtManager *auth.JWTManager, tokenDuration time.Duration, bindCertificate bool, logger logger.Logger) *DeviceTokenService {
+	return &DeviceTokenService{
+		jwtManager:      jwtManager,
+		tokenDuration:   tokenDuration,
+		bindCertificate: bindCertificate,
+		logger:          logger,
+	}
+}
+
+// IssueToken issues a device access token carrying the device ID and the thumbprint of
+// the certificate the device authenticated with
+func (s *DeviceTokenService) IssueToken(device *models.Device, certInfo *auth.CertificateInfo) (*TokenResponse, error) {
+	var cnf *auth.Confirmation
+	if s.bindCertificate {
+		cnf = auth.NewCertificateConfirmation(certInfo.Thumbprint)
+	}
+
+	token, err := s.jwtManager.GenerateDeviceToken(device.ID.String(), certInfo.Fingerprint, cnf, s.tokenDuration)
+	if err != nil {
+		s.logger.Error("Failed to issue device token", "device_id", device.ID, "error", err)
+		return nil, fmt.Errorf("failed to issue device token: %w", err)
+	}
+
+	s.logger.Debug("Device token issued", "device_id", device.ID)
+
+	return &TokenResponse{
+		AccessToken: token,
+		TokenType:   "Bearer",
+		ExpiresIn:   int(s.tokenDuration.Seconds()),
+	}, nil
+}
@@ -0,0 +1,155 @@
+package services
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"device-assignment-api/internal/models"
+	"device-assignment-api/pkg/logger"
+
+	"github.com/google/uuid"
+)
+
+// heartbeatBatchSize bounds the number of heartbeats written by a single query. Reaching it
+// also triggers a flush before the next interval.
+const heartbeatBatchSize = 500
+
+// PresenceTracker coalesces device heartbeats in memory and writes them to the database in
+// batches, so that frequent heartbeats cost at most one row update per device and interval
+type PresenceTracker struct {
+	presenceRepo  models.DevicePresenceRepository
+	onlineTimeout time.Duration
+	flushInterval time.Duration
+	logger        logger.Logger
+
+	mu      sync.Mutex
+	pending map[uuid.UUID]*models.DeviceHeartbeat
+
+	flushNow chan struct{}
+	stop     chan struct{}
+	stopOnce sync.Once
+}
+
+// NewPresenceTracker creates a tracker flushing heartbeats every interval. Devices are online
+// while their last heartbeat is no older than the online timeout.
+func NewPresenceTracker(presenceRepo models.DevicePresenceRepository, onlineTimeout, flushInterval time.Duration, logger logger.Logger) *PresenceTracker {
+	return &PresenceTracker{
+		presenceRepo:  presenceRepo,
+		onlineTimeout: onlineTimeout,
+		flushInterval: flushInterval,
+		logger:        logger,
+		pending:       make(map[uuid.UUID]*models.DeviceHeartbeat),
+		flushNow:      make(chan struct{}, 1),
+		stop:          make(chan struct{}),
+	}
+}
+
+// Start flushes pending heartbeats every interval, or as soon as a full batch is pending,
+// until Stop is called
+func (t *PresenceTracker) Start() {
+	go func() {
+		ticker := time.NewTicker(t.flushInterval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ticker.C:
+				t.Flush()
+			case <-t.flushNow:
+				t.Flush()
+			case <-t.stop:
+				return
+			}
+		}
+	}()
+}
+
+// Stop halts the periodic flushes and writes the heartbeats still pending
+func (t *PresenceTracker) Stop() {
+	t.stopOnce.Do(func() {
+		close(t.stop)
+	})
+	t.Flush()
+}
+
+// Record queues a heartbeat, replacing any older heartbeat of the same device still pending
+func (t *PresenceTracker) Record(heartbeat *models.DeviceHeartbeat) {
+	t.mu.Lock()
+	if pending, ok := t.pending[heartbeat.DeviceID]; ok {
+		pending.Merge(heartbeat)
+	} else {
+		queued := *heartbeat
+		t.pending[heartbeat.DeviceID] = &queued
+	}
+	full := len(t.pending) >= heartbeatBatchSize
+	t.mu.Unlock()
+
+	if full {
+		select {
+		case t.flushNow <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// Flush writes every pending heartbeat in batches. Heartbeats that could not be written are
+// queued again for the next flush.
+func (t *PresenceTracker) Flush() error {
+	t.mu.Lock()
+	heartbeats := make([]*models.DeviceHeartbeat, 0, len(t.pending))
+	for _, heartbeat := range t.pending {
+		heartbeats = append(heartbeats, heartbeat)
+	}
+	t.pending = make(map[uuid.UUID]*models.DeviceHeartbeat)
+	t.mu.Unlock()
+
+	for start := 0; start < len(heartbeats); start += heartbeatBatchSize {
+		end := start + heartbeatBatchSize
+		if end > len(heartbeats) {
+			end = len(heartbeats)
+		}
+
+		if err := t.presenceRepo.RecordHeartbeats(heartbeats[start:end]); err != nil {
+			t.logger.Error("Failed to record device heartbeats", "count", len(heartbeats)-start, "error", err)
+			t.requeue(heartbeats[start:])
+			return fmt.Errorf("failed to record device heartbeats: %w", err)
+		}
+	}
+
+	if len(heartbeats) > 0 {
+		t.logger.Debug("Recorded device heartbeats", "count", len(heartbeats))
+	}
+	return nil
+}
+
+// requeue returns unwritten heartbeats to the pending set, merging them with any heartbeat
+// of the same device recorded since
+func (t *PresenceTracker) requeue(heartbeats []*models.DeviceHeartbeat) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	for _, heartbeat := range heartbeats {
+		if pending, ok := t.pending[heartbeat.DeviceID]; ok {
+			heartbeat.Merge(pending)
+		}
+		t.pending[heartbeat.DeviceID] = heartbeat
+	}
+}
+
+// apply records the device's pending heartbeat on it, so that reads reflect heartbeats not
+// yet written
+func (t *PresenceTracker) apply(device *models.Device) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if heartbeat, ok := t.pending[device.ID]; ok {
+		device.ApplyHeartbeat(heartbeat)
+	}
+}
+
+// annotate applies the device's pending heartbeat and derives whether it is online
+func (t *PresenceTracker) annotate(device *models.DeviceWithAssignment, now time.Time) {
+	t.apply(&device.Device)
+	device.SetOnline(now, t.onlineTimeout)
+}
@@ -0,0 +1,118 @@
+package services
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"device-assignment-api/internal/models"
+
+	"github.com/google/uuid"
+)
+
+// presenceRepository stores written heartbeats by device. The write numbered failOn (counting
+// from 1) fails after calling beforeFailure with the batch.
+type presenceRepository struct {
+	batches       []int
+	stored        map[uuid.UUID]models.DeviceHeartbeat
+	failOn        int
+	beforeFailure func(batch []*models.DeviceHeartbeat)
+}
+
+func newPresenceRepository() *presenceRepository {
+	return &presenceRepository{stored: make(map[uuid.UUID]models.DeviceHeartbeat)}
+}
+
+func (r *presenceRepository) RecordHeartbeats(heartbeats []*models.DeviceHeartbeat) error {
+	if len(r.batches)+1 == r.failOn {
+		r.failOn = 0
+		if r.beforeFailure != nil {
+			r.beforeFailure(heartbeats)
+		}
+		return fmt.Errorf("connection refused")
+	}
+
+	r.batches = append(r.batches, len(heartbeats))
+	for _, heartbeat := range heartbeats {
+		r.stored[heartbeat.DeviceID] = *heartbeat
+	}
+	return nil
+}
+
+// recordHeartbeats records a heartbeat for each of count new devices
+func recordHeartbeats(tracker *PresenceTracker, count int, seenAt time.Time) {
+	for i := 0; i < count; i++ {
+		heartbeat, _ := models.NewDeviceHeartbeat(uuid.New(), seenAt, "198.51.100.7", "charging")
+		tracker.Record(heartbeat)
+	}
+}
+
+func TestPresenceTrackerFlushSplitsBatches(t *testing.T) {
+	repo := newPresenceRepository()
+	tracker := NewPresenceTracker(repo, 5*time.Minute, time.Hour, testLogger())
+
+	recordHeartbeats(tracker, heartbeatBatchSize-1, time.Now())
+	if len(tracker.flushNow) != 0 {
+		t.Fatal("Expected no early flush before a full batch is pending")
+	}
+	recordHeartbeats(tracker, heartbeatBatchSize+202, time.Now())
+	if len(tracker.flushNow) != 1 {
+		t.Fatal("Expected a full batch to trigger an early flush")
+	}
+
+	if err := tracker.Flush(); err != nil {
+		t.Fatalf("Flush failed: %v", err)
+	}
+
+	expected := []int{heartbeatBatchSize, heartbeatBatchSize, 201}
+	if fmt.Sprint(repo.batches) != fmt.Sprint(expected) {
+		t.Errorf("Expected batches of %v, got %v", expected, repo.batches)
+	}
+	if len(repo.stored) != 2*heartbeatBatchSize+201 {
+		t.Errorf("Expected %d heartbeats to be written, got %d", 2*heartbeatBatchSize+201, len(repo.stored))
+	}
+	if len(tracker.pending) != 0 {
+		t.Errorf("Expected nothing to be pending after the flush, got %d heartbeats", len(tracker.pending))
+	}
+}
+
+func TestPresenceTrackerFlushRequeuesFailedBatches(t *testing.T) {
+	repo := newPresenceRepository()
+	tracker := NewPresenceTracker(repo, 5*time.Minute, time.Hour, testLogger())
+
+	seenAt := time.Now().Add(-time.Minute)
+	recordHeartbeats(tracker, heartbeatBatchSize+200, seenAt)
+
+	// A device in the failing batch sends a newer heartbeat without a status while the batch
+	// is being written
+	var merged uuid.UUID
+	later := seenAt.Add(30 * time.Second)
+	repo.failOn = 2
+	repo.beforeFailure = func(batch []*models.DeviceHeartbeat) {
+		merged = batch[0].DeviceID
+		heartbeat, _ := models.NewDeviceHeartbeat(merged, later, "203.0.113.9", "")
+		tracker.Record(heartbeat)
+	}
+
+	if err := tracker.Flush(); err == nil {
+		t.Fatal("Expected the flush to fail")
+	}
+	if len(repo.stored) != heartbeatBatchSize {
+		t.Fatalf("Expected the first batch to be written, got %d heartbeats", len(repo.stored))
+	}
+	if len(tracker.pending) != 200 {
+		t.Fatalf("Expected the failed batch to be pending again, got %d heartbeats", len(tracker.pending))
+	}
+
+	if err := tracker.Flush(); err != nil {
+		t.Fatalf("Retried flush failed: %v", err)
+	}
+	if len(repo.stored) != heartbeatBatchSize+200 {
+		t.Errorf("Expected every heartbeat to be written after the retry, got %d", len(repo.stored))
+	}
+
+	stored := repo.stored[merged]
+	if !stored.SeenAt.Equal(later.UTC()) || stored.SourceIP != "203.0.113.9" || stored.ReportedStatus != "charging" {
+		t.Errorf("Expected the requeued heartbeat to be merged with the newer one, got %+v", stored)
+	}
+}
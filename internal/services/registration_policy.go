@@ -0,0 +1,38 @@
+package services
+
+import (
+	"fmt"
+
+	"device-assignment-api/internal/models"
+	"device-assignment-api/pkg/auth"
+)
+
+// RegistrationPolicy decides whether a device authenticating for the first time may register,
+// and the status it registers in
+type RegistrationPolicy struct {
+	Mode models.RegistrationMode
+	// InitialStatus is the status devices register in outside approval mode: active, or
+	// provisioned to require activation by an administrator
+	InitialStatus models.DeviceStatus
+	// Allowlist holds the certificates admitted in allowlist mode
+	Allowlist models.DeviceAllowlistRepository
+}
+
+// admit returns the status an unknown device registers in. Devices the policy refuses are
+// reported with "device is not allowlisted".
+func (p RegistrationPolicy) admit(certInfo *auth.CertificateInfo) (models.DeviceStatus, error) {
+	switch p.Mode {
+	case models.RegistrationModeApproval:
+		return models.DeviceStatusPending, nil
+	case models.RegistrationModeAllowlist:
+		allowed, err := p.Allowlist.IsAllowlisted(certInfo.IssuerDN, certInfo.SerialNumber, certInfo.Fingerprint, certInfo.SPKIFingerprint)
+		if err != nil {
+			return "", fmt.Errorf("failed to check device allowlist: %w", err)
+		}
+		if !allowed {
+			return "", fmt.Errorf("device is not allowlisted")
+		}
+	}
+
+	return p.InitialStatus, nil
+}
@@ -0,0 +1,334 @@
+package services
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"time"
+
+	"device-assignment-api/internal/models"
+	"device-assignment-api/pkg/auth"
+	"device-assignment-api/pkg/logger"
+
+	"github.com/google/uuid"
+)
+
+// refreshTokenBytes is the amount of randomness in a refresh token
+const refreshTokenBytes = 32
+
+// TokenResponse is returned to a user who logged in or refreshed their tokens successfully,
+// and to a device that was issued a device access token (without a refresh token)
+type TokenResponse struct {
+	AccessToken  string `json:"access_token"`
+	TokenType    string `json:"token_type"`
+	ExpiresIn    int    `json:"expires_in"`
+	RefreshToken string `json:"refresh_token,omitempty"`
+}
+
+// ClientInfo describes the client a session is started or refreshed from
+type ClientInfo struct {
+	UserAgent string
+	IPAddress string
+	// CertificateThumbprint is the x5t#S256 thumbprint of the verified client certificate,
+	// empty when none was presented
+	CertificateThumbprint string
+}
+
+// SessionService issues short-lived access tokens paired with rotating refresh tokens and
+// handles revocation. Each login starts a session; refreshing replaces the session's refresh
+// token, and presenting a refresh token that was already used revokes the whole session.
+// With certificate binding, a session is bound to the client certificate it was started
+// with: its access tokens carry the certificate thumbprint and it can only be refreshed
+// with the same certificate.
+type SessionService struct {
+	sessionRepo          models.SessionRepository
+	userRepo             models.UserRepository
+	jwtManager           *auth.JWTManager
+	refreshTokenDuration time.Duration
+	bindCertificate      bool
+	logger               logger.Logger
+}
+
+// NewSessionService creates a new SessionService
+func NewSessionService(
+	sessionRepo models.SessionRepository,
+	userRepo models.UserRepository,
+	jwtManager *auth.JWTManager,
+	refreshTokenDuration time.Duration,
+	bindCertificate bool,
+	logger logger.Logger,
+) *SessionService {
+	return &SessionService{
+		sessionRepo:          sessionRepo,
+		userRepo:             userRepo,
+		jwtManager:           jwtManager,
+		refreshTokenDuration: refreshTokenDuration,
+		bindCertificate:      bindCertificate,
+		logger:               logger,
+	}
+}
+
+// StartSession starts a login session for an authenticated user and issues its first tokens
+func (s *SessionService) StartSession(user *models.User, client ClientInfo) (*TokenResponse, error) {
+	if s.bindCertificate && client.CertificateThumbprint == "" {
+		s.logger.Warn("Login rejected: no client certificate to bind tokens to", "user_id", user.ID)
+		return nil, fmt.Errorf("client certificate required")
+	}
+
+	session := models.NewSession(user.ID.String(), client.UserAgent, client.IPAddress, time.Now().Add(s.refreshTokenDuration))
+	if s.bindCertificate {
+		session.CertificateThumbprint = client.CertificateThumbprint
+	}
+
+	if err := s.sessionRepo.CreateSession(session); err != nil {
+		s.logger.Error("Failed to create session", "user_id", user.ID, "error", err)
+		return nil, fmt.Errorf("failed to create session: %w", err)
+	}
+
+	s.logger.Info("Session started", "user_id", user.ID, "session_id", session.ID)
+	return s.issueTokens(user, session)
+}
+
+// Refresh exchanges a refresh token for a new access token and refresh token. A refresh
+// token can be used once; reusing one means it was stolen, so the session is revoked.
+func (s *SessionService) Refresh(refreshToken string, client ClientInfo) (*TokenResponse, error) {
+	stored, err := s.sessionRepo.GetRefreshTokenByHash(hashRefreshToken(refreshToken))
+	if err != nil {
+		s.logger.Warn("Refresh failed: unknown refresh token")
+		return nil, fmt.Errorf("invalid refresh token")
+	}
+
+	session, err := s.sessionRepo.GetSession(stored.SessionID)
+	if err != nil || !session.IsActive() {
+		s.logger.Warn("Refresh failed: session is no longer active", "session_id", stored.SessionID)
+		return nil, fmt.Errorf("invalid refresh token")
+	}
+
+	if stored.UsedAt != nil {
+		s.revokeReusedSession(session)
+		return nil, fmt.Errorf("invalid refresh token")
+	}
+
+	if session.CertificateThumbprint != "" && session.CertificateThumbprint != client.CertificateThumbprint {
+		s.logger.Warn("Refresh failed: client certificate does not match the session", "session_id", session.ID)
+		return nil, fmt.Errorf("invalid refresh token")
+	}
+
+	if time.Now().After(stored.ExpiresAt) {
+		s.logger.Warn("Refresh failed: refresh token expired", "session_id", session.ID)
+		return nil, fmt.Errorf("invalid refresh token")
+	}
+
+	// Marking the token used is atomic, so of two concurrent refreshes only one succeeds
+	marked, err := s.sessionRepo.MarkRefreshTokenUsed(stored.ID)
+	if err != nil {
+		s.logger.Error("Failed to mark refresh token used", "session_id", session.ID, "error", err)
+		return nil, fmt.Errorf("failed to refresh token: %w", err)
+	}
+
+	if !marked {
+		s.revokeReusedSession(session)
+		return nil, fmt.Errorf("invalid refresh token")
+	}
+
+	userID, err := uuid.Parse(session.UserID)
+	if err != nil {
+		return nil, fmt.Errorf("invalid refresh token")
+	}
+
+	// Roles are read again so that role changes apply from the next refresh
+	user, err := s.userRepo.GetUserByID(userID)
+	if err != nil {
+		s.logger.Warn("Refresh failed: user no longer exists", "user_id", session.UserID)
+		return nil, fmt.Errorf("invalid refresh token")
+	}
+
+	tokens, err := s.issueTokens(user, session)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := s.sessionRepo.ExtendSession(session.ID, time.Now().Add(s.refreshTokenDuration)); err != nil {
+		s.logger.Error("Failed to extend session", "session_id", session.ID, "error", err)
+		return nil, fmt.Errorf("failed to refresh token: %w", err)
+	}
+
+	s.logger.Debug("Session refreshed", "user_id", user.ID, "session_id", session.ID)
+	return tokens, nil
+}
+
+// Logout revokes the session an access token belongs to and denylists the token itself
+func (s *SessionService) Logout(claims *auth.Claims) error {
+	if claims.SessionID != "" {
+		sessionID, err := uuid.Parse(claims.SessionID)
+		if err == nil {
+			err = s.sessionRepo.RevokeSession(sessionID)
+		}
+		if err != nil {
+			s.logger.Error("Failed to revoke session", "session_id", claims.SessionID, "error", err)
+			return fmt.Errorf("failed to revoke session: %w", err)
+		}
+	}
+
+	if err := s.revokeAccessToken(claims); err != nil {
+		return err
+	}
+
+	s.logger.Info("User logged out", "user_id", claims.UserID, "session_id", claims.SessionID)
+	return nil
+}
+
+// GetUserSessions returns a user's active sessions
+func (s *SessionService) GetUserSessions(userID string) ([]*models.Session, error) {
+	sessions, err := s.sessionRepo.GetActiveSessionsByUserID(userID)
+	if err != nil {
+		s.logger.Error("Failed to get user sessions", "user_id", userID, "error", err)
+		return nil, fmt.Errorf("failed to get sessions: %w", err)
+	}
+
+	return sessions, nil
+}
+
+// RevokeSession revokes one of a user's sessions. Sessions of other users are reported as
+// not found.
+func (s *SessionService) RevokeSession(userID, sessionID string) error {
+	id, err := uuid.Parse(sessionID)
+	if err != nil {
+		return fmt.Errorf("session not found")
+	}
+
+	session, err := s.sessionRepo.GetSession(id)
+	if err != nil || session.UserID != userID || !session.IsActive() {
+		return fmt.Errorf("session not found")
+	}
+
+	if err := s.sessionRepo.RevokeSession(session.ID); err != nil {
+		s.logger.Error("Failed to revoke session", "session_id", session.ID, "error", err)
+		return fmt.Errorf("failed to revoke session: %w", err)
+	}
+
+	s.logger.Info("Session revoked", "user_id", userID, "session_id", session.ID)
+	return nil
+}
+
+// RevokeOtherSessions revokes every session of a user except the current one, for example
+// after a password change. With no current session every session is revoked.
+func (s *SessionService) RevokeOtherSessions(userID, currentSessionID string) error {
+	// An unknown or missing current session keeps no session
+	currentID, _ := uuid.Parse(currentSessionID)
+
+	if err := s.sessionRepo.RevokeUserSessions(userID, currentID); err != nil {
+		s.logger.Error("Failed to revoke user sessions", "user_id", userID, "error", err)
+		return fmt.Errorf("failed to revoke sessions: %w", err)
+	}
+
+	s.logger.Info("Other sessions revoked", "user_id", userID, "current_session_id", currentSessionID)
+	return nil
+}
+
+// IsTokenRevoked implements auth.TokenDenylist. A token is revoked when its jti is
+// denylisted or when the session it belongs to is revoked or expired.
+func (s *SessionService) IsTokenRevoked(claims *auth.Claims) (bool, error) {
+	if claims.ID != "" {
+		revoked, err := s.sessionRepo.IsAccessTokenRevoked(claims.ID)
+		if err != nil || revoked {
+			return revoked, err
+		}
+	}
+
+	if claims.SessionID == "" {
+		return false, nil
+	}
+
+	sessionID, err := uuid.Parse(claims.SessionID)
+	if err != nil {
+		return true, nil
+	}
+
+	session, err := s.sessionRepo.GetSession(sessionID)
+	if err != nil {
+		if err.Error() == "session not found" {
+			return true, nil
+		}
+		return false, err
+	}
+
+	return !session.IsActive(), nil
+}
+
+// issueTokens issues an access token tied to the session, and bound to the session's client
+// certificate if it has one, and a new refresh token for the session
+func (s *SessionService) issueTokens(user *models.User, session *models.Session) (*TokenResponse, error) {
+	refreshToken, err := generateRefreshToken()
+	if err != nil {
+		s.logger.Error("Failed to generate refresh token", "error", err)
+		return nil, err
+	}
+
+	stored := models.NewRefreshToken(session.ID, hashRefreshToken(refreshToken), time.Now().Add(s.refreshTokenDuration))
+	if err := s.sessionRepo.CreateRefreshToken(stored); err != nil {
+		s.logger.Error("Failed to store refresh token", "session_id", session.ID, "error", err)
+		return nil, fmt.Errorf("failed to store refresh token: %w", err)
+	}
+
+	accessToken, err := s.jwtManager.GenerateSessionToken(
+		user.ID.String(),
+		user.Roles,
+		session.ID.String(),
+		auth.NewCertificateConfirmation(session.CertificateThumbprint),
+	)
+	if err != nil {
+		s.logger.Error("Failed to issue token", "user_id", user.ID, "error", err)
+		return nil, fmt.Errorf("failed to issue token: %w", err)
+	}
+
+	return &TokenResponse{
+		AccessToken:  accessToken,
+		TokenType:    "Bearer",
+		ExpiresIn:    int(s.jwtManager.TokenDuration().Seconds()),
+		RefreshToken: refreshToken,
+	}, nil
+}
+
+// revokeReusedSession revokes a session whose refresh token was presented a second time
+func (s *SessionService) revokeReusedSession(session *models.Session) {
+	s.logger.Warn("Refresh token reuse detected, revoking session",
+		"user_id", session.UserID,
+		"session_id", session.ID)
+
+	if err := s.sessionRepo.RevokeSession(session.ID); err != nil {
+		s.logger.Error("Failed to revoke session", "session_id", session.ID, "error", err)
+	}
+}
+
+// revokeAccessToken denylists an access token until it expires
+func (s *SessionService) revokeAccessToken(claims *auth.Claims) error {
+	if claims.ID == "" || claims.ExpiresAt == nil {
+		return nil
+	}
+
+	if err := s.sessionRepo.RevokeAccessToken(claims.ID, claims.ExpiresAt.Time); err != nil {
+		s.logger.Error("Failed to revoke access token", "user_id", claims.UserID, "error", err)
+		return fmt.Errorf("failed to revoke access token: %w", err)
+	}
+
+	return nil
+}
+
+// generateRefreshToken returns a random, URL-safe refresh token
+func generateRefreshToken() (string, error) {
+	buf := make([]byte, refreshTokenBytes)
+	if _, err := rand.Read(buf); err != nil {
+		return "", fmt.Errorf("failed to generate refresh token: %w", err)
+	}
+	return base64.RawURLEncoding.EncodeToString(buf), nil
+}
+
+// hashRefreshToken returns the hex SHA-256 hash under which a refresh token is stored.
+// Refresh tokens are high-entropy, so a fast unsalted hash is sufficient.
+func hashRefreshToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
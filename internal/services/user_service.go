@@ -0,0 +1,175 @@
+package services
+
+import (
+	"fmt"
+	"strings"
+
+	"device-assignment-api/internal/models"
+	"device-assignment-api/pkg/auth"
+	"device-assignment-api/pkg/logger"
+
+	"github.com/google/uuid"
+)
+
+// MinPasswordLength is the minimum length of a local user's password
+const MinPasswordLength = 12
+
+// UserService handles local user accounts, password login and token issuance
+type UserService struct {
+	userRepo  models.UserRepository
+	sessions  *SessionService
+	dummyHash string
+	logger    logger.Logger
+}
+
+// NewUserService creates a new UserService. Successful logins start a session with the
+// session service.
+func NewUserService(userRepo models.UserRepository, sessions *SessionService, logger logger.Logger) (*UserService, error) {
+	// Unknown usernames are checked against a dummy hash so that login timing does not
+	// reveal which usernames exist
+	dummyHash, err := auth.HashPassword(uuid.NewString())
+	if err != nil {
+		return nil, err
+	}
+
+	return &UserService{
+		userRepo:  userRepo,
+		sessions:  sessions,
+		dummyHash: dummyHash,
+		logger:    logger,
+	}, nil
+}
+
+// Login verifies a username and password and starts a session, issuing an access token
+// carrying the user's roles and a refresh token. The client's user agent and IP address are
+// recorded so the user can recognise the session later.
+func (s *UserService) Login(username, password string, client ClientInfo) (*TokenResponse, error) {
+	user, err := s.userRepo.GetUserByUsername(username)
+	if err != nil {
+		auth.VerifyPassword(s.dummyHash, password)
+		s.logger.Warn("Login failed: unknown user", "username", username)
+		return nil, fmt.Errorf("invalid credentials")
+	}
+
+	ok, err := auth.VerifyPassword(user.PasswordHash, password)
+	if err != nil {
+		s.logger.Error("Failed to verify password", "user_id", user.ID, "error", err)
+		return nil, fmt.Errorf("invalid credentials")
+	}
+
+	if !ok {
+		s.logger.Warn("Login failed: wrong password", "user_id", user.ID)
+		return nil, fmt.Errorf("invalid credentials")
+	}
+
+	tokens, err := s.sessions.StartSession(user, client)
+	if err != nil {
+		return nil, err
+	}
+
+	s.logger.Info("User logged in", "user_id", user.ID, "username", user.Username)
+	return tokens, nil
+}
+
+// CreateUser creates a local user account with the given roles
+func (s *UserService) CreateUser(username, password string, roles []string) (*models.User, error) {
+	username = strings.TrimSpace(username)
+	if username == "" {
+		return nil, fmt.Errorf("username is required")
+	}
+
+	if err := validatePassword(password); err != nil {
+		return nil, err
+	}
+
+	for _, role := range roles {
+		if !auth.IsValidRole(role) {
+			return nil, fmt.Errorf("unknown role %q", role)
+		}
+	}
+
+	hash, err := auth.HashPassword(password)
+	if err != nil {
+		s.logger.Error("Failed to hash password", "error", err)
+		return nil, fmt.Errorf("failed to hash password: %w", err)
+	}
+
+	user := models.NewUser(username, hash, roles)
+	if err := s.userRepo.CreateUser(user); err != nil {
+		s.logger.Warn("Failed to create user", "username", username, "error", err)
+		return nil, err
+	}
+
+	s.logger.Info("User created", "user_id", user.ID, "username", username, "roles", roles)
+	return user, nil
+}
+
+// ChangePassword replaces a user's password after verifying the current one, and signs out
+// every session of the user except the current one
+func (s *UserService) ChangePassword(userID, currentSessionID, currentPassword, newPassword string) error {
+	id, err := uuid.Parse(userID)
+	if err != nil {
+		return fmt.Errorf("user not found")
+	}
+
+	user, err := s.userRepo.GetUserByID(id)
+	if err != nil {
+		return fmt.Errorf("user not found")
+	}
+
+	ok, err := auth.VerifyPassword(user.PasswordHash, currentPassword)
+	if err != nil || !ok {
+		s.logger.Warn("Password change failed: wrong current password", "user_id", user.ID)
+		return fmt.Errorf("invalid credentials")
+	}
+
+	if err := validatePassword(newPassword); err != nil {
+		return err
+	}
+
+	hash, err := auth.HashPassword(newPassword)
+	if err != nil {
+		s.logger.Error("Failed to hash password", "error", err)
+		return fmt.Errorf("failed to hash password: %w", err)
+	}
+
+	if err := s.userRepo.UpdatePassword(user.ID, hash); err != nil {
+		s.logger.Error("Failed to update password", "user_id", user.ID, "error", err)
+		return fmt.Errorf("failed to update password: %w", err)
+	}
+
+	s.logger.Info("Password changed", "user_id", user.ID)
+
+	// Whoever knew the old password may hold other sessions
+	return s.sessions.RevokeOtherSessions(userID, currentSessionID)
+}
+
+// BootstrapAdmin creates the first user with the admin role. It does nothing once any
+// user exists, so the bootstrap credential cannot be used to take over a running deployment.
+func (s *UserService) BootstrapAdmin(username, password string) error {
+	count, err := s.userRepo.CountUsers()
+	if err != nil {
+		return err
+	}
+
+	if count > 0 {
+		s.logger.Debug("Users exist, skipping admin bootstrap")
+		return nil
+	}
+
+	user, err := s.CreateUser(username, password, []string{string(auth.RoleAdmin)})
+	if err != nil {
+		return fmt.Errorf("failed to bootstrap admin user: %w", err)
+	}
+
+	s.logger.Info("Bootstrapped admin user", "user_id", user.ID, "username", user.Username)
+	return nil
+}
+
+// validatePassword enforces the password policy
+func validatePassword(password string) error {
+	if len(password) < MinPasswordLength {
+		return fmt.Errorf("password must be at least %d characters", MinPasswordLength)
+	}
+	return nil
+}
@@ -0,0 +1,59 @@
+package auth
+
+import "strings"
+
+// Prefixes of the opaque API credentials, which tell them apart from JWTs and make leaked
+// credentials easy to recognise
+const (
+	PersonalAccessTokenPrefix = "dpat_"
+	ServiceAPIKeyPrefix       = "dsk_"
+)
+
+// Scope limits what an API credential may do. Each scope grants one permission, and only
+// routes registered with a scope accept API credentials.
+type Scope string
+
+// Scopes that can be granted to API credentials
+const (
+	ScopeDevicesRead           Scope = "devices:read"
+	ScopeDevicesWrite          Scope = "devices:write"
+	ScopeAssignmentsWrite      Scope = "assignments:write"
+	ScopeAdminDevicesRead      Scope = "admin:devices:read"
+	ScopeAdminAssignmentsWrite Scope = "admin:assignments:write"
+	ScopeAdminDevicesLifecycle Scope = "admin:devices:lifecycle"
+)
+
+// ScopePermissions maps each scope to the permission it grants
+var ScopePermissions = map[Scope]Permission{
+	ScopeDevicesRead:           PermissionDevicesRead,
+	ScopeDevicesWrite:          PermissionDevicesWrite,
+	ScopeAssignmentsWrite:      PermissionDevicesAssign,
+	ScopeAdminDevicesRead:      PermissionAdminDevicesRead,
+	ScopeAdminAssignmentsWrite: PermissionAdminDevicesAssign,
+	ScopeAdminDevicesLifecycle: PermissionAdminDevicesLifecycle,
+}
+
+// IsValidScope reports whether the scope is known
+func IsValidScope(scope string) bool {
+	_, ok := ScopePermissions[Scope(scope)]
+	return ok
+}
+
+// APICredential is a validated personal access token or service API key. Personal access
+// tokens act as their owner; service keys act as the service they were created for.
+type APICredential struct {
+	ID     string
+	UserID string
+	Roles  []string
+	Scopes []Scope
+}
+
+// APICredentialValidator validates an opaque API credential
+type APICredentialValidator interface {
+	ValidateAPICredential(token string) (*APICredential, error)
+}
+
+// IsAPICredential reports whether a bearer token is an API credential rather than a JWT
+func IsAPICredential(token string) bool {
+	return strings.HasPrefix(token, PersonalAccessTokenPrefix) || strings.HasPrefix(token, ServiceAPIKeyPrefix)
+}
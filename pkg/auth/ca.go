@@ -0,0 +1,178 @@
+package auth
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"math/big"
+	"os"
+	"time"
+)
+
+// serialNumberBits is the size of randomly generated certificate serial numbers
+const serialNumberBits = 128
+
+// clockSkewAllowance backdates issued certificates to tolerate device clock drift
+const clockSkewAllowance = 5 * time.Minute
+
+// CertificateAuthority signs device certificate requests with a configured issuing CA key
+type CertificateAuthority struct {
+	cert     *x509.Certificate
+	chain    []*x509.Certificate
+	signer   crypto.Signer
+	validity time.Duration
+}
+
+// LoadCertificateAuthority loads an issuing CA certificate (optionally followed by its chain)
+// and private key from PEM files
+func LoadCertificateAuthority(certFile, keyFile string, validity time.Duration) (*CertificateAuthority, error) {
+	certPEM, err := os.ReadFile(certFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read CA certificate: %w", err)
+	}
+
+	keyPEM, err := os.ReadFile(keyFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read CA private key: %w", err)
+	}
+
+	keyPair, err := tls.X509KeyPair(certPEM, keyPEM)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load CA key pair: %w", err)
+	}
+
+	signer, ok := keyPair.PrivateKey.(crypto.Signer)
+	if !ok {
+		return nil, fmt.Errorf("CA private key does not support signing")
+	}
+
+	var chain []*x509.Certificate
+	for _, der := range keyPair.Certificate {
+		cert, err := x509.ParseCertificate(der)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse CA certificate: %w", err)
+		}
+		chain = append(chain, cert)
+	}
+
+	return NewCertificateAuthority(chain, signer, validity)
+}
+
+// NewCertificateAuthority creates a certificate authority from an issuing certificate
+// chain (issuing certificate first) and its private key
+func NewCertificateAuthority(chain []*x509.Certificate, signer crypto.Signer, validity time.Duration) (*CertificateAuthority, error) {
+	if len(chain) == 0 {
+		return nil, fmt.Errorf("CA certificate is required")
+	}
+
+	cert := chain[0]
+	if !cert.IsCA {
+		return nil, fmt.Errorf("certificate %q is not a CA certificate", cert.Subject.String())
+	}
+
+	if validity <= 0 {
+		return nil, fmt.Errorf("certificate validity must be positive")
+	}
+
+	return &CertificateAuthority{
+		cert:     cert,
+		chain:    chain,
+		signer:   signer,
+		validity: validity,
+	}, nil
+}
+
+// Certificate returns the issuing CA certificate
+func (ca *CertificateAuthority) Certificate() *x509.Certificate {
+	return ca.cert
+}
+
+// Chain returns the issuing CA certificate followed by any intermediates it was loaded with
+func (ca *CertificateAuthority) Chain() []*x509.Certificate {
+	return ca.chain
+}
+
+// RequestInfo returns the information of the certificate SignCSR would issue for a request,
+// so that the request can be checked before anything is signed. The serial number, the
+// certificate fingerprints and the validity are only known once the certificate is issued.
+func (ca *CertificateAuthority) RequestInfo(csr *x509.CertificateRequest) (*CertificateInfo, error) {
+	if err := checkCSR(csr); err != nil {
+		return nil, err
+	}
+
+	return &CertificateInfo{
+		IssuerDN:        ca.cert.Subject.String(),
+		IssuerCN:        extractCommonName(ca.cert.Subject.CommonName),
+		AuthorityKeyID:  fmt.Sprintf("%X", ca.cert.SubjectKeyId),
+		SubjectDN:       csr.Subject.String(),
+		SubjectCN:       extractCommonName(csr.Subject.CommonName),
+		DNSNames:        csr.DNSNames,
+		EmailAddresses:  csr.EmailAddresses,
+		URIs:            []string{},
+		SPKIFingerprint: fingerprint(csr.RawSubjectPublicKeyInfo),
+		IsValid:         true,
+	}, nil
+}
+
+// SignCSR issues a client authentication certificate for a verified certificate request.
+// The subject and the DNS and email subject alternative names are copied from the request.
+func (ca *CertificateAuthority) SignCSR(csr *x509.CertificateRequest) (*x509.Certificate, error) {
+	if err := checkCSR(csr); err != nil {
+		return nil, err
+	}
+
+	serialNumber, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), serialNumberBits))
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate serial number: %w", err)
+	}
+
+	now := time.Now()
+	notAfter := now.Add(ca.validity)
+	if notAfter.After(ca.cert.NotAfter) {
+		notAfter = ca.cert.NotAfter
+	}
+
+	template := &x509.Certificate{
+		SerialNumber:   serialNumber,
+		Subject:        csr.Subject,
+		DNSNames:       csr.DNSNames,
+		EmailAddresses: csr.EmailAddresses,
+		NotBefore:      now.Add(-clockSkewAllowance),
+		NotAfter:       notAfter,
+		KeyUsage:       x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:    []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, ca.cert, csr.PublicKey, ca.signer)
+	if err != nil {
+		return nil, fmt.Errorf("failed to sign certificate: %w", err)
+	}
+
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse issued certificate: %w", err)
+	}
+
+	return cert, nil
+}
+
+// checkCSR verifies a certificate request's signature and that it only asks for the subject
+// alternative names the CA issues. URI SANs are refused because they carry workload identities
+// such as SPIFFE IDs, which the device CA must not vouch for; IP address SANs identify no device.
+func checkCSR(csr *x509.CertificateRequest) error {
+	if err := csr.CheckSignature(); err != nil {
+		return fmt.Errorf("invalid certificate request signature: %w", err)
+	}
+
+	if len(csr.URIs) > 0 {
+		return fmt.Errorf("URI subject alternative names are not allowed")
+	}
+
+	if len(csr.IPAddresses) > 0 {
+		return fmt.Errorf("IP address subject alternative names are not allowed")
+	}
+
+	return nil
+}
@@ -0,0 +1,161 @@
+package auth
+
+import (
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"net"
+	"net/url"
+	"testing"
+	"time"
+)
+
+func newTestCSR(t *testing.T, commonName string) *x509.CertificateRequest {
+	t.Helper()
+
+	der, err := x509.CreateCertificateRequest(rand.Reader, &x509.CertificateRequest{
+		Subject:  pkix.Name{CommonName: commonName},
+		DNSNames: []string{commonName + ".devices.example.com"},
+	}, newTestKey(t))
+	if err != nil {
+		t.Fatalf("Failed to create CSR: %v", err)
+	}
+
+	csr, err := x509.ParseCertificateRequest(der)
+	if err != nil {
+		t.Fatalf("Failed to parse CSR: %v", err)
+	}
+	return csr
+}
+
+func TestCertificateAuthoritySignCSR(t *testing.T) {
+	testCA := newTestCA(t, "Device CA")
+	ca, err := NewCertificateAuthority([]*x509.Certificate{testCA.cert}, testCA.key, 30*24*time.Hour)
+	if err != nil {
+		t.Fatalf("Failed to create certificate authority: %v", err)
+	}
+
+	cert, err := ca.SignCSR(newTestCSR(t, "device-1"))
+	if err != nil {
+		t.Fatalf("Failed to sign CSR: %v", err)
+	}
+
+	roots := x509.NewCertPool()
+	roots.AddCert(testCA.cert)
+	if _, err := cert.Verify(x509.VerifyOptions{
+		Roots:     roots,
+		KeyUsages: []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}); err != nil {
+		t.Errorf("Expected issued certificate to verify for client auth, got %v", err)
+	}
+
+	if cert.Subject.CommonName != "device-1" {
+		t.Errorf("Expected subject CN device-1, got %s", cert.Subject.CommonName)
+	}
+
+	if len(cert.DNSNames) != 1 || cert.DNSNames[0] != "device-1.devices.example.com" {
+		t.Errorf("Expected DNS SAN to be copied from the CSR, got %v", cert.DNSNames)
+	}
+
+	// Validity is capped at the CA's own expiry
+	if cert.NotAfter.After(testCA.cert.NotAfter) {
+		t.Errorf("Expected certificate to expire no later than the CA, got %s", cert.NotAfter)
+	}
+}
+
+func TestCertificateAuthorityRequestInfo(t *testing.T) {
+	testCA := newTestCA(t, "Device CA")
+	ca, err := NewCertificateAuthority([]*x509.Certificate{testCA.cert}, testCA.key, time.Hour)
+	if err != nil {
+		t.Fatalf("Failed to create certificate authority: %v", err)
+	}
+
+	csr := newTestCSR(t, "device-1")
+	requested, err := ca.RequestInfo(csr)
+	if err != nil {
+		t.Fatalf("Failed to get request info: %v", err)
+	}
+
+	cert, err := ca.SignCSR(csr)
+	if err != nil {
+		t.Fatalf("Failed to sign CSR: %v", err)
+	}
+	issued := ExtractCertificateInfo(cert)
+
+	if requested.IssuerDN != issued.IssuerDN || requested.IssuerCN != issued.IssuerCN ||
+		requested.AuthorityKeyID != issued.AuthorityKeyID || requested.SubjectDN != issued.SubjectDN ||
+		requested.SubjectCN != issued.SubjectCN || requested.SPKIFingerprint != issued.SPKIFingerprint ||
+		len(requested.DNSNames) != 1 || requested.DNSNames[0] != issued.DNSNames[0] {
+		t.Errorf("Expected request info %+v to describe the issued certificate %+v", requested, issued)
+	}
+}
+
+func TestCertificateAuthorityRefusesSANs(t *testing.T) {
+	testCA := newTestCA(t, "Device CA")
+	ca, err := NewCertificateAuthority([]*x509.Certificate{testCA.cert}, testCA.key, time.Hour)
+	if err != nil {
+		t.Fatalf("Failed to create certificate authority: %v", err)
+	}
+
+	spiffeID, _ := url.Parse("spiffe://devices.example.com/device/1")
+	tests := []struct {
+		name     string
+		template *x509.CertificateRequest
+	}{
+		{"URI SAN", &x509.CertificateRequest{Subject: pkix.Name{CommonName: "device-1"}, URIs: []*url.URL{spiffeID}}},
+		{"IP address SAN", &x509.CertificateRequest{Subject: pkix.Name{CommonName: "device-1"}, IPAddresses: []net.IP{net.ParseIP("10.0.0.1")}}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			der, err := x509.CreateCertificateRequest(rand.Reader, tt.template, newTestKey(t))
+			if err != nil {
+				t.Fatalf("Failed to create CSR: %v", err)
+			}
+			csr, _ := x509.ParseCertificateRequest(der)
+
+			if _, err := ca.RequestInfo(csr); err == nil {
+				t.Error("Expected the request to be refused")
+			}
+			if _, err := ca.SignCSR(csr); err == nil {
+				t.Error("Expected the CA to refuse to sign the request")
+			}
+		})
+	}
+}
+
+func TestCertificateAuthorityRejectsNonCA(t *testing.T) {
+	testCA := newTestCA(t, "Device CA")
+	leaf, _ := testCA.issueClient(t, 5, "device")
+
+	if _, err := NewCertificateAuthority([]*x509.Certificate{leaf}, testCA.key, time.Hour); err == nil {
+		t.Error("Expected a non-CA certificate to be rejected")
+	}
+}
+
+func TestCertsOnlyPKCS7RoundTrip(t *testing.T) {
+	testCA := newTestCA(t, "Device CA")
+	leaf, _ := testCA.issueClient(t, 6, "device")
+
+	encoded, err := EncodeCertsOnlyPKCS7([][]byte{leaf.Raw, testCA.cert.Raw})
+	if err != nil {
+		t.Fatalf("Failed to encode PKCS#7: %v", err)
+	}
+
+	certs, err := DecodeCertsOnlyPKCS7(encoded)
+	if err != nil {
+		t.Fatalf("Failed to decode PKCS#7: %v", err)
+	}
+
+	if len(certs) != 2 {
+		t.Fatalf("Expected 2 certificates, got %d", len(certs))
+	}
+
+	parsed, err := x509.ParseCertificate(certs[0])
+	if err != nil {
+		t.Fatalf("Failed to parse decoded certificate: %v", err)
+	}
+	if !parsed.Equal(leaf) {
+		t.Error("Expected decoded certificate to match the original")
+	}
+}
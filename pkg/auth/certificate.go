@@ -1,17 +1,34 @@
 package auth
 
 import (
+	"crypto/sha256"
 	"crypto/x509"
+	"encoding/hex"
+	"encoding/pem"
 	"fmt"
 	"math/big"
+	"os"
+	"time"
 )
 
 // CertificateInfo holds extracted information from a client certificate
 type CertificateInfo struct {
-	SerialNumber string
-	IssuerCN     string
-	SubjectCN    string
-	IsValid      bool
+	SerialNumber    string
+	IssuerDN        string
+	IssuerCN        string
+	AuthorityKeyID  string
+	SubjectDN       string
+	SubjectCN       string
+	DNSNames        []string
+	EmailAddresses  []string
+	URIs            []string
+	SPIFFEID        string
+	Fingerprint     string
+	Thumbprint      string
+	SPKIFingerprint string
+	NotBefore       time.Time
+	NotAfter        time.Time
+	IsValid         bool
 }
 
 // ExtractCertificateInfo extracts relevant information from an X.509 certificate
@@ -20,15 +37,33 @@ func ExtractCertificateInfo(cert *x509.Certificate) *CertificateInfo {
 		return &CertificateInfo{IsValid: false}
 	}
 
+	uris := make([]string, 0, len(cert.URIs))
+	for _, uri := range cert.URIs {
+		uris = append(uris, uri.String())
+	}
+
 	return &CertificateInfo{
-		SerialNumber: formatSerialNumber(cert.SerialNumber),
-		IssuerCN:     extractCommonName(cert.Issuer.CommonName),
-		SubjectCN:    extractCommonName(cert.Subject.CommonName),
-		IsValid:      true,
+		SerialNumber:    formatSerialNumber(cert.SerialNumber),
+		IssuerDN:        cert.Issuer.String(),
+		IssuerCN:        extractCommonName(cert.Issuer.CommonName),
+		AuthorityKeyID:  fmt.Sprintf("%X", cert.AuthorityKeyId),
+		SubjectDN:       cert.Subject.String(),
+		SubjectCN:       extractCommonName(cert.Subject.CommonName),
+		DNSNames:        cert.DNSNames,
+		EmailAddresses:  cert.EmailAddresses,
+		URIs:            uris,
+		SPIFFEID:        CertificateSPIFFEID(cert),
+		Fingerprint:     fingerprint(cert.Raw),
+		Thumbprint:      CertificateThumbprint(cert),
+		SPKIFingerprint: fingerprint(cert.RawSubjectPublicKeyInfo),
+		NotBefore:       cert.NotBefore,
+		NotAfter:        cert.NotAfter,
+		IsValid:         true,
 	}
 }
 
-// ValidateCertificate performs basic validation on the certificate
+// ValidateCertificate performs basic validation on the certificate. Deployment-specific
+// admission rules are enforced by a PolicyEngine.
 func ValidateCertificate(cert *x509.Certificate) error {
 	if cert == nil {
 		return fmt.Errorf("certificate is nil")
@@ -38,13 +73,12 @@ func ValidateCertificate(cert *x509.Certificate) error {
 		return fmt.Errorf("certificate serial number is missing")
 	}
 
-	if cert.Issuer.CommonName == "" {
+	// Workload identities (X.509-SVIDs) are identified by their SPIFFE ID, and their issuing
+	// CAs often have no common name
+	if cert.Issuer.CommonName == "" && CertificateSPIFFEID(cert) == "" {
 		return fmt.Errorf("certificate issuer common name is missing")
 	}
 
-	// Additional validation can be added here based on requirements
-	// For example: checking certificate validity period, key usage, etc.
-
 	return nil
 }
 
@@ -57,9 +91,47 @@ func formatSerialNumber(serialNumber *big.Int) string {
 	return fmt.Sprintf("%X", serialNumber)
 }
 
+// fingerprint returns the lowercase hex SHA-256 digest of DER-encoded data
+func fingerprint(der []byte) string {
+	sum := sha256.Sum256(der)
+	return hex.EncodeToString(sum[:])
+}
+
 // extractCommonName extracts and cleans the common name from certificate subject/issuer
 func extractCommonName(cn string) string {
 	// Basic cleaning - remove any leading/trailing whitespace
 	// Additional validation/sanitization can be added as needed
 	return cn
 }
+
+// LoadCertificates reads all PEM-encoded certificates from a file
+func LoadCertificates(path string) ([]*x509.Certificate, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read certificate file: %w", err)
+	}
+
+	var certs []*x509.Certificate
+	for {
+		var block *pem.Block
+		block, data = pem.Decode(data)
+		if block == nil {
+			break
+		}
+		if block.Type != "CERTIFICATE" {
+			continue
+		}
+
+		cert, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse certificate: %w", err)
+		}
+		certs = append(certs, cert)
+	}
+
+	if len(certs) == 0 {
+		return nil, fmt.Errorf("no certificates found in %s", path)
+	}
+
+	return certs, nil
+}
@@ -0,0 +1,403 @@
+package auth
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/asn1"
+	"encoding/json"
+	"fmt"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// PolicyRule names a rule of a certificate policy. Rejections are reported and counted per rule.
+type PolicyRule string
+
+// Rules of a certificate policy
+const (
+	PolicyRuleIssuer             PolicyRule = "issuer"
+	PolicyRuleExtKeyUsage        PolicyRule = "ext_key_usage"
+	PolicyRuleKeyUsage           PolicyRule = "key_usage"
+	PolicyRuleKeySize            PolicyRule = "key_size"
+	PolicyRuleSignatureAlgorithm PolicyRule = "signature_algorithm"
+	PolicyRuleSubject            PolicyRule = "subject"
+	PolicyRuleSAN                PolicyRule = "san"
+	PolicyRuleValidity           PolicyRule = "validity"
+	PolicyRuleRequiredOID        PolicyRule = "required_oid"
+)
+
+// CertificatePolicy declares which client certificates are admitted as devices. Empty
+// fields are not checked. Patterns must match the whole value.
+type CertificatePolicy struct {
+	// AllowedIssuers lists the issuer DNs (e.g. "CN=Device CA,O=Example") or issuer common
+	// names that may issue device certificates
+	AllowedIssuers []string `json:"allowed_issuers,omitempty"`
+	// RequiredExtKeyUsages lists extended key usages the certificate must carry, e.g. "clientAuth"
+	RequiredExtKeyUsages []string `json:"required_ext_key_usages,omitempty"`
+	// RequiredKeyUsages lists key usages the certificate must carry, e.g. "digitalSignature"
+	RequiredKeyUsages []string `json:"required_key_usages,omitempty"`
+	// MinRSAKeyBits is the minimum RSA modulus size
+	MinRSAKeyBits int `json:"min_rsa_key_bits,omitempty"`
+	// MinECKeyBits is the minimum size of the curve of an ECDSA key
+	MinECKeyBits int `json:"min_ec_key_bits,omitempty"`
+	// AllowedSignatureAlgorithms lists the signature algorithms the certificate may be
+	// signed with, named as by Go's crypto/x509 (e.g. "SHA256-RSA", "ECDSA-SHA256")
+	AllowedSignatureAlgorithms []string `json:"allowed_signature_algorithms,omitempty"`
+	// SubjectCNPattern is a regular expression the subject common name must match
+	SubjectCNPattern string `json:"subject_cn_pattern,omitempty"`
+	// SANPattern is a regular expression at least one DNS, email or URI SAN must match
+	SANPattern string `json:"san_pattern,omitempty"`
+	// MaxValidityDays is the longest validity period (NotBefore to NotAfter) accepted
+	MaxValidityDays int `json:"max_validity_days,omitempty"`
+	// RequiredOIDs lists extension OIDs (dotted notation) the certificate must carry
+	RequiredOIDs []string `json:"required_oids,omitempty"`
+}
+
+// LoadCertificatePolicy reads a certificate policy from a JSON file. Unknown fields are
+// rejected so that misspelled rules do not silently go unchecked.
+func LoadCertificatePolicy(path string) (*CertificatePolicy, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read certificate policy: %w", err)
+	}
+
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.DisallowUnknownFields()
+
+	var policy CertificatePolicy
+	if err := decoder.Decode(&policy); err != nil {
+		return nil, fmt.Errorf("failed to parse certificate policy: %w", err)
+	}
+
+	return &policy, nil
+}
+
+// PolicyViolation is one reason a certificate was rejected
+type PolicyViolation struct {
+	Rule   PolicyRule `json:"rule"`
+	Detail string     `json:"detail"`
+}
+
+// PolicyRejection is returned when a certificate violates the policy. It lists every
+// violated rule, not just the first.
+type PolicyRejection struct {
+	Violations []PolicyViolation
+}
+
+// Error implements error
+func (r *PolicyRejection) Error() string {
+	reasons := make([]string, 0, len(r.Violations))
+	for _, violation := range r.Violations {
+		reasons = append(reasons, fmt.Sprintf("%s: %s", violation.Rule, violation.Detail))
+	}
+	return "certificate rejected by policy: " + strings.Join(reasons, "; ")
+}
+
+// Rules returns the violated rules
+func (r *PolicyRejection) Rules() []PolicyRule {
+	rules := make([]PolicyRule, 0, len(r.Violations))
+	for _, violation := range r.Violations {
+		rules = append(rules, violation.Rule)
+	}
+	return rules
+}
+
+// PolicyStats counts the certificates a policy engine evaluated and rejected, and how often
+// each rule was violated
+type PolicyStats struct {
+	Evaluated  uint64                `json:"evaluated"`
+	Rejected   uint64                `json:"rejected"`
+	Violations map[PolicyRule]uint64 `json:"violations"`
+}
+
+// extKeyUsageNames maps policy names to extended key usages
+var extKeyUsageNames = map[string]x509.ExtKeyUsage{
+	"any":             x509.ExtKeyUsageAny,
+	"serverAuth":      x509.ExtKeyUsageServerAuth,
+	"clientAuth":      x509.ExtKeyUsageClientAuth,
+	"codeSigning":     x509.ExtKeyUsageCodeSigning,
+	"emailProtection": x509.ExtKeyUsageEmailProtection,
+	"timeStamping":    x509.ExtKeyUsageTimeStamping,
+	"ocspSigning":     x509.ExtKeyUsageOCSPSigning,
+}
+
+// keyUsageNames maps policy names to key usages
+var keyUsageNames = map[string]x509.KeyUsage{
+	"digitalSignature":  x509.KeyUsageDigitalSignature,
+	"contentCommitment": x509.KeyUsageContentCommitment,
+	"keyEncipherment":   x509.KeyUsageKeyEncipherment,
+	"dataEncipherment":  x509.KeyUsageDataEncipherment,
+	"keyAgreement":      x509.KeyUsageKeyAgreement,
+	"keyCertSign":       x509.KeyUsageCertSign,
+	"cRLSign":           x509.KeyUsageCRLSign,
+	"encipherOnly":      x509.KeyUsageEncipherOnly,
+	"decipherOnly":      x509.KeyUsageDecipherOnly,
+}
+
+// PolicyEngine evaluates client certificates against a certificate policy and counts the
+// rejections. It is safe for concurrent use.
+type PolicyEngine struct {
+	policy              *CertificatePolicy
+	issuers             map[string]bool
+	extKeyUsages        map[string]x509.ExtKeyUsage
+	keyUsage            x509.KeyUsage
+	signatureAlgorithms map[x509.SignatureAlgorithm]bool
+	subjectCN           *regexp.Regexp
+	san                 *regexp.Regexp
+	requiredOIDs        []asn1.ObjectIdentifier
+
+	mu    sync.Mutex
+	stats PolicyStats
+}
+
+// NewPolicyEngine validates a certificate policy and prepares it for evaluation
+func NewPolicyEngine(policy *CertificatePolicy) (*PolicyEngine, error) {
+	engine := &PolicyEngine{
+		policy:       policy,
+		issuers:      make(map[string]bool),
+		extKeyUsages: make(map[string]x509.ExtKeyUsage),
+		stats:        PolicyStats{Violations: make(map[PolicyRule]uint64)},
+	}
+
+	for _, issuer := range policy.AllowedIssuers {
+		engine.issuers[issuer] = true
+	}
+
+	for _, name := range policy.RequiredExtKeyUsages {
+		usage, ok := extKeyUsageNames[name]
+		if !ok {
+			return nil, fmt.Errorf("unknown extended key usage %q", name)
+		}
+		engine.extKeyUsages[name] = usage
+	}
+
+	for _, name := range policy.RequiredKeyUsages {
+		usage, ok := keyUsageNames[name]
+		if !ok {
+			return nil, fmt.Errorf("unknown key usage %q", name)
+		}
+		engine.keyUsage |= usage
+	}
+
+	if policy.MinRSAKeyBits < 0 || policy.MinECKeyBits < 0 || policy.MaxValidityDays < 0 {
+		return nil, fmt.Errorf("key sizes and validity period must not be negative")
+	}
+
+	if len(policy.AllowedSignatureAlgorithms) > 0 {
+		known := make(map[string]x509.SignatureAlgorithm)
+		for algorithm := x509.MD2WithRSA; algorithm <= x509.PureEd25519; algorithm++ {
+			known[algorithm.String()] = algorithm
+		}
+
+		engine.signatureAlgorithms = make(map[x509.SignatureAlgorithm]bool)
+		for _, name := range policy.AllowedSignatureAlgorithms {
+			algorithm, ok := known[name]
+			if !ok {
+				return nil, fmt.Errorf("unknown signature algorithm %q", name)
+			}
+			engine.signatureAlgorithms[algorithm] = true
+		}
+	}
+
+	var err error
+	if engine.subjectCN, err = compilePolicyPattern(policy.SubjectCNPattern); err != nil {
+		return nil, fmt.Errorf("invalid subject CN pattern: %w", err)
+	}
+	if engine.san, err = compilePolicyPattern(policy.SANPattern); err != nil {
+		return nil, fmt.Errorf("invalid SAN pattern: %w", err)
+	}
+
+	for _, dotted := range policy.RequiredOIDs {
+		oid, err := parseOID(dotted)
+		if err != nil {
+			return nil, err
+		}
+		engine.requiredOIDs = append(engine.requiredOIDs, oid)
+	}
+
+	return engine, nil
+}
+
+// Policy returns the policy the engine evaluates
+func (e *PolicyEngine) Policy() *CertificatePolicy {
+	return e.policy
+}
+
+// Stats returns a snapshot of the evaluation counters
+func (e *PolicyEngine) Stats() PolicyStats {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	stats := e.stats
+	stats.Violations = make(map[PolicyRule]uint64, len(e.stats.Violations))
+	for rule, count := range e.stats.Violations {
+		stats.Violations[rule] = count
+	}
+	return stats
+}
+
+// Evaluate checks a certificate against every rule of the policy, returning a
+// *PolicyRejection listing all violations, or nil if the certificate is admitted
+func (e *PolicyEngine) Evaluate(cert *x509.Certificate) error {
+	var violations []PolicyViolation
+	violate := func(rule PolicyRule, format string, args ...interface{}) {
+		violations = append(violations, PolicyViolation{Rule: rule, Detail: fmt.Sprintf(format, args...)})
+	}
+
+	if len(e.issuers) > 0 && !e.issuers[cert.Issuer.String()] && !e.issuers[cert.Issuer.CommonName] {
+		violate(PolicyRuleIssuer, "issuer %q is not allowed", cert.Issuer.String())
+	}
+
+	for _, name := range e.policy.RequiredExtKeyUsages {
+		if !hasExtKeyUsage(cert, e.extKeyUsages[name]) {
+			violate(PolicyRuleExtKeyUsage, "extended key usage %s is missing", name)
+		}
+	}
+
+	if cert.KeyUsage&e.keyUsage != e.keyUsage {
+		violate(PolicyRuleKeyUsage, "key usage %s is missing", strings.Join(e.policy.RequiredKeyUsages, ", "))
+	}
+
+	e.checkKeySize(cert, violate)
+
+	if e.signatureAlgorithms != nil && !e.signatureAlgorithms[cert.SignatureAlgorithm] {
+		violate(PolicyRuleSignatureAlgorithm, "signature algorithm %s is not allowed", cert.SignatureAlgorithm)
+	}
+
+	if e.subjectCN != nil && !e.subjectCN.MatchString(cert.Subject.CommonName) {
+		violate(PolicyRuleSubject, "subject CN %q does not match the pattern", cert.Subject.CommonName)
+	}
+
+	if e.san != nil && !e.matchesSAN(cert) {
+		violate(PolicyRuleSAN, "no subject alternative name matches the pattern")
+	}
+
+	if maxValidity := time.Duration(e.policy.MaxValidityDays) * 24 * time.Hour; maxValidity > 0 {
+		if validity := cert.NotAfter.Sub(cert.NotBefore); validity > maxValidity {
+			violate(PolicyRuleValidity, "validity period of %d days exceeds %d days", int(validity.Hours()/24), e.policy.MaxValidityDays)
+		}
+	}
+
+	for _, oid := range e.requiredOIDs {
+		if !hasExtension(cert, oid) {
+			violate(PolicyRuleRequiredOID, "extension %s is missing", oid)
+		}
+	}
+
+	e.record(violations)
+
+	if len(violations) > 0 {
+		return &PolicyRejection{Violations: violations}
+	}
+	return nil
+}
+
+// checkKeySize checks the public key against the minimum RSA and EC key sizes
+func (e *PolicyEngine) checkKeySize(cert *x509.Certificate, violate func(PolicyRule, string, ...interface{})) {
+	switch key := cert.PublicKey.(type) {
+	case *rsa.PublicKey:
+		if bits := key.N.BitLen(); bits < e.policy.MinRSAKeyBits {
+			violate(PolicyRuleKeySize, "RSA key of %d bits is below %d bits", bits, e.policy.MinRSAKeyBits)
+		}
+	case *ecdsa.PublicKey:
+		if bits := key.Curve.Params().BitSize; bits < e.policy.MinECKeyBits {
+			violate(PolicyRuleKeySize, "EC key of %d bits is below %d bits", bits, e.policy.MinECKeyBits)
+		}
+	case ed25519.PublicKey:
+		// Ed25519 keys have a fixed size
+	default:
+		if e.policy.MinRSAKeyBits > 0 || e.policy.MinECKeyBits > 0 {
+			violate(PolicyRuleKeySize, "unsupported public key type %T", cert.PublicKey)
+		}
+	}
+}
+
+// matchesSAN reports whether any DNS, email or URI SAN matches the SAN pattern
+func (e *PolicyEngine) matchesSAN(cert *x509.Certificate) bool {
+	for _, name := range cert.DNSNames {
+		if e.san.MatchString(name) {
+			return true
+		}
+	}
+	for _, email := range cert.EmailAddresses {
+		if e.san.MatchString(email) {
+			return true
+		}
+	}
+	for _, uri := range cert.URIs {
+		if e.san.MatchString(uri.String()) {
+			return true
+		}
+	}
+	return false
+}
+
+// record counts an evaluation and its violations
+func (e *PolicyEngine) record(violations []PolicyViolation) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	e.stats.Evaluated++
+	if len(violations) > 0 {
+		e.stats.Rejected++
+	}
+	for _, violation := range violations {
+		e.stats.Violations[violation.Rule]++
+	}
+}
+
+// compilePolicyPattern compiles a pattern that must match the whole value, or returns nil
+// for an empty pattern
+func compilePolicyPattern(pattern string) (*regexp.Regexp, error) {
+	if pattern == "" {
+		return nil, nil
+	}
+	return regexp.Compile("^(?:" + pattern + ")$")
+}
+
+// parseOID parses an OID in dotted notation
+func parseOID(dotted string) (asn1.ObjectIdentifier, error) {
+	parts := strings.Split(dotted, ".")
+	if len(parts) < 2 {
+		return nil, fmt.Errorf("invalid OID %q", dotted)
+	}
+
+	oid := make(asn1.ObjectIdentifier, 0, len(parts))
+	for _, part := range parts {
+		arc, err := strconv.Atoi(part)
+		if err != nil || arc < 0 || strconv.Itoa(arc) != part {
+			return nil, fmt.Errorf("invalid OID %q", dotted)
+		}
+		oid = append(oid, arc)
+	}
+
+	return oid, nil
+}
+
+// hasExtKeyUsage reports whether the certificate carries the extended key usage, or the
+// anyExtendedKeyUsage that stands for every usage
+func hasExtKeyUsage(cert *x509.Certificate, usage x509.ExtKeyUsage) bool {
+	for _, certUsage := range cert.ExtKeyUsage {
+		if certUsage == usage || certUsage == x509.ExtKeyUsageAny {
+			return true
+		}
+	}
+	return false
+}
+
+// hasExtension reports whether the certificate carries an extension with the OID
+func hasExtension(cert *x509.Certificate, oid asn1.ObjectIdentifier) bool {
+	for _, extension := range cert.Extensions {
+		if extension.Id.Equal(oid) {
+			return true
+		}
+	}
+	return false
+}
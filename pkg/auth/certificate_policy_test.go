@@ -0,0 +1,199 @@
+package auth
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"errors"
+	"math/big"
+	"net/url"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestPolicyEngineEvaluate(t *testing.T) {
+	ca := newTestCA(t, "Device CA")
+	otherCA := newTestCA(t, "Other CA")
+	deviceOID := asn1.ObjectIdentifier{1, 3, 6, 1, 4, 1, 55555, 1}
+
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 1024)
+	if err != nil {
+		t.Fatalf("Failed to generate RSA key: %v", err)
+	}
+
+	engine, err := NewPolicyEngine(&CertificatePolicy{
+		AllowedIssuers:             []string{"Device CA"},
+		RequiredExtKeyUsages:       []string{"clientAuth"},
+		RequiredKeyUsages:          []string{"digitalSignature"},
+		MinRSAKeyBits:              2048,
+		MinECKeyBits:               256,
+		AllowedSignatureAlgorithms: []string{"ECDSA-SHA256"},
+		SubjectCNPattern:           `device-[0-9]+`,
+		SANPattern:                 `spiffe://example\.org/device/.+`,
+		MaxValidityDays:            30,
+		RequiredOIDs:               []string{deviceOID.String()},
+	})
+	if err != nil {
+		t.Fatalf("Failed to create policy engine: %v", err)
+	}
+
+	// compliant returns a certificate template satisfying every rule
+	compliant := func() *x509.Certificate {
+		uri, _ := url.Parse("spiffe://example.org/device/1")
+		return &x509.Certificate{
+			SerialNumber:    big.NewInt(2),
+			Subject:         pkix.Name{CommonName: "device-1"},
+			URIs:            []*url.URL{uri},
+			KeyUsage:        x509.KeyUsageDigitalSignature,
+			ExtKeyUsage:     []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+			ExtraExtensions: []pkix.Extension{{Id: deviceOID, Value: []byte{0x05, 0x00}}},
+		}
+	}
+
+	tests := []struct {
+		name     string
+		cert     func() *x509.Certificate
+		expected []PolicyRule
+	}{
+		{
+			name: "compliant certificate",
+			cert: func() *x509.Certificate { return ca.issue(t, compliant(), newTestKey(t).Public()) },
+		},
+		{
+			name:     "issuer not allowed",
+			cert:     func() *x509.Certificate { return otherCA.issue(t, compliant(), newTestKey(t).Public()) },
+			expected: []PolicyRule{PolicyRuleIssuer},
+		},
+		{
+			name: "server certificate with a long validity",
+			cert: func() *x509.Certificate {
+				template := compliant()
+				template.ExtKeyUsage = []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth}
+				template.KeyUsage = x509.KeyUsageKeyEncipherment
+				template.NotBefore = time.Now().Add(-time.Hour)
+				template.NotAfter = time.Now().AddDate(1, 0, 0)
+				return ca.issue(t, template, newTestKey(t).Public())
+			},
+			expected: []PolicyRule{PolicyRuleExtKeyUsage, PolicyRuleKeyUsage, PolicyRuleValidity},
+		},
+		{
+			name:     "weak RSA key",
+			cert:     func() *x509.Certificate { return ca.issue(t, compliant(), &rsaKey.PublicKey) },
+			expected: []PolicyRule{PolicyRuleKeySize},
+		},
+		{
+			name: "subject, SAN and extension mismatch",
+			cert: func() *x509.Certificate {
+				template := compliant()
+				template.Subject.CommonName = "laptop-1"
+				template.URIs = nil
+				template.DNSNames = []string{"device-1.example.org"}
+				template.ExtraExtensions = nil
+				return ca.issue(t, template, newTestKey(t).Public())
+			},
+			expected: []PolicyRule{PolicyRuleSubject, PolicyRuleSAN, PolicyRuleRequiredOID},
+		},
+		{
+			name: "patterns match the whole value",
+			cert: func() *x509.Certificate {
+				template := compliant()
+				template.Subject.CommonName = "device-1-evil"
+				return ca.issue(t, template, newTestKey(t).Public())
+			},
+			expected: []PolicyRule{PolicyRuleSubject},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := engine.Evaluate(tt.cert())
+
+			if tt.expected == nil {
+				if err != nil {
+					t.Fatalf("Expected certificate to be admitted, got %v", err)
+				}
+				return
+			}
+
+			var rejection *PolicyRejection
+			if !errors.As(err, &rejection) {
+				t.Fatalf("Expected a policy rejection, got %v", err)
+			}
+			if !reflect.DeepEqual(rejection.Rules(), tt.expected) {
+				t.Errorf("Expected violations %v, got %v", tt.expected, rejection.Violations)
+			}
+		})
+	}
+
+	stats := engine.Stats()
+	if stats.Evaluated != uint64(len(tests)) || stats.Rejected != uint64(len(tests)-1) {
+		t.Errorf("Expected %d evaluated and %d rejected, got %+v", len(tests), len(tests)-1, stats)
+	}
+	if stats.Violations[PolicyRuleSubject] != 2 || stats.Violations[PolicyRuleIssuer] != 1 {
+		t.Errorf("Expected violations to be counted per rule, got %v", stats.Violations)
+	}
+}
+
+func TestPolicyEngineSignatureAlgorithm(t *testing.T) {
+	ca := newTestCA(t, "Device CA")
+	cert, _ := ca.issueClient(t, 2, "device-1")
+
+	engine, err := NewPolicyEngine(&CertificatePolicy{AllowedSignatureAlgorithms: []string{"SHA256-RSA"}})
+	if err != nil {
+		t.Fatalf("Failed to create policy engine: %v", err)
+	}
+
+	var rejection *PolicyRejection
+	if err := engine.Evaluate(cert); !errors.As(err, &rejection) || rejection.Rules()[0] != PolicyRuleSignatureAlgorithm {
+		t.Errorf("Expected an ECDSA-signed certificate to be rejected, got %v", err)
+	}
+}
+
+func TestNewPolicyEngineRejectsInvalidPolicies(t *testing.T) {
+	tests := []struct {
+		name   string
+		policy CertificatePolicy
+	}{
+		{"unknown extended key usage", CertificatePolicy{RequiredExtKeyUsages: []string{"deviceAuth"}}},
+		{"unknown key usage", CertificatePolicy{RequiredKeyUsages: []string{"signing"}}},
+		{"unknown signature algorithm", CertificatePolicy{AllowedSignatureAlgorithms: []string{"SHA256"}}},
+		{"invalid pattern", CertificatePolicy{SubjectCNPattern: `device-(`}},
+		{"invalid OID", CertificatePolicy{RequiredOIDs: []string{"1.3.x"}}},
+		{"negative key size", CertificatePolicy{MinRSAKeyBits: -1}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := NewPolicyEngine(&tt.policy); err == nil {
+				t.Error("Expected the policy to be rejected")
+			}
+		})
+	}
+}
+
+func TestLoadCertificatePolicy(t *testing.T) {
+	dir := t.TempDir()
+
+	valid := filepath.Join(dir, "policy.json")
+	os.WriteFile(valid, []byte(`{"required_ext_key_usages": ["clientAuth"], "max_validity_days": 397}`), 0o600)
+
+	policy, err := LoadCertificatePolicy(valid)
+	if err != nil {
+		t.Fatalf("Failed to load policy: %v", err)
+	}
+	if policy.MaxValidityDays != 397 || len(policy.RequiredExtKeyUsages) != 1 {
+		t.Errorf("Unexpected policy %+v", policy)
+	}
+
+	misspelled := filepath.Join(dir, "misspelled.json")
+	os.WriteFile(misspelled, []byte(`{"required_eku": ["clientAuth"]}`), 0o600)
+
+	if _, err := LoadCertificatePolicy(misspelled); err == nil {
+		t.Error("Expected unknown fields to be rejected")
+	}
+}
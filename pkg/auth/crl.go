@@ -0,0 +1,311 @@
+package auth
+
+import (
+	"bytes"
+	"crypto/x509"
+	"encoding/pem"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"device-assignment-api/pkg/logger"
+)
+
+// crlFetchTimeout bounds how long a single CRL download may take
+const crlFetchTimeout = 30 * time.Second
+
+// crlMinRefreshDelay is the shortest wait between refreshes, so that a CRL whose next update
+// time has passed without a newer list being published is not fetched in a tight loop
+const crlMinRefreshDelay = time.Minute
+
+// crlDefaultRefreshInterval is how often a CRL without a next update time is refreshed when
+// no refresh interval is configured
+const crlDefaultRefreshInterval = time.Hour
+
+// crlReasons maps RFC 5280 CRLReason codes to readable names for logging
+var crlReasons = map[int]string{
+	0:  "unspecified",
+	1:  "keyCompromise",
+	2:  "cACompromise",
+	3:  "affiliationChanged",
+	4:  "superseded",
+	5:  "cessationOfOperation",
+	6:  "certificateHold",
+	8:  "removeFromCRL",
+	9:  "privilegeWithdrawn",
+	10: "aACompromise",
+}
+
+// loadedCRL holds the parsed contents of a single CRL source
+type loadedCRL struct {
+	rawIssuer  []byte
+	revoked    map[string]int
+	thisUpdate time.Time
+	nextUpdate time.Time
+}
+
+// CRLChecker checks certificates against certificate revocation lists loaded
+// from local files or HTTP distribution points and refreshed in the background
+type CRLChecker struct {
+	sources         []string
+	refreshInterval time.Duration
+	httpClient      *http.Client
+	logger          logger.Logger
+
+	mu      sync.RWMutex
+	issuers []*x509.Certificate
+	crls    map[string]*loadedCRL
+
+	stop     chan struct{}
+	stopOnce sync.Once
+}
+
+// NewCRLChecker creates a CRL checker and performs the initial load of every source.
+// Sources may be file paths or http(s) URLs. Each CRL signature must verify against one of
+// the issuers.
+func NewCRLChecker(sources []string, issuers []*x509.Certificate, refreshInterval time.Duration, logger logger.Logger) (*CRLChecker, error) {
+	if len(sources) == 0 {
+		return nil, fmt.Errorf("at least one CRL source is required")
+	}
+
+	if len(issuers) == 0 {
+		return nil, fmt.Errorf("at least one CRL issuer is required")
+	}
+
+	c := &CRLChecker{
+		sources:         sources,
+		issuers:         issuers,
+		refreshInterval: refreshInterval,
+		httpClient:      &http.Client{Timeout: crlFetchTimeout},
+		logger:          logger,
+		crls:            make(map[string]*loadedCRL),
+		stop:            make(chan struct{}),
+	}
+
+	for _, source := range sources {
+		if err := c.refreshSource(source); err != nil {
+			return nil, err
+		}
+	}
+
+	return c, nil
+}
+
+// Start begins refreshing all CRL sources in the background, every refresh interval or as
+// soon as a CRL reaches its next update time, whichever comes first
+func (c *CRLChecker) Start() {
+	go func() {
+		for {
+			wait := c.nextRefresh(time.Now())
+
+			timer := time.NewTimer(wait)
+			select {
+			case <-timer.C:
+				c.Refresh()
+			case <-c.stop:
+				timer.Stop()
+				return
+			}
+		}
+	}()
+}
+
+// Stop halts the background refresh
+func (c *CRLChecker) Stop() {
+	c.stopOnce.Do(func() {
+		close(c.stop)
+	})
+}
+
+// SetIssuers replaces the certificates CRL signatures must verify against, for example after
+// the client CA bundle was reloaded, and reloads every CRL source against them
+func (c *CRLChecker) SetIssuers(issuers []*x509.Certificate) {
+	c.mu.Lock()
+	c.issuers = issuers
+	c.mu.Unlock()
+
+	c.Refresh()
+}
+
+// Refresh reloads every CRL source, keeping the previous list for any source that fails
+func (c *CRLChecker) Refresh() {
+	for _, source := range c.sources {
+		if err := c.refreshSource(source); err != nil {
+			c.logger.Error("Failed to refresh CRL, keeping previous list", "source", source, "error", err)
+
+			c.mu.RLock()
+			previous := c.crls[source]
+			c.mu.RUnlock()
+			if previous != nil && !previous.nextUpdate.IsZero() && time.Now().After(previous.nextUpdate) {
+				c.logger.Warn("CRL is past its next update time", "source", source, "next_update", previous.nextUpdate)
+			}
+		}
+	}
+}
+
+// nextRefresh returns how long to wait before the next refresh: the refresh interval, or less
+// when a CRL reaches its next update time sooner. Without a refresh interval, CRLs that name
+// no next update time are refreshed every crlDefaultRefreshInterval, so that no source is
+// left without refreshes.
+func (c *CRLChecker) nextRefresh(now time.Time) time.Duration {
+	wait := c.refreshInterval
+
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	for _, crl := range c.crls {
+		var untilUpdate time.Duration
+		switch {
+		case !crl.nextUpdate.IsZero():
+			untilUpdate = crl.nextUpdate.Sub(now)
+			if untilUpdate < crlMinRefreshDelay {
+				untilUpdate = crlMinRefreshDelay
+			}
+		case c.refreshInterval <= 0:
+			untilUpdate = crlDefaultRefreshInterval
+		default:
+			continue
+		}
+
+		if wait <= 0 || untilUpdate < wait {
+			wait = untilUpdate
+		}
+	}
+
+	if wait <= 0 {
+		wait = crlDefaultRefreshInterval
+	}
+
+	return wait
+}
+
+// CheckRevocation implements RevocationChecker
+func (c *CRLChecker) CheckRevocation(cert, issuer *x509.Certificate) error {
+	if cert == nil {
+		return fmt.Errorf("certificate is nil")
+	}
+
+	serial := cert.SerialNumber.String()
+
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	for source, crl := range c.crls {
+		if !bytes.Equal(crl.rawIssuer, cert.RawIssuer) {
+			continue
+		}
+
+		reasonCode, revoked := crl.revoked[serial]
+		if !revoked {
+			continue
+		}
+
+		reason := crlReasons[reasonCode]
+		c.logger.Warn("Rejected revoked certificate",
+			"serial_number", formatSerialNumber(cert.SerialNumber),
+			"issuer_cn", cert.Issuer.CommonName,
+			"reason", reason,
+			"source", source)
+		return fmt.Errorf("%w: serial %s listed in CRL %s (reason: %s)",
+			ErrCertificateRevoked, formatSerialNumber(cert.SerialNumber), source, reason)
+	}
+
+	return nil
+}
+
+// refreshSource loads, verifies and stores a single CRL source
+func (c *CRLChecker) refreshSource(source string) error {
+	data, err := c.fetch(source)
+	if err != nil {
+		return fmt.Errorf("failed to load CRL from %s: %w", source, err)
+	}
+
+	crl, err := parseCRL(data)
+	if err != nil {
+		return fmt.Errorf("failed to parse CRL from %s: %w", source, err)
+	}
+
+	if err := c.verifyCRLSignature(crl); err != nil {
+		return fmt.Errorf("failed to verify CRL from %s: %w", source, err)
+	}
+
+	loaded := &loadedCRL{
+		rawIssuer:  crl.RawIssuer,
+		revoked:    make(map[string]int, len(crl.RevokedCertificateEntries)),
+		thisUpdate: crl.ThisUpdate,
+		nextUpdate: crl.NextUpdate,
+	}
+	for _, entry := range crl.RevokedCertificateEntries {
+		loaded.revoked[entry.SerialNumber.String()] = entry.ReasonCode
+	}
+
+	c.mu.Lock()
+	c.crls[source] = loaded
+	c.mu.Unlock()
+
+	c.logger.Info("CRL loaded",
+		"source", source,
+		"issuer", crl.Issuer.String(),
+		"revoked_count", len(loaded.revoked),
+		"next_update", crl.NextUpdate)
+
+	return nil
+}
+
+// fetch reads raw CRL bytes from a file path or HTTP URL
+func (c *CRLChecker) fetch(source string) ([]byte, error) {
+	if !strings.HasPrefix(source, "http://") && !strings.HasPrefix(source, "https://") {
+		return os.ReadFile(source)
+	}
+
+	resp, err := c.httpClient.Get(source)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected status: %s", resp.Status)
+	}
+
+	return io.ReadAll(resp.Body)
+}
+
+// verifyCRLSignature checks the CRL against the configured issuers. Without issuers no CRL
+// is trusted.
+func (c *CRLChecker) verifyCRLSignature(crl *x509.RevocationList) error {
+	c.mu.RLock()
+	issuers := c.issuers
+	c.mu.RUnlock()
+
+	if len(issuers) == 0 {
+		return fmt.Errorf("no trusted CRL issuers are configured")
+	}
+
+	for _, issuer := range issuers {
+		if !bytes.Equal(issuer.RawSubject, crl.RawIssuer) {
+			continue
+		}
+		if err := crl.CheckSignatureFrom(issuer); err == nil {
+			return nil
+		}
+	}
+
+	return fmt.Errorf("CRL is not signed by a trusted issuer: %s", crl.Issuer.String())
+}
+
+// parseCRL parses a CRL in either PEM or DER encoding
+func parseCRL(data []byte) (*x509.RevocationList, error) {
+	if block, _ := pem.Decode(data); block != nil {
+		if block.Type != "X509 CRL" {
+			return nil, fmt.Errorf("unexpected PEM block type %q", block.Type)
+		}
+		data = block.Bytes
+	}
+
+	return x509.ParseRevocationList(data)
+}
@@ -0,0 +1,213 @@
+package auth
+
+import (
+	"crypto/x509"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func writeCRL(t *testing.T, der []byte) string {
+	t.Helper()
+
+	path := filepath.Join(t.TempDir(), "ca.crl")
+	if err := os.WriteFile(path, der, 0o600); err != nil {
+		t.Fatalf("Failed to write CRL: %v", err)
+	}
+	return path
+}
+
+func TestCRLCheckerRejectsRevokedCertificate(t *testing.T) {
+	ca := newTestCA(t, "Test CA")
+	revokedCert, _ := ca.issueClient(t, 100, "revoked-device")
+	goodCert, _ := ca.issueClient(t, 101, "good-device")
+
+	checker, err := NewCRLChecker([]string{writeCRL(t, ca.revoke(t, 100))}, []*x509.Certificate{ca.cert}, 0, testLogger())
+	if err != nil {
+		t.Fatalf("Failed to create CRL checker: %v", err)
+	}
+
+	if err := checker.CheckRevocation(revokedCert, ca.cert); !errors.Is(err, ErrCertificateRevoked) {
+		t.Errorf("Expected ErrCertificateRevoked, got %v", err)
+	}
+
+	if err := checker.CheckRevocation(goodCert, ca.cert); err != nil {
+		t.Errorf("Expected good certificate to pass, got %v", err)
+	}
+}
+
+func TestCRLCheckerIgnoresOtherIssuers(t *testing.T) {
+	ca := newTestCA(t, "Test CA")
+	otherCA := newTestCA(t, "Other CA")
+	otherCert, _ := otherCA.issueClient(t, 100, "other-device")
+
+	checker, err := NewCRLChecker([]string{writeCRL(t, ca.revoke(t, 100))}, []*x509.Certificate{ca.cert}, 0, testLogger())
+	if err != nil {
+		t.Fatalf("Failed to create CRL checker: %v", err)
+	}
+
+	if err := checker.CheckRevocation(otherCert, otherCA.cert); err != nil {
+		t.Errorf("Expected certificate from another issuer to pass, got %v", err)
+	}
+}
+
+func TestCRLCheckerRejectsUntrustedSignature(t *testing.T) {
+	ca := newTestCA(t, "Test CA")
+	impostor := newTestCA(t, "Test CA")
+
+	_, err := NewCRLChecker([]string{writeCRL(t, impostor.revoke(t, 100))}, []*x509.Certificate{ca.cert}, 0, testLogger())
+	if err == nil {
+		t.Fatal("Expected CRL signed by an untrusted key to be rejected")
+	}
+
+	if _, err := NewCRLChecker([]string{writeCRL(t, ca.revoke(t, 100))}, nil, 0, testLogger()); err == nil {
+		t.Error("Expected a CRL checker without issuers to be refused")
+	}
+
+	checker, err := NewCRLChecker([]string{writeCRL(t, ca.revoke(t, 100))}, []*x509.Certificate{ca.cert}, 0, testLogger())
+	if err != nil {
+		t.Fatalf("Failed to create CRL checker: %v", err)
+	}
+	checker.mu.Lock()
+	checker.issuers = nil
+	checker.mu.Unlock()
+	if err := checker.refreshSource(writeCRL(t, ca.revoke(t))); err == nil {
+		t.Error("Expected a CRL to be rejected once no issuers are trusted")
+	}
+}
+
+func TestCRLCheckerRefreshFromHTTP(t *testing.T) {
+	ca := newTestCA(t, "Test CA")
+	clientCert, _ := ca.issueClient(t, 200, "device")
+
+	var current atomic.Value
+	current.Store(ca.revoke(t))
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/pkix-crl")
+		w.Write(current.Load().([]byte))
+	}))
+	defer server.Close()
+
+	checker, err := NewCRLChecker([]string{server.URL + "/ca.crl"}, []*x509.Certificate{ca.cert}, 10*time.Millisecond, testLogger())
+	if err != nil {
+		t.Fatalf("Failed to create CRL checker: %v", err)
+	}
+
+	if err := checker.CheckRevocation(clientCert, ca.cert); err != nil {
+		t.Fatalf("Expected certificate to pass before revocation, got %v", err)
+	}
+
+	current.Store(ca.revoke(t, 200))
+	checker.Start()
+	defer checker.Stop()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		if errors.Is(checker.CheckRevocation(clientCert, ca.cert), ErrCertificateRevoked) {
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatal("Expected background refresh to pick up the revocation")
+}
+
+func TestCRLCheckerHandshake(t *testing.T) {
+	ca := newTestCA(t, "Test CA")
+	_, revokedClient := ca.issueClient(t, 300, "revoked-device")
+	_, goodClient := ca.issueClient(t, 301, "good-device")
+
+	checker, err := NewCRLChecker([]string{writeCRL(t, ca.revoke(t, 300))}, []*x509.Certificate{ca.cert}, 0, testLogger())
+	if err != nil {
+		t.Fatalf("Failed to create CRL checker: %v", err)
+	}
+
+	serverConfig := mTLSServerConfig(t, ca)
+	serverConfig.VerifyPeerCertificate = VerifyPeerRevocation(checker)
+
+	if err := handshake(t, serverConfig, ca, revokedClient); !errors.Is(err, ErrCertificateRevoked) {
+		t.Errorf("Expected handshake with revoked certificate to fail with ErrCertificateRevoked, got %v", err)
+	}
+
+	if err := handshake(t, serverConfig, ca, goodClient); err != nil {
+		t.Errorf("Expected handshake with good certificate to succeed, got %v", err)
+	}
+}
+
+func TestCRLCheckerRefreshesAtNextUpdate(t *testing.T) {
+	ca := newTestCA(t, "Test CA")
+	now := time.Now()
+
+	checker, err := NewCRLChecker([]string{writeCRL(t, ca.revoke(t))}, []*x509.Certificate{ca.cert}, 0, testLogger())
+	if err != nil {
+		t.Fatalf("Failed to create CRL checker: %v", err)
+	}
+	checker.crls["other.crl"] = &loadedCRL{nextUpdate: now.Add(10 * time.Minute)}
+
+	if wait := checker.nextRefresh(now); wait != 10*time.Minute {
+		t.Errorf("Expected a refresh at the earliest next update time, got %s", wait)
+	}
+
+	checker.refreshInterval = 5 * time.Minute
+	if wait := checker.nextRefresh(now); wait != 5*time.Minute {
+		t.Errorf("Expected a refresh at the refresh interval when it comes first, got %s", wait)
+	}
+
+	checker.crls["other.crl"].nextUpdate = now.Add(-time.Hour)
+	if wait := checker.nextRefresh(now); wait != crlMinRefreshDelay {
+		t.Errorf("Expected a stale CRL to be retried after %s, got %s", crlMinRefreshDelay, wait)
+	}
+
+	// Without a refresh interval, a CRL naming no next update must still be refreshed
+	checker.refreshInterval = 0
+	checker.crls = map[string]*loadedCRL{"other.crl": {}}
+	if wait := checker.nextRefresh(now); wait != crlDefaultRefreshInterval {
+		t.Errorf("Expected a CRL without next update to be refreshed after %s, got %s", crlDefaultRefreshInterval, wait)
+	}
+}
+
+func TestCRLCheckerReloadsWithClientCAs(t *testing.T) {
+	serverCA := newTestCA(t, "Server CA")
+	deviceCA := newTestCA(t, "Device CA")
+	deviceCert, _ := deviceCA.issueClient(t, 400, "device")
+
+	files := newTLSFiles(t)
+	files.writeServer(t, serverCA.issueServer(t))
+	files.writeCAs(t, serverCA)
+
+	reloader, err := NewTLSReloader(files.cert, files.key, files.ca, testLogger())
+	if err != nil {
+		t.Fatalf("Failed to create reloader: %v", err)
+	}
+
+	crlFile := writeCRL(t, serverCA.revoke(t))
+	checker, err := NewCRLChecker([]string{crlFile}, reloader.ClientCACertificates(), 0, testLogger())
+	if err != nil {
+		t.Fatalf("Failed to create CRL checker: %v", err)
+	}
+	reloader.OnReload(func() {
+		checker.SetIssuers(reloader.ClientCACertificates())
+	})
+
+	// The new device CA's CRL is only accepted once the CA is in the client CA bundle
+	if err := os.WriteFile(crlFile, deviceCA.revoke(t, 400), 0o600); err != nil {
+		t.Fatalf("Failed to write CRL: %v", err)
+	}
+	checker.Refresh()
+	if err := checker.CheckRevocation(deviceCert, deviceCA.cert); err != nil {
+		t.Fatalf("Expected a CRL from an unknown issuer to be ignored, got %v", err)
+	}
+
+	files.writeCAs(t, serverCA, deviceCA)
+	if err := reloader.Reload(); err != nil {
+		t.Fatalf("Failed to reload: %v", err)
+	}
+	if err := checker.CheckRevocation(deviceCert, deviceCA.cert); !errors.Is(err, ErrCertificateRevoked) {
+		t.Errorf("Expected the CRL to be reloaded with the client CAs, got %v", err)
+	}
+}
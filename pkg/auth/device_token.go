@@ -0,0 +1,75 @@
+package auth
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/google/uuid"
+)
+
+// DeviceTokenAudience is the audience of device access tokens. It keeps device tokens
+// from being accepted where user tokens are expected, and the other way round.
+const DeviceTokenAudience = "device"
+
+// DeviceClaims represents the claims of a device access token, issued to a device after
+// it authenticated with its client certificate. CertificateThumbprint is the hex SHA-256
+// fingerprint of that certificate. Confirmation optionally binds the token to it.
+type DeviceClaims struct {
+	DeviceID              string        `json:"device_id"`
+	CertificateThumbprint string        `json:"cert_thumbprint"`
+	Confirmation          *Confirmation `json:"cnf,omitempty"`
+	jwt.RegisteredClaims
+}
+
+// DeviceTokenValidator validates a device access token and returns its claims
+type DeviceTokenValidator interface {
+	ValidateDeviceToken(tokenString string) (*DeviceClaims, error)
+}
+
+// GenerateDeviceToken creates a device access token valid for the given duration. The
+// confirmation may be nil; otherwise it binds the token to the device's certificate.
+func (j *JWTManager) GenerateDeviceToken(deviceID, certThumbprint string, cnf *Confirmation, duration time.Duration) (string, error) {
+	now := time.Now()
+	claims := &DeviceClaims{
+		DeviceID:              deviceID,
+		CertificateThumbprint: certThumbprint,
+		Confirmation:          cnf,
+		RegisteredClaims: jwt.RegisteredClaims{
+			ID:        uuid.NewString(),
+			Issuer:    j.issuer,
+			Subject:   deviceID,
+			Audience:  jwt.ClaimStrings{DeviceTokenAudience},
+			IssuedAt:  jwt.NewNumericDate(now),
+			ExpiresAt: jwt.NewNumericDate(now.Add(duration)),
+			NotBefore: jwt.NewNumericDate(now),
+		},
+	}
+
+	return j.sign(claims)
+}
+
+// ValidateDeviceToken validates and parses a device access token, returning the claims
+func (j *JWTManager) ValidateDeviceToken(tokenString string) (*DeviceClaims, error) {
+	parser := jwt.NewParser(
+		jwt.WithIssuer(j.issuer),
+		jwt.WithAudience(DeviceTokenAudience),
+		jwt.WithExpirationRequired(),
+	)
+
+	token, err := parser.ParseWithClaims(tokenString, &DeviceClaims{}, j.verificationKey)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse device token: %w", err)
+	}
+
+	claims, ok := token.Claims.(*DeviceClaims)
+	if !ok || !token.Valid {
+		return nil, fmt.Errorf("invalid device token claims")
+	}
+
+	if claims.DeviceID == "" || claims.CertificateThumbprint == "" {
+		return nil, fmt.Errorf("device ID or certificate thumbprint is missing from token")
+	}
+
+	return claims, nil
+}
@@ -0,0 +1,51 @@
+package auth
+
+import (
+	"testing"
+	"time"
+)
+
+func TestJWTManagerDeviceToken(t *testing.T) {
+	manager := NewJWTManager("secret", time.Hour, "test")
+
+	token, err := manager.GenerateDeviceToken("device-1", "ab12", nil, 5*time.Minute)
+	if err != nil {
+		t.Fatalf("Failed to generate device token: %v", err)
+	}
+
+	claims, err := manager.ValidateDeviceToken(token)
+	if err != nil {
+		t.Fatalf("Expected device token to validate, got %v", err)
+	}
+	if claims.DeviceID != "device-1" || claims.CertificateThumbprint != "ab12" {
+		t.Errorf("Expected device-1 with thumbprint ab12, got %s with %s", claims.DeviceID, claims.CertificateThumbprint)
+	}
+	if remaining := time.Until(claims.ExpiresAt.Time); remaining > 5*time.Minute {
+		t.Errorf("Expected the device token duration to be used, got %v remaining", remaining)
+	}
+}
+
+func TestDeviceAndUserTokensAreNotInterchangeable(t *testing.T) {
+	manager := NewJWTManager("secret", time.Hour, "test")
+
+	deviceToken, _ := manager.GenerateDeviceToken("device-1", "ab12", nil, time.Hour)
+	if _, err := manager.ValidateToken(deviceToken); err == nil {
+		t.Error("Expected a device token to be rejected as a user token")
+	}
+
+	userToken, _ := manager.GenerateToken("user-1")
+	if _, err := manager.ValidateDeviceToken(userToken); err == nil {
+		t.Error("Expected a user token to be rejected as a device token")
+	}
+
+	other := NewJWTManager("secret", time.Hour, "other-issuer")
+	otherToken, _ := other.GenerateDeviceToken("device-1", "ab12", nil, time.Hour)
+	if _, err := manager.ValidateDeviceToken(otherToken); err == nil {
+		t.Error("Expected a device token from another issuer to be rejected")
+	}
+
+	expired, _ := manager.GenerateDeviceToken("device-1", "ab12", nil, -time.Minute)
+	if _, err := manager.ValidateDeviceToken(expired); err == nil {
+		t.Error("Expected an expired device token to be rejected")
+	}
+}
@@ -0,0 +1,231 @@
+package auth
+
+import (
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/pem"
+	"fmt"
+	"net"
+	"net/url"
+	"strings"
+)
+
+// ForwardedCertFormat is the encoding of a client certificate forwarded by a TLS-terminating proxy
+type ForwardedCertFormat string
+
+const (
+	// ForwardedCertXFCC is Envoy's X-Forwarded-Client-Cert header, with the certificate in its
+	// Cert or Chain field
+	ForwardedCertXFCC ForwardedCertFormat = "xfcc"
+	// ForwardedCertPEM is a URL-encoded PEM certificate or chain, such as nginx's
+	// $ssl_client_escaped_cert
+	ForwardedCertPEM ForwardedCertFormat = "pem"
+)
+
+// maxForwardedChainLength bounds the number of certificates accepted in a forwarded chain
+const maxForwardedChainLength = 8
+
+// ParseForwardedCertFormat converts a configuration value into a ForwardedCertFormat
+func ParseForwardedCertFormat(value string) (ForwardedCertFormat, error) {
+	switch ForwardedCertFormat(value) {
+	case ForwardedCertXFCC, ForwardedCertPEM:
+		return ForwardedCertFormat(value), nil
+	default:
+		return "", fmt.Errorf("invalid forwarded certificate format %q (expected %q or %q)", value, ForwardedCertXFCC, ForwardedCertPEM)
+	}
+}
+
+// ParseForwardedCertificates decodes the client certificate forwarded in a header value. The
+// leaf certificate comes first, followed by any intermediates the proxy forwarded.
+func ParseForwardedCertificates(format ForwardedCertFormat, value string) ([]*x509.Certificate, error) {
+	encoded := value
+	if format == ForwardedCertXFCC {
+		var err error
+		if encoded, err = xfccCertificate(value); err != nil {
+			return nil, err
+		}
+	}
+
+	decoded, err := url.PathUnescape(encoded)
+	if err != nil {
+		return nil, fmt.Errorf("forwarded certificate is not URL-encoded: %w", err)
+	}
+
+	return parsePEMCertificates([]byte(decoded))
+}
+
+// xfccCertificate returns the encoded certificate chain, or the certificate alone, of an
+// X-Forwarded-Client-Cert value. Only a single element is accepted: the proxy must replace
+// the header rather than append to one sent by the client.
+func xfccCertificate(value string) (string, error) {
+	elements := splitUnquoted(value, ',')
+	if len(elements) != 1 {
+		return "", fmt.Errorf("X-Forwarded-Client-Cert must contain exactly one element, got %d", len(elements))
+	}
+
+	fields := make(map[string]string)
+	for _, pair := range splitUnquoted(elements[0], ';') {
+		key, val, found := strings.Cut(strings.TrimSpace(pair), "=")
+		if !found {
+			return "", fmt.Errorf("malformed X-Forwarded-Client-Cert field %q", pair)
+		}
+		fields[strings.ToLower(key)] = strings.Trim(val, `"`)
+	}
+
+	if chain := fields["chain"]; chain != "" {
+		return chain, nil
+	}
+	if cert := fields["cert"]; cert != "" {
+		return cert, nil
+	}
+
+	return "", fmt.Errorf("X-Forwarded-Client-Cert carries no Cert or Chain field")
+}
+
+// splitUnquoted splits s on sep, ignoring separators inside double-quoted values
+func splitUnquoted(s string, sep byte) []string {
+	var parts []string
+	quoted := false
+	start := 0
+
+	for i := 0; i < len(s); i++ {
+		switch {
+		case s[i] == '\\' && quoted:
+			i++
+		case s[i] == '"':
+			quoted = !quoted
+		case s[i] == sep && !quoted:
+			parts = append(parts, s[start:i])
+			start = i + 1
+		}
+	}
+
+	return append(parts, s[start:])
+}
+
+// parsePEMCertificates parses a sequence of PEM certificates with nothing else around them
+func parsePEMCertificates(data []byte) ([]*x509.Certificate, error) {
+	var certs []*x509.Certificate
+	for {
+		block, rest := pem.Decode(data)
+		if block == nil {
+			break
+		}
+		if block.Type != "CERTIFICATE" {
+			return nil, fmt.Errorf("unexpected PEM block %q in forwarded certificate", block.Type)
+		}
+
+		cert, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse forwarded certificate: %w", err)
+		}
+		certs = append(certs, cert)
+		data = rest
+	}
+
+	if len(certs) == 0 || strings.TrimSpace(string(data)) != "" {
+		return nil, fmt.Errorf("forwarded certificate is not valid PEM")
+	}
+
+	if len(certs) > maxForwardedChainLength {
+		return nil, fmt.Errorf("forwarded certificate chain is longer than %d certificates", maxForwardedChainLength)
+	}
+
+	return certs, nil
+}
+
+// VerifyForwardedChain verifies a forwarded client certificate against the client CA pool, as
+// the TLS handshake would have, returning its verified chains
+func VerifyForwardedChain(certs []*x509.Certificate, roots *x509.CertPool) ([][]*x509.Certificate, error) {
+	if len(certs) == 0 {
+		return nil, fmt.Errorf("no forwarded certificate")
+	}
+
+	// A nil pool would fall back to the system roots
+	if roots == nil {
+		return nil, fmt.Errorf("client CA pool is not configured")
+	}
+
+	intermediates := x509.NewCertPool()
+	for _, cert := range certs[1:] {
+		intermediates.AddCert(cert)
+	}
+
+	chains, err := certs[0].Verify(x509.VerifyOptions{
+		Roots:         roots,
+		Intermediates: intermediates,
+		KeyUsages:     []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("forwarded certificate verification failed: %w", err)
+	}
+
+	return chains, nil
+}
+
+// TrustedProxies decides which peers may forward client certificates, either by source
+// address or by the public key of their own verified client certificate
+type TrustedProxies struct {
+	networks     []*net.IPNet
+	fingerprints map[string]bool
+}
+
+// NewTrustedProxies creates the trusted proxy list from CIDRs and the hex SHA-256 SPKI
+// fingerprints of proxy client certificates. Proxies are pinned to their keys rather than to
+// names, since names are also issued to devices by the client CAs.
+func NewTrustedProxies(cidrs, spkiFingerprints []string) (*TrustedProxies, error) {
+	if len(cidrs) == 0 && len(spkiFingerprints) == 0 {
+		return nil, fmt.Errorf("at least one trusted proxy CIDR or SPKI fingerprint is required")
+	}
+
+	proxies := &TrustedProxies{fingerprints: make(map[string]bool, len(spkiFingerprints))}
+	for _, cidr := range cidrs {
+		// Accept single addresses as well as networks
+		if !strings.Contains(cidr, "/") {
+			if ip := net.ParseIP(cidr); ip != nil && ip.To4() != nil {
+				cidr += "/32"
+			} else {
+				cidr += "/128"
+			}
+		}
+
+		_, network, err := net.ParseCIDR(cidr)
+		if err != nil {
+			return nil, fmt.Errorf("invalid trusted proxy CIDR %q", cidr)
+		}
+		proxies.networks = append(proxies.networks, network)
+	}
+
+	for _, spki := range spkiFingerprints {
+		normalized := strings.ToLower(strings.ReplaceAll(strings.TrimSpace(spki), ":", ""))
+		if decoded, err := hex.DecodeString(normalized); err != nil || len(decoded) != sha256.Size {
+			return nil, fmt.Errorf("invalid trusted proxy SPKI fingerprint %q", spki)
+		}
+		proxies.fingerprints[normalized] = true
+	}
+
+	return proxies, nil
+}
+
+// Trusts reports whether a peer may forward client certificates. The proxy certificate is the
+// peer's verified TLS client certificate, or nil.
+func (p *TrustedProxies) Trusts(remoteAddr string, proxyCert *x509.Certificate) bool {
+	host, _, err := net.SplitHostPort(remoteAddr)
+	if err != nil {
+		host = remoteAddr
+	}
+
+	if ip := net.ParseIP(host); ip != nil {
+		for _, network := range p.networks {
+			if network.Contains(ip) {
+				return true
+			}
+		}
+	}
+
+	if proxyCert == nil {
+		return false
+	}
+	return p.fingerprints[fingerprint(proxyCert.RawSubjectPublicKeyInfo)]
+}
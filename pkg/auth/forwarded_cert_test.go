@@ -0,0 +1,160 @@
+package auth
+
+import (
+	"crypto/x509"
+	"encoding/pem"
+	"math/big"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+// pemEncode returns the PEM encoding of certificates
+func pemEncode(certs ...*x509.Certificate) string {
+	var b strings.Builder
+	for _, cert := range certs {
+		pem.Encode(&b, &pem.Block{Type: "CERTIFICATE", Bytes: cert.Raw})
+	}
+	return b.String()
+}
+
+// proxyEscape URL-encodes a value the way nginx and Envoy do, with spaces as %20
+func proxyEscape(s string) string {
+	return strings.ReplaceAll(url.QueryEscape(s), "+", "%20")
+}
+
+func TestParseForwardedCertificates(t *testing.T) {
+	ca := newTestCA(t, "Test CA")
+	cert, _ := ca.issueClient(t, 2, "device-1")
+	escaped := proxyEscape(pemEncode(cert))
+
+	tests := []struct {
+		name   string
+		format ForwardedCertFormat
+		value  string
+	}{
+		{"pem", ForwardedCertPEM, escaped},
+		{"xfcc cert", ForwardedCertXFCC, `Hash=abc;Cert="` + escaped + `";Subject="CN=device-1,O=Example";URI=`},
+		{"xfcc chain", ForwardedCertXFCC, `By=spiffe://example.org/proxy;Chain="` + proxyEscape(pemEncode(cert, ca.cert)) + `"`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			certs, err := ParseForwardedCertificates(tt.format, tt.value)
+			if err != nil {
+				t.Fatalf("Failed to parse forwarded certificate: %v", err)
+			}
+			if !certs[0].Equal(cert) {
+				t.Errorf("Expected the client certificate first, got %s", certs[0].Subject)
+			}
+		})
+	}
+
+	invalid := []struct {
+		name   string
+		format ForwardedCertFormat
+		value  string
+	}{
+		{"pem garbage", ForwardedCertPEM, "not-a-certificate"},
+		{"pem trailing data", ForwardedCertPEM, escaped + "trailing"},
+		{"xfcc hash only", ForwardedCertXFCC, "Hash=abc"},
+		{"xfcc appended by client", ForwardedCertXFCC, `Cert="` + escaped + `",Cert="` + escaped + `"`},
+		{"xfcc malformed field", ForwardedCertXFCC, "Cert"},
+	}
+
+	for _, tt := range invalid {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := ParseForwardedCertificates(tt.format, tt.value); err == nil {
+				t.Error("Expected an error")
+			}
+		})
+	}
+}
+
+func TestVerifyForwardedChain(t *testing.T) {
+	ca := newTestCA(t, "Test CA")
+	other := newTestCA(t, "Other CA")
+	cert, _ := ca.issueClient(t, 2, "device-1")
+
+	roots := x509.NewCertPool()
+	roots.AddCert(ca.cert)
+
+	chains, err := VerifyForwardedChain([]*x509.Certificate{cert}, roots)
+	if err != nil {
+		t.Fatalf("Expected the forwarded certificate to verify: %v", err)
+	}
+	if len(chains) == 0 || !chains[0][len(chains[0])-1].Equal(ca.cert) {
+		t.Error("Expected a chain ending at the client CA")
+	}
+
+	foreign, _ := other.issueClient(t, 3, "device-2")
+	if _, err := VerifyForwardedChain([]*x509.Certificate{foreign}, roots); err == nil {
+		t.Error("Expected a certificate from another CA to be rejected")
+	}
+
+	serverOnly := ca.issue(t, &x509.Certificate{
+		SerialNumber: big.NewInt(4),
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}, newTestKey(t).Public())
+	if _, err := VerifyForwardedChain([]*x509.Certificate{serverOnly}, roots); err == nil {
+		t.Error("Expected a certificate without client authentication usage to be rejected")
+	}
+
+	if _, err := VerifyForwardedChain([]*x509.Certificate{cert}, nil); err == nil {
+		t.Error("Expected an error without a client CA pool")
+	}
+}
+
+func TestTrustedProxies(t *testing.T) {
+	ca := newTestCA(t, "Test CA")
+	proxyURI, _ := url.Parse("spiffe://example.org/envoy")
+	proxyCert := ca.issue(t, &x509.Certificate{
+		SerialNumber: big.NewInt(2),
+		URIs:         []*url.URL{proxyURI},
+	}, newTestKey(t).Public())
+	otherCert, _ := ca.issueClient(t, 3, "device-1")
+
+	// A device certificate with the proxy's name must not be trusted as the proxy
+	impostorCert := ca.issue(t, &x509.Certificate{
+		SerialNumber: big.NewInt(4),
+		URIs:         []*url.URL{proxyURI},
+	}, newTestKey(t).Public())
+
+	spki := ExtractCertificateInfo(proxyCert).SPKIFingerprint
+	proxies, err := NewTrustedProxies([]string{"10.0.0.0/8", "192.0.2.10"}, []string{strings.ToUpper(spki[:2]) + ":" + spki[2:]})
+	if err != nil {
+		t.Fatalf("Failed to create trusted proxies: %v", err)
+	}
+
+	tests := []struct {
+		name       string
+		remoteAddr string
+		cert       *x509.Certificate
+		want       bool
+	}{
+		{"trusted network", "10.1.2.3:5000", nil, true},
+		{"trusted address", "192.0.2.10:5000", nil, true},
+		{"untrusted address", "192.0.2.11:5000", nil, false},
+		{"pinned key", "203.0.113.5:5000", proxyCert, true},
+		{"other key", "203.0.113.5:5000", otherCert, false},
+		{"other key with the proxy's name", "203.0.113.5:5000", impostorCert, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := proxies.Trusts(tt.remoteAddr, tt.cert); got != tt.want {
+				t.Errorf("Expected Trusts to return %v, got %v", tt.want, got)
+			}
+		})
+	}
+
+	if _, err := NewTrustedProxies(nil, nil); err == nil {
+		t.Error("Expected an error without trusted proxies")
+	}
+	if _, err := NewTrustedProxies([]string{"10.0.0.0/33"}, nil); err == nil {
+		t.Error("Expected an error for an invalid CIDR")
+	}
+	if _, err := NewTrustedProxies(nil, []string{"spiffe://example.org/envoy"}); err == nil {
+		t.Error("Expected an error for a name instead of an SPKI fingerprint")
+	}
+}
@@ -0,0 +1,207 @@
+package auth
+
+import (
+	"crypto/x509"
+	"fmt"
+)
+
+// IdentityMode selects which certificate attribute identifies a device across renewals
+type IdentityMode string
+
+const (
+	// IdentityModeIssuerSerial identifies a device by issuer DN and serial number, so every
+	// renewed certificate registers a new device
+	IdentityModeIssuerSerial IdentityMode = "issuer_serial"
+	// IdentityModeSPKI binds a device to the SHA-256 hash of its subject public key info
+	IdentityModeSPKI IdentityMode = "spki"
+	// IdentityModeSubjectCN binds a device to its certificate subject common name
+	IdentityModeSubjectCN IdentityMode = "subject_cn"
+	// IdentityModeSAN binds a device to the first subject alternative name of a configured type
+	IdentityModeSAN IdentityMode = "san"
+	// IdentityModeSPIFFE binds a device to the SPIFFE ID of its X.509-SVID, accepting only
+	// configured trust domains whose SVIDs chain to the domain's own trust bundle
+	IdentityModeSPIFFE IdentityMode = "spiffe"
+)
+
+// Subject alternative name types usable with IdentityModeSAN
+const (
+	SANTypeDNS   = "dns"
+	SANTypeEmail = "email"
+	SANTypeURI   = "uri"
+)
+
+// IdentityConfig describes how device identity is derived from a certificate
+type IdentityConfig struct {
+	Mode    IdentityMode
+	SANType string
+	// TrustBundles maps each accepted SPIFFE trust domain to the CA certificates that may
+	// issue its SVIDs
+	TrustBundles map[string][]*x509.Certificate
+}
+
+// NewIdentityConfig validates and builds an identity configuration. The trust bundles are
+// only used, and then required, in SPIFFE mode.
+func NewIdentityConfig(mode, sanType string, trustBundles map[string][]*x509.Certificate) (IdentityConfig, error) {
+	config := IdentityConfig{Mode: IdentityMode(mode), SANType: sanType}
+
+	switch config.Mode {
+	case IdentityModeIssuerSerial, IdentityModeSPKI, IdentityModeSubjectCN:
+		return config, nil
+	case IdentityModeSPIFFE:
+		if len(trustBundles) == 0 {
+			return IdentityConfig{}, fmt.Errorf("SPIFFE identity mode requires at least one trust domain")
+		}
+		for trustDomain, bundle := range trustBundles {
+			if err := ValidateTrustDomain(trustDomain); err != nil {
+				return IdentityConfig{}, err
+			}
+			if len(bundle) == 0 {
+				return IdentityConfig{}, fmt.Errorf("SPIFFE trust domain %q has an empty trust bundle", trustDomain)
+			}
+		}
+		config.TrustBundles = trustBundles
+		return config, nil
+	case IdentityModeSAN:
+		switch sanType {
+		case SANTypeDNS, SANTypeEmail, SANTypeURI:
+			return config, nil
+		default:
+			return IdentityConfig{}, fmt.Errorf("invalid SAN type %q (expected %q, %q or %q)", sanType, SANTypeDNS, SANTypeEmail, SANTypeURI)
+		}
+	default:
+		return IdentityConfig{}, fmt.Errorf("invalid identity mode %q", mode)
+	}
+}
+
+// LoadTrustBundles reads the CA certificates of each SPIFFE trust domain from the PEM file
+// configured for it
+func LoadTrustBundles(paths map[string]string) (map[string][]*x509.Certificate, error) {
+	bundles := make(map[string][]*x509.Certificate, len(paths))
+	for trustDomain, path := range paths {
+		bundle, err := LoadCertificates(path)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load trust bundle of SPIFFE trust domain %q: %w", trustDomain, err)
+		}
+		bundles[trustDomain] = bundle
+	}
+	return bundles, nil
+}
+
+// VerifyTrustDomain checks, in SPIFFE mode, that a verified client certificate chains to the
+// trust bundle of the trust domain in its SPIFFE ID, so that one trust domain's CA cannot
+// issue SVIDs for another. The intermediates are taken from the verified chains. Outside
+// SPIFFE mode it accepts every certificate.
+func (c IdentityConfig) VerifyTrustDomain(leaf *x509.Certificate, verifiedChains [][]*x509.Certificate) error {
+	if c.Mode != IdentityModeSPIFFE {
+		return nil
+	}
+
+	spiffeID := CertificateSPIFFEID(leaf)
+	if spiffeID == "" {
+		return fmt.Errorf("certificate has no SPIFFE ID")
+	}
+	id, err := ParseSPIFFEID(spiffeID)
+	if err != nil {
+		return err
+	}
+
+	bundle, ok := c.TrustBundles[id.TrustDomain]
+	if !ok {
+		return fmt.Errorf("SPIFFE trust domain %q is not allowed", id.TrustDomain)
+	}
+
+	roots := x509.NewCertPool()
+	for _, cert := range bundle {
+		roots.AddCert(cert)
+	}
+	intermediates := x509.NewCertPool()
+	for _, chain := range verifiedChains {
+		for _, cert := range chain[1:] {
+			intermediates.AddCert(cert)
+		}
+	}
+
+	_, err = leaf.Verify(x509.VerifyOptions{
+		Roots:         roots,
+		Intermediates: intermediates,
+		KeyUsages:     []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	})
+	if err != nil {
+		return fmt.Errorf("certificate does not chain to the trust bundle of SPIFFE trust domain %q: %w", id.TrustDomain, err)
+	}
+	return nil
+}
+
+// Key returns the stable identity key for a certificate. It returns an empty key in
+// issuer/serial mode, where the device is looked up by issuer DN and serial number instead.
+// Common names and SANs are only unique per CA, so their keys are scoped by issuer DN.
+func (c IdentityConfig) Key(info *CertificateInfo) (string, error) {
+	if info == nil || !info.IsValid {
+		return "", fmt.Errorf("invalid certificate information")
+	}
+
+	switch c.Mode {
+	case IdentityModeIssuerSerial, "":
+		return "", nil
+	case IdentityModeSPKI:
+		if info.SPKIFingerprint == "" {
+			return "", fmt.Errorf("certificate public key fingerprint is missing")
+		}
+		return "spki:" + info.SPKIFingerprint, nil
+	case IdentityModeSubjectCN:
+		if info.SubjectCN == "" {
+			return "", fmt.Errorf("certificate subject common name is missing")
+		}
+		return "cn/" + issuerScope(info) + ":" + info.SubjectCN, nil
+	case IdentityModeSAN:
+		value := firstSAN(info, c.SANType)
+		if value == "" {
+			return "", fmt.Errorf("certificate has no %s subject alternative name", c.SANType)
+		}
+		return "san/" + issuerScope(info) + ":" + c.SANType + ":" + value, nil
+	case IdentityModeSPIFFE:
+		if info.SPIFFEID == "" {
+			return "", fmt.Errorf("certificate has no SPIFFE ID")
+		}
+		id, err := ParseSPIFFEID(info.SPIFFEID)
+		if err != nil {
+			return "", err
+		}
+		if !c.allowsTrustDomain(id.TrustDomain) {
+			return "", fmt.Errorf("SPIFFE trust domain %q is not allowed", id.TrustDomain)
+		}
+		return "spiffe:" + info.SPIFFEID, nil
+	default:
+		return "", fmt.Errorf("invalid identity mode %q", c.Mode)
+	}
+}
+
+// issuerScope returns the fixed-length scope of identity keys only unique per issuer: the
+// hex SHA-256 of the issuer DN
+func issuerScope(info *CertificateInfo) string {
+	return fingerprint([]byte(info.IssuerDN))
+}
+
+// allowsTrustDomain reports whether the trust domain is one of the configured ones
+func (c IdentityConfig) allowsTrustDomain(trustDomain string) bool {
+	_, ok := c.TrustBundles[trustDomain]
+	return ok
+}
+
+// firstSAN returns the first subject alternative name of the given type
+func firstSAN(info *CertificateInfo, sanType string) string {
+	var values []string
+	switch sanType {
+	case SANTypeDNS:
+		values = info.DNSNames
+	case SANTypeEmail:
+		values = info.EmailAddresses
+	case SANTypeURI:
+		values = info.URIs
+	}
+
+	if len(values) == 0 {
+		return ""
+	}
+	return values[0]
+}
@@ -0,0 +1,208 @@
+package auth
+
+import (
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"math/big"
+	"net/url"
+	"testing"
+)
+
+func TestIdentityConfigKey(t *testing.T) {
+	ca := newTestCA(t, "Test CA")
+	key := newTestKey(t)
+	deviceURI, _ := url.Parse("urn:device:1234")
+
+	cert := ca.issue(t, &x509.Certificate{
+		SerialNumber:   big.NewInt(42),
+		Subject:        pkix.Name{CommonName: "device-1234"},
+		DNSNames:       []string{"device-1234.example.com"},
+		EmailAddresses: []string{"device@example.com"},
+		URIs:           []*url.URL{deviceURI},
+	}, key.Public())
+
+	// A renewed certificate for the same key and names but a new serial
+	renewed := ca.issue(t, &x509.Certificate{
+		SerialNumber:   big.NewInt(43),
+		Subject:        pkix.Name{CommonName: "device-1234"},
+		DNSNames:       []string{"device-1234.example.com"},
+		EmailAddresses: []string{"device@example.com"},
+		URIs:           []*url.URL{deviceURI},
+	}, key.Public())
+
+	issuer := fingerprint([]byte("CN=Test CA"))
+
+	tests := []struct {
+		mode    string
+		sanType string
+		want    string
+	}{
+		{mode: "issuer_serial", want: ""},
+		{mode: "spki", want: "spki:" + fingerprint(cert.RawSubjectPublicKeyInfo)},
+		{mode: "subject_cn", want: "cn/" + issuer + ":device-1234"},
+		{mode: "san", sanType: "dns", want: "san/" + issuer + ":dns:device-1234.example.com"},
+		{mode: "san", sanType: "email", want: "san/" + issuer + ":email:device@example.com"},
+		{mode: "san", sanType: "uri", want: "san/" + issuer + ":uri:urn:device:1234"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.mode+tt.sanType, func(t *testing.T) {
+			config, err := NewIdentityConfig(tt.mode, tt.sanType, nil)
+			if err != nil {
+				t.Fatalf("Failed to create identity config: %v", err)
+			}
+
+			got, err := config.Key(ExtractCertificateInfo(cert))
+			if err != nil {
+				t.Fatalf("Failed to derive identity key: %v", err)
+			}
+			if got != tt.want {
+				t.Errorf("Expected identity key %q, got %q", tt.want, got)
+			}
+
+			renewedKey, err := config.Key(ExtractCertificateInfo(renewed))
+			if err != nil {
+				t.Fatalf("Failed to derive identity key for renewed certificate: %v", err)
+			}
+			if renewedKey != got {
+				t.Errorf("Expected renewed certificate to keep identity key %q, got %q", got, renewedKey)
+			}
+		})
+	}
+}
+
+func TestIdentityConfigKeyScopedByIssuer(t *testing.T) {
+	ca := newTestCA(t, "Test CA")
+	otherCA := newTestCA(t, "Other CA")
+
+	issue := func(ca *testCA) *CertificateInfo {
+		return ExtractCertificateInfo(ca.issue(t, &x509.Certificate{
+			SerialNumber: big.NewInt(42),
+			Subject:      pkix.Name{CommonName: "device-1234"},
+			DNSNames:     []string{"device-1234.example.com"},
+		}, newTestKey(t).Public()))
+	}
+
+	for _, mode := range []string{"subject_cn", "san"} {
+		config, err := NewIdentityConfig(mode, "dns", nil)
+		if err != nil {
+			t.Fatalf("Failed to create identity config: %v", err)
+		}
+
+		key, _ := config.Key(issue(ca))
+		otherKey, _ := config.Key(issue(otherCA))
+		if key == "" || key == otherKey {
+			t.Errorf("Expected %s identity keys of different CAs to differ, got %q and %q", mode, key, otherKey)
+		}
+	}
+}
+
+func TestIdentityConfigMissingAttribute(t *testing.T) {
+	ca := newTestCA(t, "Test CA")
+	cert, _ := ca.issueClient(t, 1, "device")
+
+	config, err := NewIdentityConfig("san", "uri", nil)
+	if err != nil {
+		t.Fatalf("Failed to create identity config: %v", err)
+	}
+
+	if _, err := config.Key(ExtractCertificateInfo(cert)); err == nil {
+		t.Error("Expected an error for a certificate without a URI SAN")
+	}
+}
+
+func TestNewIdentityConfigRejectsInvalidValues(t *testing.T) {
+	if _, err := NewIdentityConfig("fingerprint", "", nil); err == nil {
+		t.Error("Expected an error for an unknown identity mode")
+	}
+
+	if _, err := NewIdentityConfig("san", "ip", nil); err == nil {
+		t.Error("Expected an error for an unsupported SAN type")
+	}
+
+	if _, err := NewIdentityConfig("spiffe", "", nil); err == nil {
+		t.Error("Expected an error for SPIFFE mode without trust domains")
+	}
+
+	ca := newTestCA(t, "")
+	if _, err := NewIdentityConfig("spiffe", "", map[string][]*x509.Certificate{"Example.org": {ca.cert}}); err == nil {
+		t.Error("Expected an error for an invalid trust domain")
+	}
+
+	if _, err := NewIdentityConfig("spiffe", "", map[string][]*x509.Certificate{"example.org": nil}); err == nil {
+		t.Error("Expected an error for a trust domain without a trust bundle")
+	}
+}
+
+func TestIdentityConfigSPIFFE(t *testing.T) {
+	ca := newTestCA(t, "")
+
+	config, err := NewIdentityConfig("spiffe", "", map[string][]*x509.Certificate{
+		"example.org":         {ca.cert},
+		"devices.example.com": {ca.cert},
+	})
+	if err != nil {
+		t.Fatalf("Failed to create identity config: %v", err)
+	}
+
+	got, err := config.Key(ExtractCertificateInfo(issueSVID(t, ca, 1, "spiffe://devices.example.com/device/1")))
+	if err != nil {
+		t.Fatalf("Failed to derive identity key: %v", err)
+	}
+	if got != "spiffe:spiffe://devices.example.com/device/1" {
+		t.Errorf("Unexpected identity key %q", got)
+	}
+
+	if _, err := config.Key(ExtractCertificateInfo(issueSVID(t, ca, 2, "spiffe://other.org/device/1"))); err == nil {
+		t.Error("Expected an error for a SPIFFE ID outside the allowed trust domains")
+	}
+
+	if _, err := config.Key(ExtractCertificateInfo(issueSVID(t, ca, 3))); err == nil {
+		t.Error("Expected an error for a certificate without a SPIFFE ID")
+	}
+}
+
+func TestIdentityConfigVerifyTrustDomain(t *testing.T) {
+	devicesCA := newTestCA(t, "Devices CA")
+	otherCA := newTestCA(t, "Other CA")
+
+	config, err := NewIdentityConfig("spiffe", "", map[string][]*x509.Certificate{
+		"devices.example.com": {devicesCA.cert},
+		"other.example.com":   {otherCA.cert},
+	})
+	if err != nil {
+		t.Fatalf("Failed to create identity config: %v", err)
+	}
+
+	tests := []struct {
+		name  string
+		ca    *testCA
+		uris  []string
+		valid bool
+	}{
+		{"issued by the trust domain's CA", devicesCA, []string{"spiffe://devices.example.com/device/1"}, true},
+		// Both CAs are client CAs, but only one is trusted for each trust domain
+		{"issued by another trust domain's CA", otherCA, []string{"spiffe://devices.example.com/device/1"}, false},
+		{"trust domain not allowed", devicesCA, []string{"spiffe://example.org/device/1"}, false},
+		{"no SPIFFE ID", devicesCA, nil, false},
+	}
+
+	for i, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cert := issueSVID(t, tt.ca, int64(i+1), tt.uris...)
+			err := config.VerifyTrustDomain(cert, [][]*x509.Certificate{{cert, tt.ca.cert}})
+			if tt.valid && err != nil {
+				t.Errorf("Expected the SVID to be accepted, got %v", err)
+			}
+			if !tt.valid && err == nil {
+				t.Error("Expected the SVID to be rejected")
+			}
+		})
+	}
+
+	subjectCN, _ := NewIdentityConfig("subject_cn", "", nil)
+	cert := issueSVID(t, otherCA, 10, "spiffe://devices.example.com/device/1")
+	if err := subjectCN.VerifyTrustDomain(cert, nil); err != nil {
+		t.Errorf("Expected trust domains to be ignored outside SPIFFE mode, got %v", err)
+	}
+}
@@ -2,39 +2,169 @@ package auth
 
 import (
 	"fmt"
+	"sort"
+	"sync"
 	"time"
 
 	"github.com/golang-jwt/jwt/v5"
+	"github.com/google/uuid"
 )
 
-// Claims represents the JWT claims structure
+// Claims represents the JWT claims structure. Roles and Permissions are resolved into a
+// Principal by RBAC; tokens without roles are treated as belonging to a regular user.
+// SessionID links a token to the login session that issued it, so it can be revoked.
+// Confirmation optionally binds the token to a client certificate.
 type Claims struct {
-	UserID string `json:"user_id"`
+	UserID       string        `json:"user_id"`
+	Roles        []string      `json:"roles,omitempty"`
+	Permissions  []string      `json:"permissions,omitempty"`
+	SessionID    string        `json:"sid,omitempty"`
+	Confirmation *Confirmation `json:"cnf,omitempty"`
 	jwt.RegisteredClaims
 }
 
-// JWTManager handles JWT token creation and validation
+// JWTManager handles JWT token creation and validation. Tokens are signed with the
+// designated signing key and verified with whichever active key matches their kid header,
+// so a signing key can be rotated while tokens signed with the previous key stay valid.
 type JWTManager struct {
-	secretKey     []byte
 	tokenDuration time.Duration
 	issuer        string
+
+	mu         sync.RWMutex
+	keys       map[string]*JWTKey
+	signingKey *JWTKey
 }
 
-// NewJWTManager creates a new JWT manager
+// NewJWTManager creates a new JWT manager that signs with a single HMAC secret
 func NewJWTManager(secretKey string, tokenDuration time.Duration, issuer string) *JWTManager {
+	key := NewHMACJWTKey("", []byte(secretKey))
 	return &JWTManager{
-		secretKey:     []byte(secretKey),
 		tokenDuration: tokenDuration,
 		issuer:        issuer,
+		keys:          map[string]*JWTKey{key.ID: key},
+		signingKey:    key,
 	}
 }
 
+// NewJWTManagerWithKeys creates a JWT manager with a signing key and additional
+// verification-only keys, typically the previous signing keys during a rotation
+func NewJWTManagerWithKeys(signingKey *JWTKey, verificationKeys []*JWTKey, tokenDuration time.Duration, issuer string) (*JWTManager, error) {
+	j := &JWTManager{
+		tokenDuration: tokenDuration,
+		issuer:        issuer,
+		keys:          make(map[string]*JWTKey),
+	}
+
+	for _, key := range verificationKeys {
+		j.AddKey(key)
+	}
+
+	if err := j.SetSigningKey(signingKey); err != nil {
+		return nil, err
+	}
+
+	return j, nil
+}
+
+// TokenDuration returns how long issued tokens stay valid
+func (j *JWTManager) TokenDuration() time.Duration {
+	return j.tokenDuration
+}
+
+// AddKey adds or replaces a verification key
+func (j *JWTManager) AddKey(key *JWTKey) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	j.keys[key.ID] = key
+}
+
+// SetSigningKey makes the key the one used for new tokens and adds it to the verification keys
+func (j *JWTManager) SetSigningKey(key *JWTKey) error {
+	if key == nil || !key.CanSign() {
+		return fmt.Errorf("signing key must include private key material")
+	}
+
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	j.keys[key.ID] = key
+	j.signingKey = key
+	return nil
+}
+
+// RemoveKey retires a verification key; tokens signed with it are rejected afterwards.
+// The current signing key cannot be removed.
+func (j *JWTManager) RemoveKey(keyID string) error {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	if j.signingKey != nil && j.signingKey.ID == keyID {
+		return fmt.Errorf("cannot remove the current signing key")
+	}
+
+	delete(j.keys, keyID)
+	return nil
+}
+
+// JWKS returns the public verification keys for publication at /.well-known/jwks.json.
+// Symmetric keys are never published.
+func (j *JWTManager) JWKS() JWKSet {
+	j.mu.RLock()
+	defer j.mu.RUnlock()
+
+	set := JWKSet{Keys: []JWK{}}
+	for _, key := range j.keys {
+		if jwk, ok := key.JWK(); ok {
+			set.Keys = append(set.Keys, jwk)
+		}
+	}
+
+	sort.Slice(set.Keys, func(a, b int) bool { return set.Keys[a].KeyID < set.Keys[b].KeyID })
+	return set
+}
+
+// verificationKey selects the key for a parsed token by kid, rejecting algorithm mismatches
+func (j *JWTManager) verificationKey(token *jwt.Token) (interface{}, error) {
+	keyID, _ := token.Header["kid"].(string)
+
+	j.mu.RLock()
+	key, ok := j.keys[keyID]
+	j.mu.RUnlock()
+
+	if !ok {
+		return nil, fmt.Errorf("unknown signing key %q", keyID)
+	}
+
+	if token.Method.Alg() != key.Method.Alg() {
+		return nil, fmt.Errorf("unexpected signing method: %v", token.Header["alg"])
+	}
+
+	return key.verifyKey, nil
+}
+
 // GenerateToken creates a new JWT token for the given user ID
 func (j *JWTManager) GenerateToken(userID string) (string, error) {
+	return j.GenerateTokenWithRoles(userID, nil)
+}
+
+// GenerateTokenWithRoles creates a new JWT token for the given user ID carrying the given roles
+func (j *JWTManager) GenerateTokenWithRoles(userID string, roles []string) (string, error) {
+	return j.GenerateSessionToken(userID, roles, "", nil)
+}
+
+// GenerateSessionToken creates a new JWT token for the given user ID carrying the given
+// roles and the ID of the login session it belongs to. Every token has a unique jti. The
+// confirmation may be nil; otherwise it binds the token to a client certificate.
+func (j *JWTManager) GenerateSessionToken(userID string, roles []string, sessionID string, cnf *Confirmation) (string, error) {
 	now := time.Now()
 	claims := &Claims{
-		UserID: userID,
+		UserID:       userID,
+		Roles:        roles,
+		SessionID:    sessionID,
+		Confirmation: cnf,
 		RegisteredClaims: jwt.RegisteredClaims{
+			ID:        uuid.NewString(),
 			Issuer:    j.issuer,
 			Subject:   userID,
 			IssuedAt:  jwt.NewNumericDate(now),
@@ -43,8 +173,20 @@ func (j *JWTManager) GenerateToken(userID string) (string, error) {
 		},
 	}
 
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
-	tokenString, err := token.SignedString(j.secretKey)
+	return j.sign(claims)
+}
+
+// sign signs the claims with the current signing key, naming it in the kid header
+func (j *JWTManager) sign(claims jwt.Claims) (string, error) {
+	j.mu.RLock()
+	signingKey := j.signingKey
+	j.mu.RUnlock()
+
+	token := jwt.NewWithClaims(signingKey.Method, claims)
+	if signingKey.ID != "" {
+		token.Header["kid"] = signingKey.ID
+	}
+	tokenString, err := token.SignedString(signingKey.signKey)
 	if err != nil {
 		return "", fmt.Errorf("failed to sign token: %w", err)
 	}
@@ -54,12 +196,7 @@ func (j *JWTManager) GenerateToken(userID string) (string, error) {
 
 // ValidateToken validates and parses a JWT token, returning the claims
 func (j *JWTManager) ValidateToken(tokenString string) (*Claims, error) {
-	token, err := jwt.ParseWithClaims(tokenString, &Claims{}, func(token *jwt.Token) (interface{}, error) {
-		if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
-			return nil, fmt.Errorf("unexpected signing method: %v", token.Header["alg"])
-		}
-		return j.secretKey, nil
-	})
+	token, err := jwt.ParseWithClaims(tokenString, &Claims{}, j.verificationKey)
 
 	if err != nil {
 		return nil, fmt.Errorf("failed to parse token: %w", err)
@@ -0,0 +1,277 @@
+package auth
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/pem"
+	"fmt"
+	"math/big"
+	"os"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// JWTKey is a key used to sign and/or verify tokens, identified by its key ID (kid)
+type JWTKey struct {
+	ID        string
+	Method    jwt.SigningMethod
+	signKey   interface{}
+	verifyKey interface{}
+}
+
+// NewHMACJWTKey creates a symmetric key used for both signing and verification
+func NewHMACJWTKey(id string, secret []byte) *JWTKey {
+	return &JWTKey{
+		ID:        id,
+		Method:    jwt.SigningMethodHS256,
+		signKey:   secret,
+		verifyKey: secret,
+	}
+}
+
+// NewAsymmetricJWTKey creates a key from an RSA, ECDSA or Ed25519 private or public key.
+// Private keys can sign and verify; public keys can only verify. The key ID is derived
+// from the public key, and the signing method from the key type.
+func NewAsymmetricJWTKey(key interface{}) (*JWTKey, error) {
+	var publicKey crypto.PublicKey
+	var signKey interface{}
+
+	switch k := key.(type) {
+	case *rsa.PrivateKey:
+		publicKey, signKey = &k.PublicKey, k
+	case *ecdsa.PrivateKey:
+		publicKey, signKey = &k.PublicKey, k
+	case ed25519.PrivateKey:
+		publicKey, signKey = k.Public(), k
+	case *rsa.PublicKey, *ecdsa.PublicKey, ed25519.PublicKey:
+		publicKey = k
+	default:
+		return nil, fmt.Errorf("unsupported key type %T", key)
+	}
+
+	method, err := signingMethodForKey(publicKey)
+	if err != nil {
+		return nil, err
+	}
+
+	keyID, err := deriveKeyID(publicKey)
+	if err != nil {
+		return nil, err
+	}
+
+	return &JWTKey{
+		ID:        keyID,
+		Method:    method,
+		signKey:   signKey,
+		verifyKey: publicKey,
+	}, nil
+}
+
+// LoadJWTKey loads a PEM-encoded private or public key from a file
+func LoadJWTKey(path string) (*JWTKey, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read key file: %w", err)
+	}
+
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, fmt.Errorf("no PEM data found in %s", path)
+	}
+
+	var key interface{}
+	switch block.Type {
+	case "PRIVATE KEY":
+		key, err = x509.ParsePKCS8PrivateKey(block.Bytes)
+	case "RSA PRIVATE KEY":
+		key, err = x509.ParsePKCS1PrivateKey(block.Bytes)
+	case "EC PRIVATE KEY":
+		key, err = x509.ParseECPrivateKey(block.Bytes)
+	case "PUBLIC KEY":
+		key, err = x509.ParsePKIXPublicKey(block.Bytes)
+	case "RSA PUBLIC KEY":
+		key, err = x509.ParsePKCS1PublicKey(block.Bytes)
+	default:
+		return nil, fmt.Errorf("unsupported PEM block type %q in %s", block.Type, path)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse key in %s: %w", path, err)
+	}
+
+	return NewAsymmetricJWTKey(key)
+}
+
+// CanSign reports whether the key holds private material
+func (k *JWTKey) CanSign() bool {
+	return k.signKey != nil
+}
+
+// JWK is a JSON Web Key (RFC 7517) holding a public verification key
+type JWK struct {
+	KeyType   string `json:"kty"`
+	KeyID     string `json:"kid"`
+	Use       string `json:"use"`
+	Algorithm string `json:"alg"`
+	Curve     string `json:"crv,omitempty"`
+	N         string `json:"n,omitempty"`
+	E         string `json:"e,omitempty"`
+	X         string `json:"x,omitempty"`
+	Y         string `json:"y,omitempty"`
+}
+
+// JWKSet is a JSON Web Key Set
+type JWKSet struct {
+	Keys []JWK `json:"keys"`
+}
+
+// JWK returns the public JSON Web Key, or false for symmetric keys that must not be published
+func (k *JWTKey) JWK() (JWK, bool) {
+	jwk := JWK{
+		KeyID:     k.ID,
+		Use:       "sig",
+		Algorithm: k.Method.Alg(),
+	}
+
+	switch key := k.verifyKey.(type) {
+	case *rsa.PublicKey:
+		jwk.KeyType = "RSA"
+		jwk.N = base64URL(key.N.Bytes())
+		jwk.E = base64URL(big.NewInt(int64(key.E)).Bytes())
+	case *ecdsa.PublicKey:
+		size := (key.Curve.Params().BitSize + 7) / 8
+		jwk.KeyType = "EC"
+		jwk.Curve = key.Curve.Params().Name
+		jwk.X = base64URL(key.X.FillBytes(make([]byte, size)))
+		jwk.Y = base64URL(key.Y.FillBytes(make([]byte, size)))
+	case ed25519.PublicKey:
+		jwk.KeyType = "OKP"
+		jwk.Curve = "Ed25519"
+		jwk.X = base64URL(key)
+	default:
+		return JWK{}, false
+	}
+
+	return jwk, true
+}
+
+// signingMethodForKey picks the JWS algorithm matching a public key
+func signingMethodForKey(publicKey crypto.PublicKey) (jwt.SigningMethod, error) {
+	switch key := publicKey.(type) {
+	case *rsa.PublicKey:
+		return jwt.SigningMethodRS256, nil
+	case *ecdsa.PublicKey:
+		switch key.Curve {
+		case elliptic.P256():
+			return jwt.SigningMethodES256, nil
+		case elliptic.P384():
+			return jwt.SigningMethodES384, nil
+		case elliptic.P521():
+			return jwt.SigningMethodES512, nil
+		default:
+			return nil, fmt.Errorf("unsupported ECDSA curve %s", key.Curve.Params().Name)
+		}
+	case ed25519.PublicKey:
+		return jwt.SigningMethodEdDSA, nil
+	default:
+		return nil, fmt.Errorf("unsupported public key type %T", publicKey)
+	}
+}
+
+// deriveKeyID derives a stable key ID from the SHA-256 hash of the public key
+func deriveKeyID(publicKey crypto.PublicKey) (string, error) {
+	der, err := x509.MarshalPKIXPublicKey(publicKey)
+	if err != nil {
+		return "", fmt.Errorf("failed to encode public key: %w", err)
+	}
+
+	sum := sha256.Sum256(der)
+	return hex.EncodeToString(sum[:8]), nil
+}
+
+// base64URL encodes bytes as unpadded base64url
+func base64URL(data []byte) string {
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+// PublicKey decodes the JWK into an RSA, ECDSA or Ed25519 public key
+func (k JWK) PublicKey() (crypto.PublicKey, error) {
+	switch k.KeyType {
+	case "RSA":
+		n, err := base64.RawURLEncoding.DecodeString(k.N)
+		if err != nil {
+			return nil, fmt.Errorf("invalid RSA modulus: %w", err)
+		}
+		e, err := base64.RawURLEncoding.DecodeString(k.E)
+		if err != nil {
+			return nil, fmt.Errorf("invalid RSA exponent: %w", err)
+		}
+		return &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(new(big.Int).SetBytes(e).Int64())}, nil
+	case "EC":
+		var curve elliptic.Curve
+		switch k.Curve {
+		case "P-256":
+			curve = elliptic.P256()
+		case "P-384":
+			curve = elliptic.P384()
+		case "P-521":
+			curve = elliptic.P521()
+		default:
+			return nil, fmt.Errorf("unsupported EC curve %q", k.Curve)
+		}
+		x, err := base64.RawURLEncoding.DecodeString(k.X)
+		if err != nil {
+			return nil, fmt.Errorf("invalid EC x coordinate: %w", err)
+		}
+		y, err := base64.RawURLEncoding.DecodeString(k.Y)
+		if err != nil {
+			return nil, fmt.Errorf("invalid EC y coordinate: %w", err)
+		}
+		key := &ecdsa.PublicKey{Curve: curve, X: new(big.Int).SetBytes(x), Y: new(big.Int).SetBytes(y)}
+		if !curve.IsOnCurve(key.X, key.Y) {
+			return nil, fmt.Errorf("EC point is not on curve %s", k.Curve)
+		}
+		return key, nil
+	case "OKP":
+		if k.Curve != "Ed25519" {
+			return nil, fmt.Errorf("unsupported OKP curve %q", k.Curve)
+		}
+		x, err := base64.RawURLEncoding.DecodeString(k.X)
+		if err != nil || len(x) != ed25519.PublicKeySize {
+			return nil, fmt.Errorf("invalid Ed25519 public key")
+		}
+		return ed25519.PublicKey(x), nil
+	default:
+		return nil, fmt.Errorf("unsupported key type %q", k.KeyType)
+	}
+}
+
+// NewJWTKeyFromJWK creates a verification key from a published JWK, keeping its kid and alg
+func NewJWTKeyFromJWK(jwk JWK) (*JWTKey, error) {
+	publicKey, err := jwk.PublicKey()
+	if err != nil {
+		return nil, err
+	}
+
+	key, err := NewAsymmetricJWTKey(publicKey)
+	if err != nil {
+		return nil, err
+	}
+
+	key.ID = jwk.KeyID
+	if jwk.Algorithm != "" {
+		method := jwt.GetSigningMethod(jwk.Algorithm)
+		if method == nil {
+			return nil, fmt.Errorf("unsupported algorithm %q", jwk.Algorithm)
+		}
+		key.Method = method
+	}
+
+	return key, nil
+}
@@ -0,0 +1,244 @@
+package auth
+
+import (
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+func newTestJWTKey(t *testing.T, alg string) *JWTKey {
+	t.Helper()
+
+	var privateKey interface{}
+	var err error
+	switch alg {
+	case "RS256":
+		privateKey, err = rsa.GenerateKey(rand.Reader, 2048)
+	case "ES256":
+		privateKey, err = ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	case "EdDSA":
+		_, privateKey, err = ed25519.GenerateKey(rand.Reader)
+	}
+	if err != nil {
+		t.Fatalf("Failed to generate %s key: %v", alg, err)
+	}
+
+	key, err := NewAsymmetricJWTKey(privateKey)
+	if err != nil {
+		t.Fatalf("Failed to create %s JWT key: %v", alg, err)
+	}
+	return key
+}
+
+func TestJWTManagerAsymmetricAlgorithms(t *testing.T) {
+	for _, alg := range []string{"RS256", "ES256", "EdDSA"} {
+		t.Run(alg, func(t *testing.T) {
+			key := newTestJWTKey(t, alg)
+			if key.Method.Alg() != alg {
+				t.Fatalf("Expected algorithm %s, got %s", alg, key.Method.Alg())
+			}
+
+			manager, err := NewJWTManagerWithKeys(key, nil, time.Hour, "test")
+			if err != nil {
+				t.Fatalf("Failed to create JWT manager: %v", err)
+			}
+
+			token, err := manager.GenerateToken("user-1")
+			if err != nil {
+				t.Fatalf("Failed to generate token: %v", err)
+			}
+
+			claims, err := manager.ValidateToken(token)
+			if err != nil {
+				t.Fatalf("Failed to validate token: %v", err)
+			}
+			if claims.UserID != "user-1" {
+				t.Errorf("Expected user ID user-1, got %s", claims.UserID)
+			}
+		})
+	}
+}
+
+func TestJWTManagerRotationKeepsPreviousTokensValid(t *testing.T) {
+	oldKey := newTestJWTKey(t, "ES256")
+	newKey := newTestJWTKey(t, "ES256")
+
+	manager, err := NewJWTManagerWithKeys(oldKey, nil, time.Hour, "test")
+	if err != nil {
+		t.Fatalf("Failed to create JWT manager: %v", err)
+	}
+
+	oldToken, err := manager.GenerateToken("user-1")
+	if err != nil {
+		t.Fatalf("Failed to generate token: %v", err)
+	}
+
+	if err := manager.SetSigningKey(newKey); err != nil {
+		t.Fatalf("Failed to rotate signing key: %v", err)
+	}
+
+	newToken, err := manager.GenerateToken("user-1")
+	if err != nil {
+		t.Fatalf("Failed to generate token: %v", err)
+	}
+
+	parsed, _, err := jwt.NewParser().ParseUnverified(newToken, &Claims{})
+	if err != nil {
+		t.Fatalf("Failed to parse token: %v", err)
+	}
+	if parsed.Header["kid"] != newKey.ID {
+		t.Errorf("Expected new tokens to carry kid %s, got %v", newKey.ID, parsed.Header["kid"])
+	}
+
+	if _, err := manager.ValidateToken(oldToken); err != nil {
+		t.Errorf("Expected token signed with the previous key to stay valid, got %v", err)
+	}
+	if _, err := manager.ValidateToken(newToken); err != nil {
+		t.Errorf("Expected token signed with the new key to be valid, got %v", err)
+	}
+
+	if err := manager.RemoveKey(oldKey.ID); err != nil {
+		t.Fatalf("Failed to remove old key: %v", err)
+	}
+	if _, err := manager.ValidateToken(oldToken); err == nil {
+		t.Error("Expected token signed with a retired key to be rejected")
+	}
+	if err := manager.RemoveKey(newKey.ID); err == nil {
+		t.Error("Expected removing the current signing key to fail")
+	}
+}
+
+func TestJWTManagerRejectsAlgorithmConfusion(t *testing.T) {
+	key := newTestJWTKey(t, "RS256")
+	manager, err := NewJWTManagerWithKeys(key, nil, time.Hour, "test")
+	if err != nil {
+		t.Fatalf("Failed to create JWT manager: %v", err)
+	}
+
+	// An attacker signs an HS256 token using the public key as the HMAC secret
+	publicDER, _ := x509.MarshalPKIXPublicKey(key.verifyKey)
+	forged := jwt.NewWithClaims(jwt.SigningMethodHS256, &Claims{UserID: "attacker"})
+	forged.Header["kid"] = key.ID
+	tokenString, err := forged.SignedString(pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: publicDER}))
+	if err != nil {
+		t.Fatalf("Failed to sign forged token: %v", err)
+	}
+
+	if _, err := manager.ValidateToken(tokenString); err == nil {
+		t.Error("Expected HS256 token to be rejected by an RS256 key")
+	}
+}
+
+func TestJWTManagerHMACCompatibility(t *testing.T) {
+	manager := NewJWTManager("secret", time.Hour, "test")
+
+	token, err := manager.GenerateToken("user-1")
+	if err != nil {
+		t.Fatalf("Failed to generate token: %v", err)
+	}
+
+	if _, err := manager.ValidateToken(token); err != nil {
+		t.Errorf("Expected HMAC token to validate, got %v", err)
+	}
+
+	if keys := manager.JWKS().Keys; len(keys) != 0 {
+		t.Errorf("Expected HMAC secrets never to be published, got %d keys", len(keys))
+	}
+}
+
+func TestJWTManagerSessionToken(t *testing.T) {
+	manager := NewJWTManager("secret", time.Hour, "test")
+
+	first, err := manager.GenerateSessionToken("user-1", nil, "session-1", nil)
+	if err != nil {
+		t.Fatalf("Failed to generate token: %v", err)
+	}
+	second, _ := manager.GenerateSessionToken("user-1", nil, "session-1", nil)
+
+	firstClaims, err := manager.ValidateToken(first)
+	if err != nil {
+		t.Fatalf("Expected token to validate, got %v", err)
+	}
+	secondClaims, _ := manager.ValidateToken(second)
+
+	if firstClaims.SessionID != "session-1" {
+		t.Errorf("Expected session ID session-1, got %q", firstClaims.SessionID)
+	}
+	if firstClaims.ID == "" || firstClaims.ID == secondClaims.ID {
+		t.Errorf("Expected every token to have a unique jti, got %q and %q", firstClaims.ID, secondClaims.ID)
+	}
+}
+
+func TestJWTManagerJWKS(t *testing.T) {
+	rsaKey := newTestJWTKey(t, "RS256")
+	ecKey := newTestJWTKey(t, "ES256")
+	edKey := newTestJWTKey(t, "EdDSA")
+
+	manager, err := NewJWTManagerWithKeys(ecKey, []*JWTKey{rsaKey, edKey}, time.Hour, "test")
+	if err != nil {
+		t.Fatalf("Failed to create JWT manager: %v", err)
+	}
+
+	byID := make(map[string]JWK)
+	for _, jwk := range manager.JWKS().Keys {
+		byID[jwk.KeyID] = jwk
+	}
+
+	if len(byID) != 3 {
+		t.Fatalf("Expected 3 published keys, got %d", len(byID))
+	}
+	if jwk := byID[rsaKey.ID]; jwk.KeyType != "RSA" || jwk.N == "" || jwk.E != "AQAB" {
+		t.Errorf("Unexpected RSA JWK: %+v", jwk)
+	}
+	if jwk := byID[ecKey.ID]; jwk.KeyType != "EC" || jwk.Curve != "P-256" || len(jwk.X) != 43 || len(jwk.Y) != 43 {
+		t.Errorf("Unexpected EC JWK: %+v", jwk)
+	}
+	if jwk := byID[edKey.ID]; jwk.KeyType != "OKP" || jwk.Curve != "Ed25519" || jwk.X == "" {
+		t.Errorf("Unexpected OKP JWK: %+v", jwk)
+	}
+}
+
+func TestLoadJWTKey(t *testing.T) {
+	privateKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("Failed to generate key: %v", err)
+	}
+
+	dir := t.TempDir()
+	privateDER, _ := x509.MarshalECPrivateKey(privateKey)
+	publicDER, _ := x509.MarshalPKIXPublicKey(&privateKey.PublicKey)
+	privatePath := filepath.Join(dir, "signing.pem")
+	publicPath := filepath.Join(dir, "verify.pem")
+	os.WriteFile(privatePath, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: privateDER}), 0o600)
+	os.WriteFile(publicPath, pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: publicDER}), 0o600)
+
+	signing, err := LoadJWTKey(privatePath)
+	if err != nil {
+		t.Fatalf("Failed to load private key: %v", err)
+	}
+	verify, err := LoadJWTKey(publicPath)
+	if err != nil {
+		t.Fatalf("Failed to load public key: %v", err)
+	}
+
+	if !signing.CanSign() || verify.CanSign() {
+		t.Error("Expected only the private key to be able to sign")
+	}
+	if signing.ID != verify.ID {
+		t.Errorf("Expected matching key IDs, got %s and %s", signing.ID, verify.ID)
+	}
+	if _, err := NewJWTManagerWithKeys(verify, nil, time.Hour, "test"); err == nil || !strings.Contains(err.Error(), "private key") {
+		t.Errorf("Expected a public key to be rejected as signing key, got %v", err)
+	}
+}
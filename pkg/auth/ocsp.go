@@ -0,0 +1,253 @@
+package auth
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/sha256"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"sync"
+	"time"
+
+	"device-assignment-api/pkg/logger"
+
+	"golang.org/x/crypto/ocsp"
+)
+
+// ErrOCSPStatusUnknown is returned when the responder does not know the certificate
+var ErrOCSPStatusUnknown = errors.New("certificate status unknown to OCSP responder")
+
+// OCSPFailMode controls what happens when a certificate's OCSP status cannot be determined
+type OCSPFailMode string
+
+const (
+	// OCSPSoftFail accepts the certificate when the responder is unreachable or answers unknown
+	OCSPSoftFail OCSPFailMode = "soft"
+	// OCSPHardFail rejects the certificate when the responder is unreachable or answers unknown
+	OCSPHardFail OCSPFailMode = "hard"
+)
+
+// ParseOCSPFailMode converts a configuration value into an OCSPFailMode
+func ParseOCSPFailMode(value string) (OCSPFailMode, error) {
+	switch OCSPFailMode(value) {
+	case OCSPSoftFail, OCSPHardFail:
+		return OCSPFailMode(value), nil
+	default:
+		return "", fmt.Errorf("invalid OCSP fail mode %q (expected %q or %q)", value, OCSPSoftFail, OCSPHardFail)
+	}
+}
+
+// ocspMinCacheDuration is used for responses that carry no nextUpdate, and is also how often
+// expired cache entries are swept
+const ocspMinCacheDuration = 5 * time.Minute
+
+// ocspClockSkew is the clock difference tolerated between the responder and this server
+const ocspClockSkew = 5 * time.Minute
+
+// ocspCacheEntry holds a verified OCSP response until its nextUpdate
+type ocspCacheEntry struct {
+	status     int
+	reason     int
+	revokedAt  time.Time
+	nextUpdate time.Time
+}
+
+// OCSPChecker checks certificates against their OCSP responder and caches the answers
+type OCSPChecker struct {
+	responderURL string
+	failMode     OCSPFailMode
+	httpClient   *http.Client
+	logger       logger.Logger
+	now          func() time.Time
+
+	mu        sync.Mutex
+	cache     map[string]*ocspCacheEntry
+	nextSweep time.Time
+}
+
+// NewOCSPChecker creates an OCSP checker. When responderURL is empty the responder
+// named in each certificate's Authority Information Access extension is used.
+func NewOCSPChecker(responderURL string, failMode OCSPFailMode, timeout time.Duration, logger logger.Logger) *OCSPChecker {
+	return &OCSPChecker{
+		responderURL: responderURL,
+		failMode:     failMode,
+		httpClient:   &http.Client{Timeout: timeout},
+		logger:       logger,
+		now:          time.Now,
+		cache:        make(map[string]*ocspCacheEntry),
+	}
+}
+
+// CheckRevocation implements RevocationChecker
+func (c *OCSPChecker) CheckRevocation(cert, issuer *x509.Certificate) error {
+	if cert == nil {
+		return fmt.Errorf("certificate is nil")
+	}
+
+	// Intermediate CAs rarely name a responder, and a configured responder only answers for
+	// device certificates, so CA certificates are only checked against a responder they name
+	if cert.IsCA && (c.responderURL != "" || len(cert.OCSPServer) == 0) {
+		return nil
+	}
+
+	serial := formatSerialNumber(cert.SerialNumber)
+
+	if issuer == nil {
+		return c.fail(cert, fmt.Errorf("issuer certificate is required for OCSP"))
+	}
+
+	key := ocspCacheKey(cert, issuer)
+	entry := c.cached(key)
+	if entry == nil {
+		var err error
+		entry, err = c.query(cert, issuer)
+		if err != nil {
+			return c.fail(cert, err)
+		}
+		c.store(key, entry)
+	}
+
+	switch entry.status {
+	case ocsp.Good:
+		return nil
+	case ocsp.Revoked:
+		reason := crlReasons[entry.reason]
+		c.logger.Warn("Rejected revoked certificate",
+			"serial_number", serial,
+			"issuer_cn", cert.Issuer.CommonName,
+			"reason", reason,
+			"revoked_at", entry.revokedAt,
+			"source", "ocsp")
+		return fmt.Errorf("%w: serial %s revoked at %s according to OCSP (reason: %s)",
+			ErrCertificateRevoked, serial, entry.revokedAt.Format(time.RFC3339), reason)
+	default:
+		return c.fail(cert, ErrOCSPStatusUnknown)
+	}
+}
+
+// fail applies the configured fail mode to an undetermined status
+func (c *OCSPChecker) fail(cert *x509.Certificate, err error) error {
+	serial := formatSerialNumber(cert.SerialNumber)
+
+	if c.failMode == OCSPHardFail {
+		c.logger.Warn("OCSP check failed, rejecting certificate (hard-fail)",
+			"serial_number", serial,
+			"error", err)
+		return fmt.Errorf("OCSP check failed for serial %s: %w", serial, err)
+	}
+
+	c.logger.Warn("OCSP check failed, accepting certificate (soft-fail)",
+		"serial_number", serial,
+		"error", err)
+	return nil
+}
+
+// cached returns a cache entry that has not yet passed its nextUpdate
+func (c *OCSPChecker) cached(key string) *ocspCacheEntry {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	entry, ok := c.cache[key]
+	if !ok {
+		return nil
+	}
+
+	if !c.now().Before(entry.nextUpdate) {
+		delete(c.cache, key)
+		return nil
+	}
+
+	return entry
+}
+
+// store records a response in the cache. Entries past their nextUpdate are swept at most
+// every ocspMinCacheDuration, so certificates that are not seen again do not stay cached.
+func (c *OCSPChecker) store(key string, entry *ocspCacheEntry) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	now := c.now()
+	if !now.Before(c.nextSweep) {
+		for cachedKey, cachedEntry := range c.cache {
+			if !now.Before(cachedEntry.nextUpdate) {
+				delete(c.cache, cachedKey)
+			}
+		}
+		c.nextSweep = now.Add(ocspMinCacheDuration)
+	}
+
+	c.cache[key] = entry
+}
+
+// query sends an OCSP request to the responder and verifies the answer
+func (c *OCSPChecker) query(cert, issuer *x509.Certificate) (*ocspCacheEntry, error) {
+	responderURL := c.responderURL
+	if responderURL == "" {
+		if len(cert.OCSPServer) == 0 {
+			return nil, fmt.Errorf("certificate names no OCSP responder")
+		}
+		responderURL = cert.OCSPServer[0]
+	}
+
+	request, err := ocsp.CreateRequest(cert, issuer, &ocsp.RequestOptions{Hash: crypto.SHA1})
+	if err != nil {
+		return nil, fmt.Errorf("failed to create OCSP request: %w", err)
+	}
+
+	resp, err := c.httpClient.Post(responderURL, "application/ocsp-request", bytes.NewReader(request))
+	if err != nil {
+		return nil, fmt.Errorf("failed to reach OCSP responder: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("OCSP responder returned status %s", resp.Status)
+	}
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read OCSP response: %w", err)
+	}
+
+	response, err := ocsp.ParseResponseForCert(body, cert, issuer)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse OCSP response: %w", err)
+	}
+
+	// ParseResponseForCert does not check the validity interval, so a replayed response
+	// would otherwise be accepted as current
+	now := c.now()
+	if response.ThisUpdate.After(now.Add(ocspClockSkew)) {
+		return nil, fmt.Errorf("OCSP response is not yet valid (thisUpdate %s)", response.ThisUpdate.Format(time.RFC3339))
+	}
+	if !response.NextUpdate.IsZero() && now.After(response.NextUpdate.Add(ocspClockSkew)) {
+		return nil, fmt.Errorf("OCSP response is stale (nextUpdate %s)", response.NextUpdate.Format(time.RFC3339))
+	}
+
+	nextUpdate := response.NextUpdate
+	if nextUpdate.IsZero() {
+		nextUpdate = now.Add(ocspMinCacheDuration)
+	}
+
+	c.logger.Debug("OCSP response received",
+		"serial_number", formatSerialNumber(cert.SerialNumber),
+		"responder", responderURL,
+		"status", response.Status,
+		"next_update", nextUpdate)
+
+	return &ocspCacheEntry{
+		status:     response.Status,
+		reason:     response.RevocationReason,
+		revokedAt:  response.RevokedAt,
+		nextUpdate: nextUpdate,
+	}, nil
+}
+
+// ocspCacheKey identifies a certificate by its issuer and serial number
+func ocspCacheKey(cert, issuer *x509.Certificate) string {
+	issuerKeyHash := sha256.Sum256(issuer.RawSubjectPublicKeyInfo)
+	return fmt.Sprintf("%x:%s", issuerKeyHash, cert.SerialNumber.String())
+}
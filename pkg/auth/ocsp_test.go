@@ -0,0 +1,290 @@
+package auth
+
+import (
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"errors"
+	"io"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"golang.org/x/crypto/ocsp"
+)
+
+// testResponder is a local OCSP responder stand-in
+type testResponder struct {
+	ca       *testCA
+	statuses map[int64]int
+	delay    time.Duration
+	// thisUpdate and nextUpdate replace the validity interval of responses when set
+	thisUpdate time.Time
+	nextUpdate time.Time
+	requests   atomic.Int32
+	server     *httptest.Server
+}
+
+func newTestResponder(t *testing.T, ca *testCA, statuses map[int64]int) *testResponder {
+	t.Helper()
+
+	r := &testResponder{ca: ca, statuses: statuses}
+	r.server = httptest.NewServer(http.HandlerFunc(r.serve))
+	t.Cleanup(r.server.Close)
+	return r
+}
+
+func (r *testResponder) serve(w http.ResponseWriter, req *http.Request) {
+	r.requests.Add(1)
+
+	if r.delay > 0 {
+		time.Sleep(r.delay)
+	}
+
+	body, _ := io.ReadAll(req.Body)
+	ocspReq, err := ocsp.ParseRequest(body)
+	if err != nil {
+		http.Error(w, "bad request", http.StatusBadRequest)
+		return
+	}
+
+	template := ocsp.Response{
+		SerialNumber: ocspReq.SerialNumber,
+		Status:       ocsp.Unknown,
+		ThisUpdate:   time.Now().Add(-time.Minute),
+		NextUpdate:   time.Now().Add(time.Hour),
+	}
+	if !r.thisUpdate.IsZero() {
+		template.ThisUpdate = r.thisUpdate
+	}
+	if !r.nextUpdate.IsZero() {
+		template.NextUpdate = r.nextUpdate
+	}
+	if status, ok := r.statuses[ocspReq.SerialNumber.Int64()]; ok {
+		template.Status = status
+	}
+	if template.Status == ocsp.Revoked {
+		template.RevokedAt = time.Now().Add(-time.Minute)
+		template.RevocationReason = ocsp.KeyCompromise
+	}
+
+	resp, err := ocsp.CreateResponse(r.ca.cert, r.ca.cert, template, r.ca.key)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/ocsp-response")
+	w.Write(resp)
+}
+
+// issueWithOCSP issues a client certificate pointing at the given responder
+func (ca *testCA) issueWithOCSP(t *testing.T, serial int64, responderURL string) *x509.Certificate {
+	t.Helper()
+
+	return ca.issue(t, &x509.Certificate{
+		SerialNumber: big.NewInt(serial),
+		Subject:      pkix.Name{CommonName: "device"},
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+		OCSPServer:   []string{responderURL},
+	}, newTestKey(t).Public())
+}
+
+func TestOCSPCheckerStatuses(t *testing.T) {
+	ca := newTestCA(t, "Test CA")
+	responder := newTestResponder(t, ca, map[int64]int{
+		1: ocsp.Good,
+		2: ocsp.Revoked,
+	})
+
+	tests := []struct {
+		name     string
+		serial   int64
+		failMode OCSPFailMode
+		wantErr  error
+	}{
+		{name: "good", serial: 1, failMode: OCSPHardFail},
+		{name: "revoked", serial: 2, failMode: OCSPSoftFail, wantErr: ErrCertificateRevoked},
+		{name: "unknown soft-fail", serial: 3, failMode: OCSPSoftFail},
+		{name: "unknown hard-fail", serial: 3, failMode: OCSPHardFail, wantErr: ErrOCSPStatusUnknown},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			checker := NewOCSPChecker("", tt.failMode, time.Second, testLogger())
+			cert := ca.issueWithOCSP(t, tt.serial, responder.server.URL)
+
+			err := checker.CheckRevocation(cert, ca.cert)
+			if tt.wantErr == nil && err != nil {
+				t.Errorf("Expected no error, got %v", err)
+			}
+			if tt.wantErr != nil && !errors.Is(err, tt.wantErr) {
+				t.Errorf("Expected %v, got %v", tt.wantErr, err)
+			}
+		})
+	}
+}
+
+func TestOCSPCheckerTimeout(t *testing.T) {
+	ca := newTestCA(t, "Test CA")
+	responder := newTestResponder(t, ca, map[int64]int{1: ocsp.Good})
+	responder.delay = 200 * time.Millisecond
+	cert := ca.issueWithOCSP(t, 1, responder.server.URL)
+
+	soft := NewOCSPChecker("", OCSPSoftFail, 20*time.Millisecond, testLogger())
+	if err := soft.CheckRevocation(cert, ca.cert); err != nil {
+		t.Errorf("Expected soft-fail to accept on timeout, got %v", err)
+	}
+
+	hard := NewOCSPChecker("", OCSPHardFail, 20*time.Millisecond, testLogger())
+	if err := hard.CheckRevocation(cert, ca.cert); err == nil {
+		t.Error("Expected hard-fail to reject on timeout")
+	}
+}
+
+func TestOCSPCheckerCachesUntilNextUpdate(t *testing.T) {
+	ca := newTestCA(t, "Test CA")
+	responder := newTestResponder(t, ca, map[int64]int{1: ocsp.Good, 2: ocsp.Revoked})
+	good := ca.issueWithOCSP(t, 1, responder.server.URL)
+	revoked := ca.issueWithOCSP(t, 2, responder.server.URL)
+
+	now := time.Now()
+	checker := NewOCSPChecker("", OCSPHardFail, time.Second, testLogger())
+	checker.now = func() time.Time { return now }
+
+	for i := 0; i < 3; i++ {
+		if err := checker.CheckRevocation(good, ca.cert); err != nil {
+			t.Fatalf("Expected good certificate to pass, got %v", err)
+		}
+		if err := checker.CheckRevocation(revoked, ca.cert); !errors.Is(err, ErrCertificateRevoked) {
+			t.Fatalf("Expected revoked certificate to fail, got %v", err)
+		}
+	}
+
+	if got := responder.requests.Load(); got != 2 {
+		t.Errorf("Expected 2 responder requests with caching, got %d", got)
+	}
+
+	// Move past nextUpdate so both entries expire
+	now = now.Add(2 * time.Hour)
+	checker.CheckRevocation(good, ca.cert)
+
+	if got := responder.requests.Load(); got != 3 {
+		t.Errorf("Expected cache entry to expire after nextUpdate, got %d requests", got)
+	}
+}
+
+func TestOCSPCheckerResponderOverride(t *testing.T) {
+	ca := newTestCA(t, "Test CA")
+	responder := newTestResponder(t, ca, map[int64]int{1: ocsp.Revoked})
+	cert := ca.issueWithOCSP(t, 1, "http://127.0.0.1:1/unreachable")
+
+	checker := NewOCSPChecker(responder.server.URL, OCSPHardFail, time.Second, testLogger())
+	if err := checker.CheckRevocation(cert, ca.cert); !errors.Is(err, ErrCertificateRevoked) {
+		t.Errorf("Expected override responder to report revocation, got %v", err)
+	}
+}
+
+func TestOCSPCheckerIntermediateChain(t *testing.T) {
+	root := newTestCA(t, "Root CA")
+	intermediateKey := newTestKey(t)
+	intermediate := &testCA{
+		cert: root.issue(t, &x509.Certificate{
+			SerialNumber:          big.NewInt(100),
+			Subject:               pkix.Name{CommonName: "Device CA"},
+			KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageDigitalSignature,
+			BasicConstraintsValid: true,
+			IsCA:                  true,
+		}, intermediateKey.Public()),
+		key: intermediateKey,
+	}
+	// The responder answers for the intermediate's certificates and knows nothing of serial 100
+	responder := newTestResponder(t, intermediate, map[int64]int{1: ocsp.Good, 2: ocsp.Revoked})
+
+	tests := []struct {
+		name         string
+		responderURL string
+		serial       int64
+		wantErr      error
+	}{
+		{name: "responder from certificate", serial: 1},
+		{name: "responder override", responderURL: responder.server.URL, serial: 1},
+		{name: "revoked leaf", responderURL: responder.server.URL, serial: 2, wantErr: ErrCertificateRevoked},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			checker := NewOCSPChecker(tt.responderURL, OCSPHardFail, time.Second, testLogger())
+			leaf := intermediate.issueWithOCSP(t, tt.serial, responder.server.URL)
+
+			err := CheckChainRevocation(checker, []*x509.Certificate{leaf, intermediate.cert, root.cert})
+			if tt.wantErr == nil && err != nil {
+				t.Errorf("Expected no error, got %v", err)
+			}
+			if tt.wantErr != nil && !errors.Is(err, tt.wantErr) {
+				t.Errorf("Expected %v, got %v", tt.wantErr, err)
+			}
+		})
+	}
+}
+
+func TestOCSPCheckerRejectsOutdatedResponses(t *testing.T) {
+	ca := newTestCA(t, "Test CA")
+	responder := newTestResponder(t, ca, map[int64]int{1: ocsp.Good})
+	cert := ca.issueWithOCSP(t, 1, responder.server.URL)
+
+	tests := []struct {
+		name                   string
+		thisUpdate, nextUpdate time.Time
+		wantErr                bool
+	}{
+		{name: "current", thisUpdate: time.Now().Add(-time.Minute), nextUpdate: time.Now().Add(time.Hour)},
+		{name: "within clock skew", thisUpdate: time.Now().Add(time.Minute), nextUpdate: time.Now().Add(-time.Minute)},
+		{name: "past nextUpdate", thisUpdate: time.Now().Add(-2 * time.Hour), nextUpdate: time.Now().Add(-time.Hour), wantErr: true},
+		{name: "thisUpdate in the future", thisUpdate: time.Now().Add(time.Hour), nextUpdate: time.Now().Add(2 * time.Hour), wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			responder.thisUpdate, responder.nextUpdate = tt.thisUpdate, tt.nextUpdate
+
+			hard := NewOCSPChecker("", OCSPHardFail, time.Second, testLogger())
+			if err := hard.CheckRevocation(cert, ca.cert); (err != nil) != tt.wantErr {
+				t.Errorf("Expected error %v in hard-fail mode, got %v", tt.wantErr, err)
+			}
+
+			soft := NewOCSPChecker("", OCSPSoftFail, time.Second, testLogger())
+			if err := soft.CheckRevocation(cert, ca.cert); err != nil {
+				t.Errorf("Expected soft-fail to accept, got %v", err)
+			}
+		})
+	}
+}
+
+func TestOCSPCheckerSweepsExpiredEntries(t *testing.T) {
+	ca := newTestCA(t, "Test CA")
+	responder := newTestResponder(t, ca, map[int64]int{1: ocsp.Good, 2: ocsp.Good, 3: ocsp.Good})
+
+	now := time.Now()
+	checker := NewOCSPChecker("", OCSPHardFail, time.Second, testLogger())
+	checker.now = func() time.Time { return now }
+
+	for serial := int64(1); serial <= 2; serial++ {
+		if err := checker.CheckRevocation(ca.issueWithOCSP(t, serial, responder.server.URL), ca.cert); err != nil {
+			t.Fatalf("Expected good certificate to pass, got %v", err)
+		}
+	}
+
+	// Move past nextUpdate; storing the next response sweeps the expired entries
+	now = now.Add(2 * time.Hour)
+	responder.thisUpdate, responder.nextUpdate = now.Add(-time.Minute), now.Add(time.Hour)
+	if err := checker.CheckRevocation(ca.issueWithOCSP(t, 3, responder.server.URL), ca.cert); err != nil {
+		t.Fatalf("Expected good certificate to pass, got %v", err)
+	}
+
+	if len(checker.cache) != 1 {
+		t.Errorf("Expected expired entries to be swept, got %d cached entries", len(checker.cache))
+	}
+}
@@ -0,0 +1,273 @@
+package auth
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"device-assignment-api/pkg/logger"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// oidcFetchTimeout bounds discovery and JWKS requests to the identity provider
+const oidcFetchTimeout = 10 * time.Second
+
+// oidcMinRefreshInterval limits JWKS refetches triggered by unknown key IDs
+const oidcMinRefreshInterval = time.Minute
+
+// oidcSigningMethods lists the algorithms accepted from an identity provider
+var oidcSigningMethods = []string{"RS256", "RS384", "RS512", "PS256", "PS384", "PS512", "ES256", "ES384", "ES512", "EdDSA"}
+
+// OIDC user ID claims that may be mapped to the user ID
+const (
+	OIDCClaimSubject           = "sub"
+	OIDCClaimEmail             = "email"
+	OIDCClaimPreferredUsername = "preferred_username"
+)
+
+// OIDCConfig holds the settings for validating tokens from an external identity provider.
+// GroupRoles maps group names found in the RolesClaim to API roles.
+type OIDCConfig struct {
+	IssuerURL   string
+	Audience    string
+	UserIDClaim string
+	ClockSkew   time.Duration
+	CacheTTL    time.Duration
+	RolesClaim  string
+	GroupRoles  map[string]string
+}
+
+// oidcDiscovery is the subset of the OpenID Provider metadata the verifier uses
+type oidcDiscovery struct {
+	Issuer  string `json:"issuer"`
+	JWKSURI string `json:"jwks_uri"`
+}
+
+// OIDCVerifier validates tokens issued by an OpenID Connect provider, caching its
+// discovery document and signing keys
+type OIDCVerifier struct {
+	config     OIDCConfig
+	httpClient *http.Client
+	logger     logger.Logger
+
+	mu        sync.Mutex
+	jwksURI   string
+	keys      map[string]*JWTKey
+	fetchedAt time.Time
+}
+
+// NewOIDCVerifier creates an OIDC verifier. Discovery happens on first use.
+func NewOIDCVerifier(config OIDCConfig, logger logger.Logger) (*OIDCVerifier, error) {
+	if config.IssuerURL == "" {
+		return nil, fmt.Errorf("OIDC issuer URL is required")
+	}
+
+	if config.Audience == "" {
+		return nil, fmt.Errorf("OIDC audience is required")
+	}
+
+	switch config.UserIDClaim {
+	case OIDCClaimSubject, OIDCClaimEmail, OIDCClaimPreferredUsername:
+	default:
+		return nil, fmt.Errorf("unsupported OIDC user ID claim %q", config.UserIDClaim)
+	}
+
+	for group, role := range config.GroupRoles {
+		if !IsValidRole(role) {
+			return nil, fmt.Errorf("group %q maps to unknown role %q", group, role)
+		}
+	}
+
+	return &OIDCVerifier{
+		config:     config,
+		httpClient: &http.Client{Timeout: oidcFetchTimeout},
+		logger:     logger,
+	}, nil
+}
+
+// Issuer returns the configured issuer identifier
+func (v *OIDCVerifier) Issuer() string {
+	return v.config.IssuerURL
+}
+
+// ValidateToken implements TokenValidator
+func (v *OIDCVerifier) ValidateToken(tokenString string) (*Claims, error) {
+	parser := jwt.NewParser(
+		jwt.WithValidMethods(oidcSigningMethods),
+		jwt.WithIssuer(v.config.IssuerURL),
+		jwt.WithAudience(v.config.Audience),
+		jwt.WithLeeway(v.config.ClockSkew),
+		jwt.WithExpirationRequired(),
+	)
+
+	mapClaims := jwt.MapClaims{}
+	token, err := parser.ParseWithClaims(tokenString, mapClaims, v.verificationKey)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse token: %w", err)
+	}
+
+	if !token.Valid {
+		return nil, fmt.Errorf("invalid token claims")
+	}
+
+	userID, _ := mapClaims[v.config.UserIDClaim].(string)
+	if userID == "" {
+		return nil, fmt.Errorf("claim %q is missing from token", v.config.UserIDClaim)
+	}
+
+	claims := claimsFromMap(userID, mapClaims)
+	claims.Roles = v.mapRoles(mapClaims)
+	return claims, nil
+}
+
+// mapRoles translates the provider's groups into API roles. Unmapped groups are ignored.
+func (v *OIDCVerifier) mapRoles(mapClaims jwt.MapClaims) []string {
+	if v.config.RolesClaim == "" || len(v.config.GroupRoles) == 0 {
+		return nil
+	}
+
+	var groups []string
+	switch value := mapClaims[v.config.RolesClaim].(type) {
+	case string:
+		groups = []string{value}
+	case []interface{}:
+		for _, group := range value {
+			if name, ok := group.(string); ok {
+				groups = append(groups, name)
+			}
+		}
+	}
+
+	var roles []string
+	for _, group := range groups {
+		if role, ok := v.config.GroupRoles[group]; ok && !slices.Contains(roles, role) {
+			roles = append(roles, role)
+		}
+	}
+	return roles
+}
+
+// verificationKey finds the provider key for a token, refetching the JWKS once when the
+// kid is unknown so that provider key rotation is picked up
+func (v *OIDCVerifier) verificationKey(token *jwt.Token) (interface{}, error) {
+	keyID, _ := token.Header["kid"].(string)
+
+	key, err := v.lookupKey(keyID)
+	if err != nil {
+		return nil, err
+	}
+
+	if token.Method.Alg() != key.Method.Alg() {
+		return nil, fmt.Errorf("unexpected signing method: %v", token.Header["alg"])
+	}
+
+	return key.verifyKey, nil
+}
+
+// lookupKey returns a cached key, refreshing the cache when stale or missing the kid
+func (v *OIDCVerifier) lookupKey(keyID string) (*JWTKey, error) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	stale := v.keys == nil || time.Since(v.fetchedAt) > v.config.CacheTTL
+	if !stale {
+		if key, ok := v.keys[keyID]; ok {
+			return key, nil
+		}
+		if time.Since(v.fetchedAt) < oidcMinRefreshInterval {
+			return nil, fmt.Errorf("unknown signing key %q", keyID)
+		}
+	}
+
+	if err := v.refresh(); err != nil {
+		if v.keys == nil {
+			return nil, err
+		}
+		v.logger.Warn("Failed to refresh OIDC signing keys, using cached keys", "issuer", v.config.IssuerURL, "error", err)
+	}
+
+	key, ok := v.keys[keyID]
+	if !ok {
+		return nil, fmt.Errorf("unknown signing key %q", keyID)
+	}
+	return key, nil
+}
+
+// refresh fetches the discovery document (once) and the JWKS. Callers must hold v.mu.
+func (v *OIDCVerifier) refresh() error {
+	if v.jwksURI == "" {
+		var discovery oidcDiscovery
+		discoveryURL := strings.TrimSuffix(v.config.IssuerURL, "/") + "/.well-known/openid-configuration"
+		if err := v.getJSON(discoveryURL, &discovery); err != nil {
+			return fmt.Errorf("failed to fetch OIDC discovery document: %w", err)
+		}
+
+		if discovery.Issuer != v.config.IssuerURL {
+			return fmt.Errorf("discovery issuer %q does not match configured issuer %q", discovery.Issuer, v.config.IssuerURL)
+		}
+
+		if discovery.JWKSURI == "" {
+			return fmt.Errorf("discovery document has no jwks_uri")
+		}
+
+		v.jwksURI = discovery.JWKSURI
+	}
+
+	var set JWKSet
+	if err := v.getJSON(v.jwksURI, &set); err != nil {
+		return fmt.Errorf("failed to fetch OIDC JWKS: %w", err)
+	}
+
+	keys := make(map[string]*JWTKey, len(set.Keys))
+	for _, jwk := range set.Keys {
+		if jwk.Use != "" && jwk.Use != "sig" {
+			continue
+		}
+
+		key, err := NewJWTKeyFromJWK(jwk)
+		if err != nil {
+			v.logger.Warn("Skipping unusable OIDC signing key", "kid", jwk.KeyID, "error", err)
+			continue
+		}
+		keys[key.ID] = key
+	}
+
+	v.keys = keys
+	v.fetchedAt = time.Now()
+
+	v.logger.Info("OIDC signing keys refreshed", "issuer", v.config.IssuerURL, "keys", len(keys))
+	return nil
+}
+
+// getJSON fetches and decodes a JSON document
+func (v *OIDCVerifier) getJSON(url string, target interface{}) error {
+	resp, err := v.httpClient.Get(url)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("unexpected status: %s", resp.Status)
+	}
+
+	return json.NewDecoder(resp.Body).Decode(target)
+}
+
+// claimsFromMap converts validated provider claims into the API's claims
+func claimsFromMap(userID string, mapClaims jwt.MapClaims) *Claims {
+	claims := &Claims{UserID: userID}
+	claims.Issuer, _ = mapClaims.GetIssuer()
+	claims.Subject, _ = mapClaims.GetSubject()
+	claims.Audience, _ = mapClaims.GetAudience()
+	claims.ExpiresAt, _ = mapClaims.GetExpirationTime()
+	claims.NotBefore, _ = mapClaims.GetNotBefore()
+	claims.IssuedAt, _ = mapClaims.GetIssuedAt()
+	claims.ID, _ = mapClaims["jti"].(string)
+	return claims
+}
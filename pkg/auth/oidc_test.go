@@ -0,0 +1,280 @@
+package auth
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// testIdP is a local OpenID provider stand-in serving discovery and JWKS
+type testIdP struct {
+	mu           sync.Mutex
+	keys         []*JWTKey
+	jwksRequests atomic.Int32
+	server       *httptest.Server
+}
+
+func newTestIdP(t *testing.T, keys ...*JWTKey) *testIdP {
+	t.Helper()
+
+	idp := &testIdP{keys: keys}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(oidcDiscovery{
+			Issuer:  idp.server.URL,
+			JWKSURI: idp.server.URL + "/keys",
+		})
+	})
+	mux.HandleFunc("/keys", func(w http.ResponseWriter, r *http.Request) {
+		idp.jwksRequests.Add(1)
+
+		idp.mu.Lock()
+		defer idp.mu.Unlock()
+
+		set := JWKSet{}
+		for _, key := range idp.keys {
+			jwk, _ := key.JWK()
+			set.Keys = append(set.Keys, jwk)
+		}
+		json.NewEncoder(w).Encode(set)
+	})
+
+	idp.server = httptest.NewServer(mux)
+	t.Cleanup(idp.server.Close)
+	return idp
+}
+
+// setKeys replaces the published signing keys
+func (idp *testIdP) setKeys(keys ...*JWTKey) {
+	idp.mu.Lock()
+	defer idp.mu.Unlock()
+
+	idp.keys = keys
+}
+
+// sign issues a token with the given key, defaulting iss, aud and exp. Claims set to nil
+// are omitted.
+func (idp *testIdP) sign(t *testing.T, key *JWTKey, claims jwt.MapClaims) string {
+	t.Helper()
+
+	if _, ok := claims["iss"]; !ok {
+		claims["iss"] = idp.server.URL
+	}
+	if _, ok := claims["aud"]; !ok {
+		claims["aud"] = "device-api"
+	}
+	if _, ok := claims["exp"]; !ok {
+		claims["exp"] = time.Now().Add(time.Hour).Unix()
+	}
+	for name, value := range claims {
+		if value == nil {
+			delete(claims, name)
+		}
+	}
+
+	token := jwt.NewWithClaims(key.Method, claims)
+	token.Header["kid"] = key.ID
+	tokenString, err := token.SignedString(key.signKey)
+	if err != nil {
+		t.Fatalf("Failed to sign token: %v", err)
+	}
+	return tokenString
+}
+
+func newTestOIDCVerifier(t *testing.T, idp *testIdP, userIDClaim string) *OIDCVerifier {
+	t.Helper()
+
+	verifier, err := NewOIDCVerifier(OIDCConfig{
+		IssuerURL:   idp.server.URL,
+		Audience:    "device-api",
+		UserIDClaim: userIDClaim,
+		ClockSkew:   time.Minute,
+		CacheTTL:    time.Hour,
+	}, testLogger())
+	if err != nil {
+		t.Fatalf("Failed to create OIDC verifier: %v", err)
+	}
+	return verifier
+}
+
+func TestOIDCVerifierUserIDClaim(t *testing.T) {
+	key := newTestJWTKey(t, "RS256")
+	idp := newTestIdP(t, key)
+
+	tests := []struct {
+		claim    string
+		expected string
+	}{
+		{OIDCClaimSubject, "00u1abcd"},
+		{OIDCClaimEmail, "jane@example.com"},
+		{OIDCClaimPreferredUsername, "jane"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.claim, func(t *testing.T) {
+			verifier := newTestOIDCVerifier(t, idp, tt.claim)
+			token := idp.sign(t, key, jwt.MapClaims{
+				"sub":                "00u1abcd",
+				"email":              "jane@example.com",
+				"preferred_username": "jane",
+			})
+
+			claims, err := verifier.ValidateToken(token)
+			if err != nil {
+				t.Fatalf("Failed to validate token: %v", err)
+			}
+			if claims.UserID != tt.expected {
+				t.Errorf("Expected user ID %s, got %s", tt.expected, claims.UserID)
+			}
+		})
+	}
+}
+
+func TestOIDCVerifierRejectsInvalidClaims(t *testing.T) {
+	key := newTestJWTKey(t, "ES256")
+	idp := newTestIdP(t, key)
+	verifier := newTestOIDCVerifier(t, idp, OIDCClaimEmail)
+
+	tests := []struct {
+		name   string
+		claims jwt.MapClaims
+	}{
+		{"wrong issuer", jwt.MapClaims{"iss": "https://evil.example.com", "email": "a@example.com"}},
+		{"wrong audience", jwt.MapClaims{"aud": "other-api", "email": "a@example.com"}},
+		{"expired beyond skew", jwt.MapClaims{"exp": time.Now().Add(-2 * time.Minute).Unix(), "email": "a@example.com"}},
+		{"not yet valid beyond skew", jwt.MapClaims{"nbf": time.Now().Add(2 * time.Minute).Unix(), "email": "a@example.com"}},
+		{"missing expiry", jwt.MapClaims{"exp": nil, "email": "a@example.com"}},
+		{"missing user ID claim", jwt.MapClaims{"sub": "00u1abcd"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := verifier.ValidateToken(idp.sign(t, key, tt.claims)); err == nil {
+				t.Error("Expected token to be rejected")
+			}
+		})
+	}
+}
+
+func TestOIDCVerifierClockSkew(t *testing.T) {
+	key := newTestJWTKey(t, "EdDSA")
+	idp := newTestIdP(t, key)
+	verifier := newTestOIDCVerifier(t, idp, OIDCClaimSubject)
+
+	token := idp.sign(t, key, jwt.MapClaims{
+		"sub": "user-1",
+		"exp": time.Now().Add(-30 * time.Second).Unix(),
+		"nbf": time.Now().Add(30 * time.Second).Unix(),
+	})
+
+	if _, err := verifier.ValidateToken(token); err != nil {
+		t.Errorf("Expected token within clock skew to be accepted, got %v", err)
+	}
+}
+
+func TestOIDCVerifierCachesAndRotatesKeys(t *testing.T) {
+	oldKey := newTestJWTKey(t, "RS256")
+	newKey := newTestJWTKey(t, "RS256")
+	idp := newTestIdP(t, oldKey)
+	verifier := newTestOIDCVerifier(t, idp, OIDCClaimSubject)
+
+	for i := 0; i < 3; i++ {
+		if _, err := verifier.ValidateToken(idp.sign(t, oldKey, jwt.MapClaims{"sub": "user-1"})); err != nil {
+			t.Fatalf("Failed to validate token: %v", err)
+		}
+	}
+	if n := idp.jwksRequests.Load(); n != 1 {
+		t.Errorf("Expected JWKS to be fetched once, got %d", n)
+	}
+
+	// The provider rotates; a token with an unknown kid triggers one refetch, but only
+	// after the minimum refresh interval has elapsed
+	idp.setKeys(newKey)
+	newToken := idp.sign(t, newKey, jwt.MapClaims{"sub": "user-1"})
+	if _, err := verifier.ValidateToken(newToken); err == nil {
+		t.Error("Expected unknown kid to be rejected within the minimum refresh interval")
+	}
+
+	verifier.mu.Lock()
+	verifier.fetchedAt = time.Now().Add(-2 * oidcMinRefreshInterval)
+	verifier.mu.Unlock()
+
+	if _, err := verifier.ValidateToken(newToken); err != nil {
+		t.Errorf("Expected token signed with the rotated key to be accepted, got %v", err)
+	}
+	if n := idp.jwksRequests.Load(); n != 2 {
+		t.Errorf("Expected JWKS to be refetched once, got %d fetches", n)
+	}
+}
+
+func TestIssuerTokenValidator(t *testing.T) {
+	key := newTestJWTKey(t, "ES256")
+	idp := newTestIdP(t, key)
+	verifier := newTestOIDCVerifier(t, idp, OIDCClaimSubject)
+	local := NewJWTManager("secret", time.Hour, "device-assignment-api")
+
+	validator := NewIssuerTokenValidator(map[string]TokenValidator{verifier.Issuer(): verifier}, local)
+
+	localToken, err := local.GenerateToken("local-user")
+	if err != nil {
+		t.Fatalf("Failed to generate token: %v", err)
+	}
+
+	claims, err := validator.ValidateToken(localToken)
+	if err != nil || claims.UserID != "local-user" {
+		t.Errorf("Expected locally issued token to validate, got %v", err)
+	}
+
+	claims, err = validator.ValidateToken(idp.sign(t, key, jwt.MapClaims{"sub": "idp-user"}))
+	if err != nil || claims.UserID != "idp-user" {
+		t.Errorf("Expected provider token to validate, got %v", err)
+	}
+
+	strict := NewIssuerTokenValidator(map[string]TokenValidator{verifier.Issuer(): verifier}, nil)
+	if _, err := strict.ValidateToken(localToken); err == nil {
+		t.Error("Expected token from an unknown issuer to be rejected without a fallback")
+	}
+}
+
+func TestOIDCVerifierMapsGroupsToRoles(t *testing.T) {
+	key := newTestJWTKey(t, "RS256")
+	idp := newTestIdP(t, key)
+
+	verifier, err := NewOIDCVerifier(OIDCConfig{
+		IssuerURL:   idp.server.URL,
+		Audience:    "device-api",
+		UserIDClaim: OIDCClaimSubject,
+		CacheTTL:    time.Hour,
+		RolesClaim:  "groups",
+		GroupRoles:  map[string]string{"platform-admins": "admin", "staff": "user"},
+	}, testLogger())
+	if err != nil {
+		t.Fatalf("Failed to create OIDC verifier: %v", err)
+	}
+
+	claims, err := verifier.ValidateToken(idp.sign(t, key, jwt.MapClaims{
+		"sub":    "user-1",
+		"groups": []string{"staff", "platform-admins", "unrelated"},
+	}))
+	if err != nil {
+		t.Fatalf("Failed to validate token: %v", err)
+	}
+	if len(claims.Roles) != 2 || claims.Roles[0] != "user" || claims.Roles[1] != "admin" {
+		t.Errorf("Expected roles [user admin], got %v", claims.Roles)
+	}
+
+	if _, err := NewOIDCVerifier(OIDCConfig{
+		IssuerURL:   idp.server.URL,
+		Audience:    "device-api",
+		UserIDClaim: OIDCClaimSubject,
+		GroupRoles:  map[string]string{"platform-admins": "root"},
+	}, testLogger()); err == nil {
+		t.Error("Expected a mapping to an unknown role to be rejected")
+	}
+}
@@ -0,0 +1,61 @@
+package auth
+
+import (
+	"strings"
+	"testing"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+func TestHashPassword(t *testing.T) {
+	hash, err := HashPassword("correct horse battery staple")
+	if err != nil {
+		t.Fatalf("Failed to hash password: %v", err)
+	}
+
+	if !strings.HasPrefix(hash, "$argon2id$v=19$") {
+		t.Errorf("Expected an argon2id PHC string, got %s", hash)
+	}
+
+	other, _ := HashPassword("correct horse battery staple")
+	if hash == other {
+		t.Error("Expected hashes of the same password to use different salts")
+	}
+
+	if ok, err := VerifyPassword(hash, "correct horse battery staple"); err != nil || !ok {
+		t.Errorf("Expected password to verify, got %v, %v", ok, err)
+	}
+
+	if ok, err := VerifyPassword(hash, "wrong password"); err != nil || ok {
+		t.Errorf("Expected wrong password to be rejected, got %v, %v", ok, err)
+	}
+}
+
+func TestVerifyPasswordBcrypt(t *testing.T) {
+	hash, err := bcrypt.GenerateFromPassword([]byte("imported-password"), bcrypt.MinCost)
+	if err != nil {
+		t.Fatalf("Failed to create bcrypt hash: %v", err)
+	}
+
+	if ok, err := VerifyPassword(string(hash), "imported-password"); err != nil || !ok {
+		t.Errorf("Expected bcrypt password to verify, got %v, %v", ok, err)
+	}
+
+	if ok, err := VerifyPassword(string(hash), "wrong password"); err != nil || ok {
+		t.Errorf("Expected wrong bcrypt password to be rejected, got %v, %v", ok, err)
+	}
+}
+
+func TestVerifyPasswordRejectsMalformedHashes(t *testing.T) {
+	for _, hash := range []string{
+		"",
+		"plaintext",
+		"$argon2i$v=19$m=65536,t=3,p=2$c2FsdA$aGFzaA",
+		"$argon2id$v=16$m=65536,t=3,p=2$c2FsdA$aGFzaA",
+		"$argon2id$v=19$m=abc$c2FsdA$aGFzaA",
+	} {
+		if _, err := VerifyPassword(hash, "password"); err == nil {
+			t.Errorf("Expected malformed hash %q to be rejected", hash)
+		}
+	}
+}
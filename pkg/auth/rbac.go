@@ -0,0 +1,154 @@
+package auth
+
+import "slices"
+
+// Role names a set of permissions granted to a user
+type Role string
+
+// Roles known to the API
+const (
+	RoleUser  Role = "user"
+	RoleAdmin Role = "admin"
+)
+
+// Permission names an action a user may perform
+type Permission string
+
+// Permissions checked by the API routes
+const (
+	// PermissionDevicesRead allows viewing devices and the caller's own devices
+	PermissionDevicesRead Permission = "devices:read"
+	// PermissionDevicesAssign allows assigning devices to and unassigning them from the caller
+	PermissionDevicesAssign Permission = "devices:assign"
+	// PermissionDevicesWrite allows renaming and labelling the caller's devices
+	PermissionDevicesWrite Permission = "devices:write"
+	// PermissionAdminDevicesRead allows listing every device and any user's devices
+	PermissionAdminDevicesRead Permission = "admin:devices:read"
+	// PermissionAdminDevicesAssign allows assigning and unassigning devices on behalf of any user
+	PermissionAdminDevicesAssign Permission = "admin:devices:assign"
+	// PermissionAdminDevicesLifecycle allows activating, suspending, reporting lost and decommissioning devices
+	PermissionAdminDevicesLifecycle Permission = "admin:devices:lifecycle"
+	// PermissionAdminUsersManage allows creating local user accounts
+	PermissionAdminUsersManage Permission = "admin:users:manage"
+	// PermissionAdminAPIKeysManage allows creating and revoking service API keys
+	PermissionAdminAPIKeysManage Permission = "admin:api-keys:manage"
+)
+
+// DefaultRolePermissions maps each role to the permissions it grants
+var DefaultRolePermissions = map[Role][]Permission{
+	RoleUser: {
+		PermissionDevicesRead,
+		PermissionDevicesAssign,
+		PermissionDevicesWrite,
+	},
+	RoleAdmin: {
+		PermissionDevicesRead,
+		PermissionDevicesAssign,
+		PermissionDevicesWrite,
+		PermissionAdminDevicesRead,
+		PermissionAdminDevicesAssign,
+		PermissionAdminDevicesLifecycle,
+		PermissionAdminUsersManage,
+		PermissionAdminAPIKeysManage,
+	},
+}
+
+// IsValidRole reports whether the role is known
+func IsValidRole(role string) bool {
+	_, ok := DefaultRolePermissions[Role(role)]
+	return ok
+}
+
+// Principal is an authenticated user together with the permissions resolved from their token.
+// Scopes is set for API credentials and restricts the permissions to those the scopes grant;
+// it is nil for users authenticated with a JWT.
+type Principal struct {
+	UserID      string
+	Roles       []string
+	Scopes      []Scope
+	permissions map[Permission]bool
+}
+
+// HasPermission reports whether the principal was granted the permission, and for API
+// credentials whether one of its scopes grants it
+func (p *Principal) HasPermission(permission Permission) bool {
+	if p == nil || !p.permissions[permission] {
+		return false
+	}
+
+	if p.Scopes == nil {
+		return true
+	}
+
+	for _, scope := range p.Scopes {
+		if ScopePermissions[scope] == permission {
+			return true
+		}
+	}
+	return false
+}
+
+// IsAPICredential reports whether the principal authenticated with an API credential
+func (p *Principal) IsAPICredential() bool {
+	return p != nil && p.Scopes != nil
+}
+
+// RBAC resolves the roles and permissions carried in token claims into a Principal.
+// Tokens without roles get the user role, so tokens issued before roles existed keep
+// their access.
+type RBAC struct {
+	rolePermissions map[Role][]Permission
+	adminUsers      map[string]bool
+}
+
+// NewRBAC creates an RBAC using the default role permissions. The listed user IDs are
+// granted the admin role regardless of their token, to bootstrap the first administrators.
+func NewRBAC(adminUsers []string) *RBAC {
+	r := &RBAC{
+		rolePermissions: DefaultRolePermissions,
+		adminUsers:      make(map[string]bool, len(adminUsers)),
+	}
+
+	for _, userID := range adminUsers {
+		r.adminUsers[userID] = true
+	}
+
+	return r
+}
+
+// Principal resolves the effective roles and permissions for validated claims
+func (r *RBAC) Principal(claims *Claims) *Principal {
+	roles := claims.Roles
+	if len(roles) == 0 {
+		roles = []string{string(RoleUser)}
+	}
+	if r.adminUsers[claims.UserID] && !slices.Contains(roles, string(RoleAdmin)) {
+		roles = append(append([]string(nil), roles...), string(RoleAdmin))
+	}
+
+	principal := &Principal{
+		UserID:      claims.UserID,
+		Roles:       roles,
+		permissions: make(map[Permission]bool),
+	}
+
+	for _, role := range roles {
+		for _, permission := range r.rolePermissions[Role(role)] {
+			principal.permissions[permission] = true
+		}
+	}
+
+	for _, permission := range claims.Permissions {
+		principal.permissions[Permission(permission)] = true
+	}
+
+	return principal
+}
+
+// CredentialPrincipal resolves the principal of an API credential: the roles of the
+// credential, restricted to the permissions its scopes grant
+func (r *RBAC) CredentialPrincipal(credential *APICredential) *Principal {
+	principal := r.Principal(&Claims{UserID: credential.UserID, Roles: credential.Roles})
+	principal.Scopes = append([]Scope{}, credential.Scopes...)
+	return principal
+}
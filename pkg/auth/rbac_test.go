@@ -0,0 +1,131 @@
+package auth
+
+import (
+	"testing"
+	"time"
+)
+
+func TestRBACPrincipal(t *testing.T) {
+	rbac := NewRBAC([]string{"bootstrap-admin"})
+
+	tests := []struct {
+		name    string
+		claims  *Claims
+		allowed []Permission
+		denied  []Permission
+	}{
+		{
+			name:    "token without roles is a regular user",
+			claims:  &Claims{UserID: "alice"},
+			allowed: []Permission{PermissionDevicesRead, PermissionDevicesAssign, PermissionDevicesWrite},
+			denied:  []Permission{PermissionAdminDevicesRead, PermissionAdminDevicesAssign},
+		},
+		{
+			name:    "admin role grants admin permissions",
+			claims:  &Claims{UserID: "bob", Roles: []string{"admin"}},
+			allowed: []Permission{PermissionDevicesRead, PermissionAdminDevicesRead, PermissionAdminDevicesAssign},
+		},
+		{
+			name:    "configured admin user is granted the admin role",
+			claims:  &Claims{UserID: "bootstrap-admin"},
+			allowed: []Permission{PermissionDevicesRead, PermissionAdminDevicesAssign},
+		},
+		{
+			name:   "unknown roles grant nothing",
+			claims: &Claims{UserID: "carol", Roles: []string{"superuser"}},
+			denied: []Permission{PermissionDevicesRead, PermissionAdminDevicesRead},
+		},
+		{
+			name:    "explicit permissions are granted",
+			claims:  &Claims{UserID: "dave", Roles: []string{"superuser"}, Permissions: []string{"admin:devices:read"}},
+			allowed: []Permission{PermissionAdminDevicesRead},
+			denied:  []Permission{PermissionAdminDevicesAssign},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			principal := rbac.Principal(tt.claims)
+			if principal.UserID != tt.claims.UserID {
+				t.Errorf("Expected user ID %s, got %s", tt.claims.UserID, principal.UserID)
+			}
+			for _, permission := range tt.allowed {
+				if !principal.HasPermission(permission) {
+					t.Errorf("Expected permission %s to be granted", permission)
+				}
+			}
+			for _, permission := range tt.denied {
+				if principal.HasPermission(permission) {
+					t.Errorf("Expected permission %s to be denied", permission)
+				}
+			}
+		})
+	}
+}
+
+func TestRBACCredentialPrincipal(t *testing.T) {
+	rbac := NewRBAC(nil)
+
+	service := rbac.CredentialPrincipal(&APICredential{
+		UserID: "service:inventory",
+		Roles:  []string{"admin"},
+		Scopes: []Scope{ScopeAdminDevicesRead},
+	})
+	if !service.HasPermission(PermissionAdminDevicesRead) {
+		t.Error("Expected the scope to grant its permission")
+	}
+	for _, permission := range []Permission{PermissionAdminDevicesAssign, PermissionAdminUsersManage, PermissionDevicesRead} {
+		if service.HasPermission(permission) {
+			t.Errorf("Expected permission %s outside the scopes to be denied", permission)
+		}
+	}
+
+	// A scope does not grant a permission the credential's roles lack
+	personal := rbac.CredentialPrincipal(&APICredential{
+		UserID: "alice",
+		Roles:  []string{"user"},
+		Scopes: []Scope{ScopeDevicesRead, ScopeAdminAssignmentsWrite},
+	})
+	if !personal.HasPermission(PermissionDevicesRead) {
+		t.Error("Expected devices:read to be granted")
+	}
+	if personal.HasPermission(PermissionAdminDevicesAssign) {
+		t.Error("Expected an admin scope not to grant an admin permission to a user")
+	}
+
+	// A credential without scopes can do nothing
+	unscoped := rbac.CredentialPrincipal(&APICredential{UserID: "bob", Roles: []string{"user"}})
+	if !unscoped.IsAPICredential() || unscoped.HasPermission(PermissionDevicesRead) {
+		t.Error("Expected a credential without scopes to be granted nothing")
+	}
+}
+
+func TestRBACDoesNotModifyClaims(t *testing.T) {
+	// Spare capacity would let a careless append write into the token's roles
+	roles := make([]string, 1, 2)
+	roles[0] = "user"
+	claims := &Claims{UserID: "admin", Roles: roles}
+
+	NewRBAC([]string{"admin"}).Principal(claims)
+
+	if len(claims.Roles) != 1 || roles[:cap(roles)][1] != "" {
+		t.Errorf("Expected claims roles to be left untouched, got %v", claims.Roles)
+	}
+}
+
+func TestJWTManagerTokenRoles(t *testing.T) {
+	manager := NewJWTManager("secret", time.Hour, "test")
+
+	token, err := manager.GenerateTokenWithRoles("user-1", []string{"admin"})
+	if err != nil {
+		t.Fatalf("Failed to generate token: %v", err)
+	}
+
+	claims, err := manager.ValidateToken(token)
+	if err != nil {
+		t.Fatalf("Failed to validate token: %v", err)
+	}
+	if !NewRBAC(nil).Principal(claims).HasPermission(PermissionAdminDevicesAssign) {
+		t.Errorf("Expected admin role to survive the token round trip, got roles %v", claims.Roles)
+	}
+}
@@ -0,0 +1,79 @@
+package auth
+
+import (
+	"crypto/x509"
+	"errors"
+	"fmt"
+)
+
+// ErrCertificateRevoked is returned when a certificate appears on a revocation source
+var ErrCertificateRevoked = errors.New("certificate has been revoked")
+
+// RevocationChecker reports whether a certificate has been revoked by its issuer
+type RevocationChecker interface {
+	// CheckRevocation returns an error wrapping ErrCertificateRevoked if the certificate is revoked.
+	// The issuer may be nil when it is not known to the caller.
+	CheckRevocation(cert, issuer *x509.Certificate) error
+}
+
+// CheckChainRevocation checks every non-root certificate in a verified chain
+func CheckChainRevocation(checker RevocationChecker, chain []*x509.Certificate) error {
+	if checker == nil {
+		return nil
+	}
+
+	if len(chain) == 0 {
+		return fmt.Errorf("certificate chain is empty")
+	}
+
+	// The last certificate is the trust anchor and is never checked
+	for i := 0; i < len(chain)-1; i++ {
+		if err := checker.CheckRevocation(chain[i], chain[i+1]); err != nil {
+			return err
+		}
+	}
+
+	// A chain of one is a self-signed leaf trusted directly
+	if len(chain) == 1 {
+		return checker.CheckRevocation(chain[0], nil)
+	}
+
+	return nil
+}
+
+// VerifyPeerRevocation returns a tls.Config VerifyPeerCertificate callback that rejects
+// the handshake unless at least one verified chain is free of revoked certificates
+func VerifyPeerRevocation(checker RevocationChecker) func(rawCerts [][]byte, verifiedChains [][]*x509.Certificate) error {
+	return func(rawCerts [][]byte, verifiedChains [][]*x509.Certificate) error {
+		if checker == nil || len(verifiedChains) == 0 {
+			return nil
+		}
+
+		var lastErr error
+		for _, chain := range verifiedChains {
+			if err := CheckChainRevocation(checker, chain); err != nil {
+				lastErr = err
+				continue
+			}
+			return nil
+		}
+
+		return lastErr
+	}
+}
+
+// MultiRevocationChecker runs several revocation checkers in order and fails on the first error
+type MultiRevocationChecker []RevocationChecker
+
+// CheckRevocation implements RevocationChecker
+func (m MultiRevocationChecker) CheckRevocation(cert, issuer *x509.Certificate) error {
+	for _, checker := range m {
+		if checker == nil {
+			continue
+		}
+		if err := checker.CheckRevocation(cert, issuer); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,82 @@
+package auth
+
+import (
+	"crypto/x509"
+	"fmt"
+	"net/url"
+	"strings"
+)
+
+// spiffeScheme is the URI scheme of SPIFFE IDs
+const spiffeScheme = "spiffe"
+
+// SPIFFEID is a parsed SPIFFE ID (spiffe://<trust-domain>/<path>) identifying a workload
+type SPIFFEID struct {
+	TrustDomain string
+	Path        string
+}
+
+// String returns the SPIFFE ID as a URI
+func (id SPIFFEID) String() string {
+	return spiffeScheme + "://" + id.TrustDomain + id.Path
+}
+
+// ParseSPIFFEID parses and validates a workload SPIFFE ID. The trust domain must be
+// lowercase, and the ID must have a path and no port, user info, query or fragment.
+func ParseSPIFFEID(raw string) (SPIFFEID, error) {
+	uri, err := url.Parse(raw)
+	if err != nil || uri.Scheme != spiffeScheme {
+		return SPIFFEID{}, fmt.Errorf("invalid SPIFFE ID %q", raw)
+	}
+
+	if uri.User != nil || uri.Port() != "" || uri.RawQuery != "" || uri.Fragment != "" || uri.Opaque != "" {
+		return SPIFFEID{}, fmt.Errorf("invalid SPIFFE ID %q", raw)
+	}
+
+	if err := ValidateTrustDomain(uri.Host); err != nil {
+		return SPIFFEID{}, err
+	}
+
+	if uri.Path == "" || uri.Path == "/" || strings.HasSuffix(uri.Path, "/") || strings.Contains(uri.Path, "//") {
+		return SPIFFEID{}, fmt.Errorf("SPIFFE ID %q has no valid workload path", raw)
+	}
+
+	for _, segment := range strings.Split(uri.Path[1:], "/") {
+		if segment == "." || segment == ".." {
+			return SPIFFEID{}, fmt.Errorf("SPIFFE ID %q has no valid workload path", raw)
+		}
+	}
+
+	return SPIFFEID{TrustDomain: uri.Host, Path: uri.Path}, nil
+}
+
+// ValidateTrustDomain checks that a trust domain name only uses the characters the SPIFFE
+// specification allows
+func ValidateTrustDomain(trustDomain string) error {
+	if trustDomain == "" {
+		return fmt.Errorf("SPIFFE trust domain is missing")
+	}
+
+	for _, c := range trustDomain {
+		if !(c >= 'a' && c <= 'z' || c >= '0' && c <= '9' || c == '.' || c == '-' || c == '_') {
+			return fmt.Errorf("invalid SPIFFE trust domain %q", trustDomain)
+		}
+	}
+
+	return nil
+}
+
+// CertificateSPIFFEID returns the SPIFFE ID of an X.509-SVID, or an empty string when the
+// certificate carries none. An SVID has exactly one URI SAN, so certificates with several
+// URI SANs are not treated as SVIDs.
+func CertificateSPIFFEID(cert *x509.Certificate) string {
+	if cert == nil || len(cert.URIs) != 1 || cert.URIs[0].Scheme != spiffeScheme {
+		return ""
+	}
+
+	id, err := ParseSPIFFEID(cert.URIs[0].String())
+	if err != nil {
+		return ""
+	}
+	return id.String()
+}
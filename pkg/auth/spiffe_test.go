@@ -0,0 +1,95 @@
+package auth
+
+import (
+	"crypto/x509"
+	"math/big"
+	"net/url"
+	"testing"
+)
+
+// issueSVID returns an X.509-SVID with the given URI SANs, issued by a CA without a common name
+func issueSVID(t *testing.T, ca *testCA, serial int64, uris ...string) *x509.Certificate {
+	t.Helper()
+
+	template := &x509.Certificate{SerialNumber: big.NewInt(serial)}
+	for _, raw := range uris {
+		uri, err := url.Parse(raw)
+		if err != nil {
+			t.Fatalf("Failed to parse URI %q: %v", raw, err)
+		}
+		template.URIs = append(template.URIs, uri)
+	}
+
+	return ca.issue(t, template, newTestKey(t).Public())
+}
+
+func TestParseSPIFFEID(t *testing.T) {
+	id, err := ParseSPIFFEID("spiffe://example.org/device/1234")
+	if err != nil {
+		t.Fatalf("Failed to parse SPIFFE ID: %v", err)
+	}
+	if id.TrustDomain != "example.org" || id.Path != "/device/1234" {
+		t.Errorf("Unexpected SPIFFE ID %+v", id)
+	}
+	if id.String() != "spiffe://example.org/device/1234" {
+		t.Errorf("Expected SPIFFE ID to round-trip, got %s", id)
+	}
+
+	invalid := []string{
+		"https://example.org/device/1234",
+		"spiffe://example.org",
+		"spiffe://example.org/",
+		"spiffe://Example.org/device",
+		"spiffe://example.org:8443/device",
+		"spiffe://user@example.org/device",
+		"spiffe://example.org/device?id=1",
+		"spiffe://example.org/device#1",
+		"spiffe://example.org/device/",
+		"spiffe://example.org/device//1",
+		"spiffe://example.org/device/../admin",
+		"spiffe:///device",
+	}
+
+	for _, raw := range invalid {
+		if _, err := ParseSPIFFEID(raw); err == nil {
+			t.Errorf("Expected an error for %q", raw)
+		}
+	}
+}
+
+func TestCertificateSPIFFEID(t *testing.T) {
+	ca := newTestCA(t, "")
+
+	tests := []struct {
+		name string
+		uris []string
+		want string
+	}{
+		{name: "svid", uris: []string{"spiffe://example.org/device/1"}, want: "spiffe://example.org/device/1"},
+		{name: "no uri", want: ""},
+		{name: "other scheme", uris: []string{"urn:device:1"}, want: ""},
+		{name: "several uris", uris: []string{"spiffe://example.org/device/1", "spiffe://example.org/device/2"}, want: ""},
+		{name: "invalid id", uris: []string{"spiffe://example.org/"}, want: ""},
+	}
+
+	for i, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cert := issueSVID(t, ca, int64(i+1), tt.uris...)
+			if got := CertificateSPIFFEID(cert); got != tt.want {
+				t.Errorf("Expected SPIFFE ID %q, got %q", tt.want, got)
+			}
+		})
+	}
+}
+
+func TestValidateCertificateAcceptsSVIDWithoutIssuerCN(t *testing.T) {
+	ca := newTestCA(t, "")
+
+	if err := ValidateCertificate(issueSVID(t, ca, 1, "spiffe://example.org/device/1")); err != nil {
+		t.Errorf("Expected an X.509-SVID to be valid, got %v", err)
+	}
+
+	if err := ValidateCertificate(issueSVID(t, ca, 2)); err == nil {
+		t.Error("Expected an error for a certificate without an issuer common name or SPIFFE ID")
+	}
+}
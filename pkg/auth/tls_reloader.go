@@ -0,0 +1,221 @@
+package auth
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	"device-assignment-api/pkg/logger"
+)
+
+// TLSReloader serves the server certificate and client CA pool from files that can be
+// replaced at runtime. Invalid replacement material is rejected and the previous
+// material stays in use.
+type TLSReloader struct {
+	certFile string
+	keyFile  string
+	caFile   string
+	logger   logger.Logger
+
+	mu        sync.RWMutex
+	cert      *tls.Certificate
+	clientCAs *x509.CertPool
+	caCerts   []*x509.Certificate
+	modTimes  map[string]time.Time
+	onReload  []func()
+
+	stop     chan struct{}
+	stopOnce sync.Once
+}
+
+// NewTLSReloader creates a reloader and performs the initial load, which must succeed
+func NewTLSReloader(certFile, keyFile, caFile string, logger logger.Logger) (*TLSReloader, error) {
+	r := &TLSReloader{
+		certFile: certFile,
+		keyFile:  keyFile,
+		caFile:   caFile,
+		logger:   logger,
+		stop:     make(chan struct{}),
+	}
+
+	if err := r.load(); err != nil {
+		return nil, err
+	}
+
+	return r, nil
+}
+
+// GetCertificate implements tls.Config.GetCertificate
+func (r *TLSReloader) GetCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	return r.cert, nil
+}
+
+// ClientCAs returns the current client CA pool
+func (r *TLSReloader) ClientCAs() *x509.CertPool {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	return r.clientCAs
+}
+
+// ClientCACertificates returns the certificates of the current client CA pool
+func (r *TLSReloader) ClientCACertificates() []*x509.Certificate {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	return r.caCerts
+}
+
+// OnReload registers a function called after replacement material has been loaded, so that
+// state derived from the client CAs can be reloaded together with the pool
+func (r *TLSReloader) OnReload(fn func()) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.onReload = append(r.onReload, fn)
+}
+
+// ConfigForClient returns a tls.Config.GetConfigForClient callback that serves the base
+// configuration with the current certificate and client CA pool
+func (r *TLSReloader) ConfigForClient(base *tls.Config) func(*tls.ClientHelloInfo) (*tls.Config, error) {
+	return func(*tls.ClientHelloInfo) (*tls.Config, error) {
+		config := base.Clone()
+		config.GetConfigForClient = nil
+		config.GetCertificate = r.GetCertificate
+		config.ClientCAs = r.ClientCAs()
+		return config, nil
+	}
+}
+
+// Reload re-reads the certificate, key and CA files and logs the outcome
+func (r *TLSReloader) Reload() error {
+	if err := r.load(); err != nil {
+		r.logger.Error("TLS reload rejected, keeping previous material", "error", err)
+		return err
+	}
+
+	r.mu.RLock()
+	onReload := r.onReload
+	r.mu.RUnlock()
+
+	for _, fn := range onReload {
+		fn()
+	}
+	return nil
+}
+
+// Watch polls the files for modification at the given interval and reloads on change
+func (r *TLSReloader) Watch(interval time.Duration) {
+	if interval <= 0 {
+		return
+	}
+
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ticker.C:
+				if r.changed() {
+					r.logger.Info("TLS files changed on disk, reloading")
+					r.Reload()
+				}
+			case <-r.stop:
+				return
+			}
+		}
+	}()
+}
+
+// Stop halts file watching
+func (r *TLSReloader) Stop() {
+	r.stopOnce.Do(func() {
+		close(r.stop)
+	})
+}
+
+// load parses and validates all files before swapping them in together
+func (r *TLSReloader) load() error {
+	modTimes, err := r.statFiles()
+	if err != nil {
+		return err
+	}
+
+	cert, err := tls.LoadX509KeyPair(r.certFile, r.keyFile)
+	if err != nil {
+		return fmt.Errorf("failed to load server certificate: %w", err)
+	}
+
+	leaf, err := x509.ParseCertificate(cert.Certificate[0])
+	if err != nil {
+		return fmt.Errorf("failed to parse server certificate: %w", err)
+	}
+
+	if time.Now().After(leaf.NotAfter) {
+		return fmt.Errorf("server certificate expired at %s", leaf.NotAfter.Format(time.RFC3339))
+	}
+	cert.Leaf = leaf
+
+	caCerts, err := LoadCertificates(r.caFile)
+	if err != nil {
+		return fmt.Errorf("failed to load CA certificates: %w", err)
+	}
+
+	clientCAs := x509.NewCertPool()
+	for _, caCert := range caCerts {
+		clientCAs.AddCert(caCert)
+	}
+
+	r.mu.Lock()
+	r.cert = &cert
+	r.clientCAs = clientCAs
+	r.caCerts = caCerts
+	r.modTimes = modTimes
+	r.mu.Unlock()
+
+	r.logger.Info("TLS material loaded",
+		"server_subject", leaf.Subject.String(),
+		"server_serial_number", formatSerialNumber(leaf.SerialNumber),
+		"server_not_after", leaf.NotAfter)
+
+	return nil
+}
+
+// changed reports whether any watched file has a different modification time
+func (r *TLSReloader) changed() bool {
+	modTimes, err := r.statFiles()
+	if err != nil {
+		r.logger.Warn("Failed to check TLS files for changes", "error", err)
+		return false
+	}
+
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	for file, modTime := range modTimes {
+		if !modTime.Equal(r.modTimes[file]) {
+			return true
+		}
+	}
+	return false
+}
+
+// statFiles returns the modification time of every watched file
+func (r *TLSReloader) statFiles() (map[string]time.Time, error) {
+	modTimes := make(map[string]time.Time, 3)
+	for _, file := range []string{r.certFile, r.keyFile, r.caFile} {
+		info, err := os.Stat(file)
+		if err != nil {
+			return nil, fmt.Errorf("failed to stat %s: %w", file, err)
+		}
+		modTimes[file] = info.ModTime()
+	}
+	return modTimes, nil
+}
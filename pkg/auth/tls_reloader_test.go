@@ -0,0 +1,189 @@
+package auth
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/pem"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// tlsFiles holds the paths of the files watched by a TLSReloader under test
+type tlsFiles struct {
+	cert string
+	key  string
+	ca   string
+}
+
+func newTLSFiles(t *testing.T) tlsFiles {
+	dir := t.TempDir()
+	return tlsFiles{
+		cert: filepath.Join(dir, "server.crt"),
+		key:  filepath.Join(dir, "server.key"),
+		ca:   filepath.Join(dir, "ca.crt"),
+	}
+}
+
+func (f tlsFiles) writeServer(t *testing.T, cert tls.Certificate) {
+	t.Helper()
+
+	keyDER, err := x509.MarshalPKCS8PrivateKey(cert.PrivateKey)
+	if err != nil {
+		t.Fatalf("Failed to marshal key: %v", err)
+	}
+
+	writePEM(t, f.cert, "CERTIFICATE", cert.Certificate[0])
+	writePEM(t, f.key, "PRIVATE KEY", keyDER)
+}
+
+func (f tlsFiles) writeCAs(t *testing.T, cas ...*testCA) {
+	t.Helper()
+
+	var data []byte
+	for _, ca := range cas {
+		data = append(data, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: ca.cert.Raw})...)
+	}
+	if err := os.WriteFile(f.ca, data, 0o600); err != nil {
+		t.Fatalf("Failed to write CA file: %v", err)
+	}
+}
+
+func writePEM(t *testing.T, path, blockType string, der []byte) {
+	t.Helper()
+
+	if err := os.WriteFile(path, pem.EncodeToMemory(&pem.Block{Type: blockType, Bytes: der}), 0o600); err != nil {
+		t.Fatalf("Failed to write %s: %v", path, err)
+	}
+}
+
+func reloadingServerConfig(reloader *TLSReloader) *tls.Config {
+	config := &tls.Config{
+		GetCertificate: reloader.GetCertificate,
+		ClientAuth:     tls.RequireAndVerifyClientCert,
+		MinVersion:     tls.VersionTLS12,
+	}
+	config.GetConfigForClient = reloader.ConfigForClient(config)
+	return config
+}
+
+func currentServerSerial(t *testing.T, reloader *TLSReloader) string {
+	t.Helper()
+
+	cert, err := reloader.GetCertificate(nil)
+	if err != nil {
+		t.Fatalf("Failed to get certificate: %v", err)
+	}
+	return cert.Leaf.SerialNumber.String()
+}
+
+func TestTLSReloaderReloadsCertificateAndClientCAs(t *testing.T) {
+	serverCA := newTestCA(t, "Server CA")
+	newIntermediate := newTestCA(t, "New Device CA")
+	_, oldClient := serverCA.issueClient(t, 1, "old-device")
+	_, newClient := newIntermediate.issueClient(t, 2, "new-device")
+
+	files := newTLSFiles(t)
+	files.writeServer(t, serverCA.issueServer(t))
+	files.writeCAs(t, serverCA)
+
+	reloader, err := NewTLSReloader(files.cert, files.key, files.ca, testLogger())
+	if err != nil {
+		t.Fatalf("Failed to create reloader: %v", err)
+	}
+	config := reloadingServerConfig(reloader)
+
+	if err := handshake(t, config, serverCA, oldClient); err != nil {
+		t.Fatalf("Expected handshake from the original CA to succeed, got %v", err)
+	}
+	if err := handshake(t, config, serverCA, newClient); err == nil {
+		t.Fatal("Expected handshake from an unknown CA to fail before reload")
+	}
+
+	initialSerial := currentServerSerial(t, reloader)
+
+	// Rotate the server certificate and add the new CA to the bundle
+	files.writeServer(t, serverCA.issueServer(t))
+	files.writeCAs(t, serverCA, newIntermediate)
+	if err := reloader.Reload(); err != nil {
+		t.Fatalf("Failed to reload: %v", err)
+	}
+
+	if currentServerSerial(t, reloader) == initialSerial {
+		t.Error("Expected the rotated server certificate to be served after reload")
+	}
+	if err := handshake(t, config, serverCA, newClient); err != nil {
+		t.Errorf("Expected handshake from the new CA to succeed after reload, got %v", err)
+	}
+	if err := handshake(t, config, serverCA, oldClient); err != nil {
+		t.Errorf("Expected handshake from the original CA to keep working, got %v", err)
+	}
+}
+
+func TestTLSReloaderKeepsPreviousMaterialOnInvalidReload(t *testing.T) {
+	serverCA := newTestCA(t, "Server CA")
+	_, client := serverCA.issueClient(t, 1, "device")
+
+	files := newTLSFiles(t)
+	files.writeServer(t, serverCA.issueServer(t))
+	files.writeCAs(t, serverCA)
+
+	reloader, err := NewTLSReloader(files.cert, files.key, files.ca, testLogger())
+	if err != nil {
+		t.Fatalf("Failed to create reloader: %v", err)
+	}
+	serial := currentServerSerial(t, reloader)
+
+	// A certificate that does not match the key on disk
+	mismatched := serverCA.issueServer(t)
+	writePEM(t, files.cert, "CERTIFICATE", mismatched.Certificate[0])
+	if err := reloader.Reload(); err == nil {
+		t.Error("Expected reload with a mismatched key to fail")
+	}
+
+	files.writeServer(t, serverCA.issueServer(t))
+	if err := os.WriteFile(files.ca, []byte("not a certificate"), 0o600); err != nil {
+		t.Fatalf("Failed to write CA file: %v", err)
+	}
+	if err := reloader.Reload(); err == nil {
+		t.Error("Expected reload with an invalid CA bundle to fail")
+	}
+
+	if currentServerSerial(t, reloader) != serial {
+		t.Error("Expected the previous server certificate to stay in use")
+	}
+	if err := handshake(t, reloadingServerConfig(reloader), serverCA, client); err != nil {
+		t.Errorf("Expected the previous CA bundle to stay in use, got %v", err)
+	}
+}
+
+func TestTLSReloaderWatchDetectsChanges(t *testing.T) {
+	serverCA := newTestCA(t, "Server CA")
+
+	files := newTLSFiles(t)
+	files.writeServer(t, serverCA.issueServer(t))
+	files.writeCAs(t, serverCA)
+
+	reloader, err := NewTLSReloader(files.cert, files.key, files.ca, testLogger())
+	if err != nil {
+		t.Fatalf("Failed to create reloader: %v", err)
+	}
+	serial := currentServerSerial(t, reloader)
+
+	reloader.Watch(10 * time.Millisecond)
+	defer reloader.Stop()
+
+	files.writeServer(t, serverCA.issueServer(t))
+	future := time.Now().Add(time.Minute)
+	os.Chtimes(files.cert, future, future)
+
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		if currentServerSerial(t, reloader) != serial {
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatal("Expected watcher to reload the changed certificate")
+}
@@ -0,0 +1,56 @@
+package auth
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"crypto/x509"
+	"encoding/base64"
+	"fmt"
+)
+
+// Confirmation is the cnf claim (RFC 7800). X509Thumbprint binds a token to the client
+// certificate it was issued to (RFC 8705 section 3.1), so the token is only accepted over a
+// connection authenticated with that certificate.
+type Confirmation struct {
+	X509Thumbprint string `json:"x5t#S256,omitempty"`
+}
+
+// CertificateThumbprint returns the base64url-encoded SHA-256 hash of a certificate's DER
+// encoding, as used by the x5t#S256 confirmation method
+func CertificateThumbprint(cert *x509.Certificate) string {
+	sum := sha256.Sum256(cert.Raw)
+	return base64.RawURLEncoding.EncodeToString(sum[:])
+}
+
+// NewCertificateConfirmation returns a confirmation binding a token to the certificate with
+// the given thumbprint, or nil when there is no thumbprint
+func NewCertificateConfirmation(thumbprint string) *Confirmation {
+	if thumbprint == "" {
+		return nil
+	}
+	return &Confirmation{X509Thumbprint: thumbprint}
+}
+
+// IsCertificateBound reports whether the confirmation binds a token to a client certificate
+func (c *Confirmation) IsCertificateBound() bool {
+	return c != nil && c.X509Thumbprint != ""
+}
+
+// VerifyCertificateBinding checks that a token bound to a client certificate is presented
+// with that certificate. The certificate must already be verified; it may be nil when the
+// connection carried none. Tokens without a certificate binding are always accepted.
+func VerifyCertificateBinding(cnf *Confirmation, cert *x509.Certificate) error {
+	if !cnf.IsCertificateBound() {
+		return nil
+	}
+
+	if cert == nil {
+		return fmt.Errorf("token is bound to a client certificate but none was presented")
+	}
+
+	if subtle.ConstantTimeCompare([]byte(CertificateThumbprint(cert)), []byte(cnf.X509Thumbprint)) != 1 {
+		return fmt.Errorf("token is bound to a different client certificate")
+	}
+
+	return nil
+}
@@ -1,6 +1,7 @@
 package main
 
 import (
+	"bytes"
 	"crypto/tls"
 	"crypto/x509"
 	"encoding/json"
@@ -17,7 +18,7 @@ func main() {
 		fmt.Println("Usage: go run test-client.go <command>")
 		fmt.Println("Commands:")
 		fmt.Println("  auth         - Test device authentication")
-		fmt.Println("  generate-jwt - Generate a test JWT token")
+		fmt.Println("  generate-jwt - Log in with a username and password and print the JWT")
 		os.Exit(1)
 	}
 
@@ -89,14 +90,61 @@ func testDeviceAuth() {
 }
 
 func generateTestJWT() {
-	// This would typically be done by a separate authentication service
-	// For demo purposes, we'll show what a JWT token would look like
-	fmt.Println("In a real implementation, you would:")
-	fmt.Println("1. Authenticate with your user management system")
-	fmt.Println("2. Receive a JWT token")
-	fmt.Println("3. Use that token for API calls")
+	if len(os.Args) < 4 {
+		fmt.Println("Usage: go run test-client.go generate-jwt <username> <password>")
+		os.Exit(1)
+	}
+
+	// Load CA certificate
+	caCert, err := os.ReadFile("./certs/ca.crt")
+	if err != nil {
+		log.Fatal("Failed to read CA certificate:", err)
+	}
+
+	caCertPool := x509.NewCertPool()
+	caCertPool.AppendCertsFromPEM(caCert)
+
+	// Password login needs only server TLS
+	client := &http.Client{
+		Transport: &http.Transport{
+			TLSClientConfig: &tls.Config{
+				RootCAs:    caCertPool,
+				ServerName: "localhost",
+			},
+		},
+	}
+
+	credentials, _ := json.Marshal(map[string]string{
+		"username": os.Args[2],
+		"password": os.Args[3],
+	})
+
+	resp, err := client.Post("https://localhost:8443/api/v1/auth/login", "application/json", bytes.NewReader(credentials))
+	if err != nil {
+		log.Fatal("Failed to make request:", err)
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		log.Fatal("Failed to read response:", err)
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		fmt.Printf("Status: %s\n", resp.Status)
+		fmt.Printf("Response: %s\n", string(body))
+		os.Exit(1)
+	}
+
+	var token map[string]interface{}
+	if err := json.Unmarshal(body, &token); err != nil {
+		log.Fatal("Failed to decode response:", err)
+	}
+
+	fmt.Printf("Access token: %s\n", token["access_token"])
+	fmt.Printf("Refresh token: %s\n", token["refresh_token"])
 	fmt.Println()
 	fmt.Println("Example JWT usage:")
-	fmt.Println("curl -H 'Authorization: Bearer <your-jwt-token>' \\")
+	fmt.Println("curl -H 'Authorization: Bearer <access-token>' \\")
 	fmt.Println("     https://localhost:8443/api/v1/users/me/devices")
 }